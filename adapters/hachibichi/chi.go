@@ -0,0 +1,37 @@
+// Package hachibichi captures the traffic of a chi router with its route patterns.
+//
+//	r := chi.NewRouter()
+//	r.Use(hachibichi.Middleware(m))
+//	r.With(hachibichi.Event("register")).Post("/users", register)
+package hachibichi
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mtfiqh/hachibi"
+)
+
+// Middleware captures the requests with m, HttpData.Route is the pattern chi matched, e.g. /users/{id}.
+func Middleware(m *hachibi.Middleware) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return m.Handler(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			next.ServeHTTP(writer, request)
+
+			// the pattern is complete once the sub-routers have routed the request
+			if rctx := chi.RouteContext(request.Context()); rctx != nil {
+				hachibi.SetRouteInMiddlewareCtx(request.Context(), rctx.RoutePattern())
+			}
+		}))
+	}
+}
+
+// Event names the event of the captures of the routes it's used with.
+func Event(event string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			hachibi.SetEventInMiddlewareCtx(request.Context(), event)
+			next.ServeHTTP(writer, request)
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package hachibichi_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mtfiqh/hachibi"
+	"github.com/mtfiqh/hachibi/adapters/hachibichi"
+)
+
+func TestMiddleware(t *testing.T) {
+	recorder := hachibi.NewMemoryRecorder(0)
+	m := hachibi.NewMiddleware(hachibi.MiddlewareWithProcessor(recorder))
+
+	r := chi.NewRouter()
+	r.Use(hachibichi.Middleware(m))
+	r.Route("/users", func(r chi.Router) {
+		r.With(hachibichi.Event("user")).Get("/{id}", func(writer http.ResponseWriter, request *http.Request) {
+			io.WriteString(writer, "user "+chi.URLParam(request, "id"))
+		})
+	})
+
+	res := httptest.NewRecorder()
+	r.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/users/42", nil))
+
+	it, _ := recorder.Query(context.Background(), hachibi.Filter{})
+	records, _ := hachibi.Collect(it)
+	if len(records) != 1 {
+		t.Fatalf("unexpected records %+v", records)
+	}
+
+	got := records[0]
+	if got.Route != "/users/{id}" || got.Event != "user" || got.StatusCode != http.StatusOK || string(got.Response.Body) != "user 42" || res.Body.String() != "user 42" {
+		t.Fatalf("unexpected record %+v", got)
+	}
+}
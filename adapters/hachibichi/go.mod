@@ -0,0 +1,23 @@
+module github.com/mtfiqh/hachibi/adapters/hachibichi
+
+go 1.22
+
+require (
+	github.com/go-chi/chi/v5 v5.1.0
+	github.com/mtfiqh/hachibi v0.0.0
+)
+
+require (
+	github.com/andybalholm/brotli v1.1.1 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/jmoiron/sqlx v1.3.5 // indirect
+	github.com/klauspost/compress v1.18.0 // indirect
+	github.com/lib/pq v1.10.6 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	golang.org/x/image v0.18.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+// the adapters are developed with the hachibi of the same commit
+replace github.com/mtfiqh/hachibi => ../..
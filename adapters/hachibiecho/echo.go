@@ -0,0 +1,54 @@
+// Package hachibiecho captures the traffic of an echo server with its route patterns.
+//
+//	e := echo.New()
+//	e.Use(hachibiecho.Middleware(m))
+//	e.POST("/users", register, hachibiecho.Event("register"))
+package hachibiecho
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mtfiqh/hachibi"
+)
+
+// Middleware captures the requests with m, HttpData.Route is the path of the route, e.g. /users/:id.
+// The errors of the handlers are added to the capture and handled by the echo error handler, so that their
+// response is captured as well.
+func Middleware(m *hachibi.Middleware) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			response := c.Response()
+			writer := response.Writer
+			defer func() {
+				response.Writer = writer
+			}()
+
+			m.Handler(http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+				c.SetRequest(request)
+				response.Writer = w
+				hachibi.SetRouteInMiddlewareCtx(request.Context(), c.Path())
+
+				if err := next(c); err != nil {
+					if httpData, ok := request.Context().Value(hachibi.KeyHttpDataCtxMiddleware).(*hachibi.HttpData); ok {
+						httpData.AppendError(err)
+					}
+
+					c.Error(err)
+				}
+			})).ServeHTTP(writer, c.Request())
+
+			return nil
+		}
+	}
+}
+
+// Event names the event of the captures of the routes it's used with.
+func Event(event string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			hachibi.SetEventInMiddlewareCtx(c.Request().Context(), event)
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package hachibiecho_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mtfiqh/hachibi"
+	"github.com/mtfiqh/hachibi/adapters/hachibiecho"
+)
+
+func TestMiddleware(t *testing.T) {
+	recorder := hachibi.NewMemoryRecorder(0)
+	m := hachibi.NewMiddleware(hachibi.MiddlewareWithProcessor(recorder))
+
+	e := echo.New()
+	e.Use(hachibiecho.Middleware(m))
+	e.GET("/users/:id", func(c echo.Context) error {
+		return c.String(http.StatusOK, "user "+c.Param("id"))
+	}, hachibiecho.Event("user"))
+	e.DELETE("/users/:id", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusForbidden, "not allowed")
+	})
+
+	serve := func(t *testing.T, method string, target string) (*httptest.ResponseRecorder, hachibi.HttpData) {
+		t.Helper()
+
+		res := httptest.NewRecorder()
+		e.ServeHTTP(res, httptest.NewRequest(method, target, nil))
+
+		it, _ := recorder.Query(context.Background(), hachibi.Filter{Method: method})
+		records, _ := hachibi.Collect(it)
+		if len(records) != 1 {
+			t.Fatalf("unexpected records %+v", records)
+		}
+
+		return res, records[0]
+	}
+
+	t.Run("route", func(t *testing.T) {
+		res, got := serve(t, http.MethodGet, "/users/42")
+		if got.Route != "/users/:id" || got.Event != "user" || got.StatusCode != http.StatusOK || string(got.Response.Body) != "user 42" || res.Body.String() != "user 42" {
+			t.Fatalf("unexpected record %+v", got)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		res, got := serve(t, http.MethodDelete, "/users/42")
+		if got.StatusCode != http.StatusForbidden || len(got.Error) != 1 || res.Code != http.StatusForbidden || string(got.Response.Body) != "{\"message\":\"not allowed\"}\n" {
+			t.Fatalf("unexpected record %+v %q", got, got.Response.Body)
+		}
+	})
+}
@@ -0,0 +1,101 @@
+// Package hachibifiber captures the traffic of a fiber app with its route patterns.
+//
+//	app := fiber.New()
+//	app.Use(hachibifiber.Middleware(m))
+//	app.Post("/users", hachibifiber.Event("register"), register)
+package hachibifiber
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mtfiqh/hachibi"
+	"github.com/pkg/errors"
+)
+
+// Middleware captures the requests with m, HttpData.Route is the path of the route, e.g. /users/:id.
+// fiber isn't built on net/http: the exchange is copied to the Middleware once the handlers are done, their errors are
+// handled by the fiber error handler first so that their response is captured as well.
+// The handlers read the context of the Middleware with fiber.Ctx.UserContext.
+func Middleware(m *hachibi.Middleware) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		request, err := newRequest(c)
+		if err != nil {
+			return errors.Wrap(err, "invalid request")
+		}
+
+		var handlerErr error
+		own := c.Route()
+		m.Handler(http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+			c.SetUserContext(request.Context())
+
+			if err := c.Next(); err != nil {
+				if httpData, ok := request.Context().Value(hachibi.KeyHttpDataCtxMiddleware).(*hachibi.HttpData); ok {
+					httpData.AppendError(err)
+				}
+
+				handlerErr = c.App().Config().ErrorHandler(c, err)
+			}
+
+			// the route of the middleware itself means no route matched
+			if route := c.Route(); route != own {
+				hachibi.SetRouteInMiddlewareCtx(request.Context(), route.Path)
+			}
+
+			c.Response().Header.VisitAll(func(key, value []byte) {
+				w.Header().Add(string(key), string(value))
+			})
+			w.WriteHeader(c.Response().StatusCode())
+			w.Write(c.Response().Body())
+		})).ServeHTTP(newDiscardWriter(), request)
+
+		return handlerErr
+	}
+}
+
+// Event names the event of the captures of the routes it's used with.
+func Event(event string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		hachibi.SetEventInMiddlewareCtx(c.UserContext(), event)
+		return c.Next()
+	}
+}
+
+// newRequest copies the request of c, the buffers of fasthttp are reused once the handler returns.
+func newRequest(c *fiber.Ctx) (*http.Request, error) {
+	request, err := http.NewRequestWithContext(c.UserContext(), c.Method(), string(c.Request().RequestURI()), bytes.NewReader(bytes.Clone(c.Request().Body())))
+	if err != nil {
+		return nil, err
+	}
+
+	request.Host = string(c.Request().Host())
+	request.RemoteAddr = c.Context().RemoteAddr().String()
+	c.Request().Header.VisitAll(func(key, value []byte) {
+		// like net/http, the host is only in request.Host
+		if !bytes.EqualFold(key, []byte("Host")) {
+			request.Header.Add(string(key), string(value))
+		}
+	})
+
+	return request, nil
+}
+
+// discardWriter receives the response copied to the Middleware, it's already in the fiber context.
+type discardWriter struct {
+	header http.Header
+}
+
+func newDiscardWriter() *discardWriter {
+	return &discardWriter{header: make(http.Header)}
+}
+
+func (w *discardWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (w *discardWriter) WriteHeader(statusCode int) {}
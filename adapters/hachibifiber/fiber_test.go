@@ -0,0 +1,68 @@
+package hachibifiber_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mtfiqh/hachibi"
+	"github.com/mtfiqh/hachibi/adapters/hachibifiber"
+)
+
+func TestMiddleware(t *testing.T) {
+	recorder := hachibi.NewMemoryRecorder(0)
+	m := hachibi.NewMiddleware(hachibi.MiddlewareWithProcessor(recorder))
+
+	app := fiber.New()
+	app.Use(hachibifiber.Middleware(m))
+	app.Post("/users/:id", hachibifiber.Event("user"), func(c *fiber.Ctx) error {
+		return c.Status(http.StatusCreated).SendString("user " + c.Params("id") + " " + string(c.Body()))
+	})
+	app.Delete("/users/:id", func(c *fiber.Ctx) error {
+		return fiber.NewError(http.StatusForbidden, "not allowed")
+	})
+
+	serve := func(t *testing.T, request *http.Request) (string, hachibi.HttpData) {
+		t.Helper()
+
+		res, err := app.Test(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+
+		it, _ := recorder.Query(context.Background(), hachibi.Filter{Method: request.Method})
+		records, _ := hachibi.Collect(it)
+		if len(records) != 1 {
+			t.Fatalf("unexpected records %+v", records)
+		}
+
+		return string(body), records[0]
+	}
+
+	t.Run("route", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/users/42?verbose=1", strings.NewReader("taufiq"))
+		request.Header.Set("X-Request-Id", "req-42")
+
+		body, got := serve(t, request)
+		if body != "user 42 taufiq" || got.Route != "/users/:id" || got.Event != "user" || got.StatusCode != http.StatusCreated || got.URL != "/users/42?verbose=1" {
+			t.Fatalf("unexpected record %+v", got)
+		}
+
+		if string(got.Request.Body) != "taufiq" || got.Request.Header.Get("X-Request-Id") != "req-42" || string(got.Response.Body) != body {
+			t.Fatalf("unexpected payloads %+v", got)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		body, got := serve(t, httptest.NewRequest(http.MethodDelete, "/users/42", nil))
+		if body != "not allowed" || got.StatusCode != http.StatusForbidden || got.Error.Error() != "[not allowed]" || string(got.Response.Body) != body {
+			t.Fatalf("unexpected record %+v", got)
+		}
+	})
+}
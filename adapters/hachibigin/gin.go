@@ -0,0 +1,64 @@
+// Package hachibigin captures the traffic of a gin engine with its route patterns.
+//
+//	r := gin.New()
+//	r.Use(hachibigin.Middleware(m))
+//	r.POST("/users", hachibigin.Event("register"), register)
+package hachibigin
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mtfiqh/hachibi"
+)
+
+// Middleware captures the requests with m, HttpData.Route is the full path of the route, e.g. /users/:id.
+// The errors attached to the context with gin.Context.Error are added to the capture.
+func Middleware(m *hachibi.Middleware) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		writer := c.Writer
+		defer func() {
+			c.Writer = writer
+		}()
+
+		m.Handler(http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+			c.Request = request
+			c.Writer = &responseWriter{ResponseWriter: writer, capture: w}
+			hachibi.SetRouteInMiddlewareCtx(request.Context(), c.FullPath())
+
+			c.Next()
+
+			if httpData, ok := request.Context().Value(hachibi.KeyHttpDataCtxMiddleware).(*hachibi.HttpData); ok {
+				for _, err := range c.Errors {
+					httpData.AppendError(err.Err)
+				}
+			}
+		})).ServeHTTP(writer, c.Request)
+	}
+}
+
+// Event names the event of the captures of the routes it's used with.
+func Event(event string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		hachibi.SetEventInMiddlewareCtx(c.Request.Context(), event)
+		c.Next()
+	}
+}
+
+// responseWriter is the gin writer whose writes go through the writer of the Middleware.
+type responseWriter struct {
+	gin.ResponseWriter
+	capture http.ResponseWriter
+}
+
+func (w *responseWriter) WriteHeader(statusCode int) {
+	w.capture.WriteHeader(statusCode)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	return w.capture.Write(b)
+}
+
+func (w *responseWriter) WriteString(s string) (int, error) {
+	return w.capture.Write([]byte(s))
+}
@@ -0,0 +1,59 @@
+package hachibigin_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mtfiqh/hachibi"
+	"github.com/mtfiqh/hachibi/adapters/hachibigin"
+	"github.com/pkg/errors"
+)
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	recorder := hachibi.NewMemoryRecorder(0)
+	m := hachibi.NewMiddleware(hachibi.MiddlewareWithProcessor(recorder))
+
+	r := gin.New()
+	r.Use(hachibigin.Middleware(m))
+	r.GET("/users/:id", hachibigin.Event("user"), func(c *gin.Context) {
+		c.String(http.StatusOK, "user "+c.Param("id"))
+	})
+	r.DELETE("/users/:id", func(c *gin.Context) {
+		c.Error(errors.New("not allowed"))
+		c.JSON(http.StatusForbidden, gin.H{"error": "not allowed"})
+	})
+
+	serve := func(t *testing.T, method string, target string) (*httptest.ResponseRecorder, hachibi.HttpData) {
+		t.Helper()
+
+		res := httptest.NewRecorder()
+		r.ServeHTTP(res, httptest.NewRequest(method, target, nil))
+
+		it, _ := recorder.Query(context.Background(), hachibi.Filter{Method: method})
+		records, _ := hachibi.Collect(it)
+		if len(records) != 1 {
+			t.Fatalf("unexpected records %+v", records)
+		}
+
+		return res, records[0]
+	}
+
+	t.Run("route", func(t *testing.T) {
+		res, got := serve(t, http.MethodGet, "/users/42")
+		if got.Route != "/users/:id" || got.Event != "user" || got.StatusCode != http.StatusOK || string(got.Response.Body) != "user 42" || res.Body.String() != "user 42" {
+			t.Fatalf("unexpected record %+v", got)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		res, got := serve(t, http.MethodDelete, "/users/42")
+		if got.StatusCode != http.StatusForbidden || got.Error.Error() != "[not allowed]" || res.Code != http.StatusForbidden || string(got.Response.Body) != `{"error":"not allowed"}` {
+			t.Fatalf("unexpected record %+v", got)
+		}
+	})
+}
@@ -0,0 +1,114 @@
+package hachibigrpc
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// UnaryClient captures the unary calls sent by a client.
+func (i *Interceptor) UnaryClient() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		c := i.newCall(method, md, false)
+		c.request(req)
+
+		var header, trailer metadata.MD
+		var p peer.Peer
+		opts = append(opts, grpc.Header(&header), grpc.Trailer(&trailer), grpc.Peer(&p))
+
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if err == nil {
+			c.reply(reply)
+		}
+
+		c.setHeader(header)
+		c.setTrailer(trailer)
+		i.finish(ctx, c, peerAddr(&p), err)
+		return err
+	}
+}
+
+// StreamClient captures the streaming calls sent by a client. A call is captured once its last message is received
+// or it fails, the streams abandoned by the client aren't captured.
+func (i *Interceptor) StreamClient() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		c := i.newCall(method, md, true)
+
+		p := &peer.Peer{}
+		opts = append(opts, grpc.Peer(p))
+
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			i.finish(ctx, c, peerAddr(p), err)
+			return nil, err
+		}
+
+		return &clientStream{ClientStream: cs, interceptor: i, ctx: ctx, call: c, peer: p, desc: desc}, nil
+	}
+}
+
+type clientStream struct {
+	grpc.ClientStream
+	interceptor *Interceptor
+	ctx         context.Context
+	call        *call
+	peer        *peer.Peer
+	desc        *grpc.StreamDesc
+	once        sync.Once
+}
+
+func (s *clientStream) SendMsg(m any) error {
+	err := s.ClientStream.SendMsg(m)
+	if err == nil {
+		s.call.request(m)
+	} else if err != io.EOF {
+		s.finish(err)
+	}
+
+	return err
+}
+
+func (s *clientStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	switch {
+	case err == io.EOF:
+		s.finish(nil)
+	case err != nil:
+		s.finish(err)
+	default:
+		s.call.reply(m)
+
+		// the stream is over once the only reply is received
+		if !s.desc.ServerStreams {
+			s.finish(nil)
+		}
+	}
+
+	return err
+}
+
+func (s *clientStream) finish(err error) {
+	s.once.Do(func() {
+		// the header and trailer are there once the stream is over
+		if header, headerErr := s.ClientStream.Header(); headerErr == nil {
+			s.call.setHeader(header)
+		}
+		s.call.setTrailer(s.ClientStream.Trailer())
+
+		s.interceptor.finish(s.ctx, s.call, peerAddr(s.peer), err)
+	})
+}
+
+func peerAddr(p *peer.Peer) string {
+	if p.Addr != nil {
+		return p.Addr.String()
+	}
+
+	return ""
+}
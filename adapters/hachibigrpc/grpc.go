@@ -0,0 +1,295 @@
+// Package hachibigrpc captures gRPC calls with the processors of hachibi, for clients and servers.
+//
+//	i := hachibigrpc.NewInterceptor(hachibigrpc.InterceptorWithProcessor(store))
+//	server := grpc.NewServer(grpc.UnaryInterceptor(i.UnaryServer()), grpc.StreamInterceptor(i.StreamServer()))
+//	conn, err := grpc.NewClient(target, grpc.WithUnaryInterceptor(i.UnaryClient()), grpc.WithStreamInterceptor(i.StreamClient()))
+//
+// A call is captured like an HTTP exchange: a POST to its full method, which is also its route, with the metadata as
+// headers and the messages rendered with protojson as bodies, the messages of a stream are a JSON array of the first
+// DefaultMaxStreamMessages of each side, the side is marked Truncated when there were more.
+// The gRPC status is in the Grpc-Status and Grpc-Message response headers, StatusCode is its HTTP equivalent.
+package hachibigrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mtfiqh/hachibi"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultMaxStreamMessages is how many messages of each side of a stream are captured.
+const DefaultMaxStreamMessages = 100
+
+type Interceptor struct {
+	processor    hachibi.Processor
+	preProcessor hachibi.PreProcessor
+	errorHandler hachibi.ErrorHandler
+	event        string
+	maxMessages  int
+}
+
+type InterceptorOpt func(*Interceptor)
+
+func InterceptorWithProcessor(processor hachibi.Processor) InterceptorOpt {
+	return func(i *Interceptor) {
+		i.processor = processor
+	}
+}
+
+func InterceptorWithPreProcessor(preProcessor hachibi.PreProcessor) InterceptorOpt {
+	return func(i *Interceptor) {
+		i.preProcessor = preProcessor
+	}
+}
+
+func InterceptorWithErrorHandler(errorHandler hachibi.ErrorHandler) InterceptorOpt {
+	return func(i *Interceptor) {
+		i.errorHandler = errorHandler
+	}
+}
+
+func InterceptorWithEventName(event string) InterceptorOpt {
+	return func(i *Interceptor) {
+		i.event = event
+	}
+}
+
+// InterceptorWithMaxStreamMessages captures the first n messages of each side of a stream, 0 removes the limit.
+func InterceptorWithMaxStreamMessages(n int) InterceptorOpt {
+	return func(i *Interceptor) {
+		i.maxMessages = n
+	}
+}
+
+func NewInterceptor(opts ...InterceptorOpt) *Interceptor {
+	i := &Interceptor{maxMessages: DefaultMaxStreamMessages}
+	for _, opt := range opts {
+		opt(i)
+	}
+
+	return i
+}
+
+// call accumulates the capture of one call, the messages of streams are sent and received concurrently.
+type call struct {
+	mu       sync.Mutex
+	httpData hachibi.HttpData
+	stream   bool
+	requests []json.RawMessage
+	replies  []json.RawMessage
+	header   metadata.MD
+	trailer  metadata.MD
+	once     sync.Once
+
+	// maxMessages bounds requests and replies of a stream, the messages past it are dropped
+	maxMessages       int
+	requestsTruncated bool
+	repliesTruncated  bool
+}
+
+func (i *Interceptor) newCall(fullMethod string, md metadata.MD, stream bool) *call {
+	c := &call{
+		httpData: hachibi.HttpData{
+			ID:        uuid.New().String(),
+			StartedAt: time.Now().Local(),
+			Method:    http.MethodPost,
+			URL:       fullMethod,
+			Route:     fullMethod,
+			Event:     i.event,
+		},
+		stream:      stream,
+		maxMessages: i.maxMessages,
+	}
+	c.httpData.Request.Header = header(md)
+
+	return c
+}
+
+func (c *call) request(msg any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.full(c.requests) {
+		c.requestsTruncated = true
+		return
+	}
+
+	c.requests = append(c.requests, c.message(msg))
+}
+
+func (c *call) reply(msg any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.full(c.replies) {
+		c.repliesTruncated = true
+		return
+	}
+
+	c.replies = append(c.replies, c.message(msg))
+}
+
+// full tells whether a side of a stream has all the messages it can keep.
+func (c *call) full(messages []json.RawMessage) bool {
+	return c.stream && c.maxMessages > 0 && len(messages) >= c.maxMessages
+}
+
+func (c *call) setHeader(md metadata.MD) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.header = metadata.Join(c.header, md)
+}
+
+func (c *call) setTrailer(md metadata.MD) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.trailer = metadata.Join(c.trailer, md)
+}
+
+// message renders msg as JSON, mu must be held.
+func (c *call) message(msg any) json.RawMessage {
+	var b []byte
+	var err error
+	if m, ok := msg.(proto.Message); ok {
+		b, err = protojson.Marshal(m)
+		if err == nil {
+			// protojson randomizes its whitespace
+			compact := bytes.Buffer{}
+			json.Compact(&compact, b)
+			b = compact.Bytes()
+		}
+	} else {
+		b, err = json.Marshal(msg)
+	}
+
+	if err != nil {
+		c.httpData.AppendError(errors.Wrap(err, "failed to marshal message"))
+		return json.RawMessage("null")
+	}
+
+	return b
+}
+
+// finish completes the capture with the result of the call and runs the processors, only the first call counts.
+func (i *Interceptor) finish(ctx context.Context, c *call, peer string, err error) {
+	c.once.Do(func() {
+		c.mu.Lock()
+		httpData := &c.httpData
+		httpData.Duration = time.Since(httpData.StartedAt).Milliseconds()
+		httpData.Peer = peer
+
+		s := status.Convert(err)
+		httpData.StatusCode = httpStatus(s.Code())
+		httpData.Response.Header = header(metadata.Join(c.header, c.trailer))
+		httpData.Response.Header.Set("Grpc-Status", strconv.Itoa(int(s.Code())))
+		if s.Message() != "" {
+			httpData.Response.Header.Set("Grpc-Message", s.Message())
+		}
+
+		httpData.Request.Body = c.body(c.requests)
+		httpData.Response.Body = c.body(c.replies)
+		httpData.Request.Truncated = c.requestsTruncated
+		httpData.Response.Truncated = c.repliesTruncated
+		c.mu.Unlock()
+
+		i.process(ctx, httpData)
+	})
+}
+
+// body is the message of a unary side, or the array of the messages of a stream.
+func (c *call) body(messages []json.RawMessage) []byte {
+	if !c.stream {
+		if len(messages) == 0 {
+			return nil
+		}
+
+		return messages[0]
+	}
+
+	if len(messages) == 0 {
+		return []byte("[]")
+	}
+
+	b, _ := json.Marshal(messages)
+	return b
+}
+
+func (i *Interceptor) process(ctx context.Context, httpData *hachibi.HttpData) {
+	if i.preProcessor != nil {
+		if err := i.preProcessor.PreProcess(ctx, httpData); err != nil {
+			httpData.AppendError(errors.Wrap(err, "pre process error"))
+		}
+	}
+
+	if i.processor != nil {
+		if err := i.processor.Process(ctx, httpData); err != nil {
+			httpData.AppendError(errors.Wrap(err, "process error"))
+		}
+	}
+
+	if httpData.Error != nil && i.errorHandler != nil {
+		i.errorHandler.ErrorHandle(ctx, httpData.Error)
+	}
+}
+
+// header converts metadata to headers, binary values are base64 encoded like on the wire.
+func header(md metadata.MD) http.Header {
+	h := make(http.Header, len(md))
+	for key, values := range md {
+		for _, value := range values {
+			if strings.HasSuffix(key, "-bin") {
+				value = base64.RawStdEncoding.EncodeToString([]byte(value))
+			}
+			h.Add(key, value)
+		}
+	}
+
+	return h
+}
+
+// httpStatus maps a gRPC code to the closest HTTP status, like the gRPC gateways do.
+func httpStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		// nginx's "client closed request"
+		return 499
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,193 @@
+package hachibigrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mtfiqh/hachibi"
+	"github.com/mtfiqh/hachibi/adapters/hachibigrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type redactor struct{}
+
+func (redactor) PreProcess(ctx context.Context, httpData *hachibi.HttpData) error {
+	httpData.Request.Header.Set("Authorization", "redacted")
+	return nil
+}
+
+func TestInterceptor(t *testing.T) {
+	serverRecorder := hachibi.NewMemoryRecorder(0)
+	clientRecorder := hachibi.NewMemoryRecorder(0)
+	server := hachibigrpc.NewInterceptor(hachibigrpc.InterceptorWithProcessor(serverRecorder), hachibigrpc.InterceptorWithEventName("inbound"))
+	client := hachibigrpc.NewInterceptor(hachibigrpc.InterceptorWithProcessor(clientRecorder), hachibigrpc.InterceptorWithPreProcessor(redactor{}))
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("users", healthpb.HealthCheckResponse_SERVING)
+
+	l := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(server.UnaryServer(), func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			grpc.SetHeader(ctx, metadata.Pairs("x-served-by", "users-1"))
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(server.StreamServer()),
+	)
+	healthpb.RegisterHealthServer(s, healthServer)
+	go s.Serve(l)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return l.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(client.UnaryClient()),
+		grpc.WithStreamInterceptor(client.StreamClient()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	healthClient := healthpb.NewHealthClient(conn)
+
+	// the server captures once the handler returns, the client may see the reply first
+	last := func(t *testing.T, recorder *hachibi.MemoryRecorder, route string) hachibi.HttpData {
+		t.Helper()
+
+		for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			it, _ := recorder.Query(context.Background(), hachibi.Filter{Route: route, Limit: 1})
+			if records, _ := hachibi.Collect(it); len(records) == 1 {
+				return records[0]
+			}
+		}
+
+		t.Fatalf("%s not captured", route)
+		return hachibi.HttpData{}
+	}
+
+	t.Run("unary", func(t *testing.T) {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer secret", "x-request-id", "req-42")
+		if _, err := healthClient.Check(ctx, &healthpb.HealthCheckRequest{Service: "users"}); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, got := range []hachibi.HttpData{last(t, clientRecorder, "/grpc.health.v1.Health/Check"), last(t, serverRecorder, "/grpc.health.v1.Health/Check")} {
+			if got.Method != http.MethodPost || got.URL != "/grpc.health.v1.Health/Check" || got.StatusCode != http.StatusOK || got.Peer == "" {
+				t.Fatalf("unexpected record %+v", got)
+			}
+
+			if string(got.Request.Body) != `{"service":"users"}` || string(got.Response.Body) != `{"status":"SERVING"}` {
+				t.Fatalf("unexpected bodies %s %s", got.Request.Body, got.Response.Body)
+			}
+
+			if got.Request.Header.Get("X-Request-Id") != "req-42" || got.Response.Header.Get("X-Served-By") != "users-1" || got.Response.Header.Get("Grpc-Status") != "0" {
+				t.Fatalf("unexpected headers %v %v", got.Request.Header, got.Response.Header)
+			}
+		}
+
+		if got := last(t, clientRecorder, "/grpc.health.v1.Health/Check"); got.Request.Header.Get("Authorization") != "redacted" || got.Event != "" {
+			t.Fatalf("the client must be pre-processed %+v", got)
+		}
+
+		if got := last(t, serverRecorder, "/grpc.health.v1.Health/Check"); got.Request.Header.Get("Authorization") != "Bearer secret" || got.Event != "inbound" {
+			t.Fatalf("unexpected server record %+v", got)
+		}
+	})
+
+	t.Run("unary error", func(t *testing.T) {
+		_, err := healthClient.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "orders"})
+		if status.Code(err) != codes.NotFound {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		got := last(t, clientRecorder, "/grpc.health.v1.Health/Check")
+		if got.StatusCode != http.StatusNotFound || got.Response.Header.Get("Grpc-Status") != "5" || got.Response.Header.Get("Grpc-Message") != "unknown service" || got.Response.Body != nil {
+			t.Fatalf("unexpected record %+v", got)
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		stream, err := healthClient.Watch(ctx, &healthpb.HealthCheckRequest{Service: "users"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := stream.Recv(); err != nil {
+			t.Fatal(err)
+		}
+
+		healthServer.SetServingStatus("users", healthpb.HealthCheckResponse_NOT_SERVING)
+		if _, err := stream.Recv(); err != nil {
+			t.Fatal(err)
+		}
+
+		cancel()
+		if _, err := stream.Recv(); status.Code(err) != codes.Canceled {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		for _, got := range []hachibi.HttpData{last(t, clientRecorder, "/grpc.health.v1.Health/Watch"), last(t, serverRecorder, "/grpc.health.v1.Health/Watch")} {
+			var replies []map[string]string
+			json.Unmarshal(got.Response.Body, &replies)
+			if len(replies) != 2 || replies[0]["status"] != "SERVING" || replies[1]["status"] != "NOT_SERVING" {
+				t.Fatalf("unexpected replies %s", got.Response.Body)
+			}
+
+			if string(got.Request.Body) != `[{"service":"users"}]` || got.StatusCode != 499 {
+				t.Fatalf("unexpected record %+v", got)
+			}
+		}
+	})
+
+	t.Run("stream truncated", func(t *testing.T) {
+		recorder := hachibi.NewMemoryRecorder(0)
+		capped := hachibigrpc.NewInterceptor(hachibigrpc.InterceptorWithProcessor(recorder), hachibigrpc.InterceptorWithMaxStreamMessages(1))
+		conn, err := grpc.NewClient("passthrough:///bufnet",
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return l.DialContext(ctx) }),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithStreamInterceptor(capped.StreamClient()),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		stream, err := healthpb.NewHealthClient(conn).Watch(ctx, &healthpb.HealthCheckRequest{Service: "users"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, s := range []healthpb.HealthCheckResponse_ServingStatus{healthpb.HealthCheckResponse_SERVING, healthpb.HealthCheckResponse_NOT_SERVING} {
+			healthServer.SetServingStatus("users", s)
+			if _, err := stream.Recv(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		cancel()
+		stream.Recv()
+
+		got := last(t, recorder, "/grpc.health.v1.Health/Watch")
+		var replies []json.RawMessage
+		json.Unmarshal(got.Response.Body, &replies)
+		if len(replies) != 1 || !got.Response.Truncated || got.Request.Truncated {
+			t.Fatalf("unexpected record %s %+v", got.Response.Body, got)
+		}
+	})
+}
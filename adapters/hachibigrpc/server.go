@@ -0,0 +1,144 @@
+package hachibigrpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// UnaryServer captures the unary calls received by a server.
+func (i *Interceptor) UnaryServer() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		c := i.newCall(info.FullMethod, md, false)
+		c.request(req)
+
+		ctx = withServerTransportStream(ctx, c)
+		res, err := handler(ctx, req)
+		if err == nil {
+			c.reply(res)
+		}
+
+		i.finish(ctx, c, serverPeer(ctx), err)
+		return res, err
+	}
+}
+
+// StreamServer captures the streaming calls received by a server, once their handler returns.
+func (i *Interceptor) StreamServer() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := ss.Context()
+		md, _ := metadata.FromIncomingContext(ctx)
+		c := i.newCall(info.FullMethod, md, true)
+
+		ctx = withServerTransportStream(ctx, c)
+		err := handler(srv, &serverStream{ServerStream: ss, ctx: ctx, call: c})
+
+		i.finish(ctx, c, serverPeer(ctx), err)
+		return err
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	call *call
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *serverStream) SetHeader(md metadata.MD) error {
+	if err := s.ServerStream.SetHeader(md); err != nil {
+		return err
+	}
+
+	s.call.setHeader(md)
+	return nil
+}
+
+func (s *serverStream) SendHeader(md metadata.MD) error {
+	if err := s.ServerStream.SendHeader(md); err != nil {
+		return err
+	}
+
+	s.call.setHeader(md)
+	return nil
+}
+
+func (s *serverStream) SetTrailer(md metadata.MD) {
+	s.ServerStream.SetTrailer(md)
+	s.call.setTrailer(md)
+}
+
+func (s *serverStream) SendMsg(m any) error {
+	if err := s.ServerStream.SendMsg(m); err != nil {
+		return err
+	}
+
+	s.call.reply(m)
+	return nil
+}
+
+func (s *serverStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	s.call.request(m)
+	return nil
+}
+
+// withServerTransportStream records the metadata the handlers set with grpc.SetHeader, grpc.SendHeader and
+// grpc.SetTrailer.
+func withServerTransportStream(ctx context.Context, c *call) context.Context {
+	stream := grpc.ServerTransportStreamFromContext(ctx)
+	if stream == nil {
+		return ctx
+	}
+
+	return grpc.NewContextWithServerTransportStream(ctx, &transportStream{ServerTransportStream: stream, call: c})
+}
+
+type transportStream struct {
+	grpc.ServerTransportStream
+	call *call
+}
+
+func (s *transportStream) SetHeader(md metadata.MD) error {
+	if err := s.ServerTransportStream.SetHeader(md); err != nil {
+		return err
+	}
+
+	s.call.setHeader(md)
+	return nil
+}
+
+func (s *transportStream) SendHeader(md metadata.MD) error {
+	if err := s.ServerTransportStream.SendHeader(md); err != nil {
+		return err
+	}
+
+	s.call.setHeader(md)
+	return nil
+}
+
+func (s *transportStream) SetTrailer(md metadata.MD) error {
+	if err := s.ServerTransportStream.SetTrailer(md); err != nil {
+		return err
+	}
+
+	s.call.setTrailer(md)
+	return nil
+}
+
+func serverPeer(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+
+	return ""
+}
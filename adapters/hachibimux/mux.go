@@ -0,0 +1,51 @@
+// Package hachibimux captures the traffic of a gorilla/mux router with its path templates.
+//
+//	r := mux.NewRouter()
+//	r.Handle("/users", hachibimux.Event("register")(register)).Methods(http.MethodPost)
+//	http.ListenAndServe(":8080", hachibimux.Handler(m, r))
+package hachibimux
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mtfiqh/hachibi"
+)
+
+// Handler captures every request of router with m, HttpData.Route is the template of the matched route,
+// e.g. /users/{id}, and stays empty for the requests answered 404 or 405.
+func Handler(m *hachibi.Middleware, router *mux.Router) http.Handler {
+	router.Use(Route)
+	return m.Handler(router)
+}
+
+// Route sets HttpData.Route from the matched route, for Router.Use when the router is inside the Middleware.
+func Route(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if route := mux.CurrentRoute(request); route != nil {
+			if template, err := route.GetPathTemplate(); err == nil {
+				hachibi.SetRouteInMiddlewareCtx(request.Context(), template)
+			}
+		}
+
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// Middleware captures the requests with m for Router.Use, which only runs once a route is matched:
+// the requests answered 404 or 405 by the router aren't captured, see Handler to capture them too.
+func Middleware(m *hachibi.Middleware) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return m.Handler(Route(next))
+	}
+}
+
+// Event names the event of the captures of the handler it wraps.
+func Event(event string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			hachibi.SetEventInMiddlewareCtx(request.Context(), event)
+			next.ServeHTTP(writer, request)
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package hachibimux_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mtfiqh/hachibi"
+	"github.com/mtfiqh/hachibi/adapters/hachibimux"
+)
+
+func TestMiddleware(t *testing.T) {
+	recorder := hachibi.NewMemoryRecorder(0)
+	m := hachibi.NewMiddleware(hachibi.MiddlewareWithProcessor(recorder))
+
+	r := mux.NewRouter()
+	r.Use(hachibimux.Middleware(m))
+	r.Handle("/users/{id}", hachibimux.Event("user")(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusAccepted)
+		io.WriteString(writer, "user "+mux.Vars(request)["id"])
+	}))).Methods(http.MethodPut)
+
+	res := httptest.NewRecorder()
+	r.ServeHTTP(res, httptest.NewRequest(http.MethodPut, "/users/42", nil))
+
+	// Router.Use doesn't run for the requests matching no route
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/unknown", nil))
+
+	it, _ := recorder.Query(context.Background(), hachibi.Filter{})
+	records, _ := hachibi.Collect(it)
+	if len(records) != 1 {
+		t.Fatalf("unexpected records %+v", records)
+	}
+
+	got := records[0]
+	if got.Route != "/users/{id}" || got.Event != "user" || got.StatusCode != http.StatusAccepted || string(got.Response.Body) != "user 42" || res.Body.String() != "user 42" {
+		t.Fatalf("unexpected record %+v", got)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	recorder := hachibi.NewMemoryRecorder(0)
+	m := hachibi.NewMiddleware(hachibi.MiddlewareWithProcessor(recorder))
+
+	r := mux.NewRouter()
+	handler := hachibimux.Handler(m, r)
+	r.Handle("/users/{id}", http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		io.WriteString(writer, "user "+mux.Vars(request)["id"])
+	})).Methods(http.MethodPut)
+
+	for _, target := range []struct{ method, path string }{
+		{http.MethodPut, "/users/42"},
+		{http.MethodGet, "/users/42"},
+		{http.MethodGet, "/unknown"},
+	} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(target.method, target.path, nil))
+	}
+
+	it, _ := recorder.Query(context.Background(), hachibi.Filter{})
+	records, _ := hachibi.Collect(it)
+	routes := make(map[int]string)
+	for _, record := range records {
+		routes[record.StatusCode] = record.Route
+	}
+
+	want := map[int]string{http.StatusOK: "/users/{id}", http.StatusMethodNotAllowed: "", http.StatusNotFound: ""}
+	if len(records) != 3 || !reflect.DeepEqual(routes, want) {
+		t.Fatalf("unexpected records %+v", records)
+	}
+}
@@ -0,0 +1,114 @@
+package hachibi
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// BlobStore keeps the content that is too big to be stored inside a capture, e.g. uploaded files.
+type BlobStore interface {
+	// Put stores the content of r under key and returns the reference to open it later.
+	Put(ctx context.Context, key string, contentType string, r io.Reader) (string, error)
+	Open(ctx context.Context, ref string) (io.ReadCloser, error)
+}
+
+var unsafeBlobChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// newBlobKey returns a unique key keeping the file name readable.
+func newBlobKey(prefix, fileName string) string {
+	name := unsafeBlobChars.ReplaceAllString(filepath.Base(fileName), "_")
+	if name == "" || name == "." || name == "_" {
+		name = "blob"
+	}
+
+	return prefix + "/" + uuid.New().String() + "/" + name
+}
+
+type FileBlobStore struct {
+	dir string
+}
+
+// NewFileBlobStore stores blobs as files under dir, references are file:// URLs.
+func NewFileBlobStore(dir string) (*FileBlobStore, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(abs, 0o755); err != nil {
+		return nil, errors.Wrap(err, "failed to create blob directory")
+	}
+
+	return &FileBlobStore{dir: abs}, nil
+}
+
+func (s *FileBlobStore) Put(ctx context.Context, key string, contentType string, r io.Reader) (string, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", errors.Wrap(err, "failed to create blob directory")
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create blob")
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(path)
+		return "", errors.Wrap(err, "failed to write blob")
+	}
+
+	if err := f.Close(); err != nil {
+		return "", errors.Wrap(err, "failed to write blob")
+	}
+
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String(), nil
+}
+
+func (s *FileBlobStore) Open(ctx context.Context, ref string) (io.ReadCloser, error) {
+	u, err := url.Parse(ref)
+	if err != nil || u.Scheme != "file" {
+		return nil, errors.Errorf("invalid file blob reference %q", ref)
+	}
+
+	path, ok := s.inside(filepath.FromSlash(u.Path))
+	if !ok {
+		return nil, errors.Errorf("blob %q is outside of the store", ref)
+	}
+
+	return os.Open(path)
+}
+
+func (s *FileBlobStore) path(key string) (string, error) {
+	path, ok := s.inside(filepath.Join(s.dir, filepath.FromSlash(key)))
+	if !ok {
+		return "", errors.Errorf("invalid blob key %q", key)
+	}
+
+	return path, nil
+}
+
+// inside cleans path and checks it is a file under the directory of the store, ".." can't leave it.
+func (s *FileBlobStore) inside(path string) (string, bool) {
+	path = filepath.Clean(path)
+
+	rel, err := filepath.Rel(s.dir, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+
+	return filepath.Join(s.dir, rel), true
+}
@@ -0,0 +1,311 @@
+package hachibi
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const DefaultS3Region = "us-east-1"
+
+// S3BlobStore stores blobs in an S3 compatible object storage (AWS S3, MinIO, GCS interoperability, ...),
+// requests are signed with AWS signature version 4. References are s3://bucket/key URLs.
+type S3BlobStore struct {
+	endpoint *url.URL
+	bucket   string
+	region   string
+
+	accessKey    string
+	secretKey    string
+	sessionToken string
+
+	virtualHost bool
+	client      *http.Client
+}
+
+type S3Opt func(*S3BlobStore)
+
+func S3WithRegion(region string) S3Opt {
+	return func(s *S3BlobStore) {
+		s.region = region
+	}
+}
+
+func S3WithCredentials(accessKey, secretKey string) S3Opt {
+	return func(s *S3BlobStore) {
+		s.accessKey = accessKey
+		s.secretKey = secretKey
+	}
+}
+
+// S3WithSessionToken sends the token of temporary credentials (e.g. from STS or an instance role) with every request.
+func S3WithSessionToken(token string) S3Opt {
+	return func(s *S3BlobStore) {
+		s.sessionToken = token
+	}
+}
+
+// S3WithVirtualHost addresses the bucket as a sub domain of the endpoint instead of the first path segment.
+func S3WithVirtualHost() S3Opt {
+	return func(s *S3BlobStore) {
+		s.virtualHost = true
+	}
+}
+
+func S3WithHTTPClient(client *http.Client) S3Opt {
+	return func(s *S3BlobStore) {
+		s.client = client
+	}
+}
+
+func NewS3BlobStore(endpoint string, bucket string, opts ...S3Opt) (*S3BlobStore, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, errors.Errorf("invalid s3 endpoint %q", endpoint)
+	}
+
+	if bucket == "" {
+		return nil, errors.New("s3 bucket is required")
+	}
+
+	s := &S3BlobStore{
+		endpoint: u,
+		bucket:   bucket,
+		region:   DefaultS3Region,
+		client:   http.DefaultClient,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s, nil
+}
+
+// Put spools the content to a temporary file first, S3 needs the length and checksum before the upload.
+func (s *S3BlobStore) Put(ctx context.Context, key string, contentType string, r io.Reader) (string, error) {
+	tmp, err := os.CreateTemp("", "hachibi-blob-*")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create temporary blob")
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), r)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to spool blob")
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key), io.NopCloser(tmp))
+	if err != nil {
+		return "", err
+	}
+
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	s.Sign(req, hex.EncodeToString(hash.Sum(nil)), time.Now())
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to upload blob")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return "", errors.Errorf("failed to upload blob: %s %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return "s3://" + s.bucket + "/" + key, nil
+}
+
+func (s *S3BlobStore) Open(ctx context.Context, ref string) (io.ReadCloser, error) {
+	key := strings.TrimPrefix(ref, "s3://"+s.bucket+"/")
+	if key == ref || key == "" {
+		return nil, errors.Errorf("invalid s3 blob reference %q", ref)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.objectURL(key), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Sign(req, emptyPayloadHash, time.Now())
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to download blob")
+	}
+
+	if res.StatusCode/100 != 2 {
+		res.Body.Close()
+		return nil, errors.Errorf("failed to download blob: %s", res.Status)
+	}
+
+	return res.Body, nil
+}
+
+func (s *S3BlobStore) objectURL(key string) string {
+	u := *s.endpoint
+	if s.virtualHost {
+		u.Host = s.bucket + "." + u.Host
+		u.Path = "/" + key
+	} else {
+		u.Path = "/" + s.bucket + "/" + key
+	}
+
+	u.RawPath = ""
+	return u.Scheme + "://" + u.Host + s3Escape(u.Path)
+}
+
+// emptyPayloadHash is the SHA-256 of an empty body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Sign signs req with AWS signature version 4 at now, payloadHash is the hex SHA-256 of the body.
+// Without credentials only the x-amz headers are set.
+func (s *S3BlobStore) Sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	if s.accessKey == "" {
+		return
+	}
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           amzDate,
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		headers["content-type"] = ct
+	}
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+		headers["x-amz-security-token"] = s.sessionToken
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := strings.Builder{}
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3Escape(req.URL.Path),
+		canonicalQuery(req.URL.RawQuery),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, s.region)
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+// canonicalQuery sorts the parameters and encodes them as signature v4 requires, a space is %20 and not "+".
+func canonicalQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+
+	params := make([][2]string, 0)
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+
+		name, value, _ := strings.Cut(pair, "=")
+		if n, err := url.QueryUnescape(name); err == nil {
+			name = n
+		}
+		if v, err := url.QueryUnescape(value); err == nil {
+			value = v
+		}
+
+		params = append(params, [2]string{awsEscape(name, false), awsEscape(value, false)})
+	}
+
+	// sorted by name then value
+	sort.Slice(params, func(i, j int) bool {
+		if params[i][0] != params[j][0] {
+			return params[i][0] < params[j][0]
+		}
+		return params[i][1] < params[j][1]
+	})
+
+	encoded := make([]string, len(params))
+	for i, p := range params {
+		encoded[i] = p[0] + "=" + p[1]
+	}
+
+	return strings.Join(encoded, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape encodes everything except the unreserved characters and "/" as required by signature v4.
+func s3Escape(path string) string {
+	return awsEscape(path, true)
+}
+
+func awsEscape(s string, keepSlash bool) string {
+	b := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && keepSlash) {
+			b.WriteByte(c)
+			continue
+		}
+
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+
+	return b.String()
+}
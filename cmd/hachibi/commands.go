@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mtfiqh/hachibi"
+	"github.com/pkg/errors"
+)
+
+func list(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) error {
+	var (
+		src     source
+		filters filterFlags
+		flags   = flag.NewFlagSet("list", flag.ContinueOnError)
+		limit   = flags.Int("limit", 50, "maximum number of captures, 0 for all")
+		noColor = flags.Bool("no-color", false, "disable colors")
+	)
+	src.register(flags)
+	filters.register(flags)
+
+	if err := parse(flags, args, stderr, "[flags]"); err != nil {
+		return err
+	}
+
+	records, err := query(ctx, &src, &filters, *limit)
+	if err != nil {
+		return err
+	}
+
+	p := newPrinter(stdout, *noColor)
+	for i := range records {
+		p.summary(&records[i])
+	}
+
+	return nil
+}
+
+func show(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) error {
+	var (
+		src     source
+		flags   = flag.NewFlagSet("show", flag.ContinueOnError)
+		asJSON  = flags.Bool("json", false, "print the capture as JSON")
+		noColor = flags.Bool("no-color", false, "disable colors")
+	)
+	src.register(flags)
+
+	if err := parse(flags, args, stderr, "[flags] <id>"); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errUsage
+	}
+
+	store, closeStore, err := src.open()
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	records, err := collect(ctx, store, hachibi.Filter{ID: flags.Arg(0), Limit: 1})
+	if err != nil {
+		return err
+	}
+
+	if len(records) == 0 {
+		return errors.Errorf("capture %s not found", flags.Arg(0))
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records[0])
+	}
+
+	newPrinter(stdout, *noColor).detail(&records[0])
+	return nil
+}
+
+func export(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) error {
+	var (
+		src     source
+		filters filterFlags
+		flags   = flag.NewFlagSet("export", flag.ContinueOnError)
+		format  = flags.String("format", "har", "har, curl or csv")
+		limit   = flags.Int("limit", 0, "maximum number of captures, 0 for all")
+		output  = flags.String("o", "", "output file, stdout by default")
+	)
+	src.register(flags)
+	filters.register(flags)
+
+	if err := parse(flags, args, stderr, "[flags]"); err != nil {
+		return err
+	}
+
+	write, ok := map[string]func(io.Writer, []hachibi.HttpData) error{
+		"har":  writeHAR,
+		"curl": writeCurl,
+		"csv":  writeCSV,
+	}[*format]
+	if !ok {
+		return errors.Wrapf(errUsage, "invalid format %q", *format)
+	}
+
+	records, err := query(ctx, &src, &filters, *limit)
+	if err != nil {
+		return err
+	}
+
+	return writeOutput(*output, stdout, func(w io.Writer) error {
+		return write(w, records)
+	})
+}
+
+// writeOutput writes to the file at path, or to stdout when path is empty.
+func writeOutput(path string, stdout io.Writer, write func(io.Writer) error) error {
+	if path == "" {
+		return write(stdout)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to create output")
+	}
+
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func query(ctx context.Context, src *source, filters *filterFlags, limit int) ([]hachibi.HttpData, error) {
+	filter, err := filters.filter()
+	if err != nil {
+		return nil, err
+	}
+	filter.Limit = limit
+
+	store, closeStore, err := src.open()
+	if err != nil {
+		return nil, err
+	}
+	defer closeStore()
+
+	return collect(ctx, store, filter)
+}
+
+func writeHAR(w io.Writer, records []hachibi.HttpData) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(hachibi.NewHAR(records...))
+}
+
+// writeCurl writes a shell script replaying the requests.
+func writeCurl(w io.Writer, records []hachibi.HttpData) error {
+	if _, err := io.WriteString(w, "#!/bin/sh\n"); err != nil {
+		return err
+	}
+
+	for _, r := range records {
+		// a line break in the comment would start a command
+		comment := strings.TrimSpace(fmt.Sprintf("%s %s %d %s", r.ID, r.StartedAt.Format(time.RFC3339), r.StatusCode, r.Event))
+		comment = strings.NewReplacer("\r", " ", "\n", " ").Replace(comment)
+		_, err := fmt.Fprintf(w, "\n# %s\n%s\n", comment, r.Curl())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeCSV(w io.Writer, records []hachibi.HttpData) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"id", "started_at", "method", "url", "status_code", "duration_ms", "event", "request_size", "response_size", "errors"})
+
+	for _, r := range records {
+		errs := make([]string, 0, len(r.Error))
+		for _, e := range r.Error {
+			errs = append(errs, e.Error())
+		}
+
+		writer.Write([]string{
+			r.ID,
+			r.StartedAt.Format(time.RFC3339Nano),
+			r.Method,
+			r.URL,
+			strconv.Itoa(r.StatusCode),
+			strconv.FormatInt(r.Duration, 10),
+			r.Event,
+			strconv.Itoa(len(r.Request.Body)),
+			strconv.Itoa(len(r.Response.Body)),
+			strings.Join(errs, "; "),
+		})
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/mtfiqh/hachibi"
+	"github.com/pkg/errors"
+)
+
+const (
+	caCertFile = "ca.pem"
+	caKeyFile  = "ca-key.pem"
+)
+
+func forward(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) error {
+	var (
+		sinks     sinkFlags
+		flags     = flag.NewFlagSet("forward", flag.ContinueOnError)
+		listen    = flags.String("listen", "127.0.0.1:8888", "address the proxy listens on")
+		intercept = flags.Bool("intercept", false, "intercept HTTPS with certificates signed by a local CA")
+		caDir     = flags.String("ca-dir", defaultCADir(), "directory of the CA, it's generated on first use")
+		insecure  = flags.Bool("insecure", false, "skip the verification of the upstream certificates")
+	)
+	sinks.register(flags)
+
+	if err := parse(flags, args, stderr, "[flags]"); err != nil {
+		return err
+	}
+
+	processor, closeSinks, err := sinks.processor(ctx, stdout)
+	if err != nil {
+		return err
+	}
+	defer closeSinks()
+
+	rules, err := sinks.loadRules()
+	if err != nil {
+		return err
+	}
+
+	transportOpts := []hachibi.TransportOpt{hachibi.TransportWithProcessor(processor), hachibi.TransportWithEventName(sinks.event)}
+	if rules != nil {
+		transportOpts = append(transportOpts, hachibi.TransportWithRules(rules))
+	}
+	if *insecure {
+		transportOpts = append(transportOpts, hachibi.TransportWithRoundTripper(insecureTransport()))
+	}
+
+	opts := []hachibi.ForwardProxyOpt{hachibi.ForwardProxyWithTransport(transportOpts...)}
+	if *intercept {
+		ca, created, err := loadOrCreateCA(*caDir)
+		if err != nil {
+			return err
+		}
+
+		if created {
+			fmt.Fprintf(stderr, "hachibi: generated a CA, trust %s in the clients to intercept HTTPS\n", filepath.Join(*caDir, caCertFile))
+		}
+
+		opts = append(opts, hachibi.ForwardProxyWithInterception(ca))
+	}
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return errors.Wrap(err, "failed to listen")
+	}
+
+	fmt.Fprintf(stderr, "hachibi: forward proxy on %s, e.g. HTTPS_PROXY=http://%s\n", l.Addr(), l.Addr())
+	return serve(ctx, l, hachibi.NewForwardProxy(opts...))
+}
+
+func defaultCADir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ".hachibi"
+	}
+
+	return filepath.Join(dir, "hachibi")
+}
+
+// loadOrCreateCA reads the CA of dir, a new one is written when there is none.
+func loadOrCreateCA(dir string) (*hachibi.CertificateAuthority, bool, error) {
+	certPath, keyPath := filepath.Join(dir, caCertFile), filepath.Join(dir, caKeyFile)
+
+	certPEM, errCert := os.ReadFile(certPath)
+	keyPEM, errKey := os.ReadFile(keyPath)
+	if errCert == nil && errKey == nil {
+		ca, err := hachibi.LoadCertificateAuthority(certPEM, keyPEM)
+		return ca, false, err
+	}
+
+	if !os.IsNotExist(errCert) || !os.IsNotExist(errKey) {
+		return nil, false, errors.Errorf("incomplete CA in %s, remove it to generate a new one", dir)
+	}
+
+	ca, err := hachibi.NewCertificateAuthority()
+	if err != nil {
+		return nil, false, err
+	}
+
+	if keyPEM, err = ca.KeyPEM(); err != nil {
+		return nil, false, err
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, false, errors.Wrap(err, "failed to create CA directory")
+	}
+
+	if err := os.WriteFile(keyPath, keyPEM, 0o600); err != nil {
+		return nil, false, errors.Wrap(err, "failed to write CA key")
+	}
+
+	if err := os.WriteFile(certPath, ca.CertificatePEM(), 0o644); err != nil {
+		return nil, false, errors.Wrap(err, "failed to write CA certificate")
+	}
+
+	return ca, true, nil
+}
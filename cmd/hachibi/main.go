@@ -0,0 +1,83 @@
+// Command hachibi reads the captures written by the JSON lines and Postgres stores, or captures the traffic of a
+// service as a proxy.
+//
+//	hachibi list -file captures.jsonl -status 5xx
+//	hachibi tail -f -postgres "dbname=hachibi sslmode=disable"
+//	hachibi show -file captures.jsonl 0b6f...
+//	hachibi export -file captures.jsonl -format har -since 1h > captures.har
+//	hachibi proxy -listen :8080 -upstream https://api.example.com -sink jsonl:captures.jsonl
+//	hachibi openapi -file captures.jsonl -url-prefix https://api.example.com > openapi.yaml
+//	hachibi forward -listen 127.0.0.1:8888 -intercept -sink stdout
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+const usage = `usage: hachibi <command> [flags]
+
+commands:
+  list     print a one-line summary of the matching captures
+  tail     print the last captures, -f follows the new ones
+  show     pretty-print one capture
+  export   convert the matching captures to HAR, a cURL script or CSV
+  openapi  infer an OpenAPI document from the matching captures
+  proxy    capture the traffic of a service as a reverse proxy
+  forward  capture the traffic of clients as an HTTP(S) forward proxy
+
+run "hachibi <command> -h" for the flags of a command.
+`
+
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	switch {
+	case err == nil || errors.Is(err, flag.ErrHelp):
+	case err == errUsage:
+		// the usage has been written
+		os.Exit(2)
+	case errors.Is(err, errUsage):
+		fmt.Fprintln(os.Stderr, "hachibi:", err)
+		os.Exit(2)
+	default:
+		fmt.Fprintln(os.Stderr, "hachibi:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return errUsage
+	}
+
+	commands := map[string]func(context.Context, []string, io.Writer, io.Writer) error{
+		"list":    list,
+		"tail":    tail,
+		"show":    show,
+		"export":  export,
+		"openapi": inferOpenAPI,
+		"proxy":   proxy,
+		"forward": forward,
+	}
+
+	command, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", args[0], usage)
+		return errUsage
+	}
+
+	return command(ctx, args[1:], stdout, stderr)
+}
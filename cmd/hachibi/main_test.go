@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mtfiqh/hachibi"
+)
+
+// syncBuffer is written by a following tail while the test reads it.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.Write(p)
+}
+
+func (s *syncBuffer) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.String()
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "captures.jsonl")
+	store := hachibi.NewJSONLinesStore(path)
+	defer store.Close()
+
+	now := time.Now().Truncate(time.Millisecond)
+	records := []hachibi.HttpData{
+		{ID: "a", StartedAt: now.Add(-3 * time.Minute), Method: http.MethodGet, URL: "http://api/users", StatusCode: 200, Duration: 15},
+		{
+			ID: "b", StartedAt: now.Add(-2 * time.Minute), Method: http.MethodPost, URL: "http://api/users", StatusCode: 502, Duration: 900, Event: "register",
+			Request: hachibi.Request{Payload: hachibi.Payload{Header: http.Header{"Content-Type": {"application/json"}}, Body: []byte(`{"name":"taufiq"}`)}},
+			Error:   hachibi.Error{errors.New("process error: database is down")},
+		},
+		{ID: "c", StartedAt: now.Add(-time.Minute), Method: http.MethodDelete, URL: "http://api/users/1", StatusCode: 404, Duration: 40},
+	}
+	for i := range records {
+		store.Process(ctx, &records[i])
+	}
+
+	exec := func(t *testing.T, args ...string) string {
+		t.Helper()
+
+		var stdout, stderr bytes.Buffer
+		if err := run(ctx, args, &stdout, &stderr); err != nil {
+			t.Fatalf("%v: %v %s", args, err, stderr.String())
+		}
+
+		return stdout.String()
+	}
+
+	lines := func(s string) []string {
+		return strings.Split(strings.TrimSpace(s), "\n")
+	}
+
+	t.Run("list", func(t *testing.T) {
+		cases := []struct {
+			args []string
+			want []string
+		}{
+			{args: nil, want: []string{"http://api/users/1", "http://api/users [register] 1 error(s)", "http://api/users"}},
+			{args: []string{"-status", "5xx"}, want: []string{"POST    502    900ms http://api/users [register] 1 error(s)"}},
+			{args: []string{"-method", "delete"}, want: []string{"http://api/users/1"}},
+			{args: []string{"-min-duration", "30ms", "-max-duration", "1s", "-sort", "slowest"}, want: []string{"900ms", "40ms"}},
+			{args: []string{"-url-prefix", "http://api/users/"}, want: []string{"DELETE"}},
+			{args: []string{"-limit", "1", "-sort", "oldest"}, want: []string{"GET"}},
+		}
+
+		for _, c := range cases {
+			out := lines(exec(t, append([]string{"list", "-file", path}, c.args...)...))
+			if len(out) != len(c.want) {
+				t.Fatalf("%v: unexpected output %q", c.args, out)
+			}
+
+			for i, want := range c.want {
+				if !strings.Contains(out[i], want) {
+					t.Fatalf("%v: %q does not contain %q", c.args, out[i], want)
+				}
+			}
+
+			if strings.Contains(out[0], "\033[") {
+				t.Fatal("colors must be disabled when the output is not a terminal")
+			}
+		}
+	})
+
+	t.Run("show", func(t *testing.T) {
+		out := exec(t, "show", "-file", path, "b")
+		for _, want := range []string{"POST http://api/users", "502 Bad Gateway", "Content-Type: application/json", "  {\n    \"name\": \"taufiq\"\n  }", "process error: database is down"} {
+			if !strings.Contains(out, want) {
+				t.Fatalf("missing %q in\n%s", want, out)
+			}
+		}
+
+		var record hachibi.HttpData
+		if err := json.Unmarshal([]byte(exec(t, "show", "-file", path, "-json", "b")), &record); err != nil || record.ID != "b" {
+			t.Fatalf("unexpected json %v %+v", err, record)
+		}
+
+		if err := run(ctx, []string{"show", "-file", path, "unknown"}, &bytes.Buffer{}, &bytes.Buffer{}); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+
+	t.Run("export", func(t *testing.T) {
+		var har hachibi.HAR
+		if err := json.Unmarshal([]byte(exec(t, "export", "-file", path, "-format", "har", "-errors", "true")), &har); err != nil || len(har.Log.Entries) != 1 {
+			t.Fatalf("unexpected har %v %+v", err, har)
+		}
+
+		script := exec(t, "export", "-file", path, "-format", "curl", "-event", "register")
+		if !strings.HasPrefix(script, "#!/bin/sh\n\n# b ") || !strings.Contains(script, "curl -X 'POST' 'http://api/users'") {
+			t.Fatalf("unexpected script\n%s", script)
+		}
+
+		rows, err := csv.NewReader(strings.NewReader(exec(t, "export", "-file", path, "-format", "csv", "-sort", "oldest"))).ReadAll()
+		if err != nil || len(rows) != 4 || rows[0][0] != "id" || rows[2][0] != "b" || rows[2][5] != "900" || rows[2][7] != "17" || rows[2][9] != "process error: database is down" {
+			t.Fatalf("unexpected csv %v %v", err, rows)
+		}
+	})
+
+	t.Run("openapi", func(t *testing.T) {
+		document := exec(t, "openapi", "-file", path, "-title", "Users")
+		for _, want := range []string{"openapi: 3.1.0\n", "  title: Users\n", "  - url: http://api\n", "  /users/{id}:\n", "    delete:\n", "name:\n", "\"502\":\n"} {
+			if !strings.Contains(document, want) {
+				t.Fatalf("missing %q in\n%s", want, document)
+			}
+		}
+	})
+
+	t.Run("tail", func(t *testing.T) {
+		out := lines(exec(t, "tail", "-file", path, "-n", "2"))
+		if len(out) != 2 || !strings.Contains(out[0], "POST") || !strings.Contains(out[1], "DELETE") {
+			t.Fatalf("unexpected output %q", out)
+		}
+
+		ctx, cancel := context.WithCancel(ctx)
+		stdout := &syncBuffer{}
+		done := make(chan error)
+		go func() {
+			done <- run(ctx, []string{"tail", "-file", path, "-n", "0", "-f", "-interval", "10ms", "-method", "PUT"}, stdout, &bytes.Buffer{})
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		store.Process(ctx, &hachibi.HttpData{ID: "d", StartedAt: time.Now(), Method: http.MethodGet, URL: "http://api/ignored"})
+		store.Process(ctx, &hachibi.HttpData{ID: "e", StartedAt: time.Now(), Method: http.MethodPut, URL: "http://api/users/2", StatusCode: 200})
+
+		deadline := time.Now().Add(2 * time.Second)
+		for !strings.Contains(stdout.String(), "http://api/users/2") && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		cancel()
+
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+
+		if out := lines(stdout.String()); len(out) != 1 || !strings.Contains(out[0], "PUT") {
+			t.Fatalf("unexpected output %q", out)
+		}
+	})
+
+	t.Run("usage", func(t *testing.T) {
+		for _, args := range [][]string{nil, {"unknown"}, {"list"}, {"list", "-file", path, "-status", "abc"}, {"export", "-file", path, "-format", "xml"}} {
+			if err := run(ctx, args, &bytes.Buffer{}, &bytes.Buffer{}); !errors.Is(err, errUsage) {
+				t.Fatalf("%v: unexpected error %v", args, err)
+			}
+		}
+	})
+}
+
+func TestWriteCurl(t *testing.T) {
+	b := &bytes.Buffer{}
+	records := []hachibi.HttpData{{ID: "a\rtouch pwned", Method: http.MethodGet, URL: "http://api/users", Event: "signup\ntouch pwned"}}
+	if err := writeCurl(b, records); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range strings.Split(b.String(), "\n") {
+		if strings.Contains(line, "touch pwned") && !strings.HasPrefix(line, "# ") || strings.Contains(line, "\r") {
+			t.Fatalf("the comment must stay on its line\n%s", b)
+		}
+	}
+}
+
+func TestTailStore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	store := hachibi.NewMemoryRecorder(0)
+	now := time.Now()
+	store.Process(ctx, &hachibi.HttpData{ID: "before", StartedAt: now.Add(-time.Second)})
+
+	var mu sync.Mutex
+	var printed []string
+	emitted := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), printed...)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- tailStore(ctx, store, hachibi.Filter{}, 0, true, 10*time.Millisecond, time.Minute, func(r *hachibi.HttpData) {
+			mu.Lock()
+			printed = append(printed, r.ID)
+			mu.Unlock()
+		})
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	store.Process(ctx, &hachibi.HttpData{ID: "fast", StartedAt: time.Now()})
+	time.Sleep(50 * time.Millisecond)
+	// started before the fast one, stored after it was printed
+	store.Process(ctx, &hachibi.HttpData{ID: "slow", StartedAt: now.Add(10 * time.Millisecond)})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(emitted()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Join(emitted(), ","); got != "fast,slow" {
+		t.Fatalf("unexpected captures %s", got)
+	}
+}
+
+func TestProxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusAccepted)
+		io.WriteString(writer, "upstream "+request.URL.Path)
+	}))
+	defer upstream.Close()
+
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := l.Addr().String()
+	l.Close()
+
+	dir := t.TempDir()
+	rules := filepath.Join(dir, "rules.yaml")
+	os.WriteFile(rules, []byte("rules:\n  - match: {path: /health}\n    action: {skip: true}\n"), 0o644)
+	path := filepath.Join(dir, "captures.jsonl")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stdout := &syncBuffer{}
+	done := make(chan error)
+	go func() {
+		done <- run(ctx, []string{"proxy", "-listen", addr, "-upstream", upstream.URL, "-rules", rules, "-event", "third-party", "-sink", "file=jsonl:" + path, "-sink", "stdout"}, stdout, &bytes.Buffer{})
+	}()
+
+	var res *http.Response
+	var err error
+	for i := 0; i < 100; i++ {
+		if res, err = http.Get("http://" + addr + "/orders"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+
+	res, _ = http.Get("http://" + addr + "/health")
+	res.Body.Close()
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if res.StatusCode != http.StatusAccepted || string(body) != "upstream /orders" {
+		t.Fatalf("unexpected response %d %s", res.StatusCode, body)
+	}
+
+	if !strings.Contains(stdout.String(), "/orders [third-party]") || strings.Contains(stdout.String(), "/health") {
+		t.Fatalf("unexpected stdout %s", stdout.String())
+	}
+
+	out := new(bytes.Buffer)
+	if err := run(context.Background(), []string{"show", "-file", path, "-json", captureIDs(t, path)[0]}, out, &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var record hachibi.HttpData
+	json.Unmarshal(out.Bytes(), &record)
+	if record.Event != "third-party" || record.Upstream == nil || record.Upstream.URL != upstream.URL+"/orders" || string(record.Upstream.Response.Body) != "upstream /orders" {
+		t.Fatalf("unexpected record %s", out)
+	}
+
+	if err := run(context.Background(), []string{"proxy", "-upstream", "localhost"}, &bytes.Buffer{}, &bytes.Buffer{}); !errors.Is(err, errUsage) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+// captureIDs returns the IDs of the captures of a JSON lines file.
+func captureIDs(t *testing.T, path string) []string {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	ids := make([]string, 0)
+	hachibi.ReadJSONLines(f, func(httpData hachibi.HttpData) error {
+		ids = append(ids, httpData.ID)
+		return nil
+	})
+
+	return ids
+}
+
+func TestLoadOrCreateCA(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "ca")
+
+	ca, created, err := loadOrCreateCA(dir)
+	if err != nil || !created {
+		t.Fatalf("unexpected %v %v", created, err)
+	}
+
+	if info, err := os.Stat(filepath.Join(dir, caKeyFile)); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("unexpected key file %v %v", info, err)
+	}
+
+	loaded, created, err := loadOrCreateCA(dir)
+	if err != nil || created || !bytes.Equal(loaded.CertificatePEM(), ca.CertificatePEM()) {
+		t.Fatalf("unexpected %v %v", created, err)
+	}
+
+	os.Remove(filepath.Join(dir, caKeyFile))
+	if _, _, err := loadOrCreateCA(dir); err == nil {
+		t.Fatal("expected an error")
+	}
+}
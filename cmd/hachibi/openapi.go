@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+
+	"github.com/mtfiqh/hachibi/openapi"
+)
+
+func inferOpenAPI(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) error {
+	var (
+		src     source
+		filters filterFlags
+		flags   = flag.NewFlagSet("openapi", flag.ContinueOnError)
+		title   = flags.String("title", openapi.DefaultTitle, "title of the document")
+		version = flags.String("version", openapi.DefaultVersion, "version of the API")
+		maxEnum = flags.Int("max-enum", openapi.DefaultMaxEnum, "most distinct values of an enum, 0 disables the enums")
+		limit   = flags.Int("limit", 0, "maximum number of captures, 0 for all")
+		output  = flags.String("o", "", "output file, stdout by default")
+	)
+	src.register(flags)
+	filters.register(flags)
+
+	if err := parse(flags, args, stderr, "[flags]"); err != nil {
+		return err
+	}
+
+	records, err := query(ctx, &src, &filters, *limit)
+	if err != nil {
+		return err
+	}
+
+	inferrer := openapi.NewInferrer(openapi.InferrerWithTitle(*title), openapi.InferrerWithVersion(*version), openapi.InferrerWithMaxEnum(*maxEnum))
+	for i := range records {
+		inferrer.Add(&records[i])
+	}
+
+	return writeOutput(*output, stdout, inferrer.Document().WriteYAML)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mtfiqh/hachibi"
+)
+
+const (
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorCyan   = "\033[36m"
+	colorGray   = "\033[90m"
+	colorBold   = "\033[1m"
+)
+
+// printer writes captures, colored when the output is a terminal.
+type printer struct {
+	w     io.Writer
+	color bool
+}
+
+func newPrinter(w io.Writer, noColor bool) *printer {
+	return &printer{w: w, color: !noColor && os.Getenv("NO_COLOR") == "" && isTerminal(w)}
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (p *printer) paint(color string, s string) string {
+	if !p.color || color == "" {
+		return s
+	}
+
+	return color + s + colorReset
+}
+
+func statusColor(code int) string {
+	switch {
+	case code >= 500 || code == 0:
+		return colorRed
+	case code >= 400:
+		return colorYellow
+	case code >= 300:
+		return colorCyan
+	default:
+		return colorGreen
+	}
+}
+
+// summary writes a capture on one line: time, method, status, duration, URL, event and errors.
+func (p *printer) summary(r *hachibi.HttpData) {
+	status := "---"
+	if r.StatusCode > 0 {
+		status = fmt.Sprint(r.StatusCode)
+	}
+
+	line := []string{
+		p.paint(colorGray, r.StartedAt.Local().Format("2006-01-02 15:04:05.000")),
+		fmt.Sprintf("%-7s", r.Method),
+		p.paint(statusColor(r.StatusCode), status),
+		fmt.Sprintf("%6dms", r.Duration),
+		r.URL,
+	}
+
+	if r.Event != "" {
+		line = append(line, p.paint(colorCyan, "["+r.Event+"]"))
+	}
+
+	if len(r.Error) > 0 {
+		line = append(line, p.paint(colorRed, fmt.Sprintf("%d error(s)", len(r.Error))))
+	}
+
+	fmt.Fprintln(p.w, strings.Join(line, " "))
+}
+
+// detail pretty-prints a capture with its headers and bodies.
+func (p *printer) detail(r *hachibi.HttpData) {
+	field := func(name string, value any) {
+		fmt.Fprintf(p.w, "%s %v\n", p.paint(colorBold, fmt.Sprintf("%-9s", name)), value)
+	}
+
+	field("ID", r.ID)
+	field("Started", r.StartedAt.Local().Format(time.RFC3339Nano))
+	field("Request", r.Method+" "+r.URL)
+	field("Status", p.paint(statusColor(r.StatusCode), strings.TrimSpace(fmt.Sprintf("%d %s", r.StatusCode, http.StatusText(r.StatusCode)))))
+	field("Duration", fmt.Sprintf("%dms", r.Duration))
+	if r.Event != "" {
+		field("Event", r.Event)
+	}
+	if r.Route != "" {
+		field("Route", r.Route)
+	}
+	if r.Peer != "" {
+		field("Peer", r.Peer)
+	}
+
+	for _, v := range r.Violations {
+		field("Violation", p.paint(colorYellow, v.String()))
+	}
+
+	p.payload("Request", &r.Request.Payload)
+	p.payload("Response", &r.Response.Payload)
+
+	if len(r.Error) > 0 {
+		fmt.Fprintln(p.w, "\n"+p.paint(colorBold, "Errors"))
+		for _, e := range r.Error {
+			fmt.Fprintln(p.w, "  "+p.paint(colorRed, e.Error()))
+		}
+	}
+
+	if u := r.Upstream; u != nil {
+		fmt.Fprintln(p.w, "\n"+p.paint(colorBold, "Upstream"))
+		fmt.Fprintf(p.w, "  %s %s %s %dms\n", u.Method, u.URL, p.paint(statusColor(u.StatusCode), fmt.Sprint(u.StatusCode)), u.Duration)
+		for _, e := range u.Error {
+			fmt.Fprintln(p.w, "  "+p.paint(colorRed, e.Error()))
+		}
+	}
+
+	if len(r.PII) > 0 {
+		fmt.Fprintln(p.w, "\n"+p.paint(colorBold, "PII"))
+		for _, pii := range r.PII {
+			masked := ""
+			if pii.Masked {
+				masked = " (masked)"
+			}
+			fmt.Fprintf(p.w, "  %s %s%s\n", pii.Location, p.paint(colorYellow, pii.Kind), masked)
+		}
+	}
+
+	if len(r.Images) > 0 {
+		fmt.Fprintln(p.w, "\n"+p.paint(colorBold, "Images"))
+		for _, image := range r.Images {
+			fmt.Fprintf(p.w, "  %s %s %s %dx%d %d -> %d bytes\n", image.Location, image.Action, image.Format, image.Width, image.Height, image.OriginalSize, image.Size)
+		}
+	}
+}
+
+func (p *printer) payload(name string, payload *hachibi.Payload) {
+	fmt.Fprintln(p.w, "\n"+p.paint(colorBold, name))
+
+	keys := make([]string, 0, len(payload.Header))
+	for key := range payload.Header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		for _, value := range payload.Header[key] {
+			fmt.Fprintf(p.w, "  %s %s\n", p.paint(colorCyan, key+":"), value)
+		}
+	}
+
+	if m := payload.Multipart; m != nil {
+		fields := make([]string, 0, len(m.Fields))
+		for key := range m.Fields {
+			fields = append(fields, key)
+		}
+		sort.Strings(fields)
+
+		fmt.Fprintln(p.w)
+		for _, key := range fields {
+			for _, value := range m.Fields[key] {
+				fmt.Fprintf(p.w, "  %s = %s\n", key, value)
+			}
+		}
+
+		files := make([]string, 0, len(m.Files))
+		for key := range m.Files {
+			files = append(files, key)
+		}
+		sort.Strings(files)
+
+		for _, key := range files {
+			for _, file := range m.Files[key] {
+				fmt.Fprintf(p.w, "  %s = @%s (%s, %d bytes)\n", key, file.FileName, file.ContentType, file.Size)
+			}
+		}
+	}
+
+	if len(payload.Body) > 0 {
+		fmt.Fprintln(p.w)
+		for _, line := range strings.Split(body(payload.Body), "\n") {
+			fmt.Fprintln(p.w, "  "+line)
+		}
+	}
+
+	if payload.Truncated {
+		fmt.Fprintln(p.w, "  "+p.paint(colorYellow, "(truncated)"))
+	}
+}
+
+// body indents JSON, binary bodies are only described.
+func body(b []byte) string {
+	var indented bytes.Buffer
+	if json.Indent(&indented, b, "", "  ") == nil {
+		return indented.String()
+	}
+
+	if !utf8.Valid(b) {
+		return fmt.Sprintf("<%d bytes of %s>", len(b), http.DetectContentType(b))
+	}
+
+	return strings.TrimRight(string(b), "\n")
+}
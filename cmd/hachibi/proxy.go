@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/mtfiqh/hachibi"
+	"github.com/pkg/errors"
+)
+
+func proxy(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) error {
+	var (
+		sinks    sinkFlags
+		flags    = flag.NewFlagSet("proxy", flag.ContinueOnError)
+		listen   = flags.String("listen", ":8080", "address the proxy listens on")
+		upstream = flags.String("upstream", "", "URL of the proxied service, e.g. https://api.example.com")
+		insecure = flags.Bool("insecure", false, "skip the verification of the upstream certificate")
+	)
+	sinks.register(flags)
+
+	if err := parse(flags, args, stderr, "-upstream URL [flags]"); err != nil {
+		return err
+	}
+
+	target, err := url.Parse(*upstream)
+	if err != nil || target.Scheme == "" || target.Host == "" {
+		return errors.Wrapf(errUsage, "invalid -upstream %q", *upstream)
+	}
+
+	processor, closeSinks, err := sinks.processor(ctx, stdout)
+	if err != nil {
+		return err
+	}
+	defer closeSinks()
+
+	handler, err := newReverseProxy(target, &sinks, processor, *insecure)
+	if err != nil {
+		return err
+	}
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return errors.Wrap(err, "failed to listen")
+	}
+
+	fmt.Fprintf(stderr, "hachibi: proxying %s to %s\n", l.Addr(), target)
+	return serve(ctx, l, handler)
+}
+
+func newReverseProxy(target *url.URL, sinks *sinkFlags, processor hachibi.Processor, insecure bool) (http.Handler, error) {
+	rules, err := sinks.loadRules()
+	if err != nil {
+		return nil, err
+	}
+
+	middlewareOpts := []hachibi.MiddlewareOpt{hachibi.MiddlewareWithProcessor(processor)}
+	if rules != nil {
+		middlewareOpts = append(middlewareOpts, hachibi.MiddlewareWithRules(rules))
+	}
+
+	opts := []hachibi.ReverseProxyOpt{hachibi.ReverseProxyWithMiddleware(hachibi.NewMiddleware(middlewareOpts...))}
+	if sinks.event != "" {
+		opts = append(opts, hachibi.ReverseProxyWithPreProcessor(eventName(sinks.event)))
+	}
+
+	if insecure {
+		opts = append(opts, hachibi.ReverseProxyWithTransport(hachibi.TransportWithRoundTripper(insecureTransport())))
+	}
+
+	return hachibi.NewReverseProxy(target, opts...), nil
+}
+
+func insecureTransport() http.RoundTripper {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	return transport
+}
+
+// serve runs handler until ctx is done, the requests in flight are given some time to finish.
+func serve(ctx context.Context, l net.Listener, handler http.Handler) error {
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 30 * time.Second}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Serve(l)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(shutdown); err != nil {
+		return errors.Wrap(err, "failed to shut down")
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mtfiqh/hachibi"
+	"github.com/pkg/errors"
+)
+
+// sinkFlags are where a capturing command writes: jsonl:PATH, postgres:DSN or stdout, repeatable.
+// A sink is named for the sinks action of the rules with a prefix, e.g. audit=postgres:DSN.
+type sinkFlags struct {
+	sinks   sinkList
+	table   string
+	rules   string
+	event   string
+	noColor bool
+}
+
+type sinkList []string
+
+func (s *sinkList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *sinkList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+func (f *sinkFlags) register(flags *flag.FlagSet) {
+	flags.Var(&f.sinks, "sink", "[NAME=]jsonl:PATH, [NAME=]postgres:DSN or [NAME=]stdout, repeatable, stdout by default")
+	flags.StringVar(&f.table, "table", hachibi.DefaultPostgresTable, "Postgres table of the postgres sinks")
+	flags.StringVar(&f.rules, "rules", "", "YAML rules file selecting the captures, their body limit, event and sinks")
+	flags.StringVar(&f.event, "event", "", "event name of the captures")
+	flags.BoolVar(&f.noColor, "no-color", false, "disable colors of the stdout sink")
+}
+
+// processor opens the sinks, the returned function closes them.
+func (f *sinkFlags) processor(ctx context.Context, stdout io.Writer) (hachibi.Processor, func() error, error) {
+	sinks := f.sinks
+	if len(sinks) == 0 {
+		sinks = sinkList{"stdout"}
+	}
+
+	closers := make([]func() error, 0)
+	closeAll := func() error {
+		var first error
+		for _, c := range closers {
+			if err := c(); err != nil && first == nil {
+				first = err
+			}
+		}
+		return first
+	}
+
+	opts := make([]hachibi.FanOutOpt, 0, len(sinks))
+	for _, spec := range sinks {
+		name := spec
+		if n, rest, ok := strings.Cut(spec, "="); ok && !strings.Contains(n, ":") {
+			name, spec = n, rest
+		}
+		kind, value, _ := strings.Cut(spec, ":")
+
+		var p hachibi.Processor
+		switch kind {
+		case "stdout":
+			p = &summaryProcessor{printer: newPrinter(stdout, f.noColor)}
+		case "jsonl":
+			store := hachibi.NewJSONLinesStore(value)
+			closers = append(closers, store.Close)
+			p = store
+		case "postgres":
+			db, err := sqlx.Open("postgres", value)
+			if err != nil {
+				closeAll()
+				return nil, nil, errors.Wrap(err, "failed to open postgres")
+			}
+			closers = append(closers, db.Close)
+
+			store := hachibi.NewPostgresStore(db, hachibi.PostgresWithTable(f.table))
+			if err := store.Migrate(ctx); err != nil {
+				closeAll()
+				return nil, nil, err
+			}
+			p = store
+		default:
+			closeAll()
+			return nil, nil, errors.Wrapf(errUsage, "invalid sink %q", spec)
+		}
+
+		if len(sinks) == 1 {
+			return p, closeAll, nil
+		}
+
+		opts = append(opts, hachibi.FanOutWithSink(name, p))
+	}
+
+	return hachibi.NewFanOutProcessor(opts...), closeAll, nil
+}
+
+// loadRules reads the -rules file, nil when there is none.
+func (f *sinkFlags) loadRules() (*hachibi.Rules, error) {
+	if f.rules == "" {
+		return nil, nil
+	}
+
+	file, err := os.Open(f.rules)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open rules")
+	}
+	defer file.Close()
+
+	return hachibi.LoadRules(file)
+}
+
+// summaryProcessor prints a line per capture.
+type summaryProcessor struct {
+	mu      sync.Mutex
+	printer *printer
+}
+
+func (s *summaryProcessor) Process(ctx context.Context, httpData *hachibi.HttpData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.printer.summary(httpData)
+	return nil
+}
+
+// eventName sets the event of the captures which have none.
+type eventName string
+
+func (e eventName) PreProcess(ctx context.Context, httpData *hachibi.HttpData) error {
+	if httpData.Event == "" {
+		httpData.Event = string(e)
+	}
+
+	return nil
+}
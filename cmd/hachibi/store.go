@@ -0,0 +1,190 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mtfiqh/hachibi"
+	"github.com/pkg/errors"
+)
+
+// source is where the captures are read from, a JSON lines file or a Postgres table.
+type source struct {
+	file     string
+	postgres string
+	table    string
+}
+
+func (s *source) register(flags *flag.FlagSet) {
+	flags.StringVar(&s.file, "file", "", "JSON lines file written by hachibi.JSONLinesStore")
+	flags.StringVar(&s.postgres, "postgres", "", "Postgres connection string, e.g. \"dbname=hachibi sslmode=disable\"")
+	flags.StringVar(&s.table, "table", hachibi.DefaultPostgresTable, "Postgres table")
+}
+
+func (s *source) open() (hachibi.Store, func() error, error) {
+	switch {
+	case s.file != "" && s.postgres != "":
+		return nil, nil, errors.Wrap(errUsage, "-file and -postgres are exclusive")
+	case s.file != "":
+		return hachibi.NewJSONLinesStore(s.file), func() error { return nil }, nil
+	case s.postgres != "":
+		db, err := sqlx.Open("postgres", s.postgres)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to open postgres")
+		}
+
+		return hachibi.NewPostgresStore(db, hachibi.PostgresWithTable(s.table)), db.Close, nil
+	}
+
+	return nil, nil, errors.Wrap(errUsage, "-file or -postgres is required")
+}
+
+// filterFlags are the flags selecting captures, shared by the commands.
+type filterFlags struct {
+	method      string
+	status      string
+	event       string
+	route       string
+	url         string
+	urlPrefix   string
+	text        string
+	minDuration time.Duration
+	maxDuration time.Duration
+	since       time.Duration
+	from        string
+	to          string
+	errors      string
+	errorStage  string
+	header      headerFlag
+	sort        string
+}
+
+func (f *filterFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&f.method, "method", "", "HTTP method")
+	flags.StringVar(&f.status, "status", "", "status code, class or range: 404, 5xx, 400-499")
+	flags.StringVar(&f.event, "event", "", "event name")
+	flags.StringVar(&f.route, "route", "", "route pattern, e.g. /users/{id}")
+	flags.StringVar(&f.url, "url", "", "URL contains")
+	flags.StringVar(&f.urlPrefix, "url-prefix", "", "URL starts with")
+	flags.StringVar(&f.text, "text", "", "request or response body contains, ignoring case")
+	flags.DurationVar(&f.minDuration, "min-duration", 0, "minimum duration, e.g. 500ms")
+	flags.DurationVar(&f.maxDuration, "max-duration", 0, "maximum duration")
+	flags.DurationVar(&f.since, "since", 0, "captures of the last duration, e.g. 1h")
+	flags.StringVar(&f.from, "from", "", "captures from this RFC 3339 time")
+	flags.StringVar(&f.to, "to", "", "captures before this RFC 3339 time")
+	flags.StringVar(&f.errors, "errors", "", "true keeps the captures with errors, false the ones without")
+	flags.StringVar(&f.errorStage, "error-stage", "", "captures with an error of the stage, e.g. \"process error\"")
+	flags.Var(&f.header, "header", "request or response header containing a value, Name=value, repeatable")
+	flags.StringVar(&f.sort, "sort", "newest", "newest, oldest, slowest or fastest")
+}
+
+func (f *filterFlags) filter() (hachibi.Filter, error) {
+	filter := hachibi.Filter{
+		Method:       f.method,
+		Event:        f.event,
+		Route:        f.route,
+		URL:          f.url,
+		URLPrefix:    f.urlPrefix,
+		Text:         f.text,
+		DurationFrom: f.minDuration.Milliseconds(),
+		DurationTo:   f.maxDuration.Milliseconds(),
+		ErrorStage:   f.errorStage,
+		Header:       f.header,
+	}
+
+	if f.status != "" {
+		from, to, err := hachibi.ParseStatusRange(f.status)
+		if err != nil {
+			return filter, errors.Wrap(errUsage, err.Error())
+		}
+
+		filter.StatusFrom, filter.StatusTo = from, to
+	}
+
+	if f.since > 0 {
+		filter.From = time.Now().Add(-f.since)
+	}
+
+	for _, t := range []struct {
+		value string
+		to    *time.Time
+	}{{f.from, &filter.From}, {f.to, &filter.To}} {
+		if t.value == "" {
+			continue
+		}
+
+		v, err := time.Parse(time.RFC3339, t.value)
+		if err != nil {
+			return filter, errors.Wrapf(errUsage, "invalid time %q", t.value)
+		}
+
+		*t.to = v
+	}
+
+	switch f.errors {
+	case "":
+	case "true", "false":
+		v := f.errors == "true"
+		filter.HasError = &v
+	default:
+		return filter, errors.Wrapf(errUsage, "invalid -errors %q", f.errors)
+	}
+
+	s, err := hachibi.ParseSort(f.sort)
+	if err != nil {
+		return filter, errors.Wrap(errUsage, err.Error())
+	}
+	filter.Sort = s
+
+	return filter, nil
+}
+
+type headerFlag map[string]string
+
+func (h *headerFlag) String() string {
+	pairs := make([]string, 0, len(*h))
+	for k, v := range *h {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
+func (h *headerFlag) Set(s string) error {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return errors.Errorf("invalid header %q, expected Name=value", s)
+	}
+
+	if *h == nil {
+		*h = make(headerFlag)
+	}
+	(*h)[name] = value
+
+	return nil
+}
+
+// parse reads the flags of a command, the usage is written to stderr.
+func parse(flags *flag.FlagSet, args []string, stderr io.Writer, synopsis string) error {
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "usage: hachibi %s %s\n\n", flags.Name(), synopsis)
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+
+		return errUsage
+	}
+
+	return nil
+}
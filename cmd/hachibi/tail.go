@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/mtfiqh/hachibi"
+	"github.com/pkg/errors"
+)
+
+func tail(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) error {
+	var (
+		src      source
+		filters  filterFlags
+		flags    = flag.NewFlagSet("tail", flag.ContinueOnError)
+		n        = flags.Int("n", 10, "number of captures printed before following")
+		follow   = flags.Bool("f", false, "print the new captures as they are written")
+		interval = flags.Duration("interval", time.Second, "how often the source is checked with -f")
+		lookback = flags.Duration("lookback", time.Minute, "how long after they started the captures are still looked for with -f, the longest request")
+		noColor  = flags.Bool("no-color", false, "disable colors")
+	)
+	src.register(flags)
+	filters.register(flags)
+
+	if err := parse(flags, args, stderr, "[flags]"); err != nil {
+		return err
+	}
+
+	filter, err := filters.filter()
+	if err != nil {
+		return err
+	}
+
+	p := newPrinter(stdout, *noColor)
+	emit := func(r *hachibi.HttpData) {
+		p.summary(r)
+	}
+
+	if src.file != "" && src.postgres == "" {
+		return tailFile(ctx, src.file, filter, *n, *follow, *interval, stderr, emit)
+	}
+
+	store, closeStore, err := src.open()
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	return tailStore(ctx, store, filter, *n, *follow, *interval, *lookback, emit)
+}
+
+// tailFile reads the file once and keeps reading what is appended to it, a file truncated by a rotation is read again
+// from its start.
+func tailFile(ctx context.Context, path string, filter hachibi.Filter, n int, follow bool, interval time.Duration, stderr io.Writer, emit func(*hachibi.HttpData)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to open captures file")
+	}
+	defer func() { f.Close() }()
+
+	var (
+		reader  = bufio.NewReader(f)
+		pending []byte
+		offset  int64
+		last    = make([]hachibi.HttpData, 0, n)
+		started = false
+	)
+
+	for {
+		b, err := reader.ReadBytes('\n')
+		pending = append(pending, b...)
+
+		if err == nil {
+			offset += int64(len(pending))
+			line := bytes.TrimSpace(pending)
+			pending = pending[:0]
+			if len(line) == 0 {
+				continue
+			}
+
+			var r hachibi.HttpData
+			if err := json.Unmarshal(line, &r); err != nil {
+				fmt.Fprintln(stderr, "hachibi: skipping invalid capture:", err)
+				continue
+			}
+
+			if !filter.Match(&r) {
+				continue
+			}
+
+			if started {
+				emit(&r)
+				continue
+			}
+
+			if n > 0 {
+				if len(last) == n {
+					last = append(last[:0], last[1:]...)
+				}
+				last = append(last, r)
+			}
+			continue
+		}
+
+		if err != io.EOF {
+			return errors.Wrap(err, "failed to read captures")
+		}
+
+		if !started {
+			for i := range last {
+				emit(&last[i])
+			}
+			started = true
+		}
+
+		if !follow {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+
+		if info, err := os.Stat(path); err == nil && info.Size() < offset+int64(len(pending)) {
+			f.Close()
+			if f, err = os.Open(path); err != nil {
+				return errors.Wrap(err, "failed to reopen captures file")
+			}
+
+			reader.Reset(f)
+			pending, offset = pending[:0], 0
+		}
+	}
+}
+
+// tailStore polls the store for the captures started within lookback of the last one printed, a capture is stored
+// once it's done so a slow request is stored after the faster ones started later.
+func tailStore(ctx context.Context, store hachibi.Store, filter hachibi.Filter, n int, follow bool, interval time.Duration, lookback time.Duration, emit func(*hachibi.HttpData)) error {
+	// the captures within the window are remembered not to print them twice
+	var since time.Time
+	seen := make(map[string]time.Time)
+	mark := func(r *hachibi.HttpData) {
+		if r.StartedAt.After(since) {
+			since = r.StartedAt
+		}
+		seen[r.ID] = r.StartedAt
+	}
+
+	if n > 0 {
+		filter.Sort, filter.Limit = hachibi.SortNewest, n
+		records, err := collect(ctx, store, filter)
+		if err != nil {
+			return err
+		}
+
+		for i := len(records) - 1; i >= 0; i-- {
+			emit(&records[i])
+			mark(&records[i])
+		}
+	}
+
+	if !follow {
+		return nil
+	}
+
+	if since.IsZero() {
+		since = time.Now()
+	}
+
+	from := filter.From
+	filter.Sort, filter.Limit = hachibi.SortOldest, 0
+	poll := func(print bool) error {
+		filter.From = since.Add(-lookback)
+		if from.After(filter.From) {
+			filter.From = from
+		}
+
+		for id, startedAt := range seen {
+			if startedAt.Before(filter.From) {
+				delete(seen, id)
+			}
+		}
+
+		records, err := collect(ctx, store, filter)
+		if err != nil {
+			return err
+		}
+
+		for i := range records {
+			if _, ok := seen[records[i].ID]; ok {
+				continue
+			}
+
+			if print {
+				emit(&records[i])
+			}
+			mark(&records[i])
+		}
+
+		return nil
+	}
+
+	// the captures stored before following are only printed by -n
+	if err := poll(false); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+
+		if err := poll(true); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func collect(ctx context.Context, store hachibi.Store, filter hachibi.Filter) ([]hachibi.HttpData, error) {
+	it, err := store.Query(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return hachibi.Collect(it)
+}
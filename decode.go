@@ -0,0 +1,363 @@
+package hachibi
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+type DecodedKind string
+
+const (
+	DecodedJSON      = DecodedKind("json")
+	DecodedForm      = DecodedKind("form")
+	DecodedXML       = DecodedKind("xml")
+	DecodedMultipart = DecodedKind("multipart")
+	DecodedText      = DecodedKind("text")
+	DecodedBinary    = DecodedKind("binary")
+)
+
+// Decoded is the structured form of a body, only the field matching Kind is set.
+type Decoded struct {
+	Kind DecodedKind `json:"kind"`
+
+	JSON      any            `json:"json,omitempty"`
+	Form      url.Values     `json:"form,omitempty"`
+	XML       *XMLNode       `json:"xml,omitempty"`
+	Multipart *MultipartData `json:"multipart,omitempty"`
+	Text      string         `json:"text,omitempty"`
+	Binary    *BinaryInfo    `json:"binary,omitempty"`
+
+	// Charset is the charset the text was converted from to UTF-8.
+	Charset string `json:"charset,omitempty"`
+}
+
+type XMLNode struct {
+	Name     string            `json:"name"`
+	Attr     map[string]string `json:"attr,omitempty"`
+	Text     string            `json:"text,omitempty"`
+	Children []*XMLNode        `json:"children,omitempty"`
+}
+
+type BinaryInfo struct {
+	Size   int    `json:"size"`
+	MIME   string `json:"mime"`
+	SHA256 string `json:"sha256"`
+}
+
+func (d *Decoded) clone() *Decoded {
+	if d == nil {
+		return nil
+	}
+
+	c := *d
+	c.JSON = cloneJSON(d.JSON)
+	c.XML = d.XML.clone()
+	c.Multipart = d.Multipart.clone()
+
+	if d.Form != nil {
+		c.Form = make(url.Values, len(d.Form))
+		for key, values := range d.Form {
+			c.Form[key] = append([]string(nil), values...)
+		}
+	}
+
+	if d.Binary != nil {
+		binary := *d.Binary
+		c.Binary = &binary
+	}
+
+	return &c
+}
+
+// cloneJSON copies the objects and arrays of a decoded JSON value, the other values are immutable.
+func cloneJSON(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		c := make(map[string]any, len(v))
+		for key, value := range v {
+			c[key] = cloneJSON(value)
+		}
+		return c
+	case []any:
+		c := make([]any, len(v))
+		for i, value := range v {
+			c[i] = cloneJSON(value)
+		}
+		return c
+	default:
+		return v
+	}
+}
+
+func (n *XMLNode) clone() *XMLNode {
+	if n == nil {
+		return nil
+	}
+
+	c := *n
+	if n.Attr != nil {
+		c.Attr = make(map[string]string, len(n.Attr))
+		for key, value := range n.Attr {
+			c.Attr[key] = value
+		}
+	}
+
+	if n.Children != nil {
+		c.Children = make([]*XMLNode, len(n.Children))
+		for i, child := range n.Children {
+			c.Children[i] = child.clone()
+		}
+	}
+
+	return &c
+}
+
+// Decode parses Body according to the Content-Type header and stores the result in Decoded,
+// a body that can't be parsed as declared falls back to text or binary.
+// Body is kept as it is, so decoding never shrinks a capture, a binary body keeps its bytes next to its
+// BinaryInfo for the processors needing them (e.g. the image processor). Use a rule with headersOnly or
+// bodyLimit to keep the large bodies out of the captures.
+func (p *Payload) Decode() *Decoded {
+	if p.Decoded != nil {
+		return p.Decoded
+	}
+
+	if p.Multipart != nil {
+		p.Decoded = &Decoded{Kind: DecodedMultipart, Multipart: p.Multipart.withoutContent()}
+		return p.Decoded
+	}
+
+	if len(p.Body) == 0 {
+		return nil
+	}
+
+	contentType := ""
+	if p.Header != nil {
+		contentType = p.Header.Get("Content-Type")
+	}
+
+	p.Decoded = decodeBody(contentType, p.Body)
+	return p.Decoded
+}
+
+func decodeBody(contentType string, body []byte) *Decoded {
+	sniffed := false
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == "" {
+		mediaType, params, _ = mime.ParseMediaType(http.DetectContentType(body))
+		sniffed = true
+	}
+
+	charset := strings.ToLower(params["charset"])
+
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		if d, err := decodeJSON(body, charset); err == nil {
+			return d
+		}
+	case mediaType == "application/x-www-form-urlencoded":
+		if d, err := decodeForm(body, charset); err == nil {
+			return d
+		}
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		if d, err := decodeXML(body, charset); err == nil {
+			return d
+		}
+	case strings.HasPrefix(mediaType, "multipart/"):
+		if d, err := decodeMultipart(body, params["boundary"]); err == nil {
+			return d
+		}
+	case sniffed && mediaType == "text/plain":
+		// undeclared text is often JSON
+		if d, err := decodeJSON(body, charset); err == nil {
+			return d
+		}
+	}
+
+	if isTextMediaType(mediaType) || (charset == "" && utf8.Valid(body) && isTextMediaType(http.DetectContentType(body))) {
+		if d, err := decodeText(body, charset); err == nil {
+			return d
+		}
+	}
+
+	return &Decoded{Kind: DecodedBinary, Binary: binaryInfo(body)}
+}
+
+func isTextMediaType(mediaType string) bool {
+	mediaType, _, _ = mime.ParseMediaType(mediaType)
+
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		mediaType == "application/json", strings.HasSuffix(mediaType, "+json"),
+		mediaType == "application/xml", strings.HasSuffix(mediaType, "+xml"),
+		mediaType == "application/javascript", mediaType == "application/x-www-form-urlencoded":
+		return true
+	}
+
+	return false
+}
+
+func binaryInfo(body []byte) *BinaryInfo {
+	sum := sha256.Sum256(body)
+	return &BinaryInfo{
+		Size:   len(body),
+		MIME:   http.DetectContentType(body),
+		SHA256: hex.EncodeToString(sum[:]),
+	}
+}
+
+// toUTF8 converts body from charset, UTF-8 and US-ASCII are returned as they are.
+func toUTF8(body []byte, charset string) ([]byte, error) {
+	if charset == "" || charset == "utf-8" || charset == "utf8" || charset == "us-ascii" {
+		return body, nil
+	}
+
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unknown charset %q", charset)
+	}
+
+	return enc.NewDecoder().Bytes(body)
+}
+
+func decodeJSON(body []byte, charset string) (*Decoded, error) {
+	b, err := toUTF8(body, charset)
+	if err != nil {
+		return nil, err
+	}
+
+	var v any
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	if decoder.More() {
+		return nil, errors.New("trailing data after json value")
+	}
+
+	return &Decoded{Kind: DecodedJSON, JSON: v, Charset: charset}, nil
+}
+
+func decodeForm(body []byte, charset string) (*Decoded, error) {
+	b, err := toUTF8(body, charset)
+	if err != nil {
+		return nil, err
+	}
+
+	form, err := url.ParseQuery(string(b))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Decoded{Kind: DecodedForm, Form: form, Charset: charset}, nil
+}
+
+func decodeText(body []byte, charset string) (*Decoded, error) {
+	b, err := toUTF8(body, charset)
+	if err != nil {
+		return nil, err
+	}
+
+	if !utf8.Valid(b) {
+		return nil, errors.New("invalid utf-8 text")
+	}
+
+	return &Decoded{Kind: DecodedText, Text: string(b), Charset: charset}, nil
+}
+
+func decodeXML(body []byte, charset string) (*Decoded, error) {
+	b, err := toUTF8(body, charset)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(b))
+	decoder.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		// the body was already converted when the header declared a charset
+		if charset != "" {
+			return input, nil
+		}
+
+		enc, err := htmlindex.Get(label)
+		if err != nil {
+			return nil, err
+		}
+
+		return enc.NewDecoder().Reader(input), nil
+	}
+
+	var root *XMLNode
+	stack := make([]*XMLNode, 0)
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch tok := token.(type) {
+		case xml.StartElement:
+			node := &XMLNode{Name: tok.Name.Local}
+			for _, attr := range tok.Attr {
+				if node.Attr == nil {
+					node.Attr = map[string]string{}
+				}
+				node.Attr[attr.Name.Local] = attr.Value
+			}
+
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, node)
+			} else if root == nil {
+				root = node
+			}
+
+			stack = append(stack, node)
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].Text += strings.TrimSpace(string(tok))
+			}
+		}
+	}
+
+	if root == nil {
+		return nil, errors.New("no xml element")
+	}
+
+	return &Decoded{Kind: DecodedXML, XML: root, Charset: charset}, nil
+}
+
+func decodeMultipart(body []byte, boundary string) (*Decoded, error) {
+	if boundary == "" {
+		return nil, errors.New("no multipart boundary")
+	}
+
+	data, err := parseMultipartStream(context.Background(), bytes.NewReader(body), boundary, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Decoded{Kind: DecodedMultipart, Multipart: data.withoutContent()}, nil
+}
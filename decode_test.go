@@ -0,0 +1,130 @@
+package hachibi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/mtfiqh/hachibi"
+)
+
+func TestPayload_Decode(t *testing.T) {
+	png, err := os.ReadFile("test.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	multipartBody := &bytes.Buffer{}
+	writer := multipart.NewWriter(multipartBody)
+	writer.WriteField("name", "taufiq")
+	part, _ := writer.CreateFormFile("file", "test.png")
+	part.Write(png)
+	writer.Close()
+
+	payload := func(contentType string, body []byte) *hachibi.Payload {
+		return &hachibi.Payload{Header: http.Header{"Content-Type": {contentType}}, Body: body}
+	}
+
+	t.Run("json", func(t *testing.T) {
+		d := payload("application/json", []byte(`{"id": 12, "tags": ["a"]}`)).Decode()
+		if d.Kind != hachibi.DecodedJSON {
+			t.Fatalf("unexpected kind %s", d.Kind)
+		}
+
+		obj := d.JSON.(map[string]any)
+		if obj["id"].(json.Number) != "12" {
+			t.Fatalf("unexpected json %v", d.JSON)
+		}
+	})
+
+	t.Run("undeclared json", func(t *testing.T) {
+		d := payload("", []byte(`[1, 2]`)).Decode()
+		if d.Kind != hachibi.DecodedJSON {
+			t.Fatalf("unexpected kind %s", d.Kind)
+		}
+	})
+
+	t.Run("invalid json falls back to text", func(t *testing.T) {
+		d := payload("application/json", []byte(`{"id": `)).Decode()
+		if d.Kind != hachibi.DecodedText || d.Text != `{"id": ` {
+			t.Fatalf("unexpected decoded %+v", d)
+		}
+	})
+
+	t.Run("form", func(t *testing.T) {
+		d := payload("application/x-www-form-urlencoded", []byte("email=a%40b.c&tag=x&tag=y")).Decode()
+		if d.Kind != hachibi.DecodedForm || d.Form.Get("email") != "a@b.c" || len(d.Form["tag"]) != 2 {
+			t.Fatalf("unexpected decoded %+v", d)
+		}
+	})
+
+	t.Run("xml with charset", func(t *testing.T) {
+		// "café" in latin-1
+		body := []byte("<order id=\"7\"><item>caf\xe9</item></order>")
+		d := payload("application/xml; charset=ISO-8859-1", body).Decode()
+		if d.Kind != hachibi.DecodedXML || d.XML.Name != "order" || d.XML.Attr["id"] != "7" {
+			t.Fatalf("unexpected decoded %+v", d)
+		}
+
+		if d.XML.Children[0].Text != "café" {
+			t.Fatalf("unexpected text %q", d.XML.Children[0].Text)
+		}
+	})
+
+	t.Run("text with charset", func(t *testing.T) {
+		d := payload("text/plain; charset=windows-1252", []byte("\x93quoted\x94")).Decode()
+		if d.Kind != hachibi.DecodedText || d.Text != "“quoted”" || d.Charset != "windows-1252" {
+			t.Fatalf("unexpected decoded %+v", d)
+		}
+	})
+
+	t.Run("multipart", func(t *testing.T) {
+		d := payload(writer.FormDataContentType(), multipartBody.Bytes()).Decode()
+		if d.Kind != hachibi.DecodedMultipart || d.Multipart.Fields["name"][0] != "taufiq" {
+			t.Fatalf("unexpected decoded %+v", d)
+		}
+
+		file := d.Multipart.Files["file"][0]
+		if file.FileName != "test.png" || file.Size != int64(len(png)) || file.SHA256 == "" || file.File != nil {
+			t.Fatalf("unexpected file %+v", file)
+		}
+	})
+
+	t.Run("binary", func(t *testing.T) {
+		p := payload("application/octet-stream", png)
+		d := p.Decode()
+		if d.Kind != hachibi.DecodedBinary || d.Binary.MIME != "image/png" || d.Binary.Size != len(png) || len(d.Binary.SHA256) != 64 {
+			t.Fatalf("unexpected decoded %+v", d)
+		}
+
+		if !bytes.Equal(p.Body, png) {
+			t.Fatal("decoding must keep the body")
+		}
+	})
+
+	t.Run("middleware", func(t *testing.T) {
+		p := &captureProcessor{}
+		m := hachibi.NewMiddleware(hachibi.MiddlewareWithProcessor(p), hachibi.MiddlewareWithDecoding())
+		h := m.Middleware(func(writer http.ResponseWriter, request *http.Request) {
+			writer.Header().Set("Content-Type", "application/json")
+			writer.Write([]byte(`{"ok":true}`))
+		})
+
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(multipartBody.Bytes()))
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		record := p.records[0]
+		if record.Request.Decoded.Kind != hachibi.DecodedMultipart || record.Request.Decoded.Multipart.Files["file"][0].FileName != "test.png" {
+			t.Fatalf("unexpected request %+v", record.Request.Decoded)
+		}
+
+		if record.Response.Decoded.Kind != hachibi.DecodedJSON {
+			t.Fatalf("unexpected response %+v", record.Response.Decoded)
+		}
+	})
+}
@@ -0,0 +1,130 @@
+package hachibi
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+const DefaultMaxDecompressRatio = 100
+
+var ErrDecompressRatio = errors.New("decompression ratio limit exceeded")
+
+type decompressConfig struct {
+	disabled bool
+	maxRatio int
+}
+
+func defaultDecompressConfig() decompressConfig {
+	return decompressConfig{maxRatio: DefaultMaxDecompressRatio}
+}
+
+// decompress replaces the captured body with its decoded copy according to Content-Encoding,
+// on error the body is left as it was. A truncated body is decoded as far as it goes.
+func (p *Payload) decompress(config decompressConfig) error {
+	if config.disabled || len(p.Body) == 0 || p.Header == nil {
+		return nil
+	}
+
+	contentEncoding := p.Header.Get("Content-Encoding")
+	if contentEncoding == "" {
+		return nil
+	}
+
+	encodings := make([]string, 0)
+	for _, e := range strings.Split(contentEncoding, ",") {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if e != "" && e != "identity" {
+			encodings = append(encodings, e)
+		}
+	}
+
+	if len(encodings) == 0 {
+		return nil
+	}
+
+	limit := int64(-1)
+	if config.maxRatio > 0 {
+		limit = int64(len(p.Body)) * int64(config.maxRatio)
+	}
+
+	body := p.Body
+	// encodings are listed in the order they were applied
+	for i := len(encodings) - 1; i >= 0; i-- {
+		decoded, err := decodeContent(encodings[i], body, limit)
+		if p.Truncated && errors.Is(err, io.ErrUnexpectedEOF) && len(decoded) > 0 {
+			err = nil
+		}
+
+		if err != nil {
+			return errors.Wrapf(err, "failed to decode %s body", encodings[i])
+		}
+
+		body = decoded
+	}
+
+	p.Encoding = contentEncoding
+	p.EncodedSize = len(p.Body)
+	p.Body = body
+
+	return nil
+}
+
+func decodeContent(encoding string, body []byte, limit int64) ([]byte, error) {
+	var reader io.Reader
+
+	switch encoding {
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		reader = gz
+	case "deflate":
+		// deflate is supposed to be zlib wrapped, but some servers send raw deflate
+		zr, err := zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			fr := flate.NewReader(bytes.NewReader(body))
+			defer fr.Close()
+			reader = fr
+		} else {
+			defer zr.Close()
+			reader = zr
+		}
+	case "br":
+		reader = brotli.NewReader(bytes.NewReader(body))
+	case "zstd":
+		zr, err := zstd.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		reader = zr
+	default:
+		return nil, errors.Errorf("unsupported content encoding %q", encoding)
+	}
+
+	if limit >= 0 {
+		reader = io.LimitReader(reader, limit+1)
+	}
+
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		// what was decoded before the error, for the truncated bodies
+		return decoded, err
+	}
+
+	if limit >= 0 && int64(len(decoded)) > limit {
+		return nil, ErrDecompressRatio
+	}
+
+	return decoded, nil
+}
@@ -0,0 +1,176 @@
+package hachibi_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/mtfiqh/hachibi"
+)
+
+func encode(t *testing.T, encoding string, body []byte) []byte {
+	buf := &bytes.Buffer{}
+	var w io.WriteCloser
+
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(buf)
+	case "deflate":
+		w = zlib.NewWriter(buf)
+	case "br":
+		w = brotli.NewWriter(buf)
+	case "zstd":
+		zw, err := zstd.NewWriter(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w = zw
+	}
+
+	w.Write(body)
+	w.Close()
+
+	return buf.Bytes()
+}
+
+func TestDecompress(t *testing.T) {
+	plain := []byte(`{"message":"` + strings.Repeat("hello ", 50) + `"}`)
+
+	for _, encoding := range []string{"gzip", "deflate", "br", "zstd"} {
+		t.Run(encoding, func(t *testing.T) {
+			encoded := encode(t, encoding, plain)
+
+			server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				writer.Header().Set("Content-Encoding", encoding)
+				writer.Write(encoded)
+			}))
+			defer server.Close()
+
+			p := &captureProcessor{}
+			client := http.Client{Transport: hachibi.NewTransport(hachibi.TransportWithProcessor(p))}
+
+			req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+			req.Header.Set("Accept-Encoding", encoding)
+			res, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			received, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+
+			if !bytes.Equal(received, encoded) {
+				t.Fatal("caller must receive the original stream")
+			}
+
+			response := p.records[0].Response
+			if !bytes.Equal(response.Body, plain) {
+				t.Fatalf("expected decoded body, got %q", response.Body)
+			}
+
+			if response.Encoding != encoding || response.EncodedSize != len(encoded) {
+				t.Fatalf("unexpected encoding %q size %d", response.Encoding, response.EncodedSize)
+			}
+		})
+	}
+
+	t.Run("decompression bomb", func(t *testing.T) {
+		bomb := encode(t, "gzip", make([]byte, 1<<20))
+
+		server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			writer.Header().Set("Content-Encoding", "gzip")
+			writer.Write(bomb)
+		}))
+		defer server.Close()
+
+		p := &captureProcessor{}
+		client := http.Client{Transport: hachibi.NewTransport(hachibi.TransportWithProcessor(p), hachibi.TransportWithMaxDecompressRatio(10))}
+
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the capture follows what is read, the bomb is streamed in chunks
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+
+		record := p.records[0]
+		if !bytes.Equal(record.Response.Body, bomb) || record.Response.Encoding != "" {
+			t.Fatal("body must stay compressed")
+		}
+
+		if len(record.Error) != 1 || !errors.Is(record.Error[0], hachibi.ErrDecompressRatio) {
+			t.Fatalf("expected ratio error, got %v", record.Error)
+		}
+	})
+
+	t.Run("truncated body", func(t *testing.T) {
+		numbers := &strings.Builder{}
+		for i := 0; numbers.Len() < 1<<16; i++ {
+			numbers.WriteString(strconv.Itoa(i * 7919))
+		}
+		encoded := encode(t, "gzip", []byte(numbers.String()))
+
+		server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			writer.Header().Set("Content-Encoding", "gzip")
+			writer.Write(encoded)
+		}))
+		defer server.Close()
+
+		rules, _ := hachibi.NewRules(hachibi.Rule{Action: hachibi.RuleAction{BodyLimit: 1024}})
+		p := &captureProcessor{}
+		client := http.Client{Transport: hachibi.NewTransport(hachibi.TransportWithProcessor(p), hachibi.TransportWithRules(rules))}
+
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		received, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+
+		if !bytes.Equal(received, encoded) {
+			t.Fatal("caller must receive the original stream")
+		}
+
+		record := p.records[0]
+		if len(record.Error) != 0 || record.Response.Encoding != "gzip" || !record.Response.Truncated || string(record.Response.Body) != numbers.String()[:1024] {
+			t.Fatalf("the truncated body must be decoded as far as it goes, got %q %v", record.Response.Body, record.Error)
+		}
+	})
+
+	t.Run("middleware request", func(t *testing.T) {
+		var handlerBody []byte
+		p := &captureProcessor{}
+		m := hachibi.NewMiddleware(hachibi.MiddlewareWithProcessor(p))
+		h := m.Middleware(func(writer http.ResponseWriter, request *http.Request) {
+			handlerBody, _ = io.ReadAll(request.Body)
+			writer.WriteHeader(http.StatusNoContent)
+		})
+
+		encoded := encode(t, "gzip", plain)
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(encoded))
+		req.Header.Set("Content-Encoding", "gzip")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		if !bytes.Equal(handlerBody, encoded) {
+			t.Fatal("handler must receive the original body")
+		}
+
+		if !bytes.Equal(p.records[0].Request.Body, plain) || p.records[0].Request.Encoding != "gzip" {
+			t.Fatal("expected decoded request body")
+		}
+	})
+}
@@ -0,0 +1,555 @@
+package hachibi
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultDriftLearning is the samples of a shape learned before its drifts are reported.
+	DefaultDriftLearning = 20
+
+	// DefaultDriftMaxShapes bounds the shapes of a baseline, e.g. when the routes aren't normalized.
+	DefaultDriftMaxShapes = 1000
+
+	// DefaultDriftSaveInterval is how long the changes of a baseline are gathered before it's saved.
+	DefaultDriftSaveInterval = 5 * time.Second
+
+	// maxDriftFields bounds the fields of a shape, e.g. when the keys of an object are ids.
+	maxDriftFields = 512
+)
+
+const (
+	DriftAdded       = "added"
+	DriftRemoved     = "removed"
+	DriftTypeChanged = "type changed"
+)
+
+// Drift is a change of the shape of the JSON bodies of a route.
+type Drift struct {
+	// Kind is one of the Drift constants, e.g. DriftAdded.
+	Kind string `json:"kind"`
+	// Key is the shape, e.g. "response GET /users/{id} 200" or "request POST /users".
+	Key string `json:"key"`
+	// Pointer is the JSON pointer of the field, the items of the arrays are *, e.g. /orders/*/id.
+	Pointer string `json:"pointer"`
+	// Types are the types of the field in the baseline, Got the ones of the capture.
+	Types []string `json:"types,omitempty"`
+	Got   []string `json:"got,omitempty"`
+}
+
+func (d Drift) Error() string {
+	pointer := d.Pointer
+	if pointer == "" {
+		pointer = "the body"
+	}
+
+	switch d.Kind {
+	case DriftAdded:
+		return d.Key + ": new field " + pointer + " of type " + strings.Join(d.Got, " or ")
+	case DriftRemoved:
+		return d.Key + ": field " + pointer + " is missing"
+	default:
+		return d.Key + ": field " + pointer + " is " + strings.Join(d.Got, " or ") + ", was " + strings.Join(d.Types, " or ")
+	}
+}
+
+// DriftBaseline is the shapes learned by a DriftDetector by key.
+type DriftBaseline map[string]*DriftShape
+
+type DriftShape struct {
+	Samples int `json:"samples"`
+	// Fields are the fields by JSON pointer, the body itself is "".
+	Fields map[string]*DriftField `json:"fields"`
+}
+
+type DriftField struct {
+	Types    []string `json:"types"`
+	Optional bool     `json:"optional,omitempty"`
+}
+
+// DriftBaselineStore persists the baseline of a DriftDetector across restarts.
+type DriftBaselineStore interface {
+	LoadBaseline(ctx context.Context) (DriftBaseline, error)
+	SaveBaseline(ctx context.Context, baseline DriftBaseline) error
+}
+
+type DriftHook func(ctx context.Context, httpData *HttpData, drifts []Drift)
+
+// DriftDetector learns the shape of the JSON bodies of each route and reports how the later bodies differ: the new
+// fields, the missing ones and the new types of a field. The shapes are keyed by method, route and, for the
+// responses, status, the routes of the captures without one are derived by a RouteNormalizer.
+// A shape is learned from its first samples, a field missing from some of them is optional. Once learned, a drift
+// is reported once, then it is part of the baseline, e.g. a removed field becomes optional.
+// The drifts are given to the hook, or returned as an Error so that they reach the ErrorHandler of the pipeline.
+// The baseline is saved in the background once its changes are gathered, Flush saves it right away, e.g. on shutdown.
+type DriftDetector struct {
+	normalizer   *RouteNormalizer
+	store        DriftBaselineStore
+	hook         DriftHook
+	learning     int
+	maxShapes    int
+	saveInterval time.Duration
+
+	mu       sync.Mutex
+	loaded   bool
+	baseline DriftBaseline
+	dirty    bool
+	pending  bool
+	saveErr  error
+
+	// saving orders the saves, a baseline is never replaced by an older one
+	saving sync.Mutex
+}
+
+type DriftOpt func(*DriftDetector)
+
+// DriftWithRouteNormalizer derives the routes of the captures without one, NewRouteNormalizer by default.
+func DriftWithRouteNormalizer(normalizer *RouteNormalizer) DriftOpt {
+	return func(d *DriftDetector) {
+		d.normalizer = normalizer
+	}
+}
+
+// DriftWithBaselineStore loads the baseline before the first capture and saves it when it changes,
+// see DriftWithSaveInterval.
+func DriftWithBaselineStore(store DriftBaselineStore) DriftOpt {
+	return func(d *DriftDetector) {
+		d.store = store
+	}
+}
+
+// DriftWithHook gives the drifts to hook instead of returning them, the hook may call the detector, e.g. Baseline.
+func DriftWithHook(hook DriftHook) DriftOpt {
+	return func(d *DriftDetector) {
+		d.hook = hook
+	}
+}
+
+// DriftWithLearning sets the samples learned before reporting the drifts of a shape, DefaultDriftLearning by default.
+func DriftWithLearning(samples int) DriftOpt {
+	return func(d *DriftDetector) {
+		d.learning = samples
+	}
+}
+
+// DriftWithMaxShapes bounds the shapes learned, the captures of the keys past it are ignored,
+// DefaultDriftMaxShapes by default and 0 removes the limit.
+func DriftWithMaxShapes(n int) DriftOpt {
+	return func(d *DriftDetector) {
+		d.maxShapes = n
+	}
+}
+
+// DriftWithSaveInterval sets how long the changes are gathered before the baseline is saved,
+// DefaultDriftSaveInterval by default.
+func DriftWithSaveInterval(interval time.Duration) DriftOpt {
+	return func(d *DriftDetector) {
+		d.saveInterval = interval
+	}
+}
+
+func NewDriftDetector(opts ...DriftOpt) *DriftDetector {
+	d := &DriftDetector{
+		normalizer:   NewRouteNormalizer(),
+		learning:     DefaultDriftLearning,
+		maxShapes:    DefaultDriftMaxShapes,
+		saveInterval: DefaultDriftSaveInterval,
+		baseline:     make(DriftBaseline),
+	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	return d
+}
+
+// PreProcess detects the drifts before the processor, so that they are handled with the capture.
+func (d *DriftDetector) PreProcess(ctx context.Context, httpData *HttpData) error {
+	return d.Process(ctx, httpData)
+}
+
+func (d *DriftDetector) Process(ctx context.Context, httpData *HttpData) error {
+	route := httpData.Route
+	if route == "" {
+		route = d.normalizer.Normalize(httpData.URL)
+	}
+	key := strings.ToUpper(httpData.Method) + " " + route
+
+	// the hook runs unlocked, it may read the baseline or flush it
+	drifts, saveErr, err := d.detect(ctx, key, httpData)
+	if err != nil {
+		return err
+	}
+
+	if len(drifts) == 0 {
+		return saveErr
+	}
+
+	if d.hook != nil {
+		d.hook(ctx, httpData, drifts)
+		return saveErr
+	}
+
+	e := make(Error, 0, len(drifts)+1)
+	for _, drift := range drifts {
+		e = append(e, drift)
+	}
+	if saveErr != nil {
+		e = append(e, saveErr)
+	}
+
+	return e
+}
+
+// detect observes the bodies of httpData, saveErr is the failure of the last save in the background.
+func (d *DriftDetector) detect(ctx context.Context, key string, httpData *HttpData) (drifts []Drift, saveErr error, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.loaded && d.store != nil {
+		baseline, err := d.store.LoadBaseline(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if baseline != nil {
+			d.baseline = baseline
+		}
+	}
+	d.loaded = true
+
+	drifts = make([]Drift, 0)
+	changed := false
+
+	if v, ok := jsonBody(&httpData.Request.Payload); ok {
+		c, dd := d.observe("request "+key, v)
+		drifts, changed = append(drifts, dd...), changed || c
+	}
+
+	if v, ok := jsonBody(&httpData.Response.Payload); ok && httpData.StatusCode != 0 {
+		c, dd := d.observe("response "+key+" "+strconv.Itoa(httpData.StatusCode), v)
+		drifts, changed = append(drifts, dd...), changed || c
+	}
+
+	if changed && d.store != nil {
+		d.dirty = true
+		if !d.pending {
+			d.pending = true
+			time.AfterFunc(d.saveInterval, func() {
+				if err := d.Flush(context.Background()); err != nil {
+					d.mu.Lock()
+					d.saveErr = err
+					d.mu.Unlock()
+				}
+			})
+		}
+	}
+
+	// the failure of a save in the background is reported with the next capture
+	saveErr = d.saveErr
+	d.saveErr = nil
+
+	return drifts, saveErr, nil
+}
+
+// Flush saves the changes of the baseline not saved yet.
+func (d *DriftDetector) Flush(ctx context.Context) error {
+	d.saving.Lock()
+	defer d.saving.Unlock()
+
+	d.mu.Lock()
+	d.pending = false
+	if !d.dirty || d.store == nil {
+		d.mu.Unlock()
+		return nil
+	}
+	d.dirty = false
+	baseline := d.copyBaseline()
+	d.mu.Unlock()
+
+	if err := d.store.SaveBaseline(ctx, baseline); err != nil {
+		d.mu.Lock()
+		// saved again with the next change
+		d.dirty = true
+		d.mu.Unlock()
+
+		return errors.Wrap(err, "failed to save drift baseline")
+	}
+
+	return nil
+}
+
+// Baseline returns a copy of the shapes learned so far.
+func (d *DriftDetector) Baseline() DriftBaseline {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.copyBaseline()
+}
+
+// copyBaseline deep copies the baseline, mu must be held.
+func (d *DriftDetector) copyBaseline() DriftBaseline {
+	b, _ := json.Marshal(d.baseline)
+	baseline := make(DriftBaseline)
+	json.Unmarshal(b, &baseline)
+
+	return baseline
+}
+
+// observe merges v in the shape of key, it returns whether the shape changed and its drifts once it is learned.
+func (d *DriftDetector) observe(key string, v any) (bool, []Drift) {
+	shape, ok := d.baseline[key]
+	if !ok {
+		if d.maxShapes > 0 && len(d.baseline) >= d.maxShapes {
+			return false, nil
+		}
+
+		shape = &DriftShape{}
+		d.baseline[key] = shape
+	}
+	if shape.Fields == nil {
+		shape.Fields = make(map[string]*DriftField)
+	}
+
+	learned := shape.Samples >= d.learning
+	changed := !learned
+	if !learned {
+		shape.Samples++
+	}
+
+	body := newBodyFields()
+	body.walk("", v)
+
+	drifts := make([]Drift, 0)
+	report := func(drift Drift) {
+		if learned {
+			drift.Key = key
+			drifts = append(drifts, drift)
+		}
+	}
+
+	pointers := make([]string, 0, len(body.types))
+	for pointer := range body.types {
+		pointers = append(pointers, pointer)
+	}
+	sort.Strings(pointers)
+
+	added := make(map[string]bool)
+	for _, pointer := range pointers {
+		types := body.sortedTypes(pointer)
+		partial := body.counts[pointer] < body.objects[parentPointer(pointer)]
+
+		field, ok := shape.Fields[pointer]
+		if !ok {
+			if len(shape.Fields) >= maxDriftFields {
+				continue
+			}
+
+			// a field of the first sample is required until a sample misses it
+			shape.Fields[pointer] = &DriftField{Types: types, Optional: shape.Samples > 1 || partial}
+			changed = true
+			added[pointer] = true
+
+			if pointer != "" && !added[parentPointer(pointer)] && !strings.HasSuffix(pointer, "/*") {
+				report(Drift{Kind: DriftAdded, Pointer: pointer, Got: types})
+			}
+			continue
+		}
+
+		if merged := mergeTypes(field.Types, types); len(merged) != len(field.Types) {
+			report(Drift{Kind: DriftTypeChanged, Pointer: pointer, Types: field.Types, Got: types})
+			field.Types = merged
+			changed = true
+		}
+
+		if partial && !field.Optional {
+			report(Drift{Kind: DriftRemoved, Pointer: pointer, Types: field.Types})
+			field.Optional = true
+			changed = true
+		}
+	}
+
+	missing := make([]string, 0)
+	for pointer, field := range shape.Fields {
+		// a field is missing when its object is there without it
+		if !field.Optional && pointer != "" && body.counts[pointer] == 0 && body.objects[parentPointer(pointer)] > 0 {
+			missing = append(missing, pointer)
+		}
+	}
+	sort.Strings(missing)
+
+	for _, pointer := range missing {
+		field := shape.Fields[pointer]
+		report(Drift{Kind: DriftRemoved, Pointer: pointer, Types: field.Types})
+		field.Optional = true
+		changed = true
+	}
+
+	return changed, drifts
+}
+
+// jsonBody decodes the body of p when it is a complete JSON document.
+func jsonBody(p *Payload) (any, bool) {
+	if len(p.Body) == 0 || p.Truncated || p.Multipart != nil || !looksLikeJSON(p) {
+		return nil, false
+	}
+
+	var v any
+	if err := json.Unmarshal(p.Body, &v); err != nil {
+		return nil, false
+	}
+
+	return v, true
+}
+
+// bodyFields are the fields of one body by JSON pointer.
+type bodyFields struct {
+	types   map[string]map[string]bool
+	counts  map[string]int
+	objects map[string]int
+}
+
+func newBodyFields() *bodyFields {
+	return &bodyFields{
+		types:   make(map[string]map[string]bool),
+		counts:  make(map[string]int),
+		objects: make(map[string]int),
+	}
+}
+
+func (b *bodyFields) walk(pointer string, v any) {
+	if b.types[pointer] == nil {
+		b.types[pointer] = make(map[string]bool)
+	}
+	b.counts[pointer]++
+
+	switch v := v.(type) {
+	case nil:
+		b.types[pointer]["null"] = true
+	case bool:
+		b.types[pointer]["boolean"] = true
+	case float64:
+		b.types[pointer]["number"] = true
+	case string:
+		b.types[pointer]["string"] = true
+	case []any:
+		b.types[pointer]["array"] = true
+		for _, x := range v {
+			b.walk(pointer+"/*", x)
+		}
+	case map[string]any:
+		b.types[pointer]["object"] = true
+		b.objects[pointer]++
+		for k, x := range v {
+			b.walk(pointer+"/"+strings.NewReplacer("~", "~0", "/", "~1").Replace(k), x)
+		}
+	}
+}
+
+func (b *bodyFields) sortedTypes(pointer string) []string {
+	types := make([]string, 0, len(b.types[pointer]))
+	for typ := range b.types[pointer] {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+
+	return types
+}
+
+func parentPointer(pointer string) string {
+	i := strings.LastIndex(pointer, "/")
+	if i < 0 {
+		return ""
+	}
+
+	return pointer[:i]
+}
+
+// mergeTypes returns the sorted union of a and b.
+func mergeTypes(a []string, b []string) []string {
+	merged := append([]string{}, a...)
+	for _, typ := range b {
+		if !sliceHas(merged, typ) {
+			merged = append(merged, typ)
+		}
+	}
+	sort.Strings(merged)
+
+	return merged
+}
+
+func sliceHas(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FileDriftBaselineStore keeps the baseline in a JSON file.
+type FileDriftBaselineStore struct {
+	path string
+}
+
+func NewFileDriftBaselineStore(path string) *FileDriftBaselineStore {
+	return &FileDriftBaselineStore{path: path}
+}
+
+// LoadBaseline returns an empty baseline when the file doesn't exist yet.
+func (s *FileDriftBaselineStore) LoadBaseline(ctx context.Context) (DriftBaseline, error) {
+	b, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return make(DriftBaseline), nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read drift baseline")
+	}
+
+	baseline := make(DriftBaseline)
+	if err := json.Unmarshal(b, &baseline); err != nil {
+		return nil, errors.Wrap(err, "invalid drift baseline")
+	}
+
+	return baseline, nil
+}
+
+// SaveBaseline replaces the file atomically, a crash can't leave a partial baseline.
+func (s *FileDriftBaselineStore) SaveBaseline(ctx context.Context, baseline DriftBaseline) error {
+	b, err := json.MarshalIndent(baseline, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode drift baseline")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return errors.Wrap(err, "failed to create drift baseline directory")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return errors.Wrap(err, "failed to write drift baseline")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "failed to write drift baseline")
+	}
+
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "failed to write drift baseline")
+	}
+
+	return errors.Wrap(os.Rename(tmp.Name(), s.path), "failed to write drift baseline")
+}
@@ -0,0 +1,265 @@
+package hachibi_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mtfiqh/hachibi"
+)
+
+func driftCapture(status int, body string) *hachibi.HttpData {
+	httpData := &hachibi.HttpData{Method: http.MethodGet, URL: "https://api.example.com/users/1", StatusCode: status}
+	httpData.Response.Header = http.Header{"Content-Type": {"application/json"}}
+	httpData.Response.Body = []byte(body)
+
+	return httpData
+}
+
+func TestDriftDetector(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("drifts", func(t *testing.T) {
+		var got []hachibi.Drift
+		d := hachibi.NewDriftDetector(hachibi.DriftWithLearning(2), hachibi.DriftWithHook(func(ctx context.Context, httpData *hachibi.HttpData, drifts []hachibi.Drift) {
+			got = append(got, drifts...)
+		}))
+
+		for _, body := range []string{
+			`{"id":1,"name":"Taufiq","nickname":"fiq","orders":[{"id":1,"total":10}]}`,
+			`{"id":2,"name":"Budi","orders":[]}`,
+		} {
+			if err := d.Process(ctx, driftCapture(200, body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if len(got) != 0 {
+			t.Fatalf("no drift must be reported while learning %v", got)
+		}
+
+		d.Process(ctx, driftCapture(200, `{"id":"3","orders":[{"id":2}],"address":{"city":"Jakarta"}}`))
+
+		want := []string{
+			"response GET /users/{id} 200: new field /address of type object",
+			"response GET /users/{id} 200: field /id is string, was number",
+			"response GET /users/{id} 200: field /name is missing",
+			"response GET /users/{id} 200: field /orders/*/total is missing",
+		}
+		if len(got) != len(want) {
+			t.Fatalf("unexpected drifts %v", got)
+		}
+		for i, drift := range got {
+			if drift.Error() != want[i] {
+				t.Errorf("got %s, want %s", drift.Error(), want[i])
+			}
+		}
+
+		got = nil
+		d.Process(ctx, driftCapture(200, `{"id":"4","orders":[{"id":3}],"address":{"city":"Bandung"}}`))
+		d.Process(ctx, driftCapture(200, `{"id":5,"name":"Siti","orders":[]}`))
+		if len(got) != 0 {
+			t.Fatalf("a drift must be reported once %v", got)
+		}
+
+		baseline := d.Baseline()["response GET /users/{id} 200"]
+		if baseline.Samples != 2 || !reflect.DeepEqual(baseline.Fields["/id"].Types, []string{"number", "string"}) || !baseline.Fields["/nickname"].Optional || !baseline.Fields["/name"].Optional {
+			t.Fatalf("unexpected baseline %+v", baseline)
+		}
+	})
+
+	t.Run("keys", func(t *testing.T) {
+		d := hachibi.NewDriftDetector(hachibi.DriftWithLearning(1))
+
+		d.Process(ctx, driftCapture(200, `{"id":1}`))
+		d.Process(ctx, driftCapture(404, `{"message":"not found"}`))
+
+		routed := driftCapture(200, `[1]`)
+		routed.Route = "/users/:id"
+		d.Process(ctx, routed)
+
+		text := driftCapture(200, `plain`)
+		text.Response.Header.Set("Content-Type", "text/plain")
+		d.Process(ctx, text)
+
+		truncated := driftCapture(200, `{"name":`)
+		truncated.Response.Truncated = true
+		d.Process(ctx, truncated)
+
+		if baseline := d.Baseline(); len(baseline) != 3 || baseline["response GET /users/{id} 404"] == nil || baseline["response GET /users/:id 200"] == nil {
+			t.Fatalf("unexpected baseline %v", baseline)
+		}
+
+		err := d.Process(ctx, driftCapture(200, `{"id":2,"email":"taufiq@example.com"}`))
+		drifts, ok := err.(hachibi.Error)
+		if !ok || len(drifts) != 1 || drifts[0].(hachibi.Drift).Kind != hachibi.DriftAdded {
+			t.Fatalf("the drifts must be returned without hook, got %v", err)
+		}
+	})
+
+	t.Run("baseline store", func(t *testing.T) {
+		store := hachibi.NewFileDriftBaselineStore(filepath.Join(t.TempDir(), "drift", "baseline.json"))
+
+		d := hachibi.NewDriftDetector(hachibi.DriftWithLearning(1), hachibi.DriftWithBaselineStore(store))
+		if err := d.Process(ctx, driftCapture(200, `{"id":1}`)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := d.Flush(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		restarted := hachibi.NewDriftDetector(hachibi.DriftWithLearning(1), hachibi.DriftWithBaselineStore(store))
+		err := restarted.Process(ctx, driftCapture(200, `{"id":true}`))
+		if err == nil || err.Error() != "[response GET /users/{id} 200: field /id is boolean, was number]" {
+			t.Fatalf("the baseline must survive a restart, got %v", err)
+		}
+
+		restarted.Flush(ctx)
+		baseline, err := store.LoadBaseline(ctx)
+		if err != nil || !reflect.DeepEqual(baseline["response GET /users/{id} 200"].Fields["/id"].Types, []string{"boolean", "number"}) {
+			t.Fatalf("unexpected baseline %v %v", baseline, err)
+		}
+	})
+
+	t.Run("saves gathered", func(t *testing.T) {
+		store := &countingBaselineStore{saved: make(chan hachibi.DriftBaseline, 10)}
+		d := hachibi.NewDriftDetector(hachibi.DriftWithLearning(5), hachibi.DriftWithBaselineStore(store), hachibi.DriftWithSaveInterval(50*time.Millisecond))
+
+		for i := 0; i < 5; i++ {
+			if err := d.Process(ctx, driftCapture(200, `{"id":1}`)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		select {
+		case baseline := <-store.saved:
+			if baseline["response GET /users/{id} 200"].Samples != 5 {
+				t.Fatalf("unexpected baseline %+v", baseline)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("the baseline must be saved in the background")
+		}
+
+		time.Sleep(100 * time.Millisecond)
+		if len(store.saved) != 0 {
+			t.Fatalf("the changes must be saved at once, %d more saves", len(store.saved))
+		}
+
+		store.err = errors.New("disk full")
+		d.Process(ctx, driftCapture(201, `{"id":1}`))
+		<-store.saved
+		// the save fails, the next capture reports it
+		for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+			err := d.Process(ctx, driftCapture(200, `{"id":1}`))
+			if err != nil && strings.Contains(err.Error(), "failed to save drift baseline: disk full") {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("the failed save must be reported, got %v", err)
+			}
+		}
+	})
+
+	t.Run("hook", func(t *testing.T) {
+		store := &countingBaselineStore{saved: make(chan hachibi.DriftBaseline, 10)}
+		var d *hachibi.DriftDetector
+		var reported []hachibi.Drift
+		d = hachibi.NewDriftDetector(hachibi.DriftWithLearning(1), hachibi.DriftWithBaselineStore(store), hachibi.DriftWithSaveInterval(time.Hour),
+			hachibi.DriftWithHook(func(ctx context.Context, httpData *hachibi.HttpData, drifts []hachibi.Drift) {
+				// the detector isn't locked while its hook runs
+				d.Baseline()
+				d.Flush(ctx)
+				reported = append(reported, drifts...)
+			}))
+
+		done := make(chan error, 1)
+		go func() {
+			d.Process(ctx, driftCapture(200, `{"id":1}`))
+			done <- d.Process(ctx, driftCapture(200, `{"id":"1"}`))
+		}()
+
+		select {
+		case err := <-done:
+			if err != nil || len(reported) != 1 {
+				t.Fatalf("unexpected drifts %v %v", reported, err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("the hook must not deadlock on the detector")
+		}
+
+		full := &countingBaselineStore{saved: make(chan hachibi.DriftBaseline, 10), err: errors.New("disk full")}
+		failing := hachibi.NewDriftDetector(hachibi.DriftWithLearning(1), hachibi.DriftWithBaselineStore(full), hachibi.DriftWithSaveInterval(10*time.Millisecond),
+			hachibi.DriftWithHook(func(ctx context.Context, httpData *hachibi.HttpData, drifts []hachibi.Drift) {}))
+		failing.Process(ctx, driftCapture(200, `{"id":1}`))
+		<-full.saved
+		time.Sleep(50 * time.Millisecond)
+
+		if err := failing.Process(ctx, driftCapture(200, `{"id":"1"}`)); err == nil || !strings.Contains(err.Error(), "disk full") {
+			t.Fatalf("the failed save must be reported with the drifts given to the hook, got %v", err)
+		}
+	})
+
+	t.Run("max shapes", func(t *testing.T) {
+		d := hachibi.NewDriftDetector(hachibi.DriftWithLearning(1), hachibi.DriftWithMaxShapes(2))
+		for _, status := range []int{200, 201, 202} {
+			d.Process(ctx, driftCapture(status, `{"id":1}`))
+		}
+
+		if baseline := d.Baseline(); len(baseline) != 2 || baseline["response GET /users/{id} 202"] != nil {
+			t.Fatalf("unexpected baseline %v", baseline)
+		}
+	})
+
+	t.Run("transport", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			writer.Header().Set("Content-Type", "application/json")
+			if request.URL.Query().Get("v") == "2" {
+				writer.Write([]byte(`{"id":"1"}`))
+				return
+			}
+			writer.Write([]byte(`{"id":1}`))
+		}))
+		defer server.Close()
+
+		handled := &errorCollector{}
+		client := &http.Client{Transport: hachibi.NewTransport(
+			hachibi.TransportWithPreProcessor(hachibi.NewDriftDetector(hachibi.DriftWithLearning(1))),
+			hachibi.TransportWithErrorHandler(handled),
+		)}
+
+		for _, target := range []string{server.URL + "/users/1", server.URL + "/users/2?v=2"} {
+			response, err := client.Get(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response.Body.Close()
+		}
+
+		if handled.err.Error() != "[pre process error: [response GET /users/{id} 200: field /id is string, was number]]" {
+			t.Fatalf("unexpected error %v", handled.err)
+		}
+	})
+}
+
+// countingBaselineStore gives every saved baseline to saved.
+type countingBaselineStore struct {
+	saved chan hachibi.DriftBaseline
+	err   error
+}
+
+func (s *countingBaselineStore) LoadBaseline(ctx context.Context) (hachibi.DriftBaseline, error) {
+	return nil, nil
+}
+
+func (s *countingBaselineStore) SaveBaseline(ctx context.Context, baseline hachibi.DriftBaseline) error {
+	err := s.err
+	s.saved <- baseline
+	return err
+}
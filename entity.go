@@ -4,14 +4,19 @@ import (
 	"bytes"
 	"encoding/json"
 	"io"
-	"mime/multipart"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
 type HttpData struct {
+	// ID identifies the exchange, StartedAt is when its request was received or sent.
+	ID        string    `json:"id"`
+	StartedAt time.Time `json:"startedAt"`
+
 	Request  Request  `json:"request"`
 	Response Response `json:"response"`
 
@@ -21,8 +26,60 @@ type HttpData struct {
 	StatusCode int    `json:"statusCode"`
 
 	Event string `json:"event"`
+	// Route is the pattern of the route which handled the request, e.g. /users/{id}, set by the framework adapters.
+	Route string `json:"route,omitempty"`
+	// Peer is the address of the other side, the client of a server or the server of a client, when it's known.
+	Peer string `json:"peer,omitempty"`
+	// Origin is the scheme and host a server received the request on, e.g. https://api.example.com,
+	// the URL of a server capture is only the path, see FullURL.
+	Origin string `json:"origin,omitempty"`
 
 	Error Error `json:"error"`
+
+	Images []CapturedImage `json:"images,omitempty"`
+
+	// Violations are the differences with the contract of the API, e.g. found by an OpenAPI validator.
+	Violations []Violation `json:"violations,omitempty"`
+
+	// PII is the personal data and the secrets found by a PIIDetector.
+	PII []DetectedPII `json:"pii,omitempty"`
+
+	// Upstream is the exchange of a ReverseProxy with its upstream, as it was sent and received by the proxy.
+	Upstream *HttpData `json:"upstream,omitempty"`
+
+	requestTee *multipartTee
+}
+
+const (
+	ViolationUnknownPath        = "unknown path"
+	ViolationUnknownOperation   = "unknown operation"
+	ViolationUnknownStatus      = "unknown status"
+	ViolationUnknownContentType = "unknown content type"
+	ViolationParameter          = "invalid parameter"
+	ViolationSchema             = "schema mismatch"
+)
+
+// Violation is a difference between an exchange and the contract of its API.
+type Violation struct {
+	// Kind is one of the Violation constants, e.g. ViolationSchema.
+	Kind string `json:"kind"`
+	// In is the part of the exchange, e.g. "request.body", "response.body" or "request.query".
+	In string `json:"in,omitempty"`
+	// Pointer is the JSON pointer of the invalid value in a body, or the name of a parameter.
+	Pointer string `json:"pointer,omitempty"`
+	Message string `json:"message"`
+}
+
+func (v Violation) String() string {
+	s := v.Kind
+	if v.In != "" {
+		s += " in " + v.In
+	}
+	if v.Pointer != "" {
+		s += " at " + v.Pointer
+	}
+
+	return s + ": " + v.Message
 }
 
 func (h *HttpData) AppendError(e error) {
@@ -33,151 +90,205 @@ func (h *HttpData) AppendError(e error) {
 	h.Error = append(h.Error, e)
 }
 
-func (t *HttpData) extractResponse(r *http.Response) error {
+// extractResponse captures the status and the headers of the response, its body is captured while the caller reads
+// it and done runs once the body is read or closed, limit is how much of the body is kept, -1 for all of it.
+// The body of a 101 Switching Protocols is the upgraded connection, it's left untouched and done runs right away.
+func (t *HttpData) extractResponse(r *http.Response, limit int, done func()) {
 	t.StatusCode = r.StatusCode
 	t.Response.Header = r.Header
 
-	bodyBytes, err := io.ReadAll(r.Body)
-	if err != nil {
-		return err
+	if limit == 0 || r.Body == nil || r.Body == http.NoBody || r.StatusCode == http.StatusSwitchingProtocols {
+		done()
+		return
 	}
-	r.Body.Close()
 
-	t.Response.Body = bodyBytes
+	r.Body = &recordingBody{body: r.Body, limit: limit, length: r.ContentLength, done: func(body []byte, truncated bool, err error) {
+		t.Response.Body, t.Response.Truncated = body, truncated
+		if err != nil {
+			t.AppendError(errors.Wrap(err, "failed to read response body"))
+		}
 
-	r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+		done()
+	}}
+}
 
-	return nil
+// recordingBody keeps what is read from body so streamed responses, e.g. server-sent events, reach the caller as
+// they come, done runs once at the end of body, on a read error or on Close.
+type recordingBody struct {
+	body   io.ReadCloser
+	limit  int
+	length int64
+
+	mu        sync.Mutex
+	recorded  bytes.Buffer
+	read      int64
+	truncated bool
+	finished  bool
+	done      func(body []byte, truncated bool, err error)
 }
 
-func (t *HttpData) extractRequest(r *http.Request) error {
-	t.URL = r.URL.String()
-	t.Method = r.Method
-	t.Request.Header = r.Header.Clone()
+func (r *recordingBody) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
 
-	if strings.Contains(r.Header.Get("Content-Type"), "multipart/form-data") {
-		return t.exportMultipartFormData(r)
+	r.mu.Lock()
+	r.read += int64(n)
+	captured := p[:n]
+	if r.limit >= 0 && r.recorded.Len()+n > r.limit {
+		captured = captured[:r.limit-r.recorded.Len()]
+		r.truncated = true
 	}
-
-	if r.Body != nil {
-		body, err := io.ReadAll(r.Body)
-		r.Body.Close()
-		if err != nil {
-			return errors.Wrap(err, "failed to read all request body")
-		}
-
-		t.Request.Body = body
-
-		r.Body = io.NopCloser(bytes.NewBuffer(body))
+	r.recorded.Write(captured)
+	r.mu.Unlock()
+
+	switch {
+	case err == io.EOF:
+		r.finish(false, nil)
+	case err != nil:
+		r.finish(true, err)
 	}
 
-	return nil
+	return n, err
 }
 
-type MultipartFileData struct {
-	FileName string `json:"file_name"`
-	Size     int64  `json:"size"`
-	File     []byte `json:"file"`
-}
+// closeDrainLimit is how much of the rest of a body of known length is still read to complete its capture when
+// the caller closes it early.
+const closeDrainLimit = 10 << 20
 
-func (t *HttpData) exportMultipartFormData(request *http.Request) error {
-	r := request.Clone(request.Context())
-	// copy the body
-	rBody, err := io.ReadAll(request.Body)
-	if err != nil {
-		return err
+// Close finishes the capture when the caller stops before the end, the rest of a body of known length is read
+// when it's small, a stream is left as it is and its capture is truncated.
+func (r *recordingBody) Close() error {
+	r.mu.Lock()
+	drain := !r.finished && r.length >= 0 && r.length-r.read <= closeDrainLimit
+	r.mu.Unlock()
+
+	if drain {
+		io.Copy(io.Discard, r)
 	}
-	request.Body.Close()
 
-	//fill body
-	request.Body = io.NopCloser(bytes.NewBuffer(rBody))
-	r.Body = io.NopCloser(bytes.NewBuffer(rBody))
+	err := r.body.Close()
+	r.finish(r.length < 0 || r.read < r.length, nil)
+	return err
+}
 
-	err = r.ParseMultipartForm(32 << 20)
-	if err != nil {
-		return err
+func (r *recordingBody) finish(incomplete bool, err error) {
+	r.mu.Lock()
+	if r.finished {
+		r.mu.Unlock()
+		return
 	}
 
-	m := r.MultipartForm
+	r.finished = true
+	body := append([]byte(nil), r.recorded.Bytes()...)
+	truncated := r.truncated || incomplete
+	r.mu.Unlock()
 
-	body := map[string]any{}
+	r.done(body, truncated, err)
+}
 
-	files := m.File
-	for key, fs := range files {
-		var fileBody any
+// extractRequest captures the request, limit is how much of the body is read, -1 for all of it.
+func (t *HttpData) extractRequest(r *http.Request, store BlobStore, limit int) error {
+	t.URL = r.URL.String()
+	t.Method = r.Method
+	t.Request.Header = r.Header.Clone()
 
-		if len(fs) > 1 {
+	if limit == 0 {
+		return nil
+	}
 
-			allFiles := make([]MultipartFileData, 0)
+	if boundary, ok := multipartBoundary(r.Header.Get("Content-Type")); ok && r.Body != nil && r.Body != http.NoBody {
+		t.requestTee = newMultipartTee(r.Context(), r.Body, boundary, store)
+		r.Body = t.requestTee
+		return nil
+	}
 
-			for _, f := range fs {
-				file, err := extractFileData(f)
-				if err != nil {
-					return err
-				}
+	if r.Body == nil {
+		return nil
+	}
 
-				allFiles = append(allFiles, *file)
+	if limit > 0 {
+		body, truncated, rest, err := readBodyLimit(r.Body, limit)
+		t.Request.Body, t.Request.Truncated, r.Body = body, truncated, rest
+		return errors.Wrap(err, "failed to read request body")
+	}
 
-			}
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return errors.Wrap(err, "failed to read all request body")
+	}
 
-			fileBody = allFiles
+	t.Request.Body = body
 
-		} else {
-			file, err := extractFileData(fs[0])
-			if err != nil {
-				return err
-			}
+	r.Body = io.NopCloser(bytes.NewBuffer(body))
 
-			fileBody = file
-		}
+	return nil
+}
 
-		body[key] = fileBody
+// readBodyLimit captures the first limit bytes of body without reading the rest, the returned body replaces the
+// original and still yields every byte.
+func readBodyLimit(body io.ReadCloser, limit int) ([]byte, bool, io.ReadCloser, error) {
+	if body == nil || limit == 0 {
+		return nil, false, body, nil
 	}
 
-	for key, v := range m.Value {
-		if len(v) > 1 {
-			body[key] = v
-			continue
-		}
+	read, err := io.ReadAll(io.LimitReader(body, int64(limit)+1))
+	rest := readCloser{Reader: io.MultiReader(bytes.NewReader(read), body), Closer: body}
 
-		body[key] = v[0]
+	if len(read) > limit {
+		return read[:limit:limit], true, rest, err
 	}
 
-	b, err := json.Marshal(body)
-	if err != nil {
-		return err
-	}
+	return read, false, rest, err
+}
 
-	t.Request.Body = b
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
 
-	return nil
+type MultipartFileData struct {
+	FileName    string `json:"file_name"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type,omitempty"`
+	SHA256      string `json:"sha256,omitempty"`
+	File        []byte `json:"file,omitempty"`
+
+	// Ref is the BlobStore reference of the content when it was not kept inline.
+	Ref string `json:"ref,omitempty"`
 }
 
-func extractFileData(f *multipart.FileHeader) (*MultipartFileData, error) {
-	file, err := f.Open()
-	if err != nil {
-		return nil, err
-	}
+func (f MultipartFileData) withoutContent() MultipartFileData {
+	f.File = nil
+	return f
+}
 
-	fileByte, err := io.ReadAll(file)
-	if err != nil {
-		return nil, err
-	}
+// GetMultipartFileDataFromRequest returns the files sent under key in a multipart request.
+func (t HttpData) GetMultipartFileDataFromRequest(key string) ([]MultipartFileData, error) {
+	return t.Request.multipartFiles(key)
+}
 
-	return &MultipartFileData{
-		FileName: f.Filename,
-		Size:     f.Size,
-		File:     fileByte,
-	}, nil
+// GetMultipartFileDataFromResponse returns the files sent under key in a multipart response.
+func (t HttpData) GetMultipartFileDataFromResponse(key string) ([]MultipartFileData, error) {
+	return t.Response.multipartFiles(key)
 }
 
-func (t HttpData) GetMultipartFileDataFromRequest(key string) ([]MultipartFileData, error) {
-	if !strings.Contains(t.Request.Header.Get("Content-Type"), "multipart/form-data") {
+func (p Payload) multipartFiles(key string) ([]MultipartFileData, error) {
+	if p.Multipart != nil {
+		files := p.Multipart.FilesOf(key)
+		if len(files) == 0 {
+			return nil, errors.Errorf("no file %s", key)
+		}
+
+		return files, nil
+	}
+
+	if !strings.Contains(p.Header.Get("Content-Type"), "multipart/") {
 		return nil, errors.New("not multipart form data")
 	}
 
+	// records captured by older versions keep the multipart as JSON in the body
 	body := make(map[string]any)
-	if err := json.Unmarshal(t.Request.Body, &body); err != nil {
+	if err := json.Unmarshal(p.Body, &body); err != nil {
 		return nil, errors.Wrap(err, "failed unmarshal to body")
 	}
 
@@ -201,3 +312,58 @@ func (t HttpData) GetMultipartFileDataFromRequest(key string) ([]MultipartFileDa
 
 	return fileDatas, nil
 }
+
+// Clone returns a deep copy of the headers, bodies and errors so the copy can be changed independently.
+// FullURL is URL prefixed by Origin when URL is only a path.
+func (h HttpData) FullURL() string {
+	if h.Origin == "" || !strings.HasPrefix(h.URL, "/") {
+		return h.URL
+	}
+
+	return h.Origin + h.URL
+}
+
+func (h HttpData) Clone() HttpData {
+	c := h
+	c.Request.Payload = h.Request.Payload.clone()
+	c.Response.Payload = h.Response.Payload.clone()
+
+	if h.Error != nil {
+		c.Error = append(make(Error, 0, len(h.Error)), h.Error...)
+	}
+
+	if h.Images != nil {
+		c.Images = append(make([]CapturedImage, 0, len(h.Images)), h.Images...)
+	}
+
+	if h.Violations != nil {
+		c.Violations = append(make([]Violation, 0, len(h.Violations)), h.Violations...)
+	}
+
+	if h.PII != nil {
+		c.PII = append(make([]DetectedPII, 0, len(h.PII)), h.PII...)
+	}
+
+	if h.Upstream != nil {
+		upstream := h.Upstream.Clone()
+		c.Upstream = &upstream
+	}
+
+	return c
+}
+
+func (p Payload) clone() Payload {
+	c := p
+	if p.Header != nil {
+		c.Header = p.Header.Clone()
+	}
+
+	if p.Body != nil {
+		c.Body = append(make([]byte, 0, len(p.Body)), p.Body...)
+	}
+
+	c.Multipart = p.Multipart.clone()
+	c.Decoded = p.Decoded.clone()
+
+	return c
+}
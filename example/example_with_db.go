@@ -1,71 +1,53 @@
 package example
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
-	"hachibi"
+	"log"
 	"net/http"
-)
 
-type LoggerAdditional interface {
-	ProcessingImage(transport *hachibi.Transport) error
-}
+	"github.com/mtfiqh/hachibi"
+)
 
 type Logger struct {
 	//	 repo
-
-	LoggerAdditional
 }
 
-type LoggerImage struct {
-	//	repo gcs
-	Request struct {
-		Email string `json:"email"`
-		Phone string `json:"phone"`
-		Image string `json:"image"`
+func (l Logger) Process(ctx context.Context, httpData *hachibi.HttpData) error {
+	//	todo inser db
+	b, err := json.Marshal(httpData)
+	if err != nil {
+		return err
 	}
-}
-
-func (l LoggerImage) ProcessingImage(transport *hachibi.Transport) error {
-	request := LoggerImage{}
-
-	json.Unmarshal(transport.Request.Body, &request)
-
-	imageB64 := request.Request.Image
-
-	imageB64 = fmt.Sprintf("upload/image-123")
-
-	request.Request.Image = imageB64
-
-	requestB, _ := json.Marshal(request.Request)
-
-	transport.Request.Body = requestB
 
+	log.Println(string(b))
 	return nil
 }
 
-func (l Logger) Process(transport hachibi.Transport) error {
-	//	todo inser db
-	if l.LoggerAdditional != nil {
-		l.LoggerAdditional.ProcessingImage(&transport)
+func DoLiveness() {
+	store, err := hachibi.NewFileBlobStore("upload")
+	if err != nil {
+		log.Fatal(err)
 	}
-	return nil
-}
 
-func (l Logger) AdditionalData(additional LoggerAdditional) Logger {
-	logger := l
-	logger.LoggerAdditional = additional
-	return logger
-}
+	// the image field of the request body, {"image": "<base64>"}, is compressed and offloaded to the store
+	// when it's still bigger than 64KB
+	images := hachibi.NewImagePreProcessor(
+		hachibi.ImageWithPaths("image"),
+		hachibi.ImageWithCompression(hachibi.WithCompressQuality(), hachibi.WithResizeImage()),
+		hachibi.ImageWithMaxSize(64<<10),
+		hachibi.ImageWithBlobStore(store),
+	)
 
-func DoLiveness() {
-
-	transport := hachibi.NewTransport(hachibi.WithProcessingData(Logger{}.AdditionalData(LoggerImage{})))
+	transport := hachibi.NewTransport(hachibi.TransportWithPreProcessor(images), hachibi.TransportWithProcessor(Logger{}))
 	client := http.Client{Transport: transport}
 
 	req, _ := http.NewRequest("", "", nil)
 
-	res, _ := client.Do(req)
+	res, err := client.Do(req)
+	if err != nil {
+		return
+	}
 
-	_ = res
+	res.Body.Close()
 }
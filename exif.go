@@ -0,0 +1,428 @@
+package hachibi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	exifTagOrientation      = 0x0112
+	exifTagMake             = 0x010f
+	exifTagModel            = 0x0110
+	exifTagSoftware         = 0x0131
+	exifTagDateTime         = 0x0132
+	exifTagExifIFD          = 0x8769
+	exifTagGPSIFD           = 0x8825
+	exifTagDateTimeOriginal = 0x9003
+
+	exifTagGPSLatitudeRef  = 0x0001
+	exifTagGPSLatitude     = 0x0002
+	exifTagGPSLongitudeRef = 0x0003
+	exifTagGPSLongitude    = 0x0004
+
+	exifTypeASCII    = 2
+	exifTypeShort    = 3
+	exifTypeLong     = 4
+	exifTypeRational = 5
+)
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// ImageMetadata is what is known about an image before it's re-encoded.
+// Width and Height are the stored dimensions, an Orientation from 5 to 8 swaps them on display.
+type ImageMetadata struct {
+	Format      string `json:"format"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Orientation int    `json:"orientation,omitempty"`
+
+	HasEXIF          bool     `json:"hasExif"`
+	Make             string   `json:"make,omitempty"`
+	Model            string   `json:"model,omitempty"`
+	Software         string   `json:"software,omitempty"`
+	DateTime         string   `json:"dateTime,omitempty"`
+	DateTimeOriginal string   `json:"dateTimeOriginal,omitempty"`
+	GPS              *GPSInfo `json:"gps,omitempty"`
+
+	// app1 is the raw EXIF segment and orientationOffset the position of the orientation value in it.
+	app1              []byte
+	orientationOffset int
+	byteOrder         binary.ByteOrder
+}
+
+type GPSInfo struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// DecodeImageMetadata reads the format, dimensions and, for JPEG, the EXIF of the image in r.
+func DecodeImageMetadata(r io.Reader) (*ImageMetadata, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read image")
+	}
+
+	return decodeImageMetadata(b)
+}
+
+func decodeImageMetadata(b []byte) (*ImageMetadata, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode image config")
+	}
+
+	m := &ImageMetadata{Format: format, Width: config.Width, Height: config.Height}
+	if format != FormatJPEG {
+		return m, nil
+	}
+
+	app1 := findJPEGExif(b)
+	if app1 == nil {
+		return m, nil
+	}
+
+	if err := m.parseExif(app1); err != nil {
+		return m, err
+	}
+
+	return m, nil
+}
+
+// findJPEGExif returns the payload of the first APP1 segment holding EXIF.
+func findJPEGExif(b []byte) []byte {
+	var found []byte
+	walkJPEGSegments(b, func(marker byte, segment []byte) bool {
+		if marker == 0xe1 && bytes.HasPrefix(segment[4:], exifHeader) {
+			found = segment[4:]
+			return false
+		}
+		return true
+	})
+
+	return found
+}
+
+// walkJPEGSegments calls fn with every segment (marker and length included) before the image data,
+// it stops when fn returns false.
+func walkJPEGSegments(b []byte, fn func(marker byte, segment []byte) bool) int {
+	if len(b) < 2 || b[0] != 0xff || b[1] != 0xd8 {
+		return -1
+	}
+
+	i := 2
+	for i+4 <= len(b) {
+		if b[i] != 0xff {
+			return -1
+		}
+
+		marker := b[i+1]
+		if marker == 0xda || marker == 0xd9 {
+			return i
+		}
+
+		length := int(binary.BigEndian.Uint16(b[i+2:]))
+		if length < 2 || i+2+length > len(b) {
+			return -1
+		}
+
+		if !fn(marker, b[i:i+2+length]) {
+			return i
+		}
+		i += 2 + length
+	}
+
+	return -1
+}
+
+// StripJPEGMetadata removes the EXIF, XMP and comment segments of a JPEG without re-encoding it.
+// JFIF (APP0), ICC profiles (APP2) and Adobe (APP14) segments are kept as they change how colors are decoded.
+func StripJPEGMetadata(b []byte) ([]byte, error) {
+	out := make([]byte, 0, len(b))
+	out = append(out, 0xff, 0xd8)
+
+	end := walkJPEGSegments(b, func(marker byte, segment []byte) bool {
+		keep := marker < 0xe0 || marker > 0xef || marker == 0xe0 || marker == 0xe2 || marker == 0xee
+		if marker == 0xfe {
+			keep = false
+		}
+
+		if keep {
+			out = append(out, segment...)
+		}
+		return true
+	})
+	if end < 0 {
+		return nil, errors.New("invalid jpeg")
+	}
+
+	return append(out, b[end:]...), nil
+}
+
+func (m *ImageMetadata) parseExif(app1 []byte) error {
+	tiff := app1[len(exifHeader):]
+	if len(tiff) < 8 {
+		return errors.New("invalid exif header")
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return errors.New("invalid exif byte order")
+	}
+
+	if order.Uint16(tiff[2:]) != 42 {
+		return errors.New("invalid exif magic")
+	}
+
+	m.HasEXIF = true
+	m.app1 = app1
+	m.byteOrder = order
+
+	p := tiffParser{data: tiff, order: order}
+	var exifIFD, gpsIFD uint32
+	err := p.walkIFD(order.Uint32(tiff[4:]), func(e tiffEntry) {
+		switch e.tag {
+		case exifTagOrientation:
+			if e.typ == exifTypeShort {
+				m.Orientation = int(order.Uint16(tiff[e.valueOffset:]))
+				m.orientationOffset = len(exifHeader) + e.valueOffset
+			}
+		case exifTagMake:
+			m.Make = p.ascii(e)
+		case exifTagModel:
+			m.Model = p.ascii(e)
+		case exifTagSoftware:
+			m.Software = p.ascii(e)
+		case exifTagDateTime:
+			m.DateTime = p.ascii(e)
+		case exifTagExifIFD:
+			exifIFD = p.long(e)
+		case exifTagGPSIFD:
+			gpsIFD = p.long(e)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if exifIFD != 0 {
+		err := p.walkIFD(exifIFD, func(e tiffEntry) {
+			if e.tag == exifTagDateTimeOriginal {
+				m.DateTimeOriginal = p.ascii(e)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if gpsIFD != 0 {
+		var latRef, lonRef string
+		var lat, lon float64
+		var hasLat, hasLon bool
+		err := p.walkIFD(gpsIFD, func(e tiffEntry) {
+			switch e.tag {
+			case exifTagGPSLatitudeRef:
+				latRef = p.ascii(e)
+			case exifTagGPSLongitudeRef:
+				lonRef = p.ascii(e)
+			case exifTagGPSLatitude:
+				lat, hasLat = p.degrees(e)
+			case exifTagGPSLongitude:
+				lon, hasLon = p.degrees(e)
+			}
+		})
+		if err != nil {
+			return err
+		}
+
+		if hasLat && hasLon {
+			if latRef == "S" {
+				lat = -lat
+			}
+			if lonRef == "W" {
+				lon = -lon
+			}
+
+			m.GPS = &GPSInfo{Latitude: lat, Longitude: lon}
+		}
+	}
+
+	return nil
+}
+
+// exifWithoutOrientation returns a copy of the EXIF segment with the orientation reset to 1,
+// the pixels it goes with are already oriented.
+func (m *ImageMetadata) exifWithoutOrientation() []byte {
+	app1 := append([]byte(nil), m.app1...)
+	if m.orientationOffset > 0 {
+		m.byteOrder.PutUint16(app1[m.orientationOffset:], 1)
+	}
+
+	return app1
+}
+
+type tiffEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	// valueOffset is where the value starts in the TIFF data, inline or not.
+	valueOffset int
+}
+
+type tiffParser struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+func (p tiffParser) walkIFD(offset uint32, fn func(tiffEntry)) error {
+	if int(offset)+2 > len(p.data) {
+		return errors.New("invalid exif ifd offset")
+	}
+
+	n := int(p.order.Uint16(p.data[offset:]))
+	start := int(offset) + 2
+	if start+n*12 > len(p.data) {
+		return errors.New("invalid exif ifd")
+	}
+
+	for i := 0; i < n; i++ {
+		b := p.data[start+i*12:]
+		e := tiffEntry{
+			tag:   p.order.Uint16(b),
+			typ:   p.order.Uint16(b[2:]),
+			count: p.order.Uint32(b[4:]),
+		}
+
+		size := int(e.count) * tiffTypeSize(e.typ)
+		e.valueOffset = start + i*12 + 8
+		if size > 4 {
+			e.valueOffset = int(p.order.Uint32(b[8:]))
+		}
+
+		if size < 0 || e.valueOffset+size > len(p.data) {
+			continue
+		}
+
+		fn(e)
+	}
+
+	return nil
+}
+
+func tiffTypeSize(typ uint16) int {
+	switch typ {
+	case exifTypeShort:
+		return 2
+	case exifTypeLong:
+		return 4
+	case exifTypeRational:
+		return 8
+	default:
+		return 1
+	}
+}
+
+func (p tiffParser) ascii(e tiffEntry) string {
+	if e.typ != exifTypeASCII {
+		return ""
+	}
+
+	s := string(p.data[e.valueOffset : e.valueOffset+int(e.count)])
+	return strings.TrimSpace(strings.TrimRight(s, "\x00"))
+}
+
+func (p tiffParser) long(e tiffEntry) uint32 {
+	if e.typ != exifTypeLong {
+		return 0
+	}
+
+	return p.order.Uint32(p.data[e.valueOffset:])
+}
+
+// degrees converts the degrees, minutes and seconds rationals of a GPS coordinate.
+func (p tiffParser) degrees(e tiffEntry) (float64, bool) {
+	if e.typ != exifTypeRational || e.count != 3 {
+		return 0, false
+	}
+
+	value := 0.0
+	for i, div := range []float64{1, 60, 3600} {
+		b := p.data[e.valueOffset+i*8:]
+		num, den := p.order.Uint32(b), p.order.Uint32(b[4:])
+		if den == 0 {
+			return 0, false
+		}
+
+		value += float64(num) / float64(den) / div
+	}
+
+	return value, true
+}
+
+// applyOrientation turns the stored pixels to the way they are meant to be displayed.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	src, ok := img.(*image.RGBA)
+	if !ok || bounds.Min != (image.Point{}) {
+		src = image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+	}
+
+	w, h := bounds.Dx(), bounds.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+
+	return dst
+}
+
+// insertJPEGSegment writes an APPn segment right after the SOI marker of a JPEG.
+func insertJPEGSegment(b []byte, marker byte, payload []byte) []byte {
+	out := make([]byte, 0, len(b)+len(payload)+4)
+	out = append(out, b[:2]...)
+	out = append(out, 0xff, marker, byte((len(payload)+2)>>8), byte(len(payload)+2))
+	out = append(out, payload...)
+
+	return append(out, b[2:]...)
+}
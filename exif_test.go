@@ -0,0 +1,208 @@
+package hachibi_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/mtfiqh/hachibi"
+)
+
+// exifJPEG returns a 40x20 JPEG, red on the left and blue on the right, with an EXIF segment
+// holding orientation, a camera make and a GPS location at 6°12'S 106°49'E.
+func exifJPEG(t *testing.T, orientation uint16) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= 20 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+
+	order := binary.BigEndian
+	tiff := &bytes.Buffer{}
+	u16 := func(v uint16) { binary.Write(tiff, order, v) }
+	u32 := func(v uint32) { binary.Write(tiff, order, v) }
+	entry := func(tag, typ uint16, count, value uint32) {
+		u16(tag)
+		u16(typ)
+		u32(count)
+		u32(value)
+	}
+
+	const (
+		ifd0    = 8
+		makeAt  = ifd0 + 2 + 3*12 + 4
+		gpsIFD  = makeAt + 8
+		latAt   = gpsIFD + 2 + 4*12 + 4
+		lonAt   = latAt + 24
+		inlineS = uint32('S') << 24
+		inlineE = uint32('E') << 24
+	)
+
+	tiff.WriteString("MM")
+	u16(42)
+	u32(ifd0)
+
+	u16(3)
+	entry(0x010f, 2, 8, makeAt)
+	entry(0x0112, 3, 1, uint32(orientation)<<16)
+	entry(0x8825, 4, 1, gpsIFD)
+	u32(0)
+	tiff.WriteString("hachibi\x00")
+
+	u16(4)
+	entry(0x0001, 2, 2, inlineS)
+	entry(0x0002, 5, 3, latAt)
+	entry(0x0003, 2, 2, inlineE)
+	entry(0x0004, 5, 3, lonAt)
+	u32(0)
+	for _, v := range []uint32{6, 1, 12, 1, 0, 1, 106, 1, 49, 1, 0, 1} {
+		u32(v)
+	}
+
+	app1 := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	b := buf.Bytes()
+	out := append([]byte{}, b[:2]...)
+	out = append(out, 0xff, 0xe1, byte((len(app1)+2)>>8), byte(len(app1)+2))
+	out = append(out, app1...)
+
+	return append(out, b[2:]...)
+}
+
+func TestImageMetadata(t *testing.T) {
+	photo := exifJPEG(t, 6)
+
+	t.Run("decode", func(t *testing.T) {
+		m, err := hachibi.DecodeImageMetadata(bytes.NewReader(photo))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if m.Format != hachibi.FormatJPEG || m.Width != 40 || m.Height != 20 || !m.HasEXIF || m.Orientation != 6 || m.Make != "hachibi" {
+			t.Fatalf("unexpected metadata %+v", m)
+		}
+
+		if m.GPS == nil || math.Abs(m.GPS.Latitude+6.2) > 1e-9 || math.Abs(m.GPS.Longitude-(106+49.0/60)) > 1e-9 {
+			t.Fatalf("unexpected gps %+v", m.GPS)
+		}
+	})
+
+	t.Run("png", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		png.Encode(buf, image.NewGray(image.Rect(0, 0, 3, 2)))
+
+		m, err := hachibi.DecodeImageMetadata(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if m.Format != hachibi.FormatPNG || m.Width != 3 || m.Height != 2 || m.HasEXIF {
+			t.Fatalf("unexpected metadata %+v", m)
+		}
+	})
+
+	t.Run("compress orients and strips", func(t *testing.T) {
+		result, err := hachibi.Compress(bytes.NewReader(photo), hachibi.WithCompressQuality(hachibi.WithQualityValue(90)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result.Width != 20 || result.Height != 40 || result.OriginalWidth != 20 || result.OriginalHeight != 40 || result.Metadata.Make != "hachibi" {
+			t.Fatalf("unexpected result %+v", result)
+		}
+
+		if bytes.Contains(result.Bytes, []byte("Exif")) || bytes.Contains(result.Bytes, []byte("hachibi")) {
+			t.Fatal("metadata must be stripped")
+		}
+
+		img, _, _ := image.Decode(bytes.NewReader(result.Bytes))
+		top, _, bottom, _ := img.At(10, 5).RGBA()
+		_, _, blue, _ := img.At(10, 35).RGBA()
+		if top < 0xc000 || bottom > 0x4000 || blue < 0xc000 {
+			t.Fatal("image must be rotated clockwise")
+		}
+	})
+
+	t.Run("keep metadata", func(t *testing.T) {
+		result, err := hachibi.Compress(bytes.NewReader(photo), hachibi.WithKeepMetadata(), hachibi.WithTargetSize(4<<10))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result.Size > 4<<10 || result.TargetSize != 4<<10 {
+			t.Fatalf("unexpected result %+v", result)
+		}
+
+		m, err := hachibi.DecodeImageMetadata(bytes.NewReader(result.Bytes))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if m.Orientation != 1 || m.Make != "hachibi" || m.GPS == nil || m.Width != 20 || m.Height != 40 {
+			t.Fatalf("unexpected metadata %+v", m)
+		}
+	})
+
+	t.Run("strip without re-encoding", func(t *testing.T) {
+		stripped, err := hachibi.StripJPEGMetadata(photo)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if bytes.Contains(stripped, []byte("Exif")) || len(stripped) >= len(photo) {
+			t.Fatal("exif must be removed")
+		}
+
+		config, err := jpeg.DecodeConfig(bytes.NewReader(stripped))
+		if err != nil || config.Width != 40 || config.Height != 20 {
+			t.Fatalf("unexpected config %+v %v", config, err)
+		}
+
+		if _, err := hachibi.StripJPEGMetadata([]byte("not a jpeg")); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	t.Run("offloaded original is stripped", func(t *testing.T) {
+		store, err := hachibi.NewFileBlobStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		httpData := &hachibi.HttpData{}
+		httpData.Request.Body = []byte(`{"photo":"` + base64.StdEncoding.EncodeToString(photo) + `"}`)
+
+		p := hachibi.NewImagePreProcessor(hachibi.ImageWithPaths("photo"), hachibi.ImageWithBlobStore(store))
+		if err := p.PreProcess(context.Background(), httpData); err != nil {
+			t.Fatal(err)
+		}
+
+		img := httpData.Images[0]
+		if img.Orientation != 6 || img.Width != 20 || img.Height != 40 || !strings.HasPrefix(img.Ref, "file://") {
+			t.Fatalf("unexpected image %+v", img)
+		}
+
+		if bytes.Contains(readBlob(t, store, img.Ref), []byte("Exif")) {
+			t.Fatal("offloaded image must be stripped")
+		}
+	})
+}
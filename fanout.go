@@ -0,0 +1,174 @@
+package hachibi
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultSinkTimeout bounds the wait for a sink without timeout, the request waiting on the
+// FanOutProcessor must not take the latency of a hung sink.
+const DefaultSinkTimeout = time.Second
+
+// SinkError is appended to HttpData.Error for every sink that failed.
+type SinkError struct {
+	Sink string
+	Err  error
+}
+
+func (e *SinkError) Error() string {
+	return fmt.Sprintf("sink %s: %s", e.Sink, e.Err.Error())
+}
+
+func (e *SinkError) Unwrap() error {
+	return e.Err
+}
+
+type sink struct {
+	name      string
+	processor Processor
+	filter    func(ctx context.Context, httpData *HttpData) bool
+	timeout   time.Duration
+}
+
+type SinkOpt func(*sink)
+
+// SinkWithFilter only delivers the records for which filter returns true.
+func SinkWithFilter(filter func(ctx context.Context, httpData *HttpData) bool) SinkOpt {
+	return func(s *sink) {
+		s.filter = filter
+	}
+}
+
+// SinkWithTimeout stops waiting for the sink after d, the sink context is canceled as well.
+// It overrides the timeout of the FanOutProcessor.
+func SinkWithTimeout(d time.Duration) SinkOpt {
+	return func(s *sink) {
+		s.timeout = d
+	}
+}
+
+// FanOutProcessor delivers every record to multiple sinks, each sink runs in its own goroutine
+// with its own copy of the record so one sink can't block or alter the others.
+// When the matched rule chose sinks (RuleAction.Sinks) only those sinks receive the record.
+//
+// Process runs on the request path of the Transport and the Middleware, a request waits for
+// its slowest sink up to that sink timeout, keep the timeouts short.
+type FanOutProcessor struct {
+	sinks   []sink
+	timeout time.Duration
+}
+
+type FanOutOpt func(*FanOutProcessor)
+
+func FanOutWithSink(name string, processor Processor, opts ...SinkOpt) FanOutOpt {
+	return func(f *FanOutProcessor) {
+		s := sink{name: name, processor: processor}
+		for _, opt := range opts {
+			opt(&s)
+		}
+
+		f.sinks = append(f.sinks, s)
+	}
+}
+
+// FanOutWithTimeout sets the timeout of the sinks without their own, DefaultSinkTimeout by default.
+// 0 waits for them as long as they run, and so does the request.
+func FanOutWithTimeout(d time.Duration) FanOutOpt {
+	return func(f *FanOutProcessor) {
+		f.timeout = d
+	}
+}
+
+func NewFanOutProcessor(opts ...FanOutOpt) *FanOutProcessor {
+	f := &FanOutProcessor{timeout: DefaultSinkTimeout}
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	return f
+}
+
+// Process waits for every sink, at most for its timeout, and appends a SinkError per failing sink
+// to httpData, so it only returns an error when there is nothing to deliver to.
+func (f *FanOutProcessor) Process(ctx context.Context, httpData *HttpData) error {
+	if len(f.sinks) == 0 {
+		return errors.New("fan out has no sink")
+	}
+
+	errs := make([]error, len(f.sinks))
+	decision, hasDecision := RuleDecisionFromCtx(ctx)
+
+	wg := sync.WaitGroup{}
+	for i, s := range f.sinks {
+		if hasDecision && len(decision.Sinks) > 0 && !containsString(decision.Sinks, s.name) {
+			continue
+		}
+
+		if s.filter != nil && !s.filter(ctx, httpData) {
+			continue
+		}
+
+		if s.timeout == 0 {
+			s.timeout = f.timeout
+		}
+
+		wg.Add(1)
+		go func(i int, s sink, data HttpData) {
+			defer wg.Done()
+			errs[i] = s.deliver(ctx, &data)
+		}(i, s, httpData.Clone())
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			httpData.AppendError(&SinkError{Sink: f.sinks[i].name, Err: err})
+		}
+	}
+
+	return nil
+}
+
+func (s sink) deliver(ctx context.Context, httpData *HttpData) error {
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- errors.Errorf("panic: %v", r)
+			}
+		}()
+
+		done <- s.processor.Process(ctx, httpData)
+	}()
+
+	if s.timeout <= 0 {
+		return <-done
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "sink did not finish in time")
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,183 @@
+package hachibi_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mtfiqh/hachibi"
+)
+
+type recordSink struct {
+	mu      sync.Mutex
+	records []hachibi.HttpData
+	err     error
+	wait    time.Duration
+	// mutate changes the record, the other sinks must not see it
+	mutate bool
+}
+
+func (s *recordSink) Process(ctx context.Context, httpData *hachibi.HttpData) error {
+	if s.wait > 0 {
+		<-time.After(s.wait)
+	}
+
+	if s.mutate {
+		httpData.Request.Header.Set("X-Sink", "changed")
+	}
+
+	s.mu.Lock()
+	s.records = append(s.records, *httpData)
+	s.mu.Unlock()
+
+	return s.err
+}
+
+func (s *recordSink) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.records)
+}
+
+type errorCollector struct {
+	err hachibi.Error
+}
+
+func (e *errorCollector) ErrorHandle(ctx context.Context, err hachibi.Error) {
+	e.err = err
+}
+
+func TestFanOutProcessor(t *testing.T) {
+	audit := &recordSink{}
+	file := &recordSink{err: errors.New("disk full"), mutate: true}
+	slow := &recordSink{wait: 300 * time.Millisecond}
+	metrics := &recordSink{}
+
+	fanOut := hachibi.NewFanOutProcessor(
+		hachibi.FanOutWithSink("audit", audit),
+		hachibi.FanOutWithSink("file", file),
+		hachibi.FanOutWithSink("slow", slow, hachibi.SinkWithTimeout(20*time.Millisecond)),
+		hachibi.FanOutWithSink("metrics", metrics, hachibi.SinkWithFilter(func(ctx context.Context, httpData *hachibi.HttpData) bool {
+			return httpData.StatusCode >= 500
+		})),
+	)
+
+	collector := &errorCollector{}
+	m := hachibi.NewMiddleware(hachibi.MiddlewareWithProcessor(fanOut), hachibi.MiddlewareWithErrorHandler(collector))
+
+	h := m.Middleware(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusOK)
+		writer.Write([]byte(`{"ok":true}`))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/users", nil)
+	req.Header.Set("X-Sink", "original")
+
+	start := time.Now()
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if time.Since(start) > 200*time.Millisecond {
+		t.Fatal("slow sink blocked the pipeline")
+	}
+
+	if audit.len() != 1 || file.len() != 1 || metrics.len() != 0 {
+		t.Fatalf("unexpected deliveries audit=%d file=%d metrics=%d", audit.len(), file.len(), metrics.len())
+	}
+
+	if got := audit.records[0].Request.Header.Get("X-Sink"); got != "original" {
+		t.Fatalf("a sink changed the record of another sink, got %q", got)
+	}
+
+	if len(collector.err) != 2 {
+		t.Fatalf("expected 2 sink errors, got %v", collector.err)
+	}
+
+	sinks := map[string]bool{}
+	for _, err := range collector.err {
+		var sinkErr *hachibi.SinkError
+		if !errors.As(err, &sinkErr) {
+			t.Fatalf("expected sink error, got %v", err)
+		}
+		sinks[sinkErr.Sink] = true
+	}
+
+	if !sinks["file"] || !sinks["slow"] {
+		t.Fatalf("unexpected sink errors %v", collector.err)
+	}
+}
+
+func TestFanOutProcessor_DefaultTimeout(t *testing.T) {
+	hung := make(chan struct{})
+	defer close(hung)
+
+	fanOut := hachibi.NewFanOutProcessor(
+		hachibi.FanOutWithTimeout(20*time.Millisecond),
+		hachibi.FanOutWithSink("hung", processorFunc(func(ctx context.Context, httpData *hachibi.HttpData) error {
+			<-hung
+			return nil
+		})),
+	)
+
+	httpData := &hachibi.HttpData{}
+	start := time.Now()
+	fanOut.Process(context.Background(), httpData)
+	if time.Since(start) > 200*time.Millisecond {
+		t.Fatal("a hung sink blocked the pipeline")
+	}
+
+	var sinkErr *hachibi.SinkError
+	if len(httpData.Error) != 1 || !errors.As(httpData.Error[0], &sinkErr) || !errors.Is(sinkErr, context.DeadlineExceeded) {
+		t.Fatalf("unexpected errors %v", httpData.Error)
+	}
+}
+
+type processorFunc func(ctx context.Context, httpData *hachibi.HttpData) error
+
+func (f processorFunc) Process(ctx context.Context, httpData *hachibi.HttpData) error {
+	return f(ctx, httpData)
+}
+
+func TestFanOutProcessor_DeepCopy(t *testing.T) {
+	httpData := &hachibi.HttpData{Method: http.MethodPost, URL: "/upload"}
+	httpData.Request.Multipart = &hachibi.MultipartData{
+		Fields: map[string][]string{"name": {"namaku taufiq"}},
+		Files:  map[string][]hachibi.MultipartFileData{"file": {{FileName: "a.txt", File: []byte("content")}}},
+	}
+	httpData.Response.Decoded = &hachibi.Decoded{
+		Kind: hachibi.DecodedJSON,
+		JSON: map[string]any{"user": map[string]any{"name": "taufiq"}, "tags": []any{"a"}},
+	}
+
+	mutate := processorFunc(func(ctx context.Context, httpData *hachibi.HttpData) error {
+		httpData.Request.Multipart.Fields["name"][0] = "changed"
+		httpData.Request.Multipart.Files["file"][0].File[0] = 'X'
+		httpData.Request.Multipart.Files["file"][0].FileName = "changed.txt"
+
+		body := httpData.Response.Decoded.JSON.(map[string]any)
+		body["user"].(map[string]any)["name"] = "changed"
+		body["tags"].([]any)[0] = "changed"
+		return nil
+	})
+	audit := &recordSink{wait: 20 * time.Millisecond}
+
+	fanOut := hachibi.NewFanOutProcessor(hachibi.FanOutWithSink("mutate", mutate), hachibi.FanOutWithSink("audit", audit))
+	if err := fanOut.Process(context.Background(), httpData); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, record := range map[string]hachibi.HttpData{"original": *httpData, "audit": audit.records[0]} {
+		multipart := record.Request.Multipart
+		file := multipart.Files["file"][0]
+		if multipart.Fields["name"][0] != "namaku taufiq" || string(file.File) != "content" || file.FileName != "a.txt" {
+			t.Fatalf("%s: the multipart was changed by another sink %+v", name, multipart)
+		}
+
+		body := record.Response.Decoded.JSON.(map[string]any)
+		if body["user"].(map[string]any)["name"] != "taufiq" || body["tags"].([]any)[0] != "a" {
+			t.Fatalf("%s: the decoded body was changed by another sink %v", name, body)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package hachibi
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// ForwardProxy is an HTTP proxy, e.g. for HTTP_PROXY and HTTPS_PROXY, capturing the exchanges with its Transport.
+// CONNECT tunnels are only recorded as one CONNECT capture, unless a CertificateAuthority is set to intercept them:
+// the requests sent inside the tunnel are then captured like plain HTTP ones.
+// Streamed responses reach the client as the upstream sends them and upgrades, e.g. WebSocket, are relayed.
+type ForwardProxy struct {
+	transport *Transport
+	ca        *CertificateAuthority
+	dialer    net.Dialer
+
+	proxy *httputil.ReverseProxy
+}
+
+type ForwardProxyOpt func(*ForwardProxy)
+
+// ForwardProxyWithTransport configures the Transport capturing the exchanges, e.g. its processor and rules.
+func ForwardProxyWithTransport(opts ...TransportOpt) ForwardProxyOpt {
+	return func(p *ForwardProxy) {
+		p.transport = NewTransport(opts...)
+	}
+}
+
+// ForwardProxyWithInterception intercepts the TLS of CONNECT tunnels with certificates signed by ca.
+func ForwardProxyWithInterception(ca *CertificateAuthority) ForwardProxyOpt {
+	return func(p *ForwardProxy) {
+		p.ca = ca
+	}
+}
+
+func NewForwardProxy(opts ...ForwardProxyOpt) *ForwardProxy {
+	p := &ForwardProxy{dialer: net.Dialer{Timeout: 30 * time.Second}}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	if p.transport == nil {
+		p.transport = NewTransport()
+	}
+
+	p.proxy = &httputil.ReverseProxy{
+		// the outgoing request already targets the destination
+		Rewrite:   func(r *httputil.ProxyRequest) {},
+		Transport: p.transport,
+	}
+
+	return p
+}
+
+func (p *ForwardProxy) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if request.Method == http.MethodConnect {
+		p.connect(writer, request)
+		return
+	}
+
+	if !request.URL.IsAbs() {
+		http.Error(writer, "not a proxy request", http.StatusBadRequest)
+		return
+	}
+
+	p.proxy.ServeHTTP(writer, request)
+}
+
+func (p *ForwardProxy) connect(writer http.ResponseWriter, request *http.Request) {
+	conn, rw, err := http.NewResponseController(writer).Hijack()
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+
+	// the client may send its TLS hello before reading the answer
+	client := &bufferedConn{Conn: conn, reader: rw.Reader}
+
+	if p.ca != nil {
+		io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n")
+		p.intercept(client, request)
+		return
+	}
+
+	p.tunnel(client, request)
+}
+
+// tunnel copies the bytes both ways, the tunnel is recorded when it's closed.
+func (p *ForwardProxy) tunnel(client net.Conn, request *http.Request) {
+	ctx := request.Context()
+	start := time.Now().Local()
+	httpData := HttpData{ID: uuid.New().String(), StartedAt: start, Event: p.transport.Event, Method: request.Method, URL: request.Host}
+	httpData.Request.Header = request.Header.Clone()
+
+	head := decideHead(ctx, p.transport.rules, p.transport.sampler, request, &httpData, true)
+	defer func() {
+		if head.skip {
+			return
+		}
+
+		httpData.Duration = time.Since(start).Milliseconds()
+		p.transport.process(ctx, head, request, &httpData)
+	}()
+
+	upstream, err := p.dialer.DialContext(ctx, "tcp", request.Host)
+	if err != nil {
+		httpData.StatusCode = http.StatusBadGateway
+		httpData.AppendError(errors.Wrap(err, "upstream"))
+		io.WriteString(client, "HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\n\r\n")
+		return
+	}
+	defer upstream.Close()
+
+	httpData.StatusCode = http.StatusOK
+	io.WriteString(client, "HTTP/1.1 200 Connection Established\r\n\r\n")
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for _, c := range [][2]net.Conn{{upstream, client}, {client, upstream}} {
+		go func(dst net.Conn, src net.Conn) {
+			defer wg.Done()
+			io.Copy(dst, src)
+			// unblocks the other copy
+			dst.Close()
+			src.Close()
+		}(c[0], c[1])
+	}
+	wg.Wait()
+}
+
+// intercept terminates the TLS of the client and serves its requests, they are sent to the host of the tunnel.
+func (p *ForwardProxy) intercept(client net.Conn, request *http.Request) {
+	host, _, err := net.SplitHostPort(request.Host)
+	if err != nil {
+		host = request.Host
+	}
+
+	tlsConn := tls.Server(client, &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName != "" {
+				return p.ca.Certificate(hello.ServerName)
+			}
+			return p.ca.Certificate(host)
+		},
+		NextProtos: []string{"http/1.1"},
+	})
+
+	if err := tlsConn.HandshakeContext(request.Context()); err != nil {
+		tlsConn.Close()
+		return
+	}
+
+	target := request.Host
+	handler := http.HandlerFunc(func(writer http.ResponseWriter, r *http.Request) {
+		r.URL.Scheme = "https"
+		r.URL.Host = target
+		p.proxy.ServeHTTP(writer, r)
+	})
+
+	l := newSingleConnListener(tlsConn)
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 30 * time.Second}
+	server.Serve(l)
+}
+
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// singleConnListener accepts one connection, then blocks until that connection is closed.
+type singleConnListener struct {
+	conn   net.Conn
+	once   sync.Once
+	closed chan struct{}
+}
+
+func newSingleConnListener(conn net.Conn) *singleConnListener {
+	l := &singleConnListener{closed: make(chan struct{})}
+	l.conn = &notifyCloseConn{Conn: conn, close: func() { l.Close() }}
+	return l
+}
+
+func (l *singleConnListener) Accept() (net.Conn, error) {
+	if conn := l.conn; conn != nil {
+		l.conn = nil
+		return conn, nil
+	}
+
+	<-l.closed
+	return nil, net.ErrClosed
+}
+
+func (l *singleConnListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *singleConnListener) Addr() net.Addr {
+	return dummyAddr{}
+}
+
+type notifyCloseConn struct {
+	net.Conn
+	close func()
+}
+
+func (c *notifyCloseConn) Close() error {
+	defer c.close()
+	return c.Conn.Close()
+}
+
+type dummyAddr struct{}
+
+func (dummyAddr) Network() string {
+	return "tcp"
+}
+
+func (dummyAddr) String() string {
+	return "hachibi-intercepted"
+}
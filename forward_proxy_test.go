@@ -0,0 +1,233 @@
+package hachibi_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mtfiqh/hachibi"
+)
+
+func TestForwardProxy(t *testing.T) {
+	handler := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		io.WriteString(writer, "hello from "+request.URL.Path)
+	})
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+	secure := httptest.NewTLSServer(handler)
+	defer secure.Close()
+
+	// trusts the certificate of the test server
+	upstreamRoots := secure.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+
+	ca, err := hachibi.NewCertificateAuthority()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(t *testing.T, proxy *hachibi.ForwardProxy, target string, roots *x509.CertPool) string {
+		t.Helper()
+
+		server := httptest.NewServer(proxy)
+		defer server.Close()
+
+		proxyURL, _ := url.Parse(server.URL)
+		client := http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL), TLSClientConfig: &tls.Config{RootCAs: roots}}}
+		defer client.CloseIdleConnections()
+
+		res, err := client.Get(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		body, _ := io.ReadAll(res.Body)
+		return string(body)
+	}
+
+	// tunnels are recorded once they are closed
+	wait := func(t *testing.T, recorder *hachibi.MemoryRecorder) []hachibi.HttpData {
+		t.Helper()
+
+		for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if records, _ := query(t, recorder, hachibi.Filter{}); len(records) > 0 {
+				return records
+			}
+		}
+
+		t.Fatal("nothing recorded")
+		return nil
+	}
+
+	t.Run("plain http", func(t *testing.T) {
+		recorder := hachibi.NewMemoryRecorder(0)
+		proxy := hachibi.NewForwardProxy(hachibi.ForwardProxyWithTransport(hachibi.TransportWithProcessor(recorder), hachibi.TransportWithEventName("outbound")))
+
+		if body := get(t, proxy, plain.URL+"/users", nil); body != "hello from /users" {
+			t.Fatalf("unexpected body %s", body)
+		}
+
+		records := wait(t, recorder)
+		if len(records) != 1 || records[0].URL != plain.URL+"/users" || records[0].Event != "outbound" || string(records[0].Response.Body) != "hello from /users" {
+			t.Fatalf("unexpected records %+v", records)
+		}
+	})
+
+	t.Run("tunnel", func(t *testing.T) {
+		recorder := hachibi.NewMemoryRecorder(0)
+		proxy := hachibi.NewForwardProxy(hachibi.ForwardProxyWithTransport(hachibi.TransportWithProcessor(recorder)))
+
+		if body := get(t, proxy, secure.URL+"/secret", upstreamRoots); body != "hello from /secret" {
+			t.Fatalf("unexpected body %s", body)
+		}
+
+		records := wait(t, recorder)
+		if len(records) != 1 || records[0].Method != http.MethodConnect || records[0].URL != strings.TrimPrefix(secure.URL, "https://") || records[0].StatusCode != http.StatusOK {
+			t.Fatalf("unexpected records %+v", records)
+		}
+	})
+
+	t.Run("interception", func(t *testing.T) {
+		recorder := hachibi.NewMemoryRecorder(0)
+		upstream := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: upstreamRoots}}
+		proxy := hachibi.NewForwardProxy(
+			hachibi.ForwardProxyWithTransport(hachibi.TransportWithProcessor(recorder), hachibi.TransportWithRoundTripper(upstream)),
+			hachibi.ForwardProxyWithInterception(ca),
+		)
+
+		roots := x509.NewCertPool()
+		roots.AppendCertsFromPEM(ca.CertificatePEM())
+
+		if body := get(t, proxy, secure.URL+"/secret?token=1", roots); body != "hello from /secret" {
+			t.Fatalf("unexpected body %s", body)
+		}
+
+		records := wait(t, recorder)
+		if len(records) != 1 || records[0].Method != http.MethodGet || records[0].URL != secure.URL+"/secret?token=1" || string(records[0].Response.Body) != "hello from /secret" {
+			t.Fatalf("unexpected records %+v", records)
+		}
+	})
+
+	t.Run("streamed response", func(t *testing.T) {
+		next := make(chan struct{})
+		upstream := httptest.NewServer(eventStream(next))
+		defer upstream.Close()
+
+		recorder := hachibi.NewMemoryRecorder(0)
+		server := httptest.NewServer(hachibi.NewForwardProxy(hachibi.ForwardProxyWithTransport(hachibi.TransportWithProcessor(recorder))))
+		defer server.Close()
+
+		proxyURL, _ := url.Parse(server.URL)
+		client := http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+		defer client.CloseIdleConnections()
+
+		res, err := client.Get(upstream.URL + "/events")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		if event := readEvent(t, res.Body); event != "data: 1\n\n" {
+			t.Fatalf("unexpected event %q", event)
+		}
+
+		close(next)
+		io.ReadAll(res.Body)
+
+		records := wait(t, recorder)
+		if len(records) != 1 || string(records[0].Response.Body) != "data: 1\n\ndata: 2\n\n" {
+			t.Fatalf("unexpected records %+v", records)
+		}
+	})
+
+	t.Run("upgrade", func(t *testing.T) {
+		upstream := httptest.NewServer(http.HandlerFunc(echoUpgrade))
+		defer upstream.Close()
+
+		recorder := hachibi.NewMemoryRecorder(0)
+		server := httptest.NewServer(hachibi.NewForwardProxy(hachibi.ForwardProxyWithTransport(hachibi.TransportWithProcessor(recorder))))
+		defer server.Close()
+
+		proxyURL, _ := url.Parse(server.URL)
+		client := http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+		defer client.CloseIdleConnections()
+
+		req, _ := http.NewRequest(http.MethodGet, upstream.URL, nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "echo")
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		conn, ok := res.Body.(io.ReadWriteCloser)
+		if !ok || res.StatusCode != http.StatusSwitchingProtocols {
+			t.Fatalf("the upgrade must reach the client %d %T", res.StatusCode, res.Body)
+		}
+
+		io.WriteString(conn, "ping")
+		b := make([]byte, 4)
+		if _, err := io.ReadFull(conn, b); err != nil || string(b) != "ping" {
+			t.Fatalf("unexpected echo %q %v", b, err)
+		}
+
+		records := wait(t, recorder)
+		if len(records) != 1 || records[0].StatusCode != http.StatusSwitchingProtocols {
+			t.Fatalf("unexpected records %+v", records)
+		}
+	})
+
+	t.Run("not a proxy request", func(t *testing.T) {
+		res := httptest.NewRecorder()
+		hachibi.NewForwardProxy().ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/users", nil))
+		if res.Code != http.StatusBadRequest {
+			t.Fatalf("unexpected status %d", res.Code)
+		}
+	})
+}
+
+func TestCertificateAuthority(t *testing.T) {
+	ca, err := hachibi.NewCertificateAuthority()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyPEM, err := ca.KeyPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := hachibi.LoadCertificateAuthority(ca.CertificatePEM(), keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.CertificatePEM())
+
+	for _, host := range []string{"api.example.com", "127.0.0.1"} {
+		cert, err := loaded.Certificate(host)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: roots}); err != nil {
+			t.Fatalf("%s: %v", host, err)
+		}
+
+		if again, _ := loaded.Certificate(host); again != cert {
+			t.Fatalf("%s: certificate must be cached", host)
+		}
+	}
+
+	if _, err := hachibi.LoadCertificateAuthority(ca.CertificatePEM(), []byte("invalid")); err == nil {
+		t.Fatal("expected an error")
+	}
+}
@@ -1,10 +1,15 @@
 module github.com/mtfiqh/hachibi
 
-go 1.18
+go 1.22
 
 require (
-	github.com/google/uuid v1.3.0
+	github.com/andybalholm/brotli v1.1.1
+	github.com/google/uuid v1.6.0
 	github.com/jmoiron/sqlx v1.3.5
+	github.com/klauspost/compress v1.18.0
 	github.com/lib/pq v1.10.6
 	github.com/pkg/errors v0.9.1
+	golang.org/x/image v0.18.0
+	golang.org/x/text v0.21.0
+	gopkg.in/yaml.v3 v3.0.1
 )
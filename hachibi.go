@@ -1,6 +1,18 @@
 package hachibi
 
-import "image"
+import (
+	"bytes"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+
+	"github.com/pkg/errors"
+	"golang.org/x/image/draw"
+)
 
 const (
 	DefaultWidthCompression   = uint(3)
@@ -8,36 +20,46 @@ const (
 	DefaultQualityCompression = int(50)
 )
 
-func main() {
-
-	//img, err := Compress() // default value
-	//_ = img
-	//_ = err
-	//
-	//Compress(WithQualityCompression(100)) // quality 100
-	//
-	//Compress(WithResizeValue(uint(1), uint(5))) // w = 1, h = 5
-	//
-	//Compress(
-	//	WithResizeValue(uint(1), uint(5)),
-	//	WithQualityCompression(30),
-	//	)
-	//
-	//
-	//var img *image.Image
-	//switch  {
-	//case <2MB:
-	//	im, err := Compress()
-	//	img = im
-	//}
-
-	Coba(func(i int, i2 int) int {
-		return i * i2
-	}, 3, 4)
-
-	Coba(func(i int, i2 int) int {
-		return i / i2
-	}, 3, 4)
+const (
+	DefaultTargetMinQuality   = 10
+	DefaultTargetMaxQuality   = 90
+	DefaultTargetMinDimension = 32
+	DefaultTargetScaleStep    = 0.75
+)
+
+var ErrTargetSize = errors.New("image doesn't fit the target size")
+
+// DefaultMaxPixels bounds width x height of the images decoded, a few bytes can declare an image needing gigabytes.
+const DefaultMaxPixels = 40_000_000
+
+var ErrImageTooLarge = errors.New("image has too many pixels")
+
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatGIF  = "gif"
+)
+
+type Interpolation int
+
+const (
+	InterpolationNearestNeighbor = Interpolation(iota)
+	InterpolationApproxBiLinear
+	InterpolationBiLinear
+	InterpolationCatmullRom
+)
+
+func (i Interpolation) scaler() draw.Scaler {
+	switch i {
+	case InterpolationNearestNeighbor:
+		return draw.NearestNeighbor
+	case InterpolationBiLinear:
+		return draw.BiLinear
+	case InterpolationCatmullRom:
+		return draw.CatmullRom
+	default:
+		return draw.ApproxBiLinear
+	}
 }
 
 type QualityOptions struct {
@@ -51,41 +73,359 @@ func WithQualityValue(v int) QualityOption {
 	}
 }
 
+type ResizeOptions struct {
+	width         uint
+	height        uint
+	interpolation Interpolation
+}
+
+type ResizeOption func(options *ResizeOptions)
+
+// WithResizeValue scales both sides of the image by w/h, the defaults (3/4) keep 75% of each side.
+func WithResizeValue(w uint, h uint) ResizeOption {
+	return func(options *ResizeOptions) {
+		options.width = w
+		options.height = h
+	}
+}
+
+func WithInterpolation(interpolation Interpolation) ResizeOption {
+	return func(options *ResizeOptions) {
+		options.interpolation = interpolation
+	}
+}
+
+// TargetOptions bounds the search done by WithTargetSize.
+type TargetOptions struct {
+	minQuality   int
+	maxQuality   int
+	minDimension int
+}
+
+type TargetOption func(options *TargetOptions)
+
+// WithTargetQualityRange is the range of JPEG quality searched, the highest quality fitting the budget is kept.
+func WithTargetQualityRange(min int, max int) TargetOption {
+	return func(options *TargetOptions) {
+		options.minQuality = min
+		options.maxQuality = max
+	}
+}
+
+// WithTargetMinDimension stops downscaling when the longest side would get shorter than px.
+func WithTargetMinDimension(px int) TargetOption {
+	return func(options *TargetOptions) {
+		options.minDimension = px
+	}
+}
+
 type CompressOptions struct {
 	compressQuality bool
 	resizeImage     bool
+	pngCompression  bool
+	keepMetadata    bool
 
-	quality int
-	width   uint
-	height  uint
+	targetSize int
+	target     TargetOptions
+
+	maxPixels int
+
+	boxWidth  int
+	boxHeight int
+
+	quality       int
+	width         uint
+	height        uint
+	interpolation Interpolation
+	pngLevel      png.CompressionLevel
 }
 
 type CompressOption func(options *CompressOptions)
 
-func Compress(opts ...CompressOption) (*image.Image, error) {
+// CompressResult is the encoded image with what was done to it.
+type CompressResult struct {
+	Bytes  []byte `json:"-"`
+	Format string `json:"format"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Size   int    `json:"size"`
+
+	// Quality is the JPEG quality, it's 0 for PNG.
+	Quality int `json:"quality,omitempty"`
+
+	// Scale is the ratio between the output and the original width, TargetSize and Attempts are set by WithTargetSize.
+	Scale      float64 `json:"scale"`
+	TargetSize int     `json:"targetSize,omitempty"`
+	Attempts   int     `json:"attempts,omitempty"`
+
+	// Metadata is read from the original, the output is oriented and carries no metadata unless WithKeepMetadata is used.
+	// OriginalWidth and OriginalHeight are the dimensions once oriented.
+	Metadata       *ImageMetadata `json:"metadata,omitempty"`
+	OriginalFormat string         `json:"originalFormat,omitempty"`
+	OriginalWidth  int            `json:"originalWidth"`
+	OriginalHeight int            `json:"originalHeight"`
+	OriginalSize   int            `json:"originalSize,omitempty"`
+}
+
+// Compress decodes a JPEG, PNG or GIF image from r, resizes and re-encodes it.
+// Without option a JPEG stays JPEG at DefaultQualityCompression and the others become PNG.
+func Compress(r io.Reader, opts ...CompressOption) (*CompressResult, error) {
+	original, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read image")
+	}
+
+	option := newCompressOptions(opts)
+
+	img, format, err := decodeImage(original, option.maxPixels)
+	if err != nil {
+		return nil, err
+	}
+
+	// a broken EXIF doesn't prevent the compression, the image is then kept as stored
+	metadata, _ := decodeImageMetadata(original)
+
+	result, err := compress(img, format, metadata, option)
+	if err != nil {
+		return nil, err
+	}
+
+	result.OriginalSize = len(original)
+	return result, nil
+}
+
+// CompressImage resizes and encodes an already decoded image, by default as JPEG.
+func CompressImage(img image.Image, opts ...CompressOption) (*CompressResult, error) {
+	return compress(img, "", nil, newCompressOptions(opts))
+}
+
+// decodeImage checks the dimensions declared by the header of the image before decoding it,
+// maxPixels <= 0 removes the limit.
+func decodeImage(b []byte, maxPixels int) (image.Image, string, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to decode image")
+	}
+
+	if maxPixels > 0 && config.Width*config.Height > maxPixels {
+		return nil, "", errors.Wrapf(ErrImageTooLarge, "%dx%d", config.Width, config.Height)
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to decode image")
+	}
+
+	return img, format, nil
+}
+
+func newCompressOptions(opts []CompressOption) CompressOptions {
 	option := CompressOptions{
 		compressQuality: false,
 		resizeImage:     false,
+		quality:         DefaultQualityCompression,
+		width:           DefaultWidthCompression,
+		height:          DefaultHeightCompression,
+		interpolation:   InterpolationApproxBiLinear,
+		pngLevel:        png.DefaultCompression,
+		maxPixels:       DefaultMaxPixels,
 	}
 	for _, opt := range opts {
 		opt(&option)
 	}
 
-	if option.compressQuality {
-		// todo compress quality
+	return option
+}
+
+func compress(img image.Image, format string, metadata *ImageMetadata, option CompressOptions) (*CompressResult, error) {
+	if metadata != nil {
+		img = applyOrientation(img, metadata.Orientation)
+	}
+
+	// the EXIF is only copied to a JPEG output, its size is taken from the target size
+	var app1 []byte
+	if option.keepMetadata && metadata != nil && metadata.app1 != nil {
+		app1 = metadata.exifWithoutOrientation()
+		if option.targetSize > 0 {
+			option.targetSize -= len(app1) + 4
+		}
+	}
+
+	bounds := img.Bounds()
+	result := &CompressResult{
+		Metadata:       metadata,
+		OriginalFormat: format,
+		OriginalWidth:  bounds.Dx(),
+		OriginalHeight: bounds.Dy(),
 	}
 
 	if option.resizeImage {
-		//todo resize image
+		img = resize(img, option.width, option.height, option.interpolation)
+	}
+
+	if option.boxWidth > 0 && option.boxHeight > 0 {
+		img = fit(img, option.boxWidth, option.boxHeight, option.interpolation)
+	}
+
+	outFormat := FormatJPEG
+	switch {
+	case option.compressQuality:
+		outFormat = FormatJPEG
+	case option.pngCompression:
+		outFormat = FormatPNG
+	case option.targetSize > 0:
+		outFormat = FormatJPEG
+	case format == FormatPNG || format == FormatGIF:
+		outFormat = FormatPNG
+	}
+
+	var (
+		out []byte
+		err error
+	)
+	if option.targetSize > 0 {
+		img, out, err = fitTarget(img, outFormat, option, result)
+	} else {
+		out, err = encode(img, outFormat, option.quality, option.pngLevel)
+		if outFormat == FormatJPEG {
+			result.Quality = option.quality
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if app1 != nil && outFormat == FormatJPEG {
+		out = insertJPEGSegment(out, 0xe1, app1)
+		result.TargetSize = option.targetSize + len(app1) + 4
+	}
+
+	b := img.Bounds()
+	result.Bytes = out
+	result.Format = outFormat
+	result.Width = b.Dx()
+	result.Height = b.Dy()
+	result.Size = len(out)
+	if result.OriginalWidth > 0 {
+		result.Scale = float64(b.Dx()) / float64(result.OriginalWidth)
+	}
+
+	return result, nil
+}
+
+func encode(img image.Image, format string, quality int, level png.CompressionLevel) ([]byte, error) {
+	buf := bytes.Buffer{}
+	switch format {
+	case FormatPNG:
+		encoder := png.Encoder{CompressionLevel: level}
+		if err := encoder.Encode(&buf, img); err != nil {
+			return nil, errors.Wrap(err, "failed to encode png")
+		}
+	default:
+		if err := jpeg.Encode(&buf, flatten(img), &jpeg.Options{Quality: quality}); err != nil {
+			return nil, errors.Wrap(err, "failed to encode jpeg")
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// fitTarget looks for the highest JPEG quality fitting option.targetSize with a binary search,
+// the image is downscaled by DefaultTargetScaleStep until it fits. PNG is only downscaled.
+func fitTarget(img image.Image, format string, option CompressOptions, result *CompressResult) (image.Image, []byte, error) {
+	result.TargetSize = option.targetSize
+	scaled := img
+	bounds := img.Bounds()
+
+	for step := 0; ; step++ {
+		if step > 0 {
+			factor := math.Pow(DefaultTargetScaleStep, float64(step))
+			width := int(float64(bounds.Dx()) * factor)
+			height := int(float64(bounds.Dy()) * factor)
+			if max(width, height) < option.target.minDimension || width < 1 || height < 1 {
+				return nil, nil, errors.Wrapf(ErrTargetSize, "%d bytes after %d attempts", option.targetSize, result.Attempts)
+			}
+
+			dst := image.NewRGBA(image.Rect(0, 0, width, height))
+			option.interpolation.scaler().Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+			scaled = dst
+		}
+
+		if format == FormatPNG {
+			result.Attempts++
+			out, err := encode(scaled, format, 0, option.pngLevel)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if len(out) <= option.targetSize {
+				return scaled, out, nil
+			}
+			continue
+		}
+
+		var best []byte
+		low, high := option.target.minQuality, option.target.maxQuality
+		for low <= high {
+			quality := (low + high) / 2
+			result.Attempts++
+			out, err := encode(scaled, format, quality, option.pngLevel)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if len(out) <= option.targetSize {
+				best = out
+				result.Quality = quality
+				low = quality + 1
+			} else {
+				high = quality - 1
+			}
+		}
+
+		if best != nil {
+			return scaled, best, nil
+		}
 	}
+}
 
-	return nil, nil
+func resize(img image.Image, w uint, h uint, interpolation Interpolation) image.Image {
+	if w == 0 || h == 0 || w == h {
+		return img
+	}
+
+	bounds := img.Bounds()
+	width := int(uint(bounds.Dx()) * w / h)
+	height := int(uint(bounds.Dy()) * w / h)
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	interpolation.scaler().Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+
+	return dst
 }
 
-func Coba(a func(int, int) int, b int, c int) int {
-	return a(b, c) / 2
+// flatten draws transparent images over white, JPEG has no alpha channel.
+func flatten(img image.Image) image.Image {
+	switch img.(type) {
+	case *image.YCbCr, *image.Gray, *image.CMYK:
+		return img
+	}
+
+	bounds := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Over)
+
+	return dst
 }
 
+// WithCompressQuality encodes the output as JPEG at the given quality (DefaultQualityCompression).
 func WithCompressQuality(opts ...QualityOption) CompressOption {
 	return func(options *CompressOptions) {
 		q := QualityOptions{quality: DefaultQualityCompression}
@@ -98,12 +438,63 @@ func WithCompressQuality(opts ...QualityOption) CompressOption {
 	}
 }
 
-func WithResizeImage() CompressOption {
+// WithPNGCompression encodes the output as PNG with the given compression level.
+func WithPNGCompression(level png.CompressionLevel) CompressOption {
 	return func(options *CompressOptions) {
-		options.resizeImage = true
+		options.pngCompression = true
+		options.pngLevel = level
+	}
+}
+
+// WithTargetSize keeps the highest JPEG quality at the largest scale whose output doesn't exceed n bytes.
+// The output is JPEG unless WithPNGCompression is used, Compress returns ErrTargetSize when nothing fits.
+func WithTargetSize(n int, opts ...TargetOption) CompressOption {
+	return func(options *CompressOptions) {
+		t := TargetOptions{
+			minQuality:   DefaultTargetMinQuality,
+			maxQuality:   DefaultTargetMaxQuality,
+			minDimension: DefaultTargetMinDimension,
+		}
+		for _, opt := range opts {
+			opt(&t)
+		}
+
+		options.targetSize = n
+		options.target = t
 	}
 }
 
-func CompressA(w int, h int, q int) {
+// WithMaxPixels refuses with ErrImageTooLarge the images with more than n pixels before decoding them,
+// DefaultMaxPixels by default and 0 removes the limit.
+func WithMaxPixels(n int) CompressOption {
+	return func(options *CompressOptions) {
+		options.maxPixels = n
+	}
+}
 
+// WithKeepMetadata copies the EXIF of a JPEG to the JPEG output, it's stripped by default.
+// The orientation is reset as the pixels are already oriented.
+func WithKeepMetadata() CompressOption {
+	return func(options *CompressOptions) {
+		options.keepMetadata = true
+	}
+}
+
+// WithResizeImage scales the image, by default with DefaultWidthCompression/DefaultHeightCompression.
+func WithResizeImage(opts ...ResizeOption) CompressOption {
+	return func(options *CompressOptions) {
+		r := ResizeOptions{
+			width:         DefaultWidthCompression,
+			height:        DefaultHeightCompression,
+			interpolation: InterpolationApproxBiLinear,
+		}
+		for _, opt := range opts {
+			opt(&r)
+		}
+
+		options.resizeImage = true
+		options.width = r.width
+		options.height = r.height
+		options.interpolation = r.interpolation
+	}
 }
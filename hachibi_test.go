@@ -0,0 +1,236 @@
+package hachibi_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"hash/crc32"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mtfiqh/hachibi"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func assertGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.MkdirAll("testdata", 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(want, got) {
+		t.Fatalf("output differs from %s, run the tests with -update if the change is expected", path)
+	}
+}
+
+func TestCompress(t *testing.T) {
+	original, err := os.ReadFile("test.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(original))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jpegBuf := &bytes.Buffer{}
+	jpeg.Encode(jpegBuf, src, &jpeg.Options{Quality: 90})
+
+	gifBuf := &bytes.Buffer{}
+	gif.Encode(gifBuf, src, nil)
+
+	cases := []struct {
+		name   string
+		input  []byte
+		opts   []hachibi.CompressOption
+		golden string
+		format string
+		width  int
+		height int
+	}{
+		{
+			name:   "png keeps png",
+			input:  original,
+			golden: "compress_default.png",
+			format: hachibi.FormatPNG,
+			width:  1232,
+			height: 494,
+		},
+		{
+			name:   "jpeg quality with default resize",
+			input:  original,
+			opts:   []hachibi.CompressOption{hachibi.WithCompressQuality(), hachibi.WithResizeImage()},
+			golden: "compress_quality_resize.jpg",
+			format: hachibi.FormatJPEG,
+			width:  924,
+			height: 370,
+		},
+		{
+			name:  "jpeg quality and ratio with catmull rom",
+			input: jpegBuf.Bytes(),
+			opts: []hachibi.CompressOption{
+				hachibi.WithCompressQuality(hachibi.WithQualityValue(30)),
+				hachibi.WithResizeImage(hachibi.WithResizeValue(1, 4), hachibi.WithInterpolation(hachibi.InterpolationCatmullRom)),
+			},
+			golden: "compress_catmullrom.jpg",
+			format: hachibi.FormatJPEG,
+			width:  308,
+			height: 123,
+		},
+		{
+			name:   "gif to png with compression level",
+			input:  gifBuf.Bytes(),
+			opts:   []hachibi.CompressOption{hachibi.WithPNGCompression(png.BestCompression), hachibi.WithResizeImage(hachibi.WithResizeValue(1, 2), hachibi.WithInterpolation(hachibi.InterpolationNearestNeighbor))},
+			golden: "compress_gif.png",
+			format: hachibi.FormatPNG,
+			width:  616,
+			height: 247,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			result, err := hachibi.Compress(bytes.NewReader(c.input), c.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if result.Format != c.format || result.Width != c.width || result.Height != c.height {
+				t.Fatalf("unexpected result %+v", result)
+			}
+
+			if result.OriginalWidth != 1232 || result.OriginalHeight != 494 || result.OriginalSize != len(c.input) || result.Size != len(result.Bytes) {
+				t.Fatalf("unexpected metadata %+v", result)
+			}
+
+			assertGolden(t, c.golden, result.Bytes)
+		})
+	}
+
+	t.Run("invalid image", func(t *testing.T) {
+		if _, err := hachibi.Compress(bytes.NewReader([]byte("not an image"))); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	t.Run("too many pixels", func(t *testing.T) {
+		// a few bytes declaring a 100000x100000 image, decoding it would need 40 GB
+		header := make([]byte, 13)
+		binary.BigEndian.PutUint32(header[0:], 100000)
+		binary.BigEndian.PutUint32(header[4:], 100000)
+		header[8], header[9] = 8, 6
+
+		bomb := &bytes.Buffer{}
+		bomb.WriteString("\x89PNG\r\n\x1a\n")
+		for _, chunk := range []struct {
+			kind string
+			data []byte
+		}{{"IHDR", header}, {"IDAT", nil}, {"IEND", nil}} {
+			binary.Write(bomb, binary.BigEndian, uint32(len(chunk.data)))
+			bomb.WriteString(chunk.kind)
+			bomb.Write(chunk.data)
+			binary.Write(bomb, binary.BigEndian, crc32.ChecksumIEEE(append([]byte(chunk.kind), chunk.data...)))
+		}
+
+		if _, err := hachibi.Compress(bytes.NewReader(bomb.Bytes())); !errors.Is(err, hachibi.ErrImageTooLarge) {
+			t.Fatalf("expected ErrImageTooLarge, got %v", err)
+		}
+
+		original, _ := os.ReadFile("test.png")
+		if _, err := hachibi.Compress(bytes.NewReader(original), hachibi.WithMaxPixels(1000)); !errors.Is(err, hachibi.ErrImageTooLarge) {
+			t.Fatalf("expected ErrImageTooLarge, got %v", err)
+		}
+
+		if _, err := hachibi.Compress(bytes.NewReader(original), hachibi.WithMaxPixels(0)); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("target size", func(t *testing.T) {
+		cases := []struct {
+			name   string
+			target int
+			opts   []hachibi.CompressOption
+			format string
+			scaled bool
+		}{
+			{name: "quality only", target: 60 << 10, format: hachibi.FormatJPEG},
+			{name: "downscale", target: 4 << 10, format: hachibi.FormatJPEG, scaled: true},
+			{name: "png", target: 120 << 10, opts: []hachibi.CompressOption{hachibi.WithPNGCompression(png.BestSpeed)}, format: hachibi.FormatPNG, scaled: true},
+		}
+
+		for _, c := range cases {
+			t.Run(c.name, func(t *testing.T) {
+				opts := append(c.opts, hachibi.WithTargetSize(c.target))
+				result, err := hachibi.Compress(bytes.NewReader(original), opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if result.Format != c.format || result.Size > c.target || result.TargetSize != c.target || result.Attempts == 0 {
+					t.Fatalf("unexpected result %+v", result)
+				}
+
+				if c.scaled != (result.Scale < 1) || (c.format == hachibi.FormatJPEG && (result.Quality < hachibi.DefaultTargetMinQuality || result.Quality > hachibi.DefaultTargetMaxQuality)) {
+					t.Fatalf("unexpected parameters %+v", result)
+				}
+
+				again, _ := hachibi.Compress(bytes.NewReader(original), opts...)
+				if !bytes.Equal(again.Bytes, result.Bytes) || again.Quality != result.Quality {
+					t.Fatal("output must be deterministic")
+				}
+			})
+		}
+
+		t.Run("highest quality", func(t *testing.T) {
+			result, err := hachibi.Compress(bytes.NewReader(original), hachibi.WithTargetSize(60<<10, hachibi.WithTargetQualityRange(20, 95)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			next, _ := hachibi.Compress(bytes.NewReader(original), hachibi.WithCompressQuality(hachibi.WithQualityValue(result.Quality+1)))
+			if result.Quality < 95 && next.Size <= 60<<10 {
+				t.Fatalf("quality %d isn't the highest fitting", result.Quality)
+			}
+		})
+
+		t.Run("doesn't fit", func(t *testing.T) {
+			_, err := hachibi.Compress(bytes.NewReader(original), hachibi.WithTargetSize(100, hachibi.WithTargetMinDimension(64)))
+			if !errors.Is(err, hachibi.ErrTargetSize) {
+				t.Fatalf("expected ErrTargetSize, got %v", err)
+			}
+		})
+	})
+
+	t.Run("image.Image", func(t *testing.T) {
+		result, err := hachibi.CompressImage(src, hachibi.WithCompressQuality(hachibi.WithQualityValue(80)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result.Format != hachibi.FormatJPEG || result.Quality != 80 || result.Size >= len(original) {
+			t.Fatalf("unexpected result %+v", result)
+		}
+	})
+}
@@ -0,0 +1,282 @@
+package hachibi
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const harVersion = "1.2"
+
+// HAR is an HTTP Archive (http://www.softwareishard.com/blog/har-12-spec/) of captured exchanges,
+// it can be opened by the network panel of the browsers.
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type HAREntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            int64       `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARPostData struct {
+	MimeType string     `json:"mimeType"`
+	Text     string     `json:"text,omitempty"`
+	Params   []HARParam `json:"params,omitempty"`
+}
+
+type HARParam struct {
+	Name        string `json:"name"`
+	Value       string `json:"value,omitempty"`
+	FileName    string `json:"fileName,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+}
+
+type HARContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type HARTimings struct {
+	Send    int64 `json:"send"`
+	Wait    int64 `json:"wait"`
+	Receive int64 `json:"receive"`
+}
+
+// NewHAR converts captures to an HTTP Archive, the bodies that aren't valid UTF-8 are base64 encoded.
+func NewHAR(records ...HttpData) *HAR {
+	h := &HAR{Log: HARLog{
+		Version: harVersion,
+		Creator: HARCreator{Name: "hachibi", Version: harVersion},
+		Entries: make([]HAREntry, 0, len(records)),
+	}}
+
+	for i := range records {
+		h.Log.Entries = append(h.Log.Entries, records[i].harEntry())
+	}
+
+	return h
+}
+
+func (h *HttpData) harEntry() HAREntry {
+	entry := HAREntry{
+		StartedDateTime: h.StartedAt.Format(time.RFC3339Nano),
+		Time:            h.Duration,
+		Timings:         HARTimings{Wait: h.Duration},
+		Request: HARRequest{
+			Method:      h.Method,
+			URL:         h.FullURL(),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     make([]HARNameValue, 0),
+			Headers:     harHeaders(h.Request.Header),
+			QueryString: make([]HARNameValue, 0),
+			HeadersSize: -1,
+			BodySize:    len(h.Request.Body),
+		},
+		Response: HARResponse{
+			Status:      h.StatusCode,
+			StatusText:  http.StatusText(h.StatusCode),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     make([]HARNameValue, 0),
+			Headers:     harHeaders(h.Response.Header),
+			HeadersSize: -1,
+			BodySize:    len(h.Response.Body),
+		},
+	}
+
+	if len(h.Error) > 0 {
+		entry.Comment = h.Error.Error()
+	}
+
+	if u, err := url.Parse(h.URL); err == nil {
+		for _, key := range sortedKeys(u.Query()) {
+			for _, v := range u.Query()[key] {
+				entry.Request.QueryString = append(entry.Request.QueryString, HARNameValue{Name: key, Value: v})
+			}
+		}
+	}
+
+	for _, c := range (&http.Request{Header: h.Request.Header}).Cookies() {
+		entry.Request.Cookies = append(entry.Request.Cookies, HARNameValue{Name: c.Name, Value: c.Value})
+	}
+
+	for _, c := range (&http.Response{Header: h.Response.Header}).Cookies() {
+		entry.Response.Cookies = append(entry.Response.Cookies, HARNameValue{Name: c.Name, Value: c.Value})
+	}
+
+	contentType := h.Request.Header.Get("Content-Type")
+	if h.Request.Multipart != nil {
+		entry.Request.PostData = &HARPostData{MimeType: contentType, Params: h.Request.Multipart.harParams()}
+	} else if len(h.Request.Body) > 0 {
+		text, _ := harText(h.Request.Body)
+		entry.Request.PostData = &HARPostData{MimeType: contentType, Text: text}
+	}
+
+	entry.Response.Content = HARContent{
+		Size:     len(h.Response.Body),
+		MimeType: h.Response.Header.Get("Content-Type"),
+	}
+	entry.Response.Content.Text, entry.Response.Content.Encoding = harText(h.Response.Body)
+	if location := h.Response.Header.Get("Location"); location != "" {
+		entry.Response.RedirectURL = location
+	}
+
+	return entry
+}
+
+func harHeaders(header http.Header) []HARNameValue {
+	headers := make([]HARNameValue, 0, len(header))
+	for _, key := range sortedKeys(header) {
+		for _, v := range header[key] {
+			headers = append(headers, HARNameValue{Name: key, Value: v})
+		}
+	}
+
+	return headers
+}
+
+func harText(body []byte) (string, string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+func (m *MultipartData) harParams() []HARParam {
+	params := make([]HARParam, 0)
+	for _, key := range sortedKeys(m.Fields) {
+		for _, v := range m.Fields[key] {
+			params = append(params, HARParam{Name: key, Value: v})
+		}
+	}
+
+	for _, key := range sortedKeys(m.Files) {
+		for _, f := range m.Files[key] {
+			params = append(params, HARParam{Name: key, FileName: f.FileName, ContentType: f.ContentType})
+		}
+	}
+
+	return params
+}
+
+// Curl returns a curl command replaying the captured request,
+// multipart files are referenced by their file name as their content may be offloaded.
+func (h HttpData) Curl() string {
+	b := strings.Builder{}
+	binary := h.Request.Multipart == nil && len(h.Request.Body) > 0 && !utf8.Valid(h.Request.Body)
+	if binary {
+		b.WriteString("echo " + shellQuote(base64.StdEncoding.EncodeToString(h.Request.Body)) + " | base64 -d | ")
+	}
+
+	b.WriteString("curl")
+	if h.Method != "" && h.Method != http.MethodGet {
+		b.WriteString(" -X " + shellQuote(h.Method))
+	}
+	b.WriteString(" " + shellQuote(h.FullURL()))
+
+	skip := map[string]bool{"Content-Length": true, "Accept-Encoding": true}
+	if h.Request.Multipart != nil {
+		skip["Content-Type"] = true
+	}
+
+	// the captured body is decoded
+	if h.Request.Encoding != "" {
+		skip["Content-Encoding"] = true
+	}
+
+	keys := make([]string, 0, len(h.Request.Header))
+	for k := range h.Request.Header {
+		if !skip[http.CanonicalHeaderKey(k)] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		for _, v := range h.Request.Header[k] {
+			b.WriteString(" \\\n  -H " + shellQuote(k+": "+v))
+		}
+	}
+
+	switch {
+	case h.Request.Multipart != nil:
+		m := h.Request.Multipart
+		for _, key := range sortedKeys(m.Fields) {
+			for _, v := range m.Fields[key] {
+				b.WriteString(" \\\n  -F " + shellQuote(key+"="+v))
+			}
+		}
+
+		for _, key := range sortedKeys(m.Files) {
+			for _, f := range m.Files[key] {
+				value := key + "=@" + f.FileName
+				if f.ContentType != "" {
+					value += ";type=" + f.ContentType
+				}
+				b.WriteString(" \\\n  -F " + shellQuote(value))
+			}
+		}
+	case binary:
+		b.WriteString(" \\\n  --data-binary @-")
+	case len(h.Request.Body) > 0:
+		b.WriteString(" \\\n  --data-binary " + shellQuote(string(h.Request.Body)))
+	}
+
+	return b.String()
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,147 @@
+package hachibi_test
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mtfiqh/hachibi"
+)
+
+func TestHAR(t *testing.T) {
+	startedAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	record := hachibi.HttpData{
+		StartedAt: startedAt, Duration: 42, Method: http.MethodPost, URL: "http://api/users?page=2&sort=name", StatusCode: 201,
+		Request: hachibi.Request{Payload: hachibi.Payload{
+			Header: http.Header{"Content-Type": {"application/json"}, "Cookie": {"session=abc"}},
+			Body:   []byte(`{"name":"taufiq"}`),
+		}},
+		Response: hachibi.Response{Payload: hachibi.Payload{
+			Header: http.Header{"Content-Type": {"application/octet-stream"}, "Set-Cookie": {"token=xyz; Path=/"}},
+			Body:   []byte{0xff, 0x00, 0xfe},
+		}},
+	}
+
+	b, err := json.Marshal(hachibi.NewHAR(record))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var har hachibi.HAR
+	json.Unmarshal(b, &har)
+	entry := har.Log.Entries[0]
+
+	if har.Log.Version != "1.2" || entry.StartedDateTime != "2024-05-01T10:00:00Z" || entry.Time != 42 {
+		t.Fatalf("unexpected entry %s", b)
+	}
+
+	if len(entry.Request.QueryString) != 2 || entry.Request.QueryString[0].Name != "page" || entry.Request.Cookies[0].Value != "abc" {
+		t.Fatalf("unexpected request %+v", entry.Request)
+	}
+
+	if entry.Request.PostData.MimeType != "application/json" || entry.Request.PostData.Text != `{"name":"taufiq"}` {
+		t.Fatalf("unexpected post data %+v", entry.Request.PostData)
+	}
+
+	content := entry.Response.Content
+	if entry.Response.StatusText != "Created" || content.Encoding != "base64" || content.Text != "/wD+" || content.Size != 3 || entry.Response.Cookies[0].Name != "token" {
+		t.Fatalf("unexpected response %+v", entry.Response)
+	}
+
+	t.Run("multipart", func(t *testing.T) {
+		record := hachibi.HttpData{Method: http.MethodPost, URL: "http://api/upload", Request: hachibi.Request{Payload: hachibi.Payload{
+			Header: http.Header{"Content-Type": {"multipart/form-data; boundary=x"}},
+			Multipart: &hachibi.MultipartData{
+				Fields: map[string][]string{"name": {"me"}},
+				Files:  map[string][]hachibi.MultipartFileData{"file": {{FileName: "a.png", ContentType: "image/png"}}},
+			},
+		}}}
+
+		params := hachibi.NewHAR(record).Log.Entries[0].Request.PostData.Params
+		if len(params) != 2 || params[0].Value != "me" || params[1].FileName != "a.png" || params[1].ContentType != "image/png" {
+			t.Fatalf("unexpected params %+v", params)
+		}
+	})
+
+	t.Run("server capture", func(t *testing.T) {
+		p := &captureProcessor{}
+		h := hachibi.NewMiddleware(hachibi.MiddlewareWithProcessor(p)).Middleware(func(writer http.ResponseWriter, request *http.Request) {})
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/1?page=2", nil))
+		secure := httptest.NewRequest(http.MethodGet, "/users/2", nil)
+		secure.Host, secure.TLS = "api.example.com", &tls.ConnectionState{}
+		h.ServeHTTP(httptest.NewRecorder(), secure)
+
+		for i, want := range []string{"http://example.com/users/1?page=2", "https://api.example.com/users/2"} {
+			if got := hachibi.NewHAR(p.records[i]).Log.Entries[0].Request.URL; p.records[i].URL[0] != '/' || got != want {
+				t.Fatalf("unexpected url %s of %+v", got, p.records[i])
+			}
+		}
+	})
+}
+
+func TestHttpData_Curl(t *testing.T) {
+	cases := []struct {
+		name   string
+		record hachibi.HttpData
+		want   string
+	}{
+		{
+			name:   "get",
+			record: hachibi.HttpData{Method: http.MethodGet, URL: "http://api/users?q=it's"},
+			want:   `curl 'http://api/users?q=it'\''s'`,
+		},
+		{
+			name:   "server capture",
+			record: hachibi.HttpData{Method: http.MethodGet, URL: "/users/1", Origin: "https://api.example.com"},
+			want:   `curl 'https://api.example.com/users/1'`,
+		},
+		{
+			name: "json",
+			record: hachibi.HttpData{Method: http.MethodPost, URL: "http://api/users", Request: hachibi.Request{Payload: hachibi.Payload{
+				Header:   http.Header{"Content-Type": {"application/json"}, "Content-Length": {"17"}, "Content-Encoding": {"gzip"}},
+				Encoding: "gzip",
+				Body:     []byte(`{"name":"taufiq"}`),
+			}}},
+			want: "curl -X 'POST' 'http://api/users' \\\n  -H 'Content-Type: application/json' \\\n  --data-binary '{\"name\":\"taufiq\"}'",
+		},
+		{
+			name: "multipart",
+			record: hachibi.HttpData{Method: http.MethodPost, URL: "http://api/upload", Request: hachibi.Request{Payload: hachibi.Payload{
+				Header: http.Header{"Content-Type": {"multipart/form-data; boundary=x"}, "Authorization": {"Bearer t"}},
+				Multipart: &hachibi.MultipartData{
+					Fields: map[string][]string{"name": {"me"}},
+					Files:  map[string][]hachibi.MultipartFileData{"file": {{FileName: "a.png", ContentType: "image/png"}}},
+				},
+			}}},
+			want: "curl -X 'POST' 'http://api/upload' \\\n  -H 'Authorization: Bearer t' \\\n  -F 'name=me' \\\n  -F 'file=@a.png;type=image/png'",
+		},
+		{
+			name: "binary",
+			record: hachibi.HttpData{Method: http.MethodPut, URL: "http://api/blob", Request: hachibi.Request{Payload: hachibi.Payload{
+				Body: []byte{0xff, 0x00, 0xfe},
+			}}},
+			want: "echo '/wD+' | base64 -d | curl -X 'PUT' 'http://api/blob' \\\n  --data-binary @-",
+		},
+		{
+			name:   "hostile method",
+			record: hachibi.HttpData{Method: "GET`id`|sh&$(id)", URL: "http://api/users"},
+			want:   "curl -X 'GET`id`|sh&$(id)' 'http://api/users'",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.record.Curl(); got != c.want {
+				t.Fatalf("got\n%s\nwant\n%s", got, c.want)
+			}
+		})
+	}
+
+	if strings.Contains(cases[2].record.Curl(), "Content-Encoding") {
+		t.Fatal("decoded body must not be sent as gzip")
+	}
+}
@@ -0,0 +1,547 @@
+package hachibi
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultImageMinSize = 1 << 10
+
+	ImageActionCompressed = "compressed"
+	ImageActionOffloaded  = "offloaded"
+	ImageActionRemoved    = "removed"
+	ImageActionKept       = "kept"
+)
+
+// CapturedImage describes an image found in a captured body and what was done with it.
+type CapturedImage struct {
+	// Location is where the image was found, e.g. request.body.user.photo or response.multipart.file[0].
+	Location string `json:"location"`
+	Action   string `json:"action"`
+	Format   string `json:"format,omitempty"`
+	Width    int    `json:"width,omitempty"`
+	Height   int    `json:"height,omitempty"`
+	Quality  int    `json:"quality,omitempty"`
+
+	// Orientation is the EXIF orientation of an offloaded JPEG, the stored original has no metadata left.
+	Orientation int `json:"orientation,omitempty"`
+
+	// Thumbnail is a JPEG preview, AHash and DHash find the same picture across captures, see HammingDistance.
+	Thumbnail []byte    `json:"thumbnail,omitempty"`
+	AHash     ImageHash `json:"aHash,omitempty"`
+	DHash     ImageHash `json:"dHash,omitempty"`
+
+	OriginalSize int    `json:"originalSize"`
+	Size         int    `json:"size,omitempty"`
+	Ref          string `json:"ref,omitempty"`
+
+	// Error is why the image couldn't be compressed, the original was then offloaded, kept within the budget
+	// or removed.
+	Error string `json:"error,omitempty"`
+}
+
+// ImagePreProcessor shrinks or offloads the images carried by captured bodies: base64 or data URI strings
+// in JSON bodies and image parts of multipart bodies.
+type ImagePreProcessor struct {
+	paths        [][]string
+	compress     bool
+	compressOpts []CompressOption
+	maxSize      int
+	minSize      int
+	store        BlobStore
+
+	thumbnailWidth  int
+	thumbnailHeight int
+	hash            bool
+
+	request  bool
+	response bool
+}
+
+type ImageOpt func(*ImagePreProcessor)
+
+// ImageWithPaths only looks at the given JSON paths instead of detecting images in every string,
+// the paths start at the root of the body, their segments are separated by "." and "*" matches any key or array
+// element, e.g. "image" or "images.*.content".
+func ImageWithPaths(paths ...string) ImageOpt {
+	return func(p *ImagePreProcessor) {
+		for _, path := range paths {
+			path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+			p.paths = append(p.paths, strings.Split(path, "."))
+		}
+	}
+}
+
+// ImageWithCompression compresses the images with opts, see Compress.
+// With ImageWithMaxSize the quality and the scale are searched to fit the budget, see WithTargetSize.
+func ImageWithCompression(opts ...CompressOption) ImageOpt {
+	return func(p *ImagePreProcessor) {
+		p.compress = true
+		p.compressOpts = opts
+	}
+}
+
+// ImageWithMaxSize is the size budget in bytes of an image kept in the capture,
+// a bigger image is offloaded when there is a blob store or removed otherwise.
+func ImageWithMaxSize(n int) ImageOpt {
+	return func(p *ImagePreProcessor) {
+		p.maxSize = n
+	}
+}
+
+// ImageWithMinSize ignores the strings shorter than n when images are detected automatically.
+func ImageWithMinSize(n int) ImageOpt {
+	return func(p *ImagePreProcessor) {
+		p.minSize = n
+	}
+}
+
+// ImageWithBlobStore uploads the images to store and replaces them with their reference.
+func ImageWithBlobStore(store BlobStore) ImageOpt {
+	return func(p *ImagePreProcessor) {
+		p.store = store
+	}
+}
+
+// ImageWithThumbnail keeps a JPEG preview fitting in the width x height box of every image found,
+// including the ones left untouched.
+func ImageWithThumbnail(width, height int) ImageOpt {
+	return func(p *ImagePreProcessor) {
+		p.thumbnailWidth = width
+		p.thumbnailHeight = height
+	}
+}
+
+// ImageWithHashes computes the perceptual hashes of every image found, including the ones left untouched.
+func ImageWithHashes() ImageOpt {
+	return func(p *ImagePreProcessor) {
+		p.hash = true
+	}
+}
+
+// ImageWithPayloads chooses which payloads are processed, both by default.
+func ImageWithPayloads(request, response bool) ImageOpt {
+	return func(p *ImagePreProcessor) {
+		p.request = request
+		p.response = response
+	}
+}
+
+func NewImagePreProcessor(opts ...ImageOpt) *ImagePreProcessor {
+	p := &ImagePreProcessor{
+		minSize:  DefaultImageMinSize,
+		request:  true,
+		response: true,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+func (p *ImagePreProcessor) PreProcess(ctx context.Context, httpData *HttpData) error {
+	errs := make(Error, 0)
+	found := len(httpData.Images)
+
+	if p.request {
+		images, err := p.processPayload(ctx, "request", &httpData.Request.Payload)
+		httpData.Images = append(httpData.Images, images...)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if p.response {
+		images, err := p.processPayload(ctx, "response", &httpData.Response.Payload)
+		httpData.Images = append(httpData.Images, images...)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, img := range httpData.Images[found:] {
+		if img.Error != "" {
+			errs = append(errs, errors.Errorf("failed to compress image %s: %s", img.Location, img.Error))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func (p *ImagePreProcessor) processPayload(ctx context.Context, location string, payload *Payload) ([]CapturedImage, error) {
+	images := make([]CapturedImage, 0)
+
+	if payload.Multipart != nil {
+		for _, key := range sortedKeys(payload.Multipart.Files) {
+			files := payload.Multipart.Files[key]
+			for i := range files {
+				img, err := p.processFile(ctx, fmt.Sprintf("%s.multipart.%s[%d]", location, key, i), &files[i])
+				if err != nil {
+					return images, err
+				}
+
+				if img != nil {
+					images = append(images, *img)
+				}
+			}
+		}
+	}
+
+	if len(payload.Body) == 0 || !looksLikeJSON(payload) {
+		return images, nil
+	}
+
+	var body any
+	decoder := json.NewDecoder(bytes.NewReader(payload.Body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&body); err != nil {
+		return images, nil
+	}
+
+	changed := false
+	var walkErr error
+	replace := func(path []string, s string) (string, bool) {
+		if walkErr != nil {
+			return s, false
+		}
+
+		content, dataURI, ok := p.detect(s)
+		if !ok {
+			return s, false
+		}
+
+		img, replacement, err := p.processImage(ctx, location+".body."+strings.Join(path, "."), content, dataURI)
+		if err != nil {
+			walkErr = err
+			return s, false
+		}
+
+		if img == nil {
+			return s, false
+		}
+
+		images = append(images, *img)
+		if img.Action == ImageActionKept {
+			return s, false
+		}
+
+		changed = true
+		return replacement, true
+	}
+
+	if len(p.paths) > 0 {
+		for _, path := range p.paths {
+			body = replaceJSONPath(body, path, nil, replace)
+		}
+	} else {
+		body = replaceJSONStrings(body, nil, replace)
+	}
+
+	if walkErr != nil {
+		return images, walkErr
+	}
+
+	if changed {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return images, errors.Wrap(err, "failed to marshal body")
+		}
+
+		payload.Body = b
+		if payload.Decoded != nil {
+			payload.Decoded = nil
+			payload.Decode()
+		}
+	}
+
+	return images, nil
+}
+
+func looksLikeJSON(payload *Payload) bool {
+	if strings.Contains(payload.Header.Get("Content-Type"), "json") {
+		return true
+	}
+
+	b := bytes.TrimSpace(payload.Body)
+	return len(b) > 0 && (b[0] == '{' || b[0] == '[')
+}
+
+// detect returns the image bytes of s when s is a data URI or a base64 encoded image.
+func (p *ImagePreProcessor) detect(s string) ([]byte, string, bool) {
+	if len(p.paths) == 0 && len(s) < p.minSize {
+		return nil, "", false
+	}
+
+	dataURI := ""
+	encoded := s
+	if strings.HasPrefix(s, "data:") {
+		header, data, ok := strings.Cut(s, ",")
+		if !ok || !strings.HasSuffix(header, ";base64") || !strings.HasPrefix(header, "data:image/") {
+			return nil, "", false
+		}
+
+		dataURI = header
+		encoded = data
+	}
+
+	content, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		content, err = base64.RawStdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, "", false
+		}
+	}
+
+	if !strings.HasPrefix(http.DetectContentType(content), "image/") {
+		return nil, "", false
+	}
+
+	return content, dataURI, true
+}
+
+// processImage returns the description of the image and the string replacing it in the JSON body.
+func (p *ImagePreProcessor) processImage(ctx context.Context, location string, content []byte, dataURI string) (*CapturedImage, string, error) {
+	img, out, err := p.shrink(ctx, location, content)
+	if err != nil || img == nil {
+		return img, "", err
+	}
+
+	switch img.Action {
+	case ImageActionCompressed:
+		encoded := base64.StdEncoding.EncodeToString(out)
+		if dataURI != "" {
+			return img, "data:image/" + img.Format + ";base64," + encoded, nil
+		}
+		return img, encoded, nil
+	case ImageActionOffloaded:
+		return img, img.Ref, nil
+	case ImageActionKept:
+		return img, "", nil
+	default:
+		return img, fmt.Sprintf("[image removed: %d bytes]", img.OriginalSize), nil
+	}
+}
+
+func (p *ImagePreProcessor) processFile(ctx context.Context, location string, file *MultipartFileData) (*CapturedImage, error) {
+	if file.File == nil || !strings.HasPrefix(http.DetectContentType(file.File), "image/") {
+		return nil, nil
+	}
+
+	img, out, err := p.shrink(ctx, location, file.File)
+	if err != nil || img == nil {
+		return img, err
+	}
+
+	switch img.Action {
+	case ImageActionCompressed:
+		file.File = out
+		file.ContentType = "image/" + img.Format
+		file.Size = int64(len(out))
+		sum := sha256.Sum256(out)
+		file.SHA256 = hex.EncodeToString(sum[:])
+	case ImageActionOffloaded:
+		file.File = nil
+		file.Ref = img.Ref
+	case ImageActionKept:
+	default:
+		file.File = nil
+	}
+
+	return img, nil
+}
+
+// shrink compresses the image when configured and enforces the size budget, nil means nothing to do.
+func (p *ImagePreProcessor) shrink(ctx context.Context, location string, content []byte) (*CapturedImage, []byte, error) {
+	img := &CapturedImage{Location: location, OriginalSize: len(content)}
+	described := p.describe(img, content)
+
+	if p.compress {
+		opts := p.compressOpts
+		if p.maxSize > 0 {
+			opts = append(opts[:len(opts):len(opts)], WithTargetSize(p.maxSize))
+		}
+
+		result, err := Compress(bytes.NewReader(content), opts...)
+		if err != nil {
+			img.Error = err.Error()
+		} else if p.maxSize <= 0 || result.Size <= p.maxSize {
+			img.Action = ImageActionCompressed
+			img.Format = result.Format
+			img.Width = result.Width
+			img.Height = result.Height
+			img.Size = result.Size
+			img.Quality = result.Quality
+
+			return img, result.Bytes, nil
+		}
+	} else if p.maxSize > 0 && len(content) <= p.maxSize {
+		return keep(img, described)
+	}
+
+	if p.store != nil {
+		contentType := http.DetectContentType(content)
+		if metadata, err := decodeImageMetadata(content); err == nil {
+			img.Width, img.Height = metadata.Width, metadata.Height
+			img.Orientation = metadata.Orientation
+			if metadata.Orientation >= 5 {
+				img.Width, img.Height = metadata.Height, metadata.Width
+			}
+		}
+
+		// the offloaded original must not leak the EXIF location or device either
+		if contentType == "image/jpeg" {
+			if stripped, err := StripJPEGMetadata(content); err == nil {
+				content = stripped
+			}
+		}
+
+		ref, err := p.store.Put(ctx, newBlobKey("image", strings.ReplaceAll(location, ".", "_")), contentType, bytes.NewReader(content))
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to offload image %s", location)
+		}
+
+		img.Action = ImageActionOffloaded
+		img.Format = strings.TrimPrefix(contentType, "image/")
+		img.Ref = ref
+
+		return img, nil, nil
+	}
+
+	if p.maxSize <= 0 || len(content) <= p.maxSize {
+		if img.Error != "" {
+			// the failure must show in the capture even when the image is left as it was
+			img.Action = ImageActionKept
+			return img, nil, nil
+		}
+
+		if !p.compress {
+			return keep(img, described)
+		}
+	}
+
+	img.Action = ImageActionRemoved
+	return img, nil, nil
+}
+
+// describe fills the thumbnail and the hashes of img when configured, it returns false when there is none.
+func (p *ImagePreProcessor) describe(img *CapturedImage, content []byte) bool {
+	thumbnail := p.thumbnailWidth > 0 && p.thumbnailHeight > 0
+	if !thumbnail && !p.hash {
+		return false
+	}
+
+	decoded, err := decodeOriented(content, newCompressOptions(p.compressOpts).maxPixels)
+	if err != nil {
+		return false
+	}
+
+	bounds := decoded.Bounds()
+	img.Format = strings.TrimPrefix(http.DetectContentType(content), "image/")
+	img.Width = bounds.Dx()
+	img.Height = bounds.Dy()
+
+	if p.hash {
+		img.AHash = AverageHash(decoded)
+		img.DHash = DifferenceHash(decoded)
+	}
+
+	if thumbnail {
+		result, err := CompressImage(decoded,
+			WithBoundingBox(p.thumbnailWidth, p.thumbnailHeight),
+			WithCompressQuality(WithQualityValue(DefaultThumbnailQuality)),
+		)
+		if err == nil {
+			img.Thumbnail = result.Bytes
+		}
+	}
+
+	return true
+}
+
+func keep(img *CapturedImage, described bool) (*CapturedImage, []byte, error) {
+	if !described {
+		return nil, nil, nil
+	}
+
+	img.Action = ImageActionKept
+	return img, nil, nil
+}
+
+// sortedKeys makes the order of CapturedImage deterministic.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+type jsonReplacer func(path []string, s string) (string, bool)
+
+func replaceJSONStrings(v any, path []string, replace jsonReplacer) any {
+	switch value := v.(type) {
+	case string:
+		if s, ok := replace(path, value); ok {
+			return s
+		}
+	case map[string]any:
+		for _, k := range sortedKeys(value) {
+			value[k] = replaceJSONStrings(value[k], append(path[:len(path):len(path)], k), replace)
+		}
+	case []any:
+		for i, child := range value {
+			value[i] = replaceJSONStrings(child, append(path[:len(path):len(path)], strconv.Itoa(i)), replace)
+		}
+	}
+
+	return v
+}
+
+func replaceJSONPath(v any, remaining []string, path []string, replace jsonReplacer) any {
+	if len(remaining) == 0 {
+		if s, ok := v.(string); ok {
+			if r, ok := replace(path, s); ok {
+				return r
+			}
+		}
+		return v
+	}
+
+	segment := remaining[0]
+	switch value := v.(type) {
+	case map[string]any:
+		for _, k := range sortedKeys(value) {
+			if segment == "*" || segment == k {
+				value[k] = replaceJSONPath(value[k], remaining[1:], append(path[:len(path):len(path)], k), replace)
+			}
+		}
+	case []any:
+		for i, child := range value {
+			if segment == "*" || segment == strconv.Itoa(i) {
+				value[i] = replaceJSONPath(child, remaining[1:], append(path[:len(path):len(path)], strconv.Itoa(i)), replace)
+			}
+		}
+	}
+
+	return v
+}
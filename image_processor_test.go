@@ -0,0 +1,189 @@
+package hachibi_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mtfiqh/hachibi"
+)
+
+func TestImagePreProcessor(t *testing.T) {
+	png, err := os.ReadFile("test.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(png)
+
+	jsonData := func(body map[string]any) *hachibi.HttpData {
+		b, _ := json.Marshal(body)
+		httpData := &hachibi.HttpData{}
+		httpData.Request.Header = http.Header{"Content-Type": {"application/json"}}
+		httpData.Request.Body = b
+		return httpData
+	}
+
+	t.Run("compress detected data uri", func(t *testing.T) {
+		httpData := jsonData(map[string]any{"email": "a@b.c", "image": "data:image/png;base64," + encoded, "note": strings.Repeat("x", 2048)})
+
+		p := hachibi.NewImagePreProcessor(hachibi.ImageWithCompression(hachibi.WithCompressQuality(), hachibi.WithResizeImage()))
+		if err := p.PreProcess(context.Background(), httpData); err != nil {
+			t.Fatal(err)
+		}
+
+		body := map[string]string{}
+		json.Unmarshal(httpData.Request.Body, &body)
+		if !strings.HasPrefix(body["image"], "data:image/jpeg;base64,") || body["email"] != "a@b.c" || len(body["note"]) != 2048 {
+			t.Fatalf("unexpected body %s", httpData.Request.Body[:64])
+		}
+
+		if len(httpData.Images) != 1 {
+			t.Fatalf("unexpected images %+v", httpData.Images)
+		}
+
+		img := httpData.Images[0]
+		if img.Location != "request.body.image" || img.Action != hachibi.ImageActionCompressed || img.Format != hachibi.FormatJPEG ||
+			img.Width != 924 || img.Height != 370 || img.OriginalSize != len(png) || img.Size >= len(png) {
+			t.Fatalf("unexpected image %+v", img)
+		}
+	})
+
+	t.Run("offload paths to blob store", func(t *testing.T) {
+		store, err := hachibi.NewFileBlobStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		httpData := jsonData(map[string]any{
+			"photos":  []any{map[string]any{"content": encoded}, map[string]any{"content": "aGVsbG8="}},
+			"avatar":  encoded,
+			"ignored": encoded,
+		})
+
+		p := hachibi.NewImagePreProcessor(hachibi.ImageWithPaths("$.photos.*.content", "avatar"), hachibi.ImageWithBlobStore(store))
+		if err := p.PreProcess(context.Background(), httpData); err != nil {
+			t.Fatal(err)
+		}
+
+		var body struct {
+			Photos  []map[string]string `json:"photos"`
+			Avatar  string              `json:"avatar"`
+			Ignored string              `json:"ignored"`
+		}
+		json.Unmarshal(httpData.Request.Body, &body)
+
+		if body.Ignored != encoded || body.Photos[1]["content"] != "aGVsbG8=" {
+			t.Fatal("only images in the paths must be replaced")
+		}
+
+		for _, ref := range []string{body.Photos[0]["content"], body.Avatar} {
+			if !strings.HasPrefix(ref, "file://") || !bytes.Equal(readBlob(t, store, ref), png) {
+				t.Fatalf("unexpected ref %s", ref)
+			}
+		}
+
+		if len(httpData.Images) != 2 || httpData.Images[0].Action != hachibi.ImageActionOffloaded || httpData.Images[0].Format != hachibi.FormatPNG {
+			t.Fatalf("unexpected images %+v", httpData.Images)
+		}
+	})
+
+	t.Run("over budget without store is removed", func(t *testing.T) {
+		httpData := jsonData(map[string]any{"image": encoded})
+
+		p := hachibi.NewImagePreProcessor(hachibi.ImageWithCompression(), hachibi.ImageWithMaxSize(100))
+		err := p.PreProcess(context.Background(), httpData)
+		if err == nil || !strings.Contains(err.Error(), "request.body.image") || !strings.Contains(err.Error(), hachibi.ErrTargetSize.Error()) {
+			t.Fatalf("the failed compression must be reported, got %v", err)
+		}
+
+		body := map[string]string{}
+		json.Unmarshal(httpData.Request.Body, &body)
+		if !strings.HasPrefix(body["image"], "[image removed:") || httpData.Images[0].Action != hachibi.ImageActionRemoved ||
+			httpData.Images[0].Error == "" {
+			t.Fatalf("unexpected body %s", httpData.Request.Body)
+		}
+	})
+
+	t.Run("failed compression without store is kept", func(t *testing.T) {
+		httpData := jsonData(map[string]any{"image": encoded})
+		original := append([]byte(nil), httpData.Request.Body...)
+
+		p := hachibi.NewImagePreProcessor(hachibi.ImageWithCompression(hachibi.WithMaxPixels(1000)))
+		err := p.PreProcess(context.Background(), httpData)
+		if err == nil || !strings.Contains(err.Error(), hachibi.ErrImageTooLarge.Error()) {
+			t.Fatalf("the failed compression must be reported, got %v", err)
+		}
+
+		if !bytes.Equal(httpData.Request.Body, original) {
+			t.Fatal("the original must be kept")
+		}
+
+		if len(httpData.Images) != 1 || httpData.Images[0].Action != hachibi.ImageActionKept || httpData.Images[0].Error == "" {
+			t.Fatalf("unexpected images %+v", httpData.Images)
+		}
+	})
+
+	t.Run("compressed to budget", func(t *testing.T) {
+		httpData := jsonData(map[string]any{"image": encoded})
+
+		p := hachibi.NewImagePreProcessor(hachibi.ImageWithCompression(), hachibi.ImageWithMaxSize(8<<10))
+		if err := p.PreProcess(context.Background(), httpData); err != nil {
+			t.Fatal(err)
+		}
+
+		img := httpData.Images[0]
+		if img.Action != hachibi.ImageActionCompressed || img.Size > 8<<10 || img.Quality == 0 {
+			t.Fatalf("unexpected image %+v", img)
+		}
+	})
+
+	t.Run("under budget is kept", func(t *testing.T) {
+		httpData := jsonData(map[string]any{"image": encoded})
+		original := append([]byte(nil), httpData.Request.Body...)
+
+		p := hachibi.NewImagePreProcessor(hachibi.ImageWithMaxSize(len(png)))
+		if err := p.PreProcess(context.Background(), httpData); err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(httpData.Request.Body, original) || len(httpData.Images) != 0 {
+			t.Fatal("body must be untouched")
+		}
+	})
+
+	t.Run("multipart image through middleware", func(t *testing.T) {
+		p := &captureProcessor{}
+		m := hachibi.NewMiddleware(hachibi.MiddlewareWithProcessor(p))
+		images := hachibi.NewImagePreProcessor(hachibi.ImageWithCompression(hachibi.WithCompressQuality()))
+		h := m.Middleware(m.PreProcessMiddleware(images)(func(writer http.ResponseWriter, request *http.Request) {
+			io.Copy(io.Discard, request.Body)
+		}))
+
+		body, contentType, _ := multipartUpload(t)
+		req := httptest.NewRequest(http.MethodPost, "/upload", body)
+		req.Header.Set("Content-Type", contentType)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		record := p.records[0]
+		if len(record.Error) != 0 {
+			t.Fatal(record.Error)
+		}
+
+		files, _ := record.GetMultipartFileDataFromRequest("file")
+		if files[0].ContentType != "image/jpeg" || files[0].Size != int64(len(files[0].File)) || files[0].Size >= int64(len(png)) {
+			t.Fatalf("unexpected file %+v", files[0])
+		}
+
+		if len(record.Images) != 1 || record.Images[0].Location != "request.multipart.file[0]" {
+			t.Fatalf("unexpected images %+v", record.Images)
+		}
+	})
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 )
 
@@ -13,6 +14,10 @@ type Writer struct {
 	w          http.ResponseWriter
 	body       bytes.Buffer
 	statusCode int
+
+	// limit is how much of the body is captured, -1 for all of it.
+	limit     int
+	truncated bool
 }
 
 func newWriter(w http.ResponseWriter) *Writer {
@@ -21,6 +26,7 @@ func newWriter(w http.ResponseWriter) *Writer {
 		w:          w,
 		body:       bytes.Buffer{},
 		statusCode: statusCode,
+		limit:      -1,
 	}
 }
 
@@ -29,7 +35,18 @@ func (w *Writer) Header() http.Header {
 }
 
 func (w *Writer) Write(i []byte) (int, error) {
-	w.body.Write(i)
+	if w.statusCode == 0 {
+		// like net/http, writing without a status sends 200
+		w.statusCode = http.StatusOK
+	}
+
+	captured := i
+	if w.limit >= 0 && w.body.Len()+len(i) > w.limit {
+		captured = i[:w.limit-w.body.Len()]
+		w.truncated = w.limit > 0
+	}
+
+	w.body.Write(captured)
 	return w.w.Write(i)
 }
 
@@ -38,6 +55,11 @@ func (w *Writer) WriteHeader(statusCode int) {
 	w.w.WriteHeader(statusCode)
 }
 
+// Unwrap lets http.ResponseController reach the flusher of the original writer.
+func (w *Writer) Unwrap() http.ResponseWriter {
+	return w.w
+}
+
 type KeyCtxMiddleware int
 
 const (
@@ -45,6 +67,8 @@ const (
 	KeyHttpDataCtxMiddleware = KeyCtxMiddleware(1)
 	KeyEventCtxMiddleware    = KeyCtxMiddleware(2)
 	keyExtractData           = KeyCtxMiddleware(3)
+	keyMiddleware            = KeyCtxMiddleware(4)
+	keyWriter                = KeyCtxMiddleware(5)
 )
 
 func AddErrorInMiddlewareCtx(ctx context.Context, err error) context.Context {
@@ -75,6 +99,13 @@ type Middleware struct {
 	processor    Processor
 	preProcessor PreProcessor
 	errorHandler ErrorHandler
+	rules        *Rules
+	sampler      Sampler
+	decompress   decompressConfig
+	decode       bool
+	blobStore    BlobStore
+
+	routeNormalizer *RouteNormalizer
 
 	eventName string
 }
@@ -95,8 +126,57 @@ func MiddlewareWithErrorHandler(errorHandler ErrorHandler) MiddlewareOpt {
 	}
 }
 
+func MiddlewareWithRules(rules *Rules) MiddlewareOpt {
+	return func(middleware *Middleware) {
+		middleware.rules = rules
+	}
+}
+
+func MiddlewareWithSampler(sampler Sampler) MiddlewareOpt {
+	return func(middleware *Middleware) {
+		middleware.sampler = sampler
+	}
+}
+
+// MiddlewareWithoutDecompression keeps compressed bodies as they are.
+func MiddlewareWithoutDecompression() MiddlewareOpt {
+	return func(middleware *Middleware) {
+		middleware.decompress.disabled = true
+	}
+}
+
+// MiddlewareWithMaxDecompressRatio limits decoded size / encoded size, 0 removes the limit.
+func MiddlewareWithMaxDecompressRatio(ratio int) MiddlewareOpt {
+	return func(middleware *Middleware) {
+		middleware.decompress.maxRatio = ratio
+	}
+}
+
+// MiddlewareWithDecoding fills Payload.Decoded of the request and response before the processors run.
+// It adds to the capture and never removes the bodies, see Payload.Decode.
+func MiddlewareWithDecoding() MiddlewareOpt {
+	return func(middleware *Middleware) {
+		middleware.decode = true
+	}
+}
+
+// MiddlewareWithBlobStore offloads the files of multipart requests and responses to store, only their reference is captured.
+func MiddlewareWithBlobStore(store BlobStore) MiddlewareOpt {
+	return func(middleware *Middleware) {
+		middleware.blobStore = store
+	}
+}
+
+// MiddlewareWithRouteNormalizer sets the route of the captures from their URL when no router did, before the rules
+// and the processors run.
+func MiddlewareWithRouteNormalizer(normalizer *RouteNormalizer) MiddlewareOpt {
+	return func(middleware *Middleware) {
+		middleware.routeNormalizer = normalizer
+	}
+}
+
 func NewMiddleware(opts ...MiddlewareOpt) *Middleware {
-	m := Middleware{}
+	m := Middleware{decompress: defaultDecompressConfig()}
 	for _, opt := range opts {
 		opt(&m)
 	}
@@ -104,13 +184,29 @@ func NewMiddleware(opts ...MiddlewareOpt) *Middleware {
 	return &m
 }
 
+// SetPreProcessor returns a copy of m running preProcessor on every capture before the processor,
+// ChainPreProcessors combines several of them.
 func (m Middleware) SetPreProcessor(preProcessor PreProcessor) *Middleware {
 	newM := m
 	newM.preProcessor = preProcessor
 	return &newM
 }
 
-func getMiddlewareHttpData(w http.ResponseWriter, request *http.Request) (*HttpData, error) {
+// SetEventInMiddlewareCtx names the event of the capture of ctx, it can be called anywhere inside the Middleware.
+func SetEventInMiddlewareCtx(ctx context.Context, event string) {
+	if httpData, ok := ctx.Value(KeyHttpDataCtxMiddleware).(*HttpData); ok {
+		httpData.Event = event
+	}
+}
+
+// SetRouteInMiddlewareCtx sets the route pattern of the capture of ctx, e.g. /users/{id}.
+func SetRouteInMiddlewareCtx(ctx context.Context, route string) {
+	if httpData, ok := ctx.Value(KeyHttpDataCtxMiddleware).(*HttpData); ok {
+		httpData.Route = route
+	}
+}
+
+func getMiddlewareHttpData(request *http.Request) (*HttpData, error) {
 	httpData, ok := request.Context().Value(KeyHttpDataCtxMiddleware).(*HttpData)
 	if !ok {
 		return nil, errors.New("http data not exist")
@@ -122,20 +218,43 @@ func getMiddlewareHttpData(w http.ResponseWriter, request *http.Request) (*HttpD
 	}
 
 	if !(*extracted) || httpData.StatusCode == 0 {
-		writerClone, ok := w.(*Writer)
+		// the writer may have been wrapped by the middlewares in between
+		writerClone, ok := request.Context().Value(keyWriter).(*Writer)
 		if !ok {
-			return nil, errors.New("cannot cast writer, you need to place preProcess after middleware")
+			return nil, errors.New("no writer of the middleware")
 		}
 
 		httpData.URL = request.URL.String()
 		httpData.Method = request.Method
 		httpData.StatusCode = writerClone.statusCode
+		if httpData.StatusCode == 0 {
+			// nothing was written, net/http answers 200
+			httpData.StatusCode = http.StatusOK
+		}
+
+		if httpData.requestTee != nil {
+			httpData.requestTee.drain()
+			if err := httpData.finishRequestMultipart(); err != nil {
+				httpData.AppendError(errors.Wrap(err, "request multipart"))
+			}
+		}
 
 		httpData.Response = Response{Payload{
-			Header: writerClone.w.Header().Clone(),
-			Body:   writerClone.body.Bytes(),
+			Header:    writerClone.w.Header().Clone(),
+			Body:      writerClone.body.Bytes(),
+			Truncated: writerClone.truncated,
 		}}
 
+		if m, ok := request.Context().Value(keyMiddleware).(*Middleware); ok {
+			if err := httpData.Response.decompress(m.decompress); err != nil {
+				httpData.AppendError(errors.Wrap(err, "response"))
+			}
+
+			if err := httpData.Response.parseMultipart(request.Context(), m.blobStore); err != nil {
+				httpData.AppendError(errors.Wrap(err, "response multipart"))
+			}
+		}
+
 		*extracted = true
 	}
 
@@ -146,33 +265,68 @@ func (m Middleware) Middleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
 		ctx := request.Context()
 		timeStart := time.Now().Local()
+		httpData := HttpData{ID: uuid.New().String(), StartedAt: timeStart, Peer: request.RemoteAddr, Origin: requestOrigin(request), Method: request.Method, URL: request.URL.String(), Error: nil}
+
+		// the handler may still name the event, the rules matching it are decided once it's done
+		head := decideHead(ctx, m.rules, m.sampler, request, &httpData, false)
+		if head.skip {
+			next(writer, request)
+			return
+		}
+
 		writerClone := newWriter(writer)
-		httpData := HttpData{Error: nil}
+		writerClone.limit = head.bodyLimit()
 		extractD := extractData(false)
 
-		err := httpData.extractRequest(request)
+		err := httpData.extractRequest(request, m.blobStore, head.bodyLimit())
 		if err != nil {
 			httpData.AppendError(err)
 		}
 
+		if err := httpData.Request.decompress(m.decompress); err != nil {
+			httpData.AppendError(errors.Wrap(err, "request"))
+		}
+
 		ctx = context.WithValue(ctx, keyExtractData, &extractD)
+		ctx = context.WithValue(ctx, keyMiddleware, &m)
 		ctx = context.WithValue(ctx, KeyHttpDataCtxMiddleware, &httpData)
+		ctx = context.WithValue(ctx, keyWriter, writerClone)
 		request = request.WithContext(ctx)
 
 		defer func() {
-			httpData, err := getMiddlewareHttpData(writerClone, request)
+			httpData, err := getMiddlewareHttpData(request)
 			if err != nil {
 				return
 			}
 
 			ctx := request.Context()
-			httpData.Duration = time.Since(timeStart).Nanoseconds()
+			httpData.Duration = time.Since(timeStart).Milliseconds()
 
 			if err := getErrorInMiddlewareCtx(ctx); err != nil {
 				httpData.AppendError(err)
 
 			}
 
+			if m.routeNormalizer != nil {
+				m.routeNormalizer.PreProcess(ctx, httpData)
+			}
+
+			ctx, ok := head.decideTail(ctx, m.rules, request, httpData)
+			if !ok {
+				return
+			}
+
+			if m.decode {
+				httpData.Request.Decode()
+				httpData.Response.Decode()
+			}
+
+			if m.preProcessor != nil {
+				if err := m.preProcessor.PreProcess(ctx, httpData); err != nil {
+					httpData.AppendError(errors.Wrap(err, "pre process error"))
+				}
+			}
+
 			if m.processor != nil {
 				err := m.processor.Process(ctx, httpData)
 				if err != nil {
@@ -189,13 +343,30 @@ func (m Middleware) Middleware(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+func requestOrigin(request *http.Request) string {
+	if request.Host == "" {
+		return ""
+	}
+
+	if request.TLS != nil {
+		return "https://" + request.Host
+	}
+
+	return "http://" + request.Host
+}
+
+// Handler is Middleware for an http.Handler, e.g. a router.
+func (m Middleware) Handler(next http.Handler) http.Handler {
+	return m.Middleware(next.ServeHTTP)
+}
+
 func (m Middleware) PreProcessMiddleware(preProcessor PreProcessor) func(next http.HandlerFunc) http.HandlerFunc {
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
 			next(writer, request)
 
 			if preProcessor != nil {
-				httpData, err := getMiddlewareHttpData(writer, request)
+				httpData, err := getMiddlewareHttpData(request)
 				if err != nil {
 					return
 				}
@@ -209,18 +380,22 @@ func (m Middleware) PreProcessMiddleware(preProcessor PreProcessor) func(next ht
 	}
 }
 
+// SetEventName names the event of the captures of next, it can be placed anywhere inside the Middleware.
 func (m Middleware) SetEventName(event string) func(next http.HandlerFunc) http.HandlerFunc {
 	return func(next http.HandlerFunc) http.HandlerFunc {
-
 		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			SetEventInMiddlewareCtx(request.Context(), event)
 			next(writer, request)
+		})
+	}
+}
 
-			httpData, err := getMiddlewareHttpData(writer, request)
-			if err != nil {
-				return
-			}
-
-			httpData.Event = event
+// SetRoute sets the route pattern of the captures of next, for the routers without an adapter.
+func (m Middleware) SetRoute(route string) func(next http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			SetRouteInMiddlewareCtx(request.Context(), route)
+			next(writer, request)
 		})
 	}
 }
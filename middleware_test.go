@@ -74,3 +74,56 @@ func TestMiddleware(t *testing.T) {
 	})
 
 }
+
+// wrapping is a middleware replacing the writer, like the ones of most routers.
+type wrapping struct {
+	http.ResponseWriter
+}
+
+func TestMiddleware_Handler(t *testing.T) {
+	recorder := hachibi.NewMemoryRecorder(0)
+	m := hachibi.NewMiddleware(hachibi.MiddlewareWithProcessor(recorder))
+
+	serve := func(t *testing.T, h http.Handler) hachibi.HttpData {
+		t.Helper()
+
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/users/42", nil))
+
+		records, _ := query(t, recorder, hachibi.Filter{Limit: 1})
+		if len(records) != 1 {
+			t.Fatal("nothing recorded")
+		}
+
+		return records[0]
+	}
+
+	t.Run("default status", func(t *testing.T) {
+		written := serve(t, m.Handler(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			io.WriteString(writer, "ok")
+		})))
+		if written.StatusCode != http.StatusOK || string(written.Response.Body) != "ok" {
+			t.Fatalf("unexpected record %+v", written)
+		}
+
+		empty := serve(t, m.Handler(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {})))
+		if empty.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected record %+v", empty)
+		}
+	})
+
+	t.Run("wrapped writer", func(t *testing.T) {
+		handler := m.PreProcessMiddleware(P{})(m.SetRoute("/users/{id}")(func(writer http.ResponseWriter, request *http.Request) {
+			writer.WriteHeader(http.StatusTeapot)
+		}))
+
+		// the event and the pre-processor are placed around a middleware wrapping the writer
+		got := serve(t, m.Middleware(m.SetEventName("user")(func(writer http.ResponseWriter, request *http.Request) {
+			handler(wrapping{writer}, request)
+		})))
+
+		if got.StatusCode != http.StatusTeapot || got.Event != "user" || got.Route != "/users/{id}" || got.Request.Header.Get("Authorization") != "basic sensor" {
+			t.Fatalf("unexpected record %+v", got)
+		}
+	})
+}
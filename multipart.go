@@ -0,0 +1,292 @@
+package hachibi
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultMultipartFieldLimit is the maximum size kept for a non file field.
+const DefaultMultipartFieldLimit = 1 << 20
+
+// DefaultMultipartDrainLimit is the maximum read from a multipart request the handler left unread, to finish its
+// capture, the capture of a longer request is truncated.
+const DefaultMultipartDrainLimit = 10 << 20
+
+var (
+	errBodyClosedEarly = errors.New("body closed before the end of the multipart stream")
+	errDrainLimit      = errors.New("multipart drain limit reached")
+)
+
+// MultipartData is a parsed multipart body, files are kept inline (File) or as a BlobStore reference (Ref).
+type MultipartData struct {
+	Fields map[string][]string            `json:"fields,omitempty"`
+	Files  map[string][]MultipartFileData `json:"files,omitempty"`
+}
+
+func (m *MultipartData) withoutContent() *MultipartData {
+	c := &MultipartData{Fields: m.Fields}
+	for key, files := range m.Files {
+		if c.Files == nil {
+			c.Files = map[string][]MultipartFileData{}
+		}
+
+		for _, f := range files {
+			c.Files[key] = append(c.Files[key], f.withoutContent())
+		}
+	}
+
+	return c
+}
+
+func (m *MultipartData) clone() *MultipartData {
+	if m == nil {
+		return nil
+	}
+
+	c := &MultipartData{}
+	if m.Fields != nil {
+		c.Fields = make(map[string][]string, len(m.Fields))
+		for key, values := range m.Fields {
+			c.Fields[key] = append([]string(nil), values...)
+		}
+	}
+
+	if m.Files != nil {
+		c.Files = make(map[string][]MultipartFileData, len(m.Files))
+		for key, files := range m.Files {
+			copied := make([]MultipartFileData, len(files))
+			for i, f := range files {
+				if f.File != nil {
+					f.File = append(make([]byte, 0, len(f.File)), f.File...)
+				}
+				copied[i] = f
+			}
+			c.Files[key] = copied
+		}
+	}
+
+	return c
+}
+
+// FilesOf returns the files sent under key.
+func (m *MultipartData) FilesOf(key string) []MultipartFileData {
+	if m == nil {
+		return nil
+	}
+
+	return m.Files[key]
+}
+
+// parseMultipartStream reads a multipart stream part by part, fields are kept inline while file parts are
+// written to the blob store, without store the files are kept inline like before.
+func parseMultipartStream(ctx context.Context, r io.Reader, boundary string, store BlobStore) (*MultipartData, error) {
+	data := &MultipartData{}
+	reader := multipart.NewReader(r, boundary)
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return data, nil
+		}
+		if err != nil {
+			return data, errors.Wrap(err, "failed to read multipart")
+		}
+
+		name := part.FormName()
+		if part.FileName() == "" && name != "" {
+			value, err := io.ReadAll(io.LimitReader(part, DefaultMultipartFieldLimit))
+			if err != nil {
+				return data, errors.Wrapf(err, "failed to read field %s", name)
+			}
+
+			if data.Fields == nil {
+				data.Fields = map[string][]string{}
+			}
+			data.Fields[name] = append(data.Fields[name], string(value))
+			continue
+		}
+
+		file, err := captureFilePart(ctx, part, store)
+		if err != nil {
+			return data, errors.Wrapf(err, "failed to capture file %s", name)
+		}
+
+		if data.Files == nil {
+			data.Files = map[string][]MultipartFileData{}
+		}
+		data.Files[name] = append(data.Files[name], *file)
+	}
+}
+
+type countWriter struct {
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+func captureFilePart(ctx context.Context, part *multipart.Part, store BlobStore) (*MultipartFileData, error) {
+	hash := sha256.New()
+	counter := &countWriter{}
+	content := io.TeeReader(part, io.MultiWriter(hash, counter))
+
+	file := &MultipartFileData{
+		FileName:    part.FileName(),
+		ContentType: part.Header.Get("Content-Type"),
+	}
+
+	if store != nil {
+		ref, err := store.Put(ctx, newBlobKey("multipart", part.FileName()), file.ContentType, content)
+		if err != nil {
+			return nil, err
+		}
+
+		file.Ref = ref
+	} else {
+		b, err := io.ReadAll(content)
+		if err != nil {
+			return nil, err
+		}
+
+		file.File = b
+	}
+
+	file.Size = counter.n
+	file.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	return file, nil
+}
+
+// multipartTee hands every byte read from body to a multipart parser running in its own goroutine,
+// so the body is captured while it is consumed instead of being buffered.
+type multipartTee struct {
+	body io.ReadCloser
+	pw   *io.PipeWriter
+
+	done   chan struct{}
+	result *MultipartData
+	err    error
+
+	eof       bool
+	truncated bool
+	once      sync.Once
+}
+
+func newMultipartTee(ctx context.Context, body io.ReadCloser, boundary string, store BlobStore) *multipartTee {
+	pr, pw := io.Pipe()
+	t := &multipartTee{body: body, pw: pw, done: make(chan struct{})}
+
+	go func() {
+		defer close(t.done)
+		t.result, t.err = parseMultipartStream(context.WithoutCancel(ctx), pr, boundary, store)
+		// keep consuming so the reader is never blocked by a parser that gave up
+		io.Copy(io.Discard, pr)
+	}()
+
+	return t
+}
+
+func (t *multipartTee) Read(p []byte) (int, error) {
+	n, err := t.body.Read(p)
+	if n > 0 {
+		t.pw.Write(p[:n])
+	}
+
+	if err == io.EOF {
+		t.eof = true
+		t.pw.Close()
+	} else if err != nil {
+		t.pw.CloseWithError(err)
+	}
+
+	return n, err
+}
+
+func (t *multipartTee) Close() error {
+	err := t.body.Close()
+	t.pw.CloseWithError(errBodyClosedEarly)
+	return err
+}
+
+// drain reads what the consumer left behind so the whole stream is captured, up to DefaultMultipartDrainLimit.
+func (t *multipartTee) drain() {
+	t.once.Do(func() {
+		if t.eof {
+			return
+		}
+
+		n, _ := io.Copy(io.Discard, io.LimitReader(t, DefaultMultipartDrainLimit))
+		if !t.eof && n == DefaultMultipartDrainLimit {
+			t.truncated = true
+			t.pw.CloseWithError(errDrainLimit)
+		}
+	})
+}
+
+func (t *multipartTee) wait() (*MultipartData, error) {
+	<-t.done
+	return t.result, t.err
+}
+
+func multipartBoundary(contentType string) (string, bool) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return "", false
+	}
+
+	return params["boundary"], true
+}
+
+// finishRequestMultipart waits for the multipart parser of the request.
+func (t *HttpData) finishRequestMultipart() error {
+	tee := t.requestTee
+	if tee == nil {
+		return nil
+	}
+	t.requestTee = nil
+
+	data, err := tee.wait()
+	t.Request.Multipart = data
+
+	if tee.truncated {
+		// the parts read before the limit are kept
+		t.Request.Truncated = true
+		return nil
+	}
+
+	return err
+}
+
+// parseMultipart parses a buffered multipart body in place, the body is replaced by Multipart
+// instead of being kept twice.
+func (p *Payload) parseMultipart(ctx context.Context, store BlobStore) error {
+	if p.Multipart != nil || len(p.Body) == 0 || p.Header == nil {
+		return nil
+	}
+
+	boundary, ok := multipartBoundary(p.Header.Get("Content-Type"))
+	if !ok {
+		return nil
+	}
+
+	data, err := parseMultipartStream(ctx, bytes.NewReader(p.Body), boundary, store)
+	if err != nil {
+		return err
+	}
+
+	p.Multipart = data
+	p.Body = nil
+
+	return nil
+}
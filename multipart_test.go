@@ -0,0 +1,178 @@
+package hachibi_test
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+
+	"github.com/mtfiqh/hachibi"
+)
+
+func multipartMixed(t *testing.T) ([]byte, string) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	part, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/http"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte("HTTP/1.1 200 OK\r\n\r\n{\"id\":1}"))
+
+	part, _ = writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/http"}})
+	part.Write([]byte("HTTP/1.1 404 Not Found\r\n\r\n"))
+	writer.Close()
+
+	return body.Bytes(), "multipart/mixed; boundary=" + writer.Boundary()
+}
+
+func TestMultipart(t *testing.T) {
+	t.Run("transport response", func(t *testing.T) {
+		batch, contentType := multipartMixed(t)
+		server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			writer.Header().Set("Content-Type", contentType)
+			writer.Write(batch)
+		}))
+		defer server.Close()
+
+		p := &captureProcessor{}
+		client := http.Client{Transport: hachibi.NewTransport(hachibi.TransportWithProcessor(p))}
+
+		res, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		received, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if !bytes.Equal(received, batch) {
+			t.Fatal("caller must receive the original body")
+		}
+
+		response := p.records[0].Response
+		if response.Body != nil || response.Multipart == nil {
+			t.Fatalf("expected typed multipart instead of body, got %+v", response)
+		}
+
+		parts := response.Multipart.FilesOf("")
+		if len(parts) != 2 || parts[0].ContentType != "application/http" || !bytes.HasSuffix(parts[0].File, []byte(`{"id":1}`)) {
+			t.Fatalf("unexpected parts %+v", parts)
+		}
+	})
+
+	t.Run("middleware response", func(t *testing.T) {
+		p := &captureProcessor{}
+		m := hachibi.NewMiddleware(hachibi.MiddlewareWithProcessor(p))
+		h := m.Middleware(func(writer http.ResponseWriter, request *http.Request) {
+			body := &bytes.Buffer{}
+			mw := multipart.NewWriter(body)
+			mw.WriteField("status", "done")
+			part, _ := mw.CreateFormFile("report", "report.csv")
+			part.Write([]byte("id,name\n1,a\n"))
+			mw.Close()
+
+			writer.Header().Set("Content-Type", mw.FormDataContentType())
+			writer.Write(body.Bytes())
+		})
+
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/report", nil))
+
+		record := p.records[0]
+		files, err := record.GetMultipartFileDataFromResponse("report")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if files[0].FileName != "report.csv" || string(files[0].File) != "id,name\n1,a\n" {
+			t.Fatalf("unexpected file %+v", files[0])
+		}
+
+		if record.Response.Multipart.Fields["status"][0] != "done" {
+			t.Fatal("expected field")
+		}
+	})
+
+	t.Run("transport request", func(t *testing.T) {
+		var upstreamBody []byte
+		server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			upstreamBody, _ = io.ReadAll(request.Body)
+		}))
+		defer server.Close()
+
+		p := &captureProcessor{}
+		client := http.Client{Transport: hachibi.NewTransport(hachibi.TransportWithProcessor(p))}
+
+		body, contentType, png := multipartUpload(t)
+		sent := append([]byte(nil), body.Bytes()...)
+		res, err := client.Post(server.URL, contentType, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if !bytes.Equal(upstreamBody, sent) {
+			t.Fatal("upstream must receive the original body")
+		}
+
+		request := p.records[0].Request
+		if request.Body != nil || !bytes.Equal(request.Multipart.FilesOf("file")[0].File, png) {
+			t.Fatal("expected typed multipart request")
+		}
+	})
+
+	t.Run("drain limit", func(t *testing.T) {
+		head := &bytes.Buffer{}
+		writer := multipart.NewWriter(head)
+		writer.WriteField("name", "namaku taufiq")
+		writer.CreateFormFile("file", "big.bin")
+		contentType := writer.FormDataContentType()
+
+		// the file never ends, the drain must give up
+		body := io.MultiReader(head, zeros{})
+
+		p := &captureProcessor{}
+		m := hachibi.NewMiddleware(hachibi.MiddlewareWithProcessor(p))
+		h := m.Middleware(func(writer http.ResponseWriter, request *http.Request) {
+			writer.WriteHeader(http.StatusUnauthorized)
+		})
+
+		req := httptest.NewRequest(http.MethodPost, "/upload", body)
+		req.Header.Set("Content-Type", contentType)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		record := p.records[0]
+		if len(record.Error) != 0 || !record.Request.Truncated {
+			t.Fatalf("expected a truncated capture without error, got %v", record.Error)
+		}
+
+		if record.Request.Multipart.Fields["name"][0] != "namaku taufiq" {
+			t.Fatal("the parts before the limit must be kept")
+		}
+	})
+
+	t.Run("records from older versions", func(t *testing.T) {
+		httpData := hachibi.HttpData{Request: hachibi.Request{Payload: hachibi.Payload{
+			Header: http.Header{"Content-Type": {"multipart/form-data; boundary=x"}},
+			Body:   []byte(`{"file":{"file_name":"a.png","size":3,"file":"AQID"}}`),
+		}}}
+
+		files, err := httpData.GetMultipartFileDataFromRequest("file")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if files[0].FileName != "a.png" || !bytes.Equal(files[0].File, []byte{1, 2, 3}) {
+			t.Fatalf("unexpected file %+v", files[0])
+		}
+	})
+}
+
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -0,0 +1,201 @@
+// Package openapi infers OpenAPI 3.1 documents from captured traffic, and validates the traffic against them.
+package openapi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const Version = "3.1.0"
+
+// Document is the subset of OpenAPI written by the Inferrer and understood by the Validator, the references are
+// only supported for the schemas of the components.
+type Document struct {
+	OpenAPI    string               `yaml:"openapi" json:"openapi"`
+	Info       Info                 `yaml:"info" json:"info"`
+	Servers    []Server             `yaml:"servers,omitempty" json:"servers,omitempty"`
+	Paths      map[string]*PathItem `yaml:"paths" json:"paths"`
+	Components *Components          `yaml:"components,omitempty" json:"components,omitempty"`
+}
+
+type Components struct {
+	Schemas map[string]*Schema `yaml:"schemas,omitempty" json:"schemas,omitempty"`
+}
+
+type Info struct {
+	Title       string `yaml:"title" json:"title"`
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+	Version     string `yaml:"version" json:"version"`
+}
+
+type Server struct {
+	URL string `yaml:"url" json:"url"`
+}
+
+type PathItem struct {
+	// Parameters are shared by the operations of the path.
+	Parameters []*Parameter `yaml:"parameters,omitempty" json:"parameters,omitempty"`
+
+	Get     *Operation `yaml:"get,omitempty" json:"get,omitempty"`
+	Put     *Operation `yaml:"put,omitempty" json:"put,omitempty"`
+	Post    *Operation `yaml:"post,omitempty" json:"post,omitempty"`
+	Delete  *Operation `yaml:"delete,omitempty" json:"delete,omitempty"`
+	Options *Operation `yaml:"options,omitempty" json:"options,omitempty"`
+	Head    *Operation `yaml:"head,omitempty" json:"head,omitempty"`
+	Patch   *Operation `yaml:"patch,omitempty" json:"patch,omitempty"`
+	Trace   *Operation `yaml:"trace,omitempty" json:"trace,omitempty"`
+}
+
+// Operation returns the operation of method, nil when there is none.
+func (p *PathItem) Operation(method string) *Operation {
+	if slot := p.operation(method); slot != nil {
+		return *slot
+	}
+
+	return nil
+}
+
+// operation returns the field of method, nil when the method isn't an OpenAPI one.
+func (p *PathItem) operation(method string) **Operation {
+	switch strings.ToUpper(method) {
+	case http.MethodGet:
+		return &p.Get
+	case http.MethodPut:
+		return &p.Put
+	case http.MethodPost:
+		return &p.Post
+	case http.MethodDelete:
+		return &p.Delete
+	case http.MethodOptions:
+		return &p.Options
+	case http.MethodHead:
+		return &p.Head
+	case http.MethodPatch:
+		return &p.Patch
+	case http.MethodTrace:
+		return &p.Trace
+	default:
+		return nil
+	}
+}
+
+type Operation struct {
+	Parameters  []*Parameter         `yaml:"parameters,omitempty" json:"parameters,omitempty"`
+	RequestBody *RequestBody         `yaml:"requestBody,omitempty" json:"requestBody,omitempty"`
+	Responses   map[string]*Response `yaml:"responses" json:"responses"`
+}
+
+type Parameter struct {
+	Name     string  `yaml:"name" json:"name"`
+	In       string  `yaml:"in" json:"in"`
+	Required bool    `yaml:"required,omitempty" json:"required,omitempty"`
+	Schema   *Schema `yaml:"schema,omitempty" json:"schema,omitempty"`
+	Example  any     `yaml:"example,omitempty" json:"example,omitempty"`
+}
+
+type RequestBody struct {
+	Required bool                  `yaml:"required,omitempty" json:"required,omitempty"`
+	Content  map[string]*MediaType `yaml:"content" json:"content"`
+}
+
+type Response struct {
+	Description string                `yaml:"description" json:"description"`
+	Content     map[string]*MediaType `yaml:"content,omitempty" json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema  *Schema `yaml:"schema,omitempty" json:"schema,omitempty"`
+	Example any     `yaml:"example,omitempty" json:"example,omitempty"`
+}
+
+type Schema struct {
+	// Ref is a reference to a schema of the components, e.g. #/components/schemas/User.
+	Ref string `yaml:"$ref,omitempty" json:"$ref,omitempty"`
+
+	Type Types `yaml:"type,omitempty" json:"type,omitempty"`
+	// Nullable is the OpenAPI 3.0 way to accept null, 3.1 adds "null" to Type.
+	Nullable   bool               `yaml:"nullable,omitempty" json:"nullable,omitempty"`
+	Format     string             `yaml:"format,omitempty" json:"format,omitempty"`
+	Enum       []any              `yaml:"enum,omitempty" json:"enum,omitempty"`
+	Properties map[string]*Schema `yaml:"properties,omitempty" json:"properties,omitempty"`
+	Required   []string           `yaml:"required,omitempty" json:"required,omitempty"`
+	Items      *Schema            `yaml:"items,omitempty" json:"items,omitempty"`
+
+	AllOf []*Schema `yaml:"allOf,omitempty" json:"allOf,omitempty"`
+	AnyOf []*Schema `yaml:"anyOf,omitempty" json:"anyOf,omitempty"`
+	OneOf []*Schema `yaml:"oneOf,omitempty" json:"oneOf,omitempty"`
+}
+
+// Types is the type of a Schema, one type is written as a string and several, e.g. [string, "null"], as a list.
+type Types []string
+
+func (t Types) MarshalYAML() (any, error) {
+	if len(t) == 1 {
+		return t[0], nil
+	}
+
+	return []string(t), nil
+}
+
+func (t *Types) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*t = Types{value.Value}
+		return nil
+	}
+
+	return value.Decode((*[]string)(t))
+}
+
+func (t Types) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+
+	return json.Marshal([]string(t))
+}
+
+func (t *Types) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*t = Types{one}
+		return nil
+	}
+
+	return json.Unmarshal(b, (*[]string)(t))
+}
+
+// Has reports whether t contains typ.
+func (t Types) Has(typ string) bool {
+	for _, x := range t {
+		if x == typ {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Load reads a document written as YAML or JSON.
+func Load(r io.Reader) (*Document, error) {
+	var d Document
+	if err := yaml.NewDecoder(r).Decode(&d); err != nil {
+		return nil, errors.Wrap(err, "invalid OpenAPI document")
+	}
+
+	return &d, nil
+}
+
+func (d *Document) WriteYAML(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(d); err != nil {
+		return errors.Wrap(err, "failed to write OpenAPI document")
+	}
+
+	return encoder.Close()
+}
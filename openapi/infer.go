@@ -0,0 +1,400 @@
+package openapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/mtfiqh/hachibi"
+)
+
+const (
+	DefaultTitle   = "Inferred API"
+	DefaultVersion = "0.0.0"
+	// DefaultMaxEnum is the most distinct values of a string inferred as an enum.
+	DefaultMaxEnum = 5
+)
+
+// Inferrer builds a Document from captures: the paths are their routes, or their normalized URLs, the schemas of the
+// parameters and JSON bodies are merged across the captures of an operation.
+// A property missing from some bodies is optional, a null value makes its type nullable and a string with a few
+// repeated values is an enum. The first value seen is the example.
+type Inferrer struct {
+	normalizer *hachibi.RouteNormalizer
+	title      string
+	version    string
+	maxEnum    int
+
+	mu         sync.Mutex
+	operations map[string]*operationShape
+	servers    map[string]bool
+}
+
+type InferrerOpt func(*Inferrer)
+
+// InferrerWithRouteNormalizer derives the paths of the captures without a route, hachibi.NewRouteNormalizer by default.
+func InferrerWithRouteNormalizer(normalizer *hachibi.RouteNormalizer) InferrerOpt {
+	return func(i *Inferrer) {
+		i.normalizer = normalizer
+	}
+}
+
+func InferrerWithTitle(title string) InferrerOpt {
+	return func(i *Inferrer) {
+		i.title = title
+	}
+}
+
+func InferrerWithVersion(version string) InferrerOpt {
+	return func(i *Inferrer) {
+		i.version = version
+	}
+}
+
+// InferrerWithMaxEnum sets the most distinct values of an enum, 0 disables the enums.
+func InferrerWithMaxEnum(maxEnum int) InferrerOpt {
+	return func(i *Inferrer) {
+		i.maxEnum = maxEnum
+	}
+}
+
+func NewInferrer(opts ...InferrerOpt) *Inferrer {
+	i := &Inferrer{
+		normalizer: hachibi.NewRouteNormalizer(),
+		title:      DefaultTitle,
+		version:    DefaultVersion,
+		maxEnum:    DefaultMaxEnum,
+		operations: make(map[string]*operationShape),
+		servers:    make(map[string]bool),
+	}
+
+	for _, opt := range opts {
+		opt(i)
+	}
+
+	return i
+}
+
+// Process adds httpData, the Inferrer can learn from live traffic as a processor.
+func (i *Inferrer) Process(ctx context.Context, httpData *hachibi.HttpData) error {
+	i.Add(httpData)
+	return nil
+}
+
+// Add merges httpData in the document, the methods OpenAPI doesn't describe, e.g. CONNECT, are ignored.
+func (i *Inferrer) Add(httpData *hachibi.HttpData) {
+	if (&PathItem{}).operation(httpData.Method) == nil {
+		return
+	}
+
+	u, err := url.Parse(httpData.URL)
+	if err != nil {
+		return
+	}
+
+	route := httpData.Route
+	if route == "" {
+		route = i.normalizer.Normalize(httpData.URL)
+	}
+	path, params := pathTemplate(route)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if u.Scheme != "" && u.Host != "" {
+		i.servers[u.Scheme+"://"+u.Host] = true
+	}
+
+	key := strings.ToUpper(httpData.Method) + " " + path
+	op, ok := i.operations[key]
+	if !ok {
+		op = newOperationShape(path, httpData.Method, params)
+		i.operations[key] = op
+	}
+
+	op.add(httpData, u)
+}
+
+// Document returns the document inferred from the captures added so far.
+func (i *Inferrer) Document() *Document {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	d := &Document{
+		OpenAPI: Version,
+		Info:    Info{Title: i.title, Version: i.version},
+		Paths:   make(map[string]*PathItem),
+	}
+
+	for server := range i.servers {
+		d.Servers = append(d.Servers, Server{URL: server})
+	}
+	sort.Slice(d.Servers, func(a, b int) bool { return d.Servers[a].URL < d.Servers[b].URL })
+
+	for _, op := range i.operations {
+		item, ok := d.Paths[op.path]
+		if !ok {
+			item = &PathItem{}
+			d.Paths[op.path] = item
+		}
+
+		*item.operation(op.method) = op.operation(i.maxEnum)
+	}
+
+	return d
+}
+
+// pathParam is a parameter of a path template, index is its segment.
+type pathParam struct {
+	name  string
+	index int
+}
+
+// pathTemplate converts the parameters of route to the OpenAPI syntax, e.g. /users/:id to /users/{id}, and gives the
+// repeated names a suffix, e.g. /users/{id}/orders/{id2}.
+func pathTemplate(route string) (string, []pathParam) {
+	segments := strings.Split(route, "/")
+	params := make([]pathParam, 0)
+	seen := make(map[string]int)
+
+	for i, segment := range segments {
+		var name string
+		switch {
+		case len(segment) > 2 && strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}"):
+			name = strings.TrimSuffix(segment[1:len(segment)-1], "...")
+		case len(segment) > 1 && (segment[0] == ':' || segment[0] == '*'):
+			name = segment[1:]
+		default:
+			continue
+		}
+
+		// chi patterns may constrain the parameter, e.g. {id:[0-9]+}
+		name, _, _ = strings.Cut(name, ":")
+
+		seen[name]++
+		if n := seen[name]; n > 1 {
+			name += strconv.Itoa(n)
+		}
+
+		segments[i] = "{" + name + "}"
+		params = append(params, pathParam{name: name, index: i})
+	}
+
+	return strings.Join(segments, "/"), params
+}
+
+type operationShape struct {
+	path       string
+	method     string
+	samples    int
+	pathParams []pathParam
+	pathShapes map[string]*shape
+	query      map[string]*shape
+	requests   int
+	request    map[string]*bodyShape
+	responses  map[int]map[string]*bodyShape
+}
+
+type bodyShape struct {
+	shape   *shape
+	example any
+}
+
+func newOperationShape(path string, method string, params []pathParam) *operationShape {
+	op := &operationShape{
+		path:       path,
+		method:     strings.ToUpper(method),
+		pathParams: params,
+		pathShapes: make(map[string]*shape),
+		query:      make(map[string]*shape),
+		request:    make(map[string]*bodyShape),
+		responses:  make(map[int]map[string]*bodyShape),
+	}
+
+	for _, p := range params {
+		op.pathShapes[p.name] = &shape{}
+	}
+
+	return op
+}
+
+func (op *operationShape) add(httpData *hachibi.HttpData, u *url.URL) {
+	op.samples++
+
+	segments := strings.Split(u.Path, "/")
+	for _, p := range op.pathParams {
+		// the route of a sub-router may not align with the URL
+		if len(segments) == len(strings.Split(op.path, "/")) {
+			op.pathShapes[p.name].observeText(segments[p.index])
+		}
+	}
+
+	for name, values := range u.Query() {
+		s, ok := op.query[name]
+		if !ok {
+			s = &shape{}
+			op.query[name] = s
+		}
+
+		s.observeText(values[0])
+	}
+
+	if contentType, body, ok := payloadBody(&httpData.Request.Payload); ok {
+		op.requests++
+		observeBody(op.request, contentType, body)
+	}
+
+	content, ok := op.responses[httpData.StatusCode]
+	if !ok {
+		content = make(map[string]*bodyShape)
+		op.responses[httpData.StatusCode] = content
+	}
+
+	if contentType, body, ok := payloadBody(&httpData.Response.Payload); ok {
+		observeBody(content, contentType, body)
+	}
+}
+
+func (op *operationShape) operation(maxEnum int) *Operation {
+	o := &Operation{Responses: make(map[string]*Response)}
+
+	for _, p := range op.pathParams {
+		s := op.pathShapes[p.name]
+		o.Parameters = append(o.Parameters, &Parameter{Name: p.name, In: "path", Required: true, Schema: s.schema(maxEnum), Example: s.example})
+	}
+
+	names := make([]string, 0, len(op.query))
+	for name := range op.query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		s := op.query[name]
+		o.Parameters = append(o.Parameters, &Parameter{Name: name, In: "query", Required: s.samples == op.samples, Schema: s.schema(maxEnum), Example: s.example})
+	}
+
+	if op.requests > 0 {
+		o.RequestBody = &RequestBody{Required: op.requests == op.samples, Content: mediaTypes(op.request, maxEnum)}
+	}
+
+	for status, content := range op.responses {
+		description := http.StatusText(status)
+		if description == "" {
+			description = "Response"
+		}
+
+		response := &Response{Description: description}
+		if len(content) > 0 {
+			response.Content = mediaTypes(content, maxEnum)
+		}
+
+		o.Responses[strconv.Itoa(status)] = response
+	}
+
+	return o
+}
+
+func mediaTypes(bodies map[string]*bodyShape, maxEnum int) map[string]*MediaType {
+	content := make(map[string]*MediaType, len(bodies))
+	for contentType, body := range bodies {
+		content[contentType] = &MediaType{Schema: body.shape.schema(maxEnum), Example: body.example}
+	}
+
+	return content
+}
+
+// payloadBody returns the media type and the value of the body of p: the decoded JSON, a string, or the form of a
+// multipart body.
+func payloadBody(p *hachibi.Payload) (string, any, bool) {
+	contentType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+
+	if p.Multipart != nil {
+		form := make(map[string]any)
+		for name, values := range p.Multipart.Fields {
+			if len(values) > 0 {
+				form[name] = values[0]
+			}
+		}
+		for name := range p.Multipart.Files {
+			form[name] = binary{}
+		}
+
+		return "multipart/form-data", form, true
+	}
+
+	if len(p.Body) == 0 {
+		return "", nil, false
+	}
+
+	if contentType == "" || strings.Contains(contentType, "json") {
+		decoder := json.NewDecoder(bytes.NewReader(p.Body))
+		decoder.UseNumber()
+
+		var v any
+		if err := decoder.Decode(&v); err == nil && !decoder.More() {
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			return contentType, v, true
+		}
+	}
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	if !utf8.Valid(p.Body) {
+		return contentType, binary{}, true
+	}
+
+	return contentType, string(p.Body), true
+}
+
+func observeBody(bodies map[string]*bodyShape, contentType string, v any) {
+	body, ok := bodies[contentType]
+	if !ok {
+		body = &bodyShape{shape: &shape{}, example: example(v)}
+		bodies[contentType] = body
+	}
+
+	body.shape.observe(v)
+}
+
+// example converts the numbers decoded from JSON, so that they are written as numbers.
+func example(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case []any:
+		values := make([]any, len(v))
+		for i, x := range v {
+			values[i] = example(x)
+		}
+		return values
+	case map[string]any:
+		values := make(map[string]any, len(v))
+		for k, x := range v {
+			if _, ok := x.(binary); !ok {
+				values[k] = example(x)
+			}
+		}
+		return values
+	case binary:
+		return nil
+	default:
+		return v
+	}
+}
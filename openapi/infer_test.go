@@ -0,0 +1,133 @@
+package openapi_test
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mtfiqh/hachibi"
+	"github.com/mtfiqh/hachibi/openapi"
+)
+
+func capture(method string, url string, status int, requestBody string, responseBody string) *hachibi.HttpData {
+	httpData := &hachibi.HttpData{Method: method, URL: url, StatusCode: status}
+	httpData.Request.Header = http.Header{}
+	httpData.Response.Header = http.Header{"Content-Type": {"application/json; charset=utf-8"}}
+	if requestBody != "" {
+		httpData.Request.Header.Set("Content-Type", "application/json")
+		httpData.Request.Body = []byte(requestBody)
+	}
+	if responseBody != "" {
+		httpData.Response.Body = []byte(responseBody)
+	}
+
+	return httpData
+}
+
+func TestInferrer(t *testing.T) {
+	i := openapi.NewInferrer(openapi.InferrerWithTitle("Users"))
+	for _, c := range []*hachibi.HttpData{
+		capture(http.MethodGet, "https://api.example.com/users/1?expand=orders", 200, "", `{"id":1,"name":"Taufiq","status":"active","email":"taufiq@example.com","nickname":null,"tags":["a"]}`),
+		capture(http.MethodGet, "https://api.example.com/users/2", 200, "", `{"id":2,"name":"Budi","status":"active","email":"budi@example.com","nickname":"bud","score":1.5}`),
+		capture(http.MethodGet, "https://api.example.com/users/3", 200, "", `{"id":3,"name":"Siti","status":"banned","email":"siti@example.com","nickname":"sit","score":2}`),
+		capture(http.MethodGet, "https://api.example.com/users/99", 404, "", `{"message":"not found"}`),
+		capture(http.MethodPost, "https://api.example.com/users", 201, `{"name":"Taufiq","createdAt":"2024-05-01T10:00:00Z"}`, `{"id":1}`),
+		capture(http.MethodConnect, "api.example.com:443", 200, "", ""),
+	} {
+		i.Add(c)
+	}
+
+	routed := capture(http.MethodDelete, "https://api.example.com/users/42/orders/7", 204, "", "")
+	routed.Route = "/users/:user/orders/:id"
+	i.Add(routed)
+
+	d := i.Document()
+
+	t.Run("document", func(t *testing.T) {
+		if d.OpenAPI != "3.1.0" || d.Info.Title != "Users" || len(d.Servers) != 1 || d.Servers[0].URL != "https://api.example.com" {
+			t.Fatalf("unexpected document %+v", d)
+		}
+
+		if len(d.Paths) != 3 || d.Paths["/users/{id}"].Get == nil || d.Paths["/users"].Post == nil || d.Paths["/users/{user}/orders/{id}"].Delete == nil {
+			t.Fatalf("unexpected paths %v", d.Paths)
+		}
+	})
+
+	t.Run("parameters", func(t *testing.T) {
+		params := d.Paths["/users/{id}"].Get.Parameters
+		if len(params) != 2 {
+			t.Fatalf("unexpected parameters %+v", params)
+		}
+
+		if id := params[0]; id.Name != "id" || id.In != "path" || !id.Required || !reflect.DeepEqual(id.Schema.Type, openapi.Types{"integer"}) || id.Example != int64(1) {
+			t.Fatalf("unexpected path parameter %+v", id)
+		}
+
+		if expand := params[1]; expand.Name != "expand" || expand.In != "query" || expand.Required || expand.Example != "orders" {
+			t.Fatalf("unexpected query parameter %+v", expand)
+		}
+	})
+
+	t.Run("schemas", func(t *testing.T) {
+		responses := d.Paths["/users/{id}"].Get.Responses
+		if responses["404"].Description != "Not Found" || responses["200"].Description != "OK" {
+			t.Fatalf("unexpected responses %v", responses)
+		}
+
+		media := responses["200"].Content["application/json"]
+		schema := media.Schema
+		if !reflect.DeepEqual(schema.Required, []string{"email", "id", "name", "nickname", "status"}) {
+			t.Fatalf("unexpected required %v", schema.Required)
+		}
+
+		properties := schema.Properties
+		if !reflect.DeepEqual(properties["nickname"].Type, openapi.Types{"string", "null"}) || !reflect.DeepEqual(properties["score"].Type, openapi.Types{"number"}) {
+			t.Fatalf("unexpected nullable and number %+v %+v", properties["nickname"], properties["score"])
+		}
+
+		if !reflect.DeepEqual(properties["status"].Enum, []any{"active", "banned"}) || properties["name"].Enum != nil || properties["email"].Format != "email" {
+			t.Fatalf("unexpected strings %+v %+v %+v", properties["status"], properties["name"], properties["email"])
+		}
+
+		if properties["tags"].Items == nil || !reflect.DeepEqual(properties["tags"].Items.Type, openapi.Types{"string"}) {
+			t.Fatalf("unexpected array %+v", properties["tags"])
+		}
+
+		if example := media.Example.(map[string]any); example["id"] != int64(1) || example["name"] != "Taufiq" {
+			t.Fatalf("unexpected example %v", media.Example)
+		}
+
+		body := d.Paths["/users"].Post.RequestBody
+		if !body.Required || body.Content["application/json"].Schema.Properties["createdAt"].Format != "date-time" {
+			t.Fatalf("unexpected request body %+v", body.Content["application/json"].Schema)
+		}
+
+		if deleted := d.Paths["/users/{user}/orders/{id}"].Delete; deleted.RequestBody != nil || deleted.Responses["204"].Content != nil || len(deleted.Parameters) != 2 {
+			t.Fatalf("unexpected operation %+v", deleted)
+		}
+	})
+
+	t.Run("yaml", func(t *testing.T) {
+		out := new(bytes.Buffer)
+		if err := d.WriteYAML(out); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, want := range []string{"openapi: 3.1.0\n", "  /users/{id}:\n", "type:\n", "- string\n", "- \"null\"\n", "example: 1\n"} {
+			if !strings.Contains(out.String(), want) {
+				t.Fatalf("missing %q in\n%s", want, out)
+			}
+		}
+
+		loaded, err := openapi.Load(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(loaded.Paths["/users/{id}"].Get.Responses["200"].Content["application/json"].Schema.Properties["nickname"].Type, openapi.Types{"string", "null"}) {
+			t.Fatalf("unexpected loaded document %+v", loaded)
+		}
+	})
+}
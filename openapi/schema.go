@@ -0,0 +1,223 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// typeOrder is the order of the types of a Schema, null is last like in [string, "null"].
+var typeOrder = []string{"object", "array", "string", "integer", "number", "boolean", "null"}
+
+// formats are tried in order, the first one all the strings of a shape have is its format.
+var formats = []struct {
+	name  string
+	match func(s string) bool
+}{
+	{name: "uuid", match: uuidPattern.MatchString},
+	{name: "date-time", match: func(s string) bool {
+		_, err := time.Parse(time.RFC3339Nano, s)
+		return err == nil
+	}},
+	{name: "date", match: func(s string) bool {
+		_, err := time.Parse(time.DateOnly, s)
+		return err == nil
+	}},
+	{name: "email", match: func(s string) bool {
+		a, err := mail.ParseAddress(s)
+		return err == nil && a.Address == s
+	}},
+	{name: "uri", match: func(s string) bool {
+		u, err := url.Parse(s)
+		return err == nil && u.Scheme != "" && u.Host != ""
+	}},
+}
+
+// maxValues bounds the distinct strings a shape remembers for its enum.
+const maxValues = 64
+
+// binary is the value of a body or a file which isn't text.
+type binary struct{}
+
+// shape accumulates the values seen at one place of the bodies, or of a parameter.
+type shape struct {
+	samples int
+	types   map[string]int
+	example any
+
+	objects    int
+	properties map[string]*shape
+
+	items *shape
+
+	strings  int
+	values   map[string]bool
+	overflow bool
+	// formats holds the formats all the strings have so far, nil before the first string.
+	formats []string
+	binary  bool
+}
+
+func (s *shape) observe(v any) {
+	if s.samples == 0 {
+		s.example = example(v)
+	}
+	s.samples++
+
+	if s.types == nil {
+		s.types = make(map[string]int)
+	}
+
+	switch v := v.(type) {
+	case nil:
+		s.types["null"]++
+	case bool:
+		s.types["boolean"]++
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			s.types["integer"]++
+		} else {
+			s.types["number"]++
+		}
+	case string:
+		s.types["string"]++
+		s.observeString(v)
+	case binary:
+		s.types["string"]++
+		s.binary = true
+	case []any:
+		s.types["array"]++
+		if s.items == nil {
+			s.items = &shape{}
+		}
+		for _, x := range v {
+			s.items.observe(x)
+		}
+	case map[string]any:
+		s.types["object"]++
+		s.objects++
+		if s.properties == nil {
+			s.properties = make(map[string]*shape)
+		}
+		for k, x := range v {
+			p, ok := s.properties[k]
+			if !ok {
+				p = &shape{}
+				s.properties[k] = p
+			}
+			p.observe(x)
+		}
+	}
+}
+
+// observeText observes a parameter, its value is typed like a JSON scalar when it looks like one.
+func (s *shape) observeText(text string) {
+	if _, err := strconv.ParseInt(text, 10, 64); err == nil {
+		s.observe(json.Number(text))
+		return
+	}
+
+	if _, err := strconv.ParseFloat(text, 64); err == nil {
+		s.observe(json.Number(text))
+		return
+	}
+
+	if b, err := strconv.ParseBool(text); err == nil && (text == "true" || text == "false") {
+		s.observe(b)
+		return
+	}
+
+	s.observe(text)
+}
+
+func (s *shape) observeString(v string) {
+	s.strings++
+
+	if !s.overflow {
+		if s.values == nil {
+			s.values = make(map[string]bool)
+		}
+		s.values[v] = true
+		if len(s.values) > maxValues {
+			s.values, s.overflow = nil, true
+		}
+	}
+
+	if s.strings == 1 {
+		for _, f := range formats {
+			if f.match(v) {
+				s.formats = append(s.formats, f.name)
+			}
+		}
+		return
+	}
+
+	kept := s.formats[:0]
+	for _, name := range s.formats {
+		for _, f := range formats {
+			if f.name == name && f.match(v) {
+				kept = append(kept, name)
+			}
+		}
+	}
+	s.formats = kept
+}
+
+func (s *shape) schema(maxEnum int) *Schema {
+	schema := &Schema{}
+	for _, typ := range typeOrder {
+		if s.types[typ] == 0 || (typ == "integer" && s.types["number"] > 0) {
+			continue
+		}
+		schema.Type = append(schema.Type, typ)
+	}
+
+	if s.properties != nil {
+		schema.Properties = make(map[string]*Schema, len(s.properties))
+		for name, p := range s.properties {
+			schema.Properties[name] = p.schema(maxEnum)
+			if p.samples == s.objects {
+				schema.Required = append(schema.Required, name)
+			}
+		}
+		sort.Strings(schema.Required)
+	}
+
+	if s.items != nil {
+		schema.Items = s.items.schema(maxEnum)
+	}
+
+	switch {
+	case s.binary:
+		schema.Format = "binary"
+	case len(s.formats) > 0:
+		schema.Format = s.formats[0]
+	case s.isEnum(maxEnum):
+		for value := range s.values {
+			schema.Enum = append(schema.Enum, value)
+		}
+		sort.Slice(schema.Enum, func(a, b int) bool { return schema.Enum[a].(string) < schema.Enum[b].(string) })
+
+		// the enum must accept the values of the other types
+		switch {
+		case len(schema.Type) == 2 && schema.Type.Has("null"):
+			schema.Enum = append(schema.Enum, nil)
+		case len(schema.Type) > 1:
+			schema.Enum = nil
+		}
+	}
+
+	return schema
+}
+
+// isEnum reports whether the strings repeat a few values, which enough samples make unlikely to be a coincidence.
+func (s *shape) isEnum(maxEnum int) bool {
+	return maxEnum > 0 && !s.overflow && len(s.values) > 0 && len(s.values) <= maxEnum &&
+		s.strings >= 3 && len(s.values) < s.strings
+}
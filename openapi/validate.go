@@ -0,0 +1,631 @@
+package openapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mtfiqh/hachibi"
+	"github.com/pkg/errors"
+)
+
+type Mode int
+
+const (
+	// ModeWarn only records the violations on the captures.
+	ModeWarn Mode = iota
+	// ModeFail also returns ErrContractViolation, so that the violations reach the error handler.
+	ModeFail
+)
+
+var ErrContractViolation = errors.New("contract violation")
+
+// Counter counts the violations by kind, e.g. an expvar.Map or an adapter of a Prometheus CounterVec,
+// publishing it is left to the caller.
+type Counter interface {
+	Add(kind string, delta int64)
+}
+
+// maxRefs bounds the references followed to resolve a schema, a cycle of references is ignored.
+const maxRefs = 32
+
+// Validator checks captures against a Document: their path, operation, parameters, status and the JSON bodies with
+// the schemas of their media types. The bodies which are truncated or multipart aren't checked.
+// The server URLs of the document may have a base path, e.g. https://api.example.com/v1, it is removed from the paths
+// of the captures before matching them.
+type Validator struct {
+	doc       *Document
+	mode      Mode
+	basePaths []string
+	routes    []route
+	counter   Counter
+}
+
+type ValidatorOpt func(*Validator)
+
+// ValidatorWithMode sets the mode, ModeWarn by default.
+func ValidatorWithMode(mode Mode) ValidatorOpt {
+	return func(v *Validator) {
+		v.mode = mode
+	}
+}
+
+// ValidatorWithCounter counts the violations found with counter.
+func ValidatorWithCounter(counter Counter) ValidatorOpt {
+	return func(v *Validator) {
+		v.counter = counter
+	}
+}
+
+// route is a path of the document, its parameter segments are empty.
+type route struct {
+	path     string
+	segments []string
+	params   map[int]string
+	literals int
+	item     *PathItem
+}
+
+func NewValidator(doc *Document, opts ...ValidatorOpt) *Validator {
+	v := &Validator{doc: doc}
+
+	for _, server := range doc.Servers {
+		if u, err := url.Parse(server.URL); err == nil {
+			if base := strings.TrimSuffix(u.Path, "/"); base != "" {
+				v.basePaths = append(v.basePaths, base)
+			}
+		}
+	}
+	// the longest base path is removed first
+	sort.Slice(v.basePaths, func(a, b int) bool { return len(v.basePaths[a]) > len(v.basePaths[b]) })
+
+	for path, item := range doc.Paths {
+		r := route{path: path, segments: strings.Split(path, "/"), params: make(map[int]string), item: item}
+		for i, segment := range r.segments {
+			if len(segment) > 2 && strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+				r.params[i] = segment[1 : len(segment)-1]
+				r.segments[i] = ""
+				continue
+			}
+			r.literals++
+		}
+		v.routes = append(v.routes, r)
+	}
+	// the most literal path matches first, e.g. /users/me before /users/{id}
+	sort.Slice(v.routes, func(a, b int) bool {
+		if v.routes[a].literals != v.routes[b].literals {
+			return v.routes[a].literals > v.routes[b].literals
+		}
+		return v.routes[a].path < v.routes[b].path
+	})
+
+	for _, opt := range opts {
+		opt(v)
+	}
+
+	return v
+}
+
+// PreProcess records the violations before the processor, which stores them with the capture.
+func (v *Validator) PreProcess(ctx context.Context, httpData *hachibi.HttpData) error {
+	return v.Process(ctx, httpData)
+}
+
+// Process appends the violations of httpData to its Violations and counts them with the Counter, in ModeFail it returns an error
+// wrapping ErrContractViolation when there is any.
+func (v *Validator) Process(ctx context.Context, httpData *hachibi.HttpData) error {
+	violations := v.Validate(httpData)
+	if len(violations) == 0 {
+		return nil
+	}
+
+	httpData.Violations = append(httpData.Violations, violations...)
+	if v.counter != nil {
+		for _, violation := range violations {
+			v.counter.Add(violation.Kind, 1)
+		}
+	}
+
+	if v.mode == ModeFail {
+		return errors.Wrapf(ErrContractViolation, "%s %s: %s", httpData.Method, httpData.URL, violations[0])
+	}
+
+	return nil
+}
+
+// Validate returns the violations of httpData, the methods OpenAPI doesn't describe, e.g. CONNECT, have none.
+func (v *Validator) Validate(httpData *hachibi.HttpData) []hachibi.Violation {
+	if (&PathItem{}).operation(httpData.Method) == nil {
+		return nil
+	}
+
+	u, err := url.Parse(httpData.URL)
+	if err != nil {
+		return nil
+	}
+
+	c := &check{validator: v}
+
+	path := v.trimBasePath(u.EscapedPath())
+	r, values := v.match(path)
+	if r == nil {
+		c.report(hachibi.ViolationUnknownPath, "request.path", "", "no path of the contract matches %s", path)
+		return c.violations
+	}
+
+	op := r.item.Operation(httpData.Method)
+	if op == nil {
+		c.report(hachibi.ViolationUnknownOperation, "request.method", "", "%s isn't an operation of %s", strings.ToUpper(httpData.Method), r.path)
+		return c.violations
+	}
+
+	c.parameters(mergeParameters(r.item.Parameters, op.Parameters), values, u.Query(), &httpData.Request.Payload)
+	c.requestBody(op.RequestBody, &httpData.Request.Payload)
+
+	// a capture without status failed before the response
+	if httpData.StatusCode != 0 {
+		c.response(op, httpData.StatusCode, &httpData.Response.Payload)
+	}
+
+	return c.violations
+}
+
+func (v *Validator) trimBasePath(path string) string {
+	for _, base := range v.basePaths {
+		if path == base {
+			return "/"
+		}
+		if strings.HasPrefix(path, base+"/") {
+			return strings.TrimPrefix(path, base)
+		}
+	}
+
+	return path
+}
+
+// match returns the route of path and the values of its path parameters.
+func (v *Validator) match(path string) (*route, map[string]string) {
+	segments := strings.Split(path, "/")
+
+	for i := range v.routes {
+		r := &v.routes[i]
+		if len(r.segments) != len(segments) {
+			continue
+		}
+
+		values := make(map[string]string, len(r.params))
+		matched := true
+		for j, segment := range segments {
+			if name, ok := r.params[j]; ok {
+				value, err := url.PathUnescape(segment)
+				if err != nil || value == "" {
+					matched = false
+					break
+				}
+				values[name] = value
+				continue
+			}
+
+			if segment != r.segments[j] {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			return r, values
+		}
+	}
+
+	return nil, nil
+}
+
+// mergeParameters returns the parameters of an operation, which override the ones of its path with the same name and
+// location.
+func mergeParameters(shared []*Parameter, own []*Parameter) []*Parameter {
+	params := make([]*Parameter, 0, len(shared)+len(own))
+	for _, p := range shared {
+		overridden := false
+		for _, o := range own {
+			overridden = overridden || (o.Name == p.Name && o.In == p.In)
+		}
+		if !overridden {
+			params = append(params, p)
+		}
+	}
+
+	return append(params, own...)
+}
+
+// check collects the violations of one capture.
+type check struct {
+	validator  *Validator
+	violations []hachibi.Violation
+}
+
+func (c *check) report(kind string, in string, pointer string, format string, args ...any) {
+	c.violations = append(c.violations, hachibi.Violation{Kind: kind, In: in, Pointer: pointer, Message: fmt.Sprintf(format, args...)})
+}
+
+func (c *check) parameters(params []*Parameter, path map[string]string, query url.Values, request *hachibi.Payload) {
+	for _, p := range params {
+		var values []string
+		switch p.In {
+		case "path":
+			if value, ok := path[p.Name]; ok {
+				values = []string{value}
+			}
+		case "query":
+			values = query[p.Name]
+		case "header":
+			values = request.Header.Values(p.Name)
+		case "cookie":
+			if cookie, err := (&http.Request{Header: request.Header}).Cookie(p.Name); err == nil {
+				values = []string{cookie.Value}
+			}
+		default:
+			continue
+		}
+
+		in := "request." + p.In
+		if len(values) == 0 {
+			if p.Required {
+				c.report(hachibi.ViolationParameter, in, p.Name, "required parameter is missing")
+			}
+			continue
+		}
+
+		if p.Schema == nil {
+			continue
+		}
+
+		value, ok := c.parameterValue(p.Schema, values)
+		if !ok {
+			c.report(hachibi.ViolationParameter, in, p.Name, "%q isn't %s", strings.Join(values, ","), strings.Join(c.resolve(p.Schema).Type, " or "))
+			continue
+		}
+
+		for _, violation := range c.schemaViolations(p.Schema, value, "") {
+			c.report(hachibi.ViolationParameter, in, p.Name, "%s", violation.Message)
+		}
+	}
+}
+
+// parameterValue converts the text of a parameter to the JSON value its schema describes, an array takes all the
+// values of a repeated parameter, or the comma separated ones of a single value.
+func (c *check) parameterValue(schema *Schema, values []string) (any, bool) {
+	schema = c.resolve(schema)
+
+	if schema.Type.Has("array") {
+		if len(values) == 1 {
+			values = strings.Split(values[0], ",")
+		}
+
+		items := make([]any, 0, len(values))
+		for _, text := range values {
+			if schema.Items == nil {
+				items = append(items, text)
+				continue
+			}
+
+			value, ok := c.parameterValue(schema.Items, []string{text})
+			if !ok {
+				return nil, false
+			}
+			items = append(items, value)
+		}
+
+		return items, true
+	}
+
+	text := values[0]
+	switch {
+	case schema.Type.Has("string") || len(schema.Type) == 0:
+		return text, true
+	case schema.Type.Has("integer") || schema.Type.Has("number"):
+		if _, err := strconv.ParseFloat(text, 64); err != nil {
+			return nil, false
+		}
+		return json.Number(text), true
+	case schema.Type.Has("boolean"):
+		b, err := strconv.ParseBool(text)
+		if err != nil || (text != "true" && text != "false") {
+			return nil, false
+		}
+		return b, true
+	case schema.Type.Has("null") && text == "":
+		return nil, true
+	default:
+		return text, true
+	}
+}
+
+func (c *check) requestBody(body *RequestBody, request *hachibi.Payload) {
+	if body == nil {
+		return
+	}
+
+	if len(request.Body) == 0 && request.Multipart == nil {
+		if body.Required {
+			c.report(hachibi.ViolationSchema, "request.body", "", "required request body is missing")
+		}
+		return
+	}
+
+	c.body("request", body.Content, request)
+}
+
+func (c *check) response(op *Operation, status int, response *hachibi.Payload) {
+	code := strconv.Itoa(status)
+
+	r, ok := op.Responses[code]
+	if !ok {
+		r, ok = op.Responses[code[:1]+"XX"]
+	}
+	if !ok {
+		r, ok = op.Responses[code[:1]+"xx"]
+	}
+	if !ok {
+		r, ok = op.Responses["default"]
+	}
+	if !ok {
+		c.report(hachibi.ViolationUnknownStatus, "response.status", "", "%d isn't a response of the operation", status)
+		return
+	}
+
+	if r == nil || len(r.Content) == 0 || (len(response.Body) == 0 && response.Multipart == nil) {
+		return
+	}
+
+	c.body("response", r.Content, response)
+}
+
+// body checks the content type of p and its JSON value.
+func (c *check) body(part string, content map[string]*MediaType, p *hachibi.Payload) {
+	in := part + ".body"
+
+	contentType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+	media, ok := mediaType(content, contentType)
+	if !ok {
+		if contentType == "" {
+			contentType = "no content type"
+		}
+		c.report(hachibi.ViolationUnknownContentType, in, "", "%s isn't a media type of the %s", contentType, part)
+		return
+	}
+
+	if media == nil || media.Schema == nil || p.Truncated || p.Multipart != nil || !strings.Contains(contentType, "json") {
+		return
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(p.Body))
+	decoder.UseNumber()
+
+	var value any
+	if err := decoder.Decode(&value); err != nil || decoder.More() {
+		c.report(hachibi.ViolationSchema, in, "", "the body isn't valid JSON")
+		return
+	}
+
+	for _, violation := range c.schemaViolations(media.Schema, value, "") {
+		violation.In = in
+		c.violations = append(c.violations, violation)
+	}
+}
+
+// mediaType returns the media type of contentType: the exact one, then its range, e.g. application/*, then */*.
+func mediaType(content map[string]*MediaType, contentType string) (*MediaType, bool) {
+	ranges := make(map[string]*MediaType, len(content))
+	for key, media := range content {
+		name, _, err := mime.ParseMediaType(key)
+		if err != nil {
+			name = strings.ToLower(key)
+		}
+		ranges[name] = media
+	}
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	if media, ok := ranges[contentType]; ok {
+		return media, true
+	}
+
+	if typ, _, ok := strings.Cut(contentType, "/"); ok {
+		if media, ok := ranges[typ+"/*"]; ok {
+			return media, true
+		}
+	}
+
+	media, ok := ranges["*/*"]
+	return media, ok
+}
+
+// resolve follows the reference of schema to the components, a schema which can't be resolved accepts any value.
+func (c *check) resolve(schema *Schema) *Schema {
+	for i := 0; schema.Ref != "" && i < maxRefs; i++ {
+		name, ok := strings.CutPrefix(schema.Ref, "#/components/schemas/")
+		if !ok || c.validator.doc.Components == nil || c.validator.doc.Components.Schemas[unescapePointer(name)] == nil {
+			return &Schema{}
+		}
+
+		schema = c.validator.doc.Components.Schemas[unescapePointer(name)]
+	}
+
+	if schema.Ref != "" {
+		return &Schema{}
+	}
+
+	return schema
+}
+
+// schemaViolations returns the differences between value and schema, pointer is the JSON pointer of value.
+func (c *check) schemaViolations(schema *Schema, value any, pointer string) []hachibi.Violation {
+	violations := make([]hachibi.Violation, 0)
+	report := func(pointer string, format string, args ...any) {
+		violations = append(violations, hachibi.Violation{Kind: hachibi.ViolationSchema, Pointer: pointer, Message: fmt.Sprintf(format, args...)})
+	}
+
+	schema = c.resolve(schema)
+
+	typ := jsonType(value)
+	if len(schema.Type) > 0 && !schema.Type.Has(typ) &&
+		!(typ == "integer" && schema.Type.Has("number")) && !(typ == "null" && schema.Nullable) {
+		report(pointer, "expected %s, got %s", strings.Join(schema.Type, " or "), typ)
+		return violations
+	}
+
+	if value == nil && (schema.Nullable || schema.Type.Has("null")) && len(schema.Enum) == 0 {
+		return violations
+	}
+
+	if len(schema.Enum) > 0 && !enumHas(schema.Enum, value) {
+		report(pointer, "%s isn't one of %s", display(value), display(schema.Enum))
+	}
+
+	if s, ok := value.(string); ok && schema.Format != "" {
+		for _, f := range formats {
+			if f.name == schema.Format && !f.match(s) {
+				report(pointer, "%q isn't a valid %s", s, schema.Format)
+			}
+		}
+	}
+
+	switch value := value.(type) {
+	case map[string]any:
+		for _, name := range schema.Required {
+			if _, ok := value[name]; !ok {
+				report(pointer+"/"+escapePointer(name), "required property is missing")
+			}
+		}
+
+		names := make([]string, 0, len(schema.Properties))
+		for name := range schema.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if x, ok := value[name]; ok {
+				violations = append(violations, c.schemaViolations(schema.Properties[name], x, pointer+"/"+escapePointer(name))...)
+			}
+		}
+	case []any:
+		if schema.Items != nil {
+			for i, x := range value {
+				violations = append(violations, c.schemaViolations(schema.Items, x, pointer+"/"+strconv.Itoa(i))...)
+			}
+		}
+	}
+
+	for _, s := range schema.AllOf {
+		violations = append(violations, c.schemaViolations(s, value, pointer)...)
+	}
+
+	if len(schema.AnyOf) > 0 {
+		matched := false
+		for _, s := range schema.AnyOf {
+			matched = matched || len(c.schemaViolations(s, value, pointer)) == 0
+		}
+		if !matched {
+			report(pointer, "doesn't match any schema of anyOf")
+		}
+	}
+
+	if len(schema.OneOf) > 0 {
+		matched := 0
+		for _, s := range schema.OneOf {
+			if len(c.schemaViolations(s, value, pointer)) == 0 {
+				matched++
+			}
+		}
+		if matched != 1 {
+			report(pointer, "matches %d schemas of oneOf, expected exactly one", matched)
+		}
+	}
+
+	return violations
+}
+
+// jsonType is the JSON schema type of a value decoded from JSON, a number without fraction is an integer.
+func jsonType(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if f, err := value.Float64(); err == nil && f == math.Trunc(f) && !math.IsInf(f, 0) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return reflect.TypeOf(value).String()
+	}
+}
+
+// enumHas reports whether value is in enum, the numbers are compared by value as the document and the body decode
+// them to different types.
+func enumHas(enum []any, value any) bool {
+	for _, x := range enum {
+		a, aNumber := number(x)
+		b, bNumber := number(value)
+		if (aNumber && bNumber && a == b) || (!aNumber && !bNumber && reflect.DeepEqual(x, value)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func number(v any) (float64, bool) {
+	switch v := v.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+func display(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+
+	return string(b)
+}
+
+// escapePointer escapes a property name for a JSON pointer, see RFC 6901.
+func escapePointer(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
+
+func unescapePointer(name string) string {
+	return strings.NewReplacer("~1", "/", "~0", "~").Replace(name)
+}
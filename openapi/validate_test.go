@@ -0,0 +1,223 @@
+package openapi_test
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mtfiqh/hachibi"
+	"github.com/mtfiqh/hachibi/openapi"
+	"github.com/pkg/errors"
+)
+
+const contract = `
+openapi: 3.0.3
+info: {title: Users, version: 1.0.0}
+servers:
+  - url: https://api.example.com/v1
+paths:
+  /users/me:
+    get:
+      responses:
+        '200':
+          description: OK
+          content:
+            application/json:
+              schema: {$ref: '#/components/schemas/User'}
+  /users/{id}:
+    parameters:
+      - {name: id, in: path, required: true, schema: {type: integer}}
+    get:
+      parameters:
+        - {name: expand, in: query, schema: {type: array, items: {type: string, enum: [orders, roles]}}}
+        - {name: X-Tenant, in: header, required: true, schema: {type: string}}
+      responses:
+        '200':
+          description: OK
+          content:
+            application/json:
+              schema: {$ref: '#/components/schemas/User'}
+        4XX:
+          description: Error
+          content:
+            application/*:
+              schema:
+                type: object
+                required: [message]
+  /users:
+    post:
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema:
+              allOf:
+                - {$ref: '#/components/schemas/NewUser'}
+                - {type: object, required: [password]}
+      responses:
+        '201': {description: Created}
+components:
+  schemas:
+    NewUser:
+      type: object
+      required: [name]
+      properties:
+        name: {type: string}
+        email: {type: string, format: email}
+    User:
+      type: object
+      required: [id, name, status]
+      properties:
+        id: {type: integer}
+        name: {type: string}
+        status: {type: string, enum: [active, banned]}
+        nickname: {type: string, nullable: true}
+        score: {type: number}
+        a/b: {type: boolean}
+        tags:
+          type: array
+          items: {type: string}
+        owner:
+          oneOf:
+            - {type: string}
+            - {type: integer}
+`
+
+func TestValidator(t *testing.T) {
+	doc, err := openapi.Load(strings.NewReader(contract))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validator := openapi.NewValidator(doc)
+
+	tenant := func(httpData *hachibi.HttpData) *hachibi.HttpData {
+		httpData.Request.Header.Set("X-Tenant", "acme")
+		return httpData
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		for _, httpData := range []*hachibi.HttpData{
+			tenant(capture(http.MethodGet, "https://api.example.com/v1/users/1?expand=orders&expand=roles", 200, "", `{"id":1,"name":"Taufiq","status":"active","nickname":null,"score":2,"tags":["a"],"owner":"acme"}`)),
+			tenant(capture(http.MethodGet, "https://api.example.com/v1/users/99", 404, "", `{"message":"not found"}`)),
+			capture(http.MethodGet, "https://api.example.com/v1/users/me", 200, "", `{"id":1.0,"name":"Taufiq","status":"banned"}`),
+			capture(http.MethodPost, "https://api.example.com/v1/users", 201, `{"name":"Taufiq","password":"secret"}`, ""),
+			capture(http.MethodConnect, "api.example.com:443", 200, "", ""),
+		} {
+			if violations := validator.Validate(httpData); len(violations) != 0 {
+				t.Errorf("%s %s: unexpected violations %v", httpData.Method, httpData.URL, violations)
+			}
+		}
+	})
+
+	t.Run("routing", func(t *testing.T) {
+		for _, c := range []struct {
+			httpData *hachibi.HttpData
+			want     string
+		}{
+			{httpData: capture(http.MethodGet, "https://api.example.com/v1/orders", 200, "", ""), want: "unknown path in request.path: no path of the contract matches /orders"},
+			{httpData: capture(http.MethodDelete, "https://api.example.com/v1/users", 204, "", ""), want: "unknown operation in request.method: DELETE isn't an operation of /users"},
+			{httpData: capture(http.MethodPost, "https://api.example.com/v1/users", 500, `{"name":"Taufiq","password":"secret"}`, ""), want: "unknown status in response.status: 500 isn't a response of the operation"},
+		} {
+			violations := validator.Validate(c.httpData)
+			if len(violations) != 1 || violations[0].String() != c.want {
+				t.Errorf("%s %s: got %v, want %s", c.httpData.Method, c.httpData.URL, violations, c.want)
+			}
+		}
+	})
+
+	t.Run("parameters", func(t *testing.T) {
+		violations := validator.Validate(capture(http.MethodGet, "https://api.example.com/v1/users/abc?expand=orders,payments", 404, "", `{"message":"not found"}`))
+
+		want := []string{
+			`invalid parameter in request.path at id: "abc" isn't integer`,
+			`invalid parameter in request.query at expand: "payments" isn't one of ["orders","roles"]`,
+			`invalid parameter in request.header at X-Tenant: required parameter is missing`,
+		}
+		if len(violations) != len(want) {
+			t.Fatalf("unexpected violations %v", violations)
+		}
+		for i, v := range violations {
+			if v.String() != want[i] {
+				t.Errorf("got %s, want %s", v, want[i])
+			}
+		}
+	})
+
+	t.Run("schemas", func(t *testing.T) {
+		response := tenant(capture(http.MethodGet, "https://api.example.com/v1/users/1", 200, "", `{"id":"1","name":"Taufiq","status":"deleted","a/b":"yes","tags":["a",2],"owner":true}`))
+		violations := validator.Validate(response)
+
+		want := map[string]string{
+			"/a~1b":   "expected boolean, got string",
+			"/id":     "expected integer, got string",
+			"/owner":  "matches 0 schemas of oneOf, expected exactly one",
+			"/status": `"deleted" isn't one of ["active","banned"]`,
+			"/tags/1": "expected string, got integer",
+		}
+		if len(violations) != len(want) {
+			t.Fatalf("unexpected violations %v", violations)
+		}
+		for _, v := range violations {
+			if v.Kind != hachibi.ViolationSchema || v.In != "response.body" || want[v.Pointer] != v.Message {
+				t.Errorf("unexpected violation %+v", v)
+			}
+		}
+
+		request := capture(http.MethodPost, "https://api.example.com/v1/users", 201, `{"email":"taufiq"}`, "")
+		violations = validator.Validate(request)
+		if len(violations) != 3 || violations[0].Pointer != "/name" || violations[1].Message != `"taufiq" isn't a valid email` || violations[2].Pointer != "/password" {
+			t.Fatalf("unexpected violations %v", violations)
+		}
+
+		missing := capture(http.MethodPost, "https://api.example.com/v1/users", 201, "", "")
+		if violations := validator.Validate(missing); len(violations) != 1 || violations[0].Message != "required request body is missing" {
+			t.Fatalf("unexpected violations %v", violations)
+		}
+	})
+
+	t.Run("content types", func(t *testing.T) {
+		text := capture(http.MethodGet, "https://api.example.com/v1/users/me", 200, "", "hello")
+		text.Response.Header.Set("Content-Type", "text/plain")
+		if violations := validator.Validate(text); len(violations) != 1 || violations[0].Kind != hachibi.ViolationUnknownContentType {
+			t.Fatalf("unexpected violations %v", violations)
+		}
+
+		truncated := capture(http.MethodGet, "https://api.example.com/v1/users/me", 200, "", `{"id":`)
+		truncated.Response.Truncated = true
+		if violations := validator.Validate(truncated); len(violations) != 0 {
+			t.Fatalf("a truncated body must not be checked %v", violations)
+		}
+
+		problem := tenant(capture(http.MethodGet, "https://api.example.com/v1/users/1", 422, "", `{}`))
+		problem.Response.Header.Set("Content-Type", "application/problem+json")
+		if violations := validator.Validate(problem); len(violations) != 1 || violations[0].Pointer != "/message" {
+			t.Fatalf("unexpected violations %v", violations)
+		}
+	})
+
+	t.Run("modes", func(t *testing.T) {
+		counter := new(expvar.Map).Init()
+
+		warned := capture(http.MethodGet, "https://api.example.com/v1/orders", 200, "", "")
+		if err := openapi.NewValidator(doc, openapi.ValidatorWithCounter(counter)).PreProcess(context.Background(), warned); err != nil || len(warned.Violations) != 1 {
+			t.Fatalf("unexpected result %v %v", err, warned.Violations)
+		}
+
+		failed := capture(http.MethodGet, "https://api.example.com/v1/orders", 200, "", "")
+		err := openapi.NewValidator(doc, openapi.ValidatorWithMode(openapi.ModeFail), openapi.ValidatorWithCounter(counter)).Process(context.Background(), failed)
+		if !errors.Is(err, openapi.ErrContractViolation) || len(failed.Violations) != 1 {
+			t.Fatalf("unexpected result %v %v", err, failed.Violations)
+		}
+
+		if got := counter.Get(hachibi.ViolationUnknownPath); got == nil || got.String() != "2" {
+			t.Fatalf("unexpected counter %v", got)
+		}
+
+		if expvar.Get("hachibi_contract_violations") != nil {
+			t.Fatal("publishing the counter is left to the caller")
+		}
+	})
+}
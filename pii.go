@@ -0,0 +1,567 @@
+package hachibi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	PIIEmail      = "email"
+	PIIPhone      = "phone"
+	PIICreditCard = "credit card"
+	PIINationalID = "national id"
+	PIIIBAN       = "iban"
+	PIIJWT        = "jwt"
+	PIIAPIKey     = "api key"
+)
+
+// DetectedPII is a kind of personal data or secret found in a capture, the value itself isn't kept.
+type DetectedPII struct {
+	// Kind is one of the PII constants, e.g. PIIEmail, or the kind of a pattern added with PIIWithPattern.
+	Kind string `json:"kind"`
+	// Location is where it was found, e.g. request.header.Authorization, request.query.email,
+	// response.body.user.phone or upstream.request.body.
+	Location string `json:"location"`
+	Masked   bool   `json:"masked,omitempty"`
+}
+
+// PIIMask returns the replacement of value, a kind of PII.
+type PIIMask func(kind string, value string) string
+
+// MaskPII replaces value with its kind, e.g. [email].
+func MaskPII(kind string, value string) string {
+	return "[" + kind + "]"
+}
+
+// MaskPIIKeepFormat replaces the letters of value with x or X and its digits with 9, the length and the separators
+// are kept so a masked field still has the type and the format of its contract: a masked card number in a JSON
+// number is still a number and a masked email still an email, e.g. for an openapi.Validator running later.
+func MaskPIIKeepFormat(kind string, value string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsDigit(r):
+			return '9'
+		case unicode.IsUpper(r):
+			return 'X'
+		case unicode.IsLetter(r):
+			return 'x'
+		default:
+			return r
+		}
+	}, value)
+}
+
+// piiPattern finds a kind of PII in a text, valid rejects the matches failing their checksum or structure.
+type piiPattern struct {
+	kind    string
+	pattern *regexp.Regexp
+	valid   func(match string) bool
+}
+
+// defaultPIIPatterns are tried in order, a text matched by a pattern isn't matched by the next ones, e.g. the digits
+// of a card aren't a phone number.
+var defaultPIIPatterns = []piiPattern{
+	{kind: PIIJWT, pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)},
+	{kind: PIIAPIKey, pattern: regexp.MustCompile(`\b(?:(?:sk|pk|rk)_(?:live|test)_[0-9A-Za-z]{16,}|AKIA[0-9A-Z]{16}|gh[pousr]_[0-9A-Za-z]{36,}|xox[abprs]-[0-9A-Za-z-]{10,}|AIza[0-9A-Za-z_-]{35}|glpat-[0-9A-Za-z_-]{20,})`)},
+	{kind: PIIIBAN, pattern: regexp.MustCompile(`\b[A-Z]{2}[0-9]{2}(?: ?[A-Z0-9]{4}){2,7}(?: ?[A-Z0-9]{1,4})?\b`), valid: validIBAN},
+	{kind: PIICreditCard, pattern: regexp.MustCompile(`\b(?:[0-9][ -]?){12,18}[0-9]\b`), valid: validCard},
+	{kind: PIINationalID, pattern: regexp.MustCompile(`\b[0-9]{16}\b`), valid: validNIK},
+	{kind: PIIEmail, pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}\b`)},
+	{kind: PIIPhone, pattern: regexp.MustCompile(`(?:\+[1-9][0-9]{0,2}|\b0)[ .-]?\(?[0-9]{2,4}\)?[ .-]?[0-9]{3,4}[ .-]?[0-9]{3,5}\b`), valid: validPhone},
+}
+
+// apiKeyName matches the names of the headers, parameters and fields holding a key, e.g. X-Api-Key or client_secret.
+var apiKeyName = regexp.MustCompile(`(?i)(^|[^a-z])(api[_-]?key|apikey|access[_-]?token|auth[_-]?token|secret[_-]?key|client[_-]?secret)$`)
+
+// minAPIKeyLength is the shortest value of a key field taken as a key, shorter ones are placeholders.
+const minAPIKeyLength = 16
+
+// nikProvinces are the province codes starting an Indonesian NIK.
+var nikProvinces = map[string]bool{
+	"11": true, "12": true, "13": true, "14": true, "15": true, "16": true, "17": true, "18": true, "19": true,
+	"21": true, "31": true, "32": true, "33": true, "34": true, "35": true, "36": true, "51": true, "52": true,
+	"53": true, "61": true, "62": true, "63": true, "64": true, "65": true, "71": true, "72": true, "73": true,
+	"74": true, "75": true, "76": true, "81": true, "82": true, "91": true, "92": true, "93": true, "94": true,
+	"95": true, "96": true, "97": true,
+}
+
+// PIIDetector finds personal data and secrets in the URL, the headers, the query parameters and the bodies of the
+// captures, including their upstream exchange, and tags the captures with what it found.
+// It finds emails, phone numbers, card numbers passing the Luhn check, Indonesian NIKs, IBANs passing their
+// checksum, JWTs, the API keys of well-known services and the values of key fields, e.g. X-Api-Key.
+// With a mask, it replaces what it found before the processor sees the capture.
+type PIIDetector struct {
+	patterns []piiPattern
+	apiKeys  bool
+	mask     PIIMask
+}
+
+type PIIOpt func(*PIIDetector)
+
+// PIIWithKinds only keeps the detection of kinds, e.g. PIIEmail and PIICreditCard.
+func PIIWithKinds(kinds ...string) PIIOpt {
+	return func(d *PIIDetector) {
+		patterns := make([]piiPattern, 0, len(d.patterns))
+		for _, p := range d.patterns {
+			if sliceHas(kinds, p.kind) {
+				patterns = append(patterns, p)
+			}
+		}
+
+		d.patterns = patterns
+		d.apiKeys = sliceHas(kinds, PIIAPIKey)
+	}
+}
+
+// PIIWithPattern detects the matches of pattern as kind, e.g. the national ids of another country.
+// The patterns added are tried before the default ones.
+func PIIWithPattern(kind string, pattern *regexp.Regexp) PIIOpt {
+	return func(d *PIIDetector) {
+		d.patterns = append([]piiPattern{{kind: kind, pattern: pattern}}, d.patterns...)
+	}
+}
+
+// PIIWithMask replaces what is found with mask, e.g. MaskPII or MaskPIIKeepFormat.
+// MaskPII changes the type and the format of the values, the validators and the drift detectors should run before
+// the detector, see ChainPreProcessors.
+func PIIWithMask(mask PIIMask) PIIOpt {
+	return func(d *PIIDetector) {
+		d.mask = mask
+	}
+}
+
+func NewPIIDetector(opts ...PIIOpt) *PIIDetector {
+	d := &PIIDetector{
+		patterns: append([]piiPattern{}, defaultPIIPatterns...),
+		apiKeys:  true,
+	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	return d
+}
+
+func (d *PIIDetector) PreProcess(ctx context.Context, httpData *HttpData) error {
+	found, err := d.detect("", httpData)
+	httpData.PII = append(httpData.PII, found...)
+
+	return err
+}
+
+func (d *PIIDetector) detect(prefix string, httpData *HttpData) ([]DetectedPII, error) {
+	found := make([]DetectedPII, 0)
+
+	if u, err := url.Parse(httpData.URL); err == nil {
+		path, pathFound := d.inspect(prefix+"request.path", "", u.Path)
+		query, queryFound := d.values(prefix+"request.query", u.Query())
+		found = append(append(found, pathFound...), queryFound...)
+
+		if d.mask != nil && (len(pathFound) > 0 || len(queryFound) > 0) {
+			if len(pathFound) > 0 {
+				u.Path, u.RawPath = path, ""
+			}
+			if len(queryFound) > 0 {
+				u.RawQuery = query.Encode()
+			}
+			httpData.URL = u.String()
+		}
+	}
+
+	errs := make(Error, 0)
+	for _, p := range []struct {
+		location string
+		payload  *Payload
+	}{{prefix + "request", &httpData.Request.Payload}, {prefix + "response", &httpData.Response.Payload}} {
+		payloadFound, err := d.payload(p.location, p.payload)
+		found = append(found, payloadFound...)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if httpData.Upstream != nil {
+		upstreamFound, err := d.detect(prefix+"upstream.", httpData.Upstream)
+		found = append(found, upstreamFound...)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return found, errs
+	}
+
+	return found, nil
+}
+
+func (d *PIIDetector) payload(location string, payload *Payload) ([]DetectedPII, error) {
+	found := make([]DetectedPII, 0)
+
+	// the header of a capture may be the one of the live response, it is replaced instead of changed
+	header := payload.Header.Clone()
+	for _, key := range sortedKeys(header) {
+		for i, value := range header[key] {
+			masked, headerFound := d.inspect(location+".header."+key, key, value)
+			found = append(found, headerFound...)
+			header[key][i] = masked
+		}
+	}
+	if d.mask != nil && len(found) > 0 {
+		payload.Header = header
+	}
+
+	if payload.Multipart != nil {
+		fields, fieldsFound := d.values(location+".multipart", payload.Multipart.Fields)
+		found = append(found, fieldsFound...)
+		if d.mask != nil {
+			payload.Multipart.Fields = fields
+		}
+	}
+
+	if len(payload.Body) == 0 {
+		return found, nil
+	}
+
+	body, bodyFound, err := d.body(location+".body", payload)
+	found = append(found, bodyFound...)
+	if err != nil {
+		return found, err
+	}
+
+	if d.mask != nil && len(bodyFound) > 0 {
+		payload.Body = body
+		if payload.Decoded != nil {
+			payload.Decoded = nil
+			payload.Decode()
+		}
+	}
+
+	return found, nil
+}
+
+// body returns the masked body of payload and what it contains: the strings of a JSON body, the values of a form or
+// the text of another body.
+func (d *PIIDetector) body(location string, payload *Payload) ([]byte, []DetectedPII, error) {
+	found := make([]DetectedPII, 0)
+
+	if looksLikeJSON(payload) {
+		// only the masked values are replaced, the rest of the body is kept byte for byte
+		body, err := replaceJSONValues(payload.Body, func(path []string, value string) (string, bool) {
+			name := ""
+			if len(path) > 0 {
+				name = path[len(path)-1]
+			}
+
+			masked, valueFound := d.inspect(strings.Join(append([]string{location}, path...), "."), name, value)
+			found = append(found, valueFound...)
+			return masked, d.mask != nil && len(valueFound) > 0
+		})
+		if err == nil {
+			return body, found, nil
+		}
+		found = found[:0]
+	}
+
+	if contentType, _, _ := mime.ParseMediaType(payload.Header.Get("Content-Type")); contentType == "application/x-www-form-urlencoded" {
+		if form, err := url.ParseQuery(string(payload.Body)); err == nil {
+			masked, formFound := d.values(strings.TrimSuffix(location, ".body")+".form", form)
+			return []byte(masked.Encode()), formFound, nil
+		}
+	}
+
+	if !utf8.Valid(payload.Body) {
+		return payload.Body, found, nil
+	}
+
+	masked, textFound := d.inspect(location, "", string(payload.Body))
+	return []byte(masked), textFound, nil
+}
+
+// jsonFrame is an object or an array being read by replaceJSONValues.
+type jsonFrame struct {
+	object    bool
+	key       string
+	index     int
+	expectKey bool
+}
+
+// replaceJSONValues calls replace with the path and the text of every string and number of the JSON document in
+// body, the values it replaces are written in place and the rest of body is kept as it is.
+// A number replaced by a text which isn't a number becomes a string.
+func replaceJSONValues(body []byte, replace func(path []string, value string) (string, bool)) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	out := new(bytes.Buffer)
+	last := int64(0)
+	stack := make([]*jsonFrame, 0)
+
+	path := func() []string {
+		p := make([]string, 0, len(stack))
+		for _, f := range stack {
+			if f.object {
+				p = append(p, f.key)
+			} else {
+				p = append(p, strconv.Itoa(f.index))
+			}
+		}
+		return p
+	}
+
+	for {
+		before := decoder.InputOffset()
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		end := decoder.InputOffset()
+
+		if n := len(stack); n > 0 && stack[n-1].expectKey {
+			if token == json.Delim('}') {
+				stack = stack[:n-1]
+			} else {
+				stack[n-1].key, _ = token.(string)
+				stack[n-1].expectKey = false
+				continue
+			}
+		} else if token == json.Delim(']') {
+			stack = stack[:n-1]
+		} else {
+			if n > 0 && !stack[n-1].object {
+				stack[n-1].index++
+			}
+
+			switch value := token.(type) {
+			case json.Delim:
+				stack = append(stack, &jsonFrame{object: value == '{', expectKey: value == '{', index: -1})
+				continue
+			case string, json.Number:
+				text, isNumber := "", false
+				if number, ok := value.(json.Number); ok {
+					text, isNumber = number.String(), true
+				} else {
+					text = value.(string)
+				}
+
+				if masked, ok := replace(path(), text); ok {
+					raw := body[before:end]
+					start := before + int64(bytes.IndexAny(raw, `"-0123456789`))
+					if !isNumber {
+						start = before + int64(bytes.IndexByte(raw, '"'))
+					}
+
+					out.Write(body[last:start])
+					out.Write(jsonValue(masked, isNumber))
+					last = end
+				}
+			}
+		}
+
+		// the value is complete, the object expects its next key
+		if n := len(stack); n > 0 {
+			stack[n-1].expectKey = stack[n-1].object
+			continue
+		}
+
+		out.Write(body[last:])
+		return out.Bytes(), nil
+	}
+}
+
+// jsonValue encodes s as a JSON string without escaping HTML, or as it is when it replaces a number and is one.
+func jsonValue(s string, number bool) []byte {
+	if number && json.Valid([]byte(s)) && strings.IndexAny(s[:1], "-0123456789") == 0 {
+		return []byte(s)
+	}
+
+	b := new(bytes.Buffer)
+	encoder := json.NewEncoder(b)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)
+
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n"))
+}
+
+// values inspects the values by name, e.g. of a query, and returns them masked.
+func (d *PIIDetector) values(location string, values map[string][]string) (url.Values, []DetectedPII) {
+	masked := make(url.Values, len(values))
+	found := make([]DetectedPII, 0)
+
+	for _, name := range sortedKeys(values) {
+		for _, value := range values[name] {
+			m, valueFound := d.inspect(location+"."+name, name, value)
+			masked[name] = append(masked[name], m)
+			found = append(found, valueFound...)
+		}
+	}
+
+	return masked, found
+}
+
+// piiMatch is a PII found in a text, from start to end.
+type piiMatch struct {
+	kind  string
+	start int
+	end   int
+}
+
+// inspect returns text masked and the kinds found in it, name is the name of its header, parameter or field.
+func (d *PIIDetector) inspect(location string, name string, text string) (string, []DetectedPII) {
+	matches := d.scan(text)
+
+	if len(matches) == 0 && d.apiKeys {
+		switch {
+		case strings.EqualFold(name, "Authorization"):
+			if scheme, token, ok := strings.Cut(text, " "); ok && strings.EqualFold(scheme, "Bearer") && len(token) >= minAPIKeyLength {
+				matches = append(matches, piiMatch{kind: PIIAPIKey, start: len(scheme) + 1, end: len(text)})
+			}
+		case apiKeyName.MatchString(name) && len(text) >= minAPIKeyLength:
+			matches = append(matches, piiMatch{kind: PIIAPIKey, start: 0, end: len(text)})
+		}
+	}
+
+	if len(matches) == 0 {
+		return text, nil
+	}
+
+	found := make([]DetectedPII, 0, len(matches))
+	for _, m := range matches {
+		pii := DetectedPII{Kind: m.kind, Location: location, Masked: d.mask != nil}
+		if !sliceHasPII(found, pii) {
+			found = append(found, pii)
+		}
+	}
+
+	if d.mask == nil {
+		return text, found
+	}
+
+	masked := new(strings.Builder)
+	last := 0
+	for _, m := range matches {
+		masked.WriteString(text[last:m.start])
+		masked.WriteString(d.mask(m.kind, text[m.start:m.end]))
+		last = m.end
+	}
+	masked.WriteString(text[last:])
+
+	return masked.String(), found
+}
+
+// scan returns the matches of the patterns in text, ordered and without overlap.
+func (d *PIIDetector) scan(text string) []piiMatch {
+	matches := make([]piiMatch, 0)
+
+	for _, p := range d.patterns {
+		for _, index := range p.pattern.FindAllStringIndex(text, -1) {
+			if p.valid != nil && !p.valid(text[index[0]:index[1]]) {
+				continue
+			}
+
+			overlaps := false
+			for _, m := range matches {
+				overlaps = overlaps || (index[0] < m.end && m.start < index[1])
+			}
+			if !overlaps {
+				matches = append(matches, piiMatch{kind: p.kind, start: index[0], end: index[1]})
+			}
+		}
+	}
+
+	sort.Slice(matches, func(a, b int) bool { return matches[a].start < matches[b].start })
+
+	return matches
+}
+
+func sliceHasPII(found []DetectedPII, pii DetectedPII) bool {
+	for _, f := range found {
+		if f == pii {
+			return true
+		}
+	}
+
+	return false
+}
+
+// digits removes the separators of a number, e.g. the spaces of a card number.
+func digits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' || r == '.' || r == '(' || r == ')' || r == '+' {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// validCard checks the length, the network prefix and the Luhn checksum of a card number.
+func validCard(s string) bool {
+	number := digits(s)
+	if len(number) < 13 || len(number) > 19 || number[0] < '2' || number[0] > '6' {
+		return false
+	}
+
+	sum := 0
+	for i := 0; i < len(number); i++ {
+		n := int(number[len(number)-1-i] - '0')
+		if i%2 == 1 {
+			n *= 2
+			if n > 9 {
+				n -= 9
+			}
+		}
+		sum += n
+	}
+
+	return sum%10 == 0
+}
+
+// validNIK checks the structure of an Indonesian NIK: the province code, the birth date, with 40 added to the day
+// of women, and a serial number. The NIKs passing the Luhn check are found as card numbers.
+func validNIK(s string) bool {
+	day, _ := strconv.Atoi(s[6:8])
+	month, _ := strconv.Atoi(s[8:10])
+	if day > 40 {
+		day -= 40
+	}
+
+	return nikProvinces[s[0:2]] && day >= 1 && day <= 31 && month >= 1 && month <= 12 && s[12:] != "0000"
+}
+
+// validIBAN checks the length and the mod 97 checksum of an IBAN.
+func validIBAN(s string) bool {
+	iban := digits(s)
+	if len(iban) < 15 || len(iban) > 34 {
+		return false
+	}
+
+	remainder := 0
+	for _, r := range iban[4:] + iban[:4] {
+		switch {
+		case r >= '0' && r <= '9':
+			remainder = (remainder*10 + int(r-'0')) % 97
+		case r >= 'A' && r <= 'Z':
+			remainder = (remainder*100 + int(r-'A') + 10) % 97
+		default:
+			return false
+		}
+	}
+
+	return remainder == 1
+}
+
+// validPhone checks the number of digits of a phone number, see E.164.
+func validPhone(s string) bool {
+	n := len(digits(s))
+	return n >= 9 && n <= 15
+}
@@ -0,0 +1,98 @@
+package hachibi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// ReverseProxy forwards the requests to an upstream and captures every exchange once: the Middleware records what the
+// client sent and received, and the Transport of the upstream leg links its own record in HttpData.Upstream.
+//
+//	m := hachibi.NewMiddleware(hachibi.MiddlewareWithProcessor(store))
+//	http.ListenAndServe(":8080", hachibi.NewReverseProxy(upstream, hachibi.ReverseProxyWithMiddleware(m)))
+type ReverseProxy struct {
+	target        *url.URL
+	middleware    *Middleware
+	preProcessor  PreProcessor
+	transportOpts []TransportOpt
+
+	handler http.HandlerFunc
+}
+
+type ReverseProxyOpt func(*ReverseProxy)
+
+// ReverseProxyWithMiddleware captures the downstream leg with m, its processor receives the linked records.
+func ReverseProxyWithMiddleware(m *Middleware) ReverseProxyOpt {
+	return func(p *ReverseProxy) {
+		p.middleware = m
+	}
+}
+
+func ReverseProxyWithPreProcessor(preProcessor PreProcessor) ReverseProxyOpt {
+	return func(p *ReverseProxy) {
+		p.preProcessor = preProcessor
+	}
+}
+
+// ReverseProxyWithTransport configures the Transport of the upstream leg, e.g. TransportWithRoundTripper.
+// Its processor is always the one linking the upstream record.
+func ReverseProxyWithTransport(opts ...TransportOpt) ReverseProxyOpt {
+	return func(p *ReverseProxy) {
+		p.transportOpts = append(p.transportOpts, opts...)
+	}
+}
+
+func NewReverseProxy(target *url.URL, opts ...ReverseProxyOpt) *ReverseProxy {
+	p := &ReverseProxy{target: target}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	if p.middleware == nil {
+		p.middleware = NewMiddleware()
+	}
+
+	transport := NewTransport(append(p.transportOpts, TransportWithProcessor(upstreamLinker{}))...)
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			r.SetXForwarded()
+		},
+		Transport:    transport,
+		ErrorHandler: proxyError,
+	}
+
+	p.handler = p.middleware.Middleware(p.middleware.PreProcessMiddleware(p.preProcessor)(proxy.ServeHTTP))
+	return p
+}
+
+func (p *ReverseProxy) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	p.handler(writer, request)
+}
+
+// proxyError answers 502 when the upstream can't be reached, the error is added to the downstream record.
+func proxyError(writer http.ResponseWriter, request *http.Request, err error) {
+	if httpData, ok := request.Context().Value(KeyHttpDataCtxMiddleware).(*HttpData); ok {
+		httpData.AppendError(errors.Wrap(err, "upstream"))
+	}
+
+	writer.WriteHeader(http.StatusBadGateway)
+}
+
+// upstreamLinker is the processor of the upstream leg, the downstream record is found in the request context.
+type upstreamLinker struct{}
+
+func (upstreamLinker) Process(ctx context.Context, httpData *HttpData) error {
+	downstream, ok := ctx.Value(KeyHttpDataCtxMiddleware).(*HttpData)
+	if !ok {
+		return errors.New("no downstream capture to link")
+	}
+
+	upstream := httpData.Clone()
+	downstream.Upstream = &upstream
+	return nil
+}
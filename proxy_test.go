@@ -0,0 +1,93 @@
+package hachibi_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mtfiqh/hachibi"
+)
+
+func TestReverseProxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, _ := io.ReadAll(request.Body)
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusCreated)
+		io.WriteString(writer, `{"path":"`+request.URL.Path+`","received":`+string(body)+`}`)
+	}))
+	defer upstream.Close()
+
+	target, _ := url.Parse(upstream.URL + "/api")
+	recorder := hachibi.NewMemoryRecorder(0)
+	m := hachibi.NewMiddleware(hachibi.MiddlewareWithProcessor(recorder))
+	proxy := httptest.NewServer(hachibi.NewReverseProxy(target, hachibi.ReverseProxyWithMiddleware(m), hachibi.ReverseProxyWithPreProcessor(eventSetter("proxied"))))
+	defer proxy.Close()
+
+	res, err := http.Post(proxy.URL+"/users?page=1", "application/json", strings.NewReader(`{"name":"taufiq"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated || string(body) != `{"path":"/api/users","received":{"name":"taufiq"}}` {
+		t.Fatalf("unexpected response %d %s", res.StatusCode, body)
+	}
+
+	records, _ := query(t, recorder, hachibi.Filter{})
+	if len(records) != 1 {
+		t.Fatalf("expected one record per exchange, got %d", len(records))
+	}
+
+	r := records[0]
+	if r.URL != "/users?page=1" || r.StatusCode != http.StatusCreated || string(r.Request.Body) != `{"name":"taufiq"}` || string(r.Response.Body) != string(body) || r.Event != "proxied" {
+		t.Fatalf("unexpected downstream record %+v", r)
+	}
+
+	u := r.Upstream
+	if u == nil || u.URL != upstream.URL+"/api/users?page=1" || u.StatusCode != http.StatusCreated || u.ID == r.ID {
+		t.Fatalf("unexpected upstream record %+v", u)
+	}
+
+	if u.Request.Header.Get("X-Forwarded-For") == "" || string(u.Request.Body) != `{"name":"taufiq"}` || string(u.Response.Body) != string(body) {
+		t.Fatalf("unexpected upstream payloads %+v", u)
+	}
+
+	t.Run("upstream down", func(t *testing.T) {
+		l, _ := net.Listen("tcp", "127.0.0.1:0")
+		down, _ := url.Parse("http://" + l.Addr().String())
+		l.Close()
+
+		recorder := hachibi.NewMemoryRecorder(0)
+		m := hachibi.NewMiddleware(hachibi.MiddlewareWithProcessor(recorder))
+		proxy := httptest.NewServer(hachibi.NewReverseProxy(down, hachibi.ReverseProxyWithMiddleware(m)))
+		defer proxy.Close()
+
+		res, err := http.Get(proxy.URL + "/health")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		records, _ := query(t, recorder, hachibi.Filter{})
+		if res.StatusCode != http.StatusBadGateway || len(records) != 1 || records[0].StatusCode != http.StatusBadGateway {
+			t.Fatalf("unexpected response %d %+v", res.StatusCode, records)
+		}
+
+		if !strings.HasPrefix(records[0].Error.Error(), "[upstream: ") || records[0].Upstream == nil || len(records[0].Upstream.Error) == 0 {
+			t.Fatalf("unexpected errors %+v", records[0])
+		}
+	})
+}
+
+type eventSetter string
+
+func (e eventSetter) PreProcess(ctx context.Context, httpData *hachibi.HttpData) error {
+	httpData.Event = string(e)
+	return nil
+}
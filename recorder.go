@@ -0,0 +1,54 @@
+package hachibi
+
+import (
+	"context"
+	"sync"
+)
+
+const DefaultMemoryRecorderCapacity = 1000
+
+// MemoryRecorder is a Processor and a Store keeping the last captures in memory, e.g. to browse them with NewUI.
+type MemoryRecorder struct {
+	mu       sync.RWMutex
+	records  []HttpData
+	next     int
+	capacity int
+}
+
+// NewMemoryRecorder keeps up to capacity captures, DefaultMemoryRecorderCapacity when it's not positive.
+func NewMemoryRecorder(capacity int) *MemoryRecorder {
+	if capacity <= 0 {
+		capacity = DefaultMemoryRecorderCapacity
+	}
+
+	return &MemoryRecorder{capacity: capacity}
+}
+
+func (m *MemoryRecorder) Process(ctx context.Context, httpData *HttpData) error {
+	record := httpData.Clone()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.records) < m.capacity {
+		m.records = append(m.records, record)
+		return nil
+	}
+
+	m.records[m.next] = record
+	m.next = (m.next + 1) % m.capacity
+	return nil
+}
+
+func (m *MemoryRecorder) Query(ctx context.Context, filter Filter) (Iterator[HttpData], error) {
+	m.mu.RLock()
+	records := make([]HttpData, 0)
+	for i := range m.records {
+		if filter.Match(&m.records[i]) {
+			records = append(records, m.records[i])
+		}
+	}
+	m.mu.RUnlock()
+
+	return newSliceIterator(records, filter)
+}
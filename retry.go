@@ -0,0 +1,275 @@
+package hachibi
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultRetryMaxAttempts = 3
+	DefaultRetryBaseDelay   = 100 * time.Millisecond
+	DefaultRetryMaxDelay    = 5 * time.Second
+	DefaultRetryJitter      = 0.2
+)
+
+type KeyCtxRetry int
+
+const (
+	KeyRetryHistoryCtx = KeyCtxRetry(0)
+)
+
+// RetryAttempt is one failed call of the wrapped processor.
+type RetryAttempt struct {
+	Attempt int           `json:"attempt"`
+	Error   string        `json:"error"`
+	Delay   time.Duration `json:"delay"`
+	At      time.Time     `json:"at"`
+
+	err error
+}
+
+func (a RetryAttempt) Unwrap() error {
+	return a.err
+}
+
+// RetryError is returned when the wrapped processor did not succeed,
+// either because the attempts are exhausted or the error is permanent.
+type RetryError struct {
+	Attempts  []RetryAttempt
+	Permanent bool
+}
+
+func (e *RetryError) Error() string {
+	reason := "retries exhausted"
+	if e.Permanent {
+		reason = "permanent error"
+	}
+
+	msgs := make([]string, 0, len(e.Attempts))
+	for _, a := range e.Attempts {
+		msgs = append(msgs, fmt.Sprintf("#%d: %s", a.Attempt, a.Error))
+	}
+
+	return fmt.Sprintf("%s after %d attempt(s) [%s]", reason, len(e.Attempts), strings.Join(msgs, ", "))
+}
+
+func (e *RetryError) Unwrap() error {
+	if len(e.Attempts) == 0 {
+		return nil
+	}
+
+	return e.Attempts[len(e.Attempts)-1].err
+}
+
+type permanentError struct {
+	err error
+}
+
+func (p permanentError) Error() string {
+	return p.err.Error()
+}
+
+func (p permanentError) Unwrap() error {
+	return p.err
+}
+
+// Permanent marks err as not retryable, the retry processor stops right away.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return permanentError{err: err}
+}
+
+// IsPermanent reports whether err, or any error it wraps, was marked with Permanent.
+func IsPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
+
+// RetryHistoryFromCtx returns the failed attempts when called from the dead letter processor.
+func RetryHistoryFromCtx(ctx context.Context) []RetryAttempt {
+	history, ok := ctx.Value(KeyRetryHistoryCtx).([]RetryAttempt)
+	if !ok {
+		return nil
+	}
+
+	return history
+}
+
+type RetryProcessor struct {
+	processor  Processor
+	deadLetter Processor
+
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	jitter      float64
+	retryable   func(err error) bool
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+type RetryOpt func(*RetryProcessor)
+
+// RetryWithMaxAttempts sets how many times the processor is called in total, including the first call.
+func RetryWithMaxAttempts(n int) RetryOpt {
+	return func(r *RetryProcessor) {
+		if n < 1 {
+			n = 1
+		}
+		r.maxAttempts = n
+	}
+}
+
+// RetryWithBackoff sets the exponential backoff, the delay doubles from base and is capped at max.
+func RetryWithBackoff(base, max time.Duration) RetryOpt {
+	return func(r *RetryProcessor) {
+		r.baseDelay = base
+		r.maxDelay = max
+	}
+}
+
+// RetryWithJitter sets the fraction (0..1) of the delay that is randomized.
+func RetryWithJitter(fraction float64) RetryOpt {
+	return func(r *RetryProcessor) {
+		if fraction < 0 {
+			fraction = 0
+		}
+		if fraction > 1 {
+			fraction = 1
+		}
+		r.jitter = fraction
+	}
+}
+
+// RetryWithClassifier decides which errors are worth another attempt,
+// errors marked with Permanent are never retried.
+func RetryWithClassifier(retryable func(err error) bool) RetryOpt {
+	return func(r *RetryProcessor) {
+		r.retryable = retryable
+	}
+}
+
+// RetryWithDeadLetter sets the processor receiving records that did not make it,
+// the failure history is available through RetryHistoryFromCtx.
+func RetryWithDeadLetter(deadLetter Processor) RetryOpt {
+	return func(r *RetryProcessor) {
+		r.deadLetter = deadLetter
+	}
+}
+
+func RetryWithRandSeed(seed int64) RetryOpt {
+	return func(r *RetryProcessor) {
+		r.rand = rand.New(rand.NewSource(seed))
+	}
+}
+
+func NewRetryProcessor(processor Processor, opts ...RetryOpt) *RetryProcessor {
+	r := &RetryProcessor{
+		processor:   processor,
+		maxAttempts: DefaultRetryMaxAttempts,
+		baseDelay:   DefaultRetryBaseDelay,
+		maxDelay:    DefaultRetryMaxDelay,
+		jitter:      DefaultRetryJitter,
+		retryable:   func(err error) bool { return true },
+		rand:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+func (r *RetryProcessor) Process(ctx context.Context, httpData *HttpData) error {
+	attempts := make([]RetryAttempt, 0, r.maxAttempts)
+	permanent := false
+
+	for i := 1; i <= r.maxAttempts; i++ {
+		err := r.processor.Process(ctx, httpData)
+		if err == nil {
+			return nil
+		}
+
+		attempt := RetryAttempt{Attempt: i, Error: err.Error(), At: time.Now().Local(), err: err}
+
+		if IsPermanent(err) || !r.retryable(err) {
+			attempts = append(attempts, attempt)
+			permanent = true
+			break
+		}
+
+		if i == r.maxAttempts {
+			attempts = append(attempts, attempt)
+			break
+		}
+
+		attempt.Delay = r.delay(i)
+		attempts = append(attempts, attempt)
+
+		if err := sleepCtx(ctx, attempt.Delay); err != nil {
+			attempts = append(attempts, RetryAttempt{Attempt: i + 1, Error: err.Error(), At: time.Now().Local(), err: err})
+			break
+		}
+	}
+
+	retryErr := &RetryError{Attempts: attempts, Permanent: permanent}
+
+	if r.deadLetter != nil {
+		dlCtx := context.WithValue(ctx, KeyRetryHistoryCtx, attempts)
+		if err := r.deadLetter.Process(dlCtx, httpData); err != nil {
+			return errors.Wrapf(retryErr, "dead letter error: %s", err.Error())
+		}
+	}
+
+	return retryErr
+}
+
+func (r *RetryProcessor) delay(attempt int) time.Duration {
+	d := r.baseDelay
+	for i := 1; i < attempt && d < r.maxDelay; i++ {
+		d *= 2
+	}
+
+	if r.jitter > 0 && d > 0 {
+		r.mu.Lock()
+		f := r.rand.Float64()
+		r.mu.Unlock()
+
+		// spread the delay in [d*(1-jitter), d*(1+jitter)]
+		d = time.Duration(float64(d) * (1 - r.jitter + 2*r.jitter*f))
+	}
+
+	// the clamp comes after the jitter, so that maxDelay is a bound
+	if r.maxDelay > 0 && d > r.maxDelay {
+		d = r.maxDelay
+	}
+
+	return d
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
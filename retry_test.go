@@ -0,0 +1,155 @@
+package hachibi_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mtfiqh/hachibi"
+)
+
+type flakyProcessor struct {
+	calls   int
+	failFor int
+	err     error
+}
+
+func (f *flakyProcessor) Process(ctx context.Context, httpData *hachibi.HttpData) error {
+	f.calls++
+	if f.calls <= f.failFor {
+		return f.err
+	}
+
+	return nil
+}
+
+type deadLetter struct {
+	records []*hachibi.HttpData
+	history []hachibi.RetryAttempt
+}
+
+func (d *deadLetter) Process(ctx context.Context, httpData *hachibi.HttpData) error {
+	d.records = append(d.records, httpData)
+	d.history = hachibi.RetryHistoryFromCtx(ctx)
+	return nil
+}
+
+func TestRetryProcessor(t *testing.T) {
+	opts := []hachibi.RetryOpt{
+		hachibi.RetryWithBackoff(time.Millisecond, 4*time.Millisecond),
+		hachibi.RetryWithJitter(0),
+	}
+
+	t.Run("succeed after retry", func(t *testing.T) {
+		p := &flakyProcessor{failFor: 2, err: errors.New("db down")}
+		dl := &deadLetter{}
+		r := hachibi.NewRetryProcessor(p, append(opts, hachibi.RetryWithDeadLetter(dl))...)
+
+		if err := r.Process(context.Background(), &hachibi.HttpData{}); err != nil {
+			t.Fatal(err)
+		}
+
+		if p.calls != 3 {
+			t.Fatalf("expected 3 calls, got %d", p.calls)
+		}
+
+		if len(dl.records) != 0 {
+			t.Fatal("dead letter should not receive anything")
+		}
+	})
+
+	t.Run("exhausted goes to dead letter", func(t *testing.T) {
+		p := &flakyProcessor{failFor: 10, err: errors.New("db down")}
+		dl := &deadLetter{}
+		r := hachibi.NewRetryProcessor(p, append(opts, hachibi.RetryWithMaxAttempts(4), hachibi.RetryWithDeadLetter(dl))...)
+
+		httpData := &hachibi.HttpData{URL: "/users"}
+		err := r.Process(context.Background(), httpData)
+
+		var retryErr *hachibi.RetryError
+		if !errors.As(err, &retryErr) {
+			t.Fatalf("expected retry error, got %v", err)
+		}
+
+		if p.calls != 4 || len(retryErr.Attempts) != 4 || retryErr.Permanent {
+			t.Fatalf("unexpected result calls=%d err=%v", p.calls, retryErr)
+		}
+
+		if len(dl.records) != 1 || dl.records[0] != httpData {
+			t.Fatal("dead letter should receive the record")
+		}
+
+		if len(dl.history) != 4 || dl.history[1].Delay != 2*time.Millisecond {
+			t.Fatalf("unexpected history %+v", dl.history)
+		}
+	})
+
+	t.Run("permanent error is not retried", func(t *testing.T) {
+		p := &flakyProcessor{failFor: 10, err: hachibi.Permanent(errors.New("bad payload"))}
+		r := hachibi.NewRetryProcessor(p, opts...)
+
+		err := r.Process(context.Background(), &hachibi.HttpData{})
+
+		var retryErr *hachibi.RetryError
+		if !errors.As(err, &retryErr) || !retryErr.Permanent {
+			t.Fatalf("expected permanent retry error, got %v", err)
+		}
+
+		if p.calls != 1 {
+			t.Fatalf("expected 1 call, got %d", p.calls)
+		}
+	})
+
+	t.Run("classifier", func(t *testing.T) {
+		errValidation := errors.New("validation")
+		p := &flakyProcessor{failFor: 10, err: errValidation}
+		r := hachibi.NewRetryProcessor(p, append(opts, hachibi.RetryWithClassifier(func(err error) bool {
+			return !errors.Is(err, errValidation)
+		}))...)
+
+		if err := r.Process(context.Background(), &hachibi.HttpData{}); err == nil {
+			t.Fatal("expected error")
+		}
+
+		if p.calls != 1 {
+			t.Fatalf("expected 1 call, got %d", p.calls)
+		}
+	})
+
+	t.Run("jitter stays under the max delay", func(t *testing.T) {
+		p := &flakyProcessor{failFor: 10, err: errors.New("db down")}
+		dl := &deadLetter{}
+		r := hachibi.NewRetryProcessor(p,
+			hachibi.RetryWithBackoff(time.Millisecond, 2*time.Millisecond),
+			hachibi.RetryWithJitter(0.9),
+			hachibi.RetryWithMaxAttempts(8),
+			hachibi.RetryWithRandSeed(1),
+			hachibi.RetryWithDeadLetter(dl),
+		)
+
+		r.Process(context.Background(), &hachibi.HttpData{})
+
+		for _, attempt := range dl.history {
+			if attempt.Delay > 2*time.Millisecond {
+				t.Fatalf("delay %s of attempt %d is over the max delay", attempt.Delay, attempt.Attempt)
+			}
+		}
+	})
+
+	t.Run("context canceled stops waiting", func(t *testing.T) {
+		p := &flakyProcessor{failFor: 10, err: errors.New("db down")}
+		r := hachibi.NewRetryProcessor(p, hachibi.RetryWithBackoff(time.Hour, time.Hour))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		if err := r.Process(ctx, &hachibi.HttpData{}); err == nil {
+			t.Fatal("expected error")
+		}
+
+		if p.calls != 1 {
+			t.Fatalf("expected 1 call, got %d", p.calls)
+		}
+	})
+}
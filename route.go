@@ -0,0 +1,162 @@
+package hachibi
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const DefaultRoutePlaceholder = "{id}"
+
+var (
+	numericSegment = regexp.MustCompile(`^[0-9]+$`)
+	uuidSegment    = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	hexSegment     = regexp.MustCompile(`^[0-9a-fA-F]{16,}$`)
+	base64Segment  = regexp.MustCompile(`^[A-Za-z0-9+_-]{16,}={0,2}$`)
+)
+
+// RouteNormalizer derives a route from the URL of the captures without one, e.g. /users/8123/orders/99 becomes
+// /users/{id}/orders/{id}, so that captures can be grouped and used as metric labels.
+// The templates are tried first, then the segments are replaced by the segment rules and the heuristics:
+// numbers, UUIDs, long hexadecimal strings and base64 tokens.
+type RouteNormalizer struct {
+	templates   [][]string
+	segments    []routeSegment
+	heuristics  bool
+	placeholder string
+}
+
+type routeSegment struct {
+	pattern     *regexp.Regexp
+	placeholder string
+}
+
+type RouteNormalizerOpt func(*RouteNormalizer)
+
+// RouteNormalizerWithTemplates adds templates like /users/{id}/avatar, a {name} segment matches any segment.
+// The first template matching all the segments of a path is its route.
+func RouteNormalizerWithTemplates(templates ...string) RouteNormalizerOpt {
+	return func(n *RouteNormalizer) {
+		for _, template := range templates {
+			n.templates = append(n.templates, strings.Split(template, "/"))
+		}
+	}
+}
+
+// RouteNormalizerWithSegment replaces the segments fully matched by pattern with placeholder, e.g. {slug}.
+// The segment rules are tried in order, before the heuristics.
+func RouteNormalizerWithSegment(pattern *regexp.Regexp, placeholder string) RouteNormalizerOpt {
+	return func(n *RouteNormalizer) {
+		n.segments = append(n.segments, routeSegment{pattern: pattern, placeholder: placeholder})
+	}
+}
+
+// RouteNormalizerWithoutHeuristics only keeps the templates and the segment rules.
+func RouteNormalizerWithoutHeuristics() RouteNormalizerOpt {
+	return func(n *RouteNormalizer) {
+		n.heuristics = false
+	}
+}
+
+// RouteNormalizerWithPlaceholder replaces the segments found by the heuristics with placeholder, DefaultRoutePlaceholder by default.
+func RouteNormalizerWithPlaceholder(placeholder string) RouteNormalizerOpt {
+	return func(n *RouteNormalizer) {
+		n.placeholder = placeholder
+	}
+}
+
+func NewRouteNormalizer(opts ...RouteNormalizerOpt) *RouteNormalizer {
+	n := &RouteNormalizer{heuristics: true, placeholder: DefaultRoutePlaceholder}
+	for _, opt := range opts {
+		opt(n)
+	}
+
+	return n
+}
+
+// Normalize returns the route of rawURL, its scheme, host and query are dropped.
+func (n *RouteNormalizer) Normalize(rawURL string) string {
+	path := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		path = u.EscapedPath()
+	}
+
+	segments := strings.Split(path, "/")
+	for _, template := range n.templates {
+		if matchTemplate(template, segments) {
+			return strings.Join(template, "/")
+		}
+	}
+
+	for i, segment := range segments {
+		segments[i] = n.segment(segment)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+func (n *RouteNormalizer) segment(segment string) string {
+	if segment == "" {
+		return segment
+	}
+
+	for _, s := range n.segments {
+		if loc := s.pattern.FindStringIndex(segment); loc != nil && loc[0] == 0 && loc[1] == len(segment) {
+			return s.placeholder
+		}
+	}
+
+	if n.heuristics && isIdentifier(segment) {
+		return n.placeholder
+	}
+
+	return segment
+}
+
+// PreProcess sets the route of the captures which don't have one, e.g. from a framework adapter.
+func (n *RouteNormalizer) PreProcess(ctx context.Context, httpData *HttpData) error {
+	if httpData.Route == "" {
+		httpData.Route = n.Normalize(httpData.URL)
+	}
+
+	return nil
+}
+
+func matchTemplate(template []string, segments []string) bool {
+	if len(template) != len(segments) {
+		return false
+	}
+
+	for i, t := range template {
+		if isTemplateParameter(t) {
+			if segments[i] == "" {
+				return false
+			}
+			continue
+		}
+
+		if t != segments[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isTemplateParameter(segment string) bool {
+	return len(segment) > 2 && strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
+
+// isIdentifier reports whether segment looks generated rather than part of the API.
+func isIdentifier(segment string) bool {
+	if numericSegment.MatchString(segment) || uuidSegment.MatchString(segment) || hexSegment.MatchString(segment) {
+		return true
+	}
+
+	// base64 of random bytes mixes cases and digits, unlike long words
+	return base64Segment.MatchString(segment) &&
+		strings.ContainsAny(segment, "0123456789") &&
+		strings.ContainsAny(segment, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") &&
+		strings.ContainsAny(segment, "abcdefghijklmnopqrstuvwxyz")
+}
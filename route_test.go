@@ -0,0 +1,101 @@
+package hachibi_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/mtfiqh/hachibi"
+)
+
+func TestRouteNormalizer(t *testing.T) {
+	t.Run("heuristics", func(t *testing.T) {
+		n := hachibi.NewRouteNormalizer()
+
+		for _, c := range []struct {
+			url  string
+			want string
+		}{
+			{url: "/users/8123/orders/99", want: "/users/{id}/orders/{id}"},
+			{url: "https://api.example.com/users/8123?expand=orders", want: "/users/{id}"},
+			{url: "/sessions/3f2504e0-4f89-11d3-9a0c-0305e82c3301", want: "/sessions/{id}"},
+			{url: "/objects/507f1f77bcf86cd799439011/", want: "/objects/{id}/"},
+			{url: "/tokens/aGVsbG8gV29ybGQgMTIzNDU2", want: "/tokens/{id}"},
+			{url: "/v1/internationalization/settings", want: "/v1/internationalization/settings"},
+			{url: "/", want: "/"},
+		} {
+			if got := n.Normalize(c.url); got != c.want {
+				t.Errorf("%s: got %s, want %s", c.url, got, c.want)
+			}
+		}
+	})
+
+	t.Run("rules", func(t *testing.T) {
+		n := hachibi.NewRouteNormalizer(
+			hachibi.RouteNormalizerWithTemplates("/users/{user}/avatar", "/files/{name}"),
+			hachibi.RouteNormalizerWithSegment(regexp.MustCompile(`[a-z]+(-[a-z]+)+`), "{slug}"),
+			hachibi.RouteNormalizerWithPlaceholder(":id"),
+		)
+
+		for _, c := range []struct {
+			url  string
+			want string
+		}{
+			{url: "/users/taufiq/avatar", want: "/users/{user}/avatar"},
+			{url: "/files/report.pdf", want: "/files/{name}"},
+			{url: "/files/", want: "/files/"},
+			{url: "/posts/hello-world/comments/12", want: "/posts/{slug}/comments/:id"},
+		} {
+			if got := n.Normalize(c.url); got != c.want {
+				t.Errorf("%s: got %s, want %s", c.url, got, c.want)
+			}
+		}
+
+		if got := hachibi.NewRouteNormalizer(hachibi.RouteNormalizerWithoutHeuristics()).Normalize("/users/12"); got != "/users/12" {
+			t.Errorf("unexpected route %s", got)
+		}
+	})
+
+	t.Run("pre process", func(t *testing.T) {
+		n := hachibi.NewRouteNormalizer()
+
+		httpData := hachibi.HttpData{URL: "/users/12"}
+		n.PreProcess(context.Background(), &httpData)
+		if httpData.Route != "/users/{id}" {
+			t.Fatalf("unexpected route %s", httpData.Route)
+		}
+
+		routed := hachibi.HttpData{URL: "/users/12", Route: "/users/:id"}
+		n.PreProcess(context.Background(), &routed)
+		if routed.Route != "/users/:id" {
+			t.Fatalf("the route of a router must be kept, got %s", routed.Route)
+		}
+	})
+
+	t.Run("middleware", func(t *testing.T) {
+		rules, err := hachibi.LoadRules(strings.NewReader("rules:\n  - match: {route: /internal/**}\n    action: {skip: true}\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		recorder := hachibi.NewMemoryRecorder(0)
+		m := hachibi.NewMiddleware(
+			hachibi.MiddlewareWithProcessor(recorder),
+			hachibi.MiddlewareWithRules(rules),
+			hachibi.MiddlewareWithRouteNormalizer(hachibi.NewRouteNormalizer()),
+		)
+		h := m.Handler(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {}))
+
+		for _, target := range []string{"/internal/jobs/1", "/users/42"} {
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+		}
+
+		records, _ := query(t, recorder, hachibi.Filter{})
+		if len(records) != 1 || records[0].Route != "/users/{id}" {
+			t.Fatalf("unexpected records %+v", records)
+		}
+	})
+}
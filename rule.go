@@ -0,0 +1,548 @@
+package hachibi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+type KeyCtxRule int
+
+const (
+	KeyRuleDecisionCtx = KeyCtxRule(0)
+)
+
+// Rules decides which traffic is captured and how, the first matching rule wins.
+//
+//	rules:
+//	  - name: health check
+//	    match: {path: "/health*"}
+//	    action: {skip: true}
+//	  - name: upload
+//	    match: {method: [POST, PUT], path: "/files/**"}
+//	    action: {headersOnly: true, event: upload, sinks: [audit]}
+type Rules struct {
+	Rules []Rule `yaml:"rules" json:"rules"`
+}
+
+type Rule struct {
+	Name   string     `yaml:"name" json:"name"`
+	Match  RuleMatch  `yaml:"match" json:"match"`
+	Action RuleAction `yaml:"action" json:"action"`
+
+	compiled *compiledMatch
+}
+
+// RuleMatch fields are combined with AND, an empty field matches everything.
+// Host, Path, Route, ContentType and Event are globs, "*" stops at "/" while "**" doesn't.
+//
+// Until a rule matching on Status, Route or ContentType (or on Event in a Middleware, where the handler may still
+// name it) may match, rules are decided from the request before anything is read: skipped exchanges are never read
+// and BodyLimit bounds what is read. The later rules are decided once the exchange is complete, their limit only trims
+// what was read.
+type RuleMatch struct {
+	Method      stringList `yaml:"method" json:"method"`
+	Host        string     `yaml:"host" json:"host"`
+	Path        string     `yaml:"path" json:"path"`
+	PathRegex   string     `yaml:"pathRegex" json:"pathRegex"`
+	Route       string     `yaml:"route" json:"route"`
+	Status      stringList `yaml:"status" json:"status"` // 200, 5xx or 400-499
+	ContentType string     `yaml:"contentType" json:"contentType"`
+	Event       string     `yaml:"event" json:"event"`
+}
+
+type RuleAction struct {
+	Skip        bool       `yaml:"skip" json:"skip"`
+	HeadersOnly bool       `yaml:"headersOnly" json:"headersOnly"`
+	BodyLimit   int        `yaml:"bodyLimit" json:"bodyLimit"`
+	Event       string     `yaml:"event" json:"event"`
+	Sinks       stringList `yaml:"sinks" json:"sinks"`
+}
+
+// RuleDecision is the action of the matched rule, it is stored in the processors context.
+type RuleDecision struct {
+	Rule string
+	RuleAction
+}
+
+// RuleDecisionFromCtx returns the decision made for the record being processed.
+func RuleDecisionFromCtx(ctx context.Context) (RuleDecision, bool) {
+	d, ok := ctx.Value(KeyRuleDecisionCtx).(RuleDecision)
+	return d, ok
+}
+
+type stringList []string
+
+func (s *stringList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*s = stringList{value.Value}
+		return nil
+	}
+
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+
+	*s = list
+	return nil
+}
+
+func (s *stringList) UnmarshalJSON(b []byte) error {
+	var value any
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+
+	if value == nil {
+		*s = nil
+		return nil
+	}
+
+	values, ok := value.([]any)
+	if !ok {
+		values = []any{value}
+	}
+
+	list := make(stringList, 0, len(values))
+	for _, v := range values {
+		switch v := v.(type) {
+		case string:
+			list = append(list, v)
+		case json.Number:
+			list = append(list, v.String())
+		default:
+			return errors.Errorf("expected a string or a list of strings, got %s", b)
+		}
+	}
+
+	*s = list
+	return nil
+}
+
+type statusRange struct {
+	from int
+	to   int
+}
+
+type compiledMatch struct {
+	host        *regexp.Regexp
+	path        *regexp.Regexp
+	route       *regexp.Regexp
+	contentType *regexp.Regexp
+	event       *regexp.Regexp
+	status      []statusRange
+}
+
+func NewRules(rules ...Rule) (*Rules, error) {
+	r := &Rules{Rules: rules}
+	if err := r.compile(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func LoadRules(reader io.Reader) (*Rules, error) {
+	r := &Rules{}
+	if err := yaml.NewDecoder(reader).Decode(r); err != nil && err != io.EOF {
+		return nil, errors.Wrap(err, "failed to decode rules")
+	}
+
+	if err := r.compile(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func LoadRulesFile(path string) (*Rules, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open rules file")
+	}
+	defer f.Close()
+
+	return LoadRules(f)
+}
+
+func (r *Rules) compile() error {
+	for i := range r.Rules {
+		rule := &r.Rules[i]
+		c, err := rule.Match.compile()
+		if err != nil {
+			return errors.Wrapf(err, "rule %d (%s)", i, rule.Name)
+		}
+
+		rule.compiled = c
+	}
+
+	return nil
+}
+
+func (m RuleMatch) compile() (*compiledMatch, error) {
+	c := &compiledMatch{}
+	var err error
+
+	if c.host, err = compileGlob(strings.ToLower(m.Host)); err != nil {
+		return nil, errors.Wrap(err, "invalid host")
+	}
+
+	if m.Path != "" && m.PathRegex != "" {
+		return nil, errors.New("path and pathRegex can't be used together")
+	}
+
+	if m.PathRegex != "" {
+		if c.path, err = regexp.Compile(m.PathRegex); err != nil {
+			return nil, errors.Wrap(err, "invalid pathRegex")
+		}
+	} else if c.path, err = compileGlob(m.Path); err != nil {
+		return nil, errors.Wrap(err, "invalid path")
+	}
+
+	if c.route, err = compileGlob(m.Route); err != nil {
+		return nil, errors.Wrap(err, "invalid route")
+	}
+
+	if c.contentType, err = compileGlob(strings.ToLower(m.ContentType)); err != nil {
+		return nil, errors.Wrap(err, "invalid contentType")
+	}
+
+	if c.event, err = compileGlob(m.Event); err != nil {
+		return nil, errors.Wrap(err, "invalid event")
+	}
+
+	for _, s := range m.Status {
+		sr, err := parseStatusRange(s)
+		if err != nil {
+			return nil, err
+		}
+
+		c.status = append(c.status, sr)
+	}
+
+	return c, nil
+}
+
+// ParseStatusRange reads a status code (404), a class (5xx) or an inclusive range (400-499).
+func ParseStatusRange(s string) (from int, to int, err error) {
+	sr, err := parseStatusRange(s)
+	return sr.from, sr.to, err
+}
+
+func parseStatusRange(s string) (statusRange, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	if len(s) == 3 && strings.HasSuffix(s, "xx") {
+		n, err := strconv.Atoi(s[:1])
+		if err != nil {
+			return statusRange{}, errors.Errorf("invalid status %q", s)
+		}
+
+		return statusRange{from: n * 100, to: n*100 + 99}, nil
+	}
+
+	if from, to, ok := strings.Cut(s, "-"); ok {
+		f, errF := strconv.Atoi(strings.TrimSpace(from))
+		t, errT := strconv.Atoi(strings.TrimSpace(to))
+		if errF != nil || errT != nil || f > t {
+			return statusRange{}, errors.Errorf("invalid status %q", s)
+		}
+
+		return statusRange{from: f, to: t}, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return statusRange{}, errors.Errorf("invalid status %q", s)
+	}
+
+	return statusRange{from: n, to: n}, nil
+}
+
+// compileGlob turns a glob into an anchored regexp, an empty glob returns nil.
+func compileGlob(glob string) (*regexp.Regexp, error) {
+	if glob == "" {
+		return nil, nil
+	}
+
+	b := strings.Builder{}
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				b.WriteString(".*")
+				i++
+				continue
+			}
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+
+	return regexp.Compile(b.String())
+}
+
+// Decide returns the action of the first rule matching the exchange.
+func (r *Rules) Decide(request *http.Request, httpData *HttpData) (RuleDecision, bool) {
+	if r == nil {
+		return RuleDecision{}, false
+	}
+
+	for _, rule := range r.Rules {
+		if rule.compiled == nil {
+			continue
+		}
+
+		if rule.matches(request, httpData) {
+			return RuleDecision{Rule: rule.Name, RuleAction: rule.Action}, true
+		}
+	}
+
+	return RuleDecision{}, false
+}
+
+// decideRequest returns the action of the first rule matching the request, before anything is captured.
+// final is false when a rule depending on the response (status, route, contentType) or on an event which can still
+// change may match first, the rules are then decided once the exchange is complete.
+func (r *Rules) decideRequest(request *http.Request, event string, eventKnown bool) (decision RuleDecision, matched bool, final bool) {
+	if r == nil {
+		return RuleDecision{}, false, true
+	}
+
+	for _, rule := range r.Rules {
+		c := rule.compiled
+		if c == nil || !rule.matchesRequest(request) {
+			continue
+		}
+
+		if c.event != nil && eventKnown && !c.event.MatchString(event) {
+			continue
+		}
+
+		if len(c.status) > 0 || c.route != nil || c.contentType != nil || (c.event != nil && !eventKnown) {
+			return RuleDecision{}, false, false
+		}
+
+		return RuleDecision{Rule: rule.Name, RuleAction: rule.Action}, true, true
+	}
+
+	return RuleDecision{}, false, true
+}
+
+// matchesRequest checks the matchers known from the request alone: method, host and path.
+func (rule Rule) matchesRequest(request *http.Request) bool {
+	c := rule.compiled
+
+	if len(rule.Match.Method) > 0 {
+		found := false
+		for _, m := range rule.Match.Method {
+			if strings.EqualFold(m, request.Method) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if c.host != nil {
+		host := request.URL.Hostname()
+		if host == "" {
+			host = request.Host
+			if h, _, ok := strings.Cut(host, ":"); ok {
+				host = h
+			}
+		}
+
+		if !c.host.MatchString(strings.ToLower(host)) {
+			return false
+		}
+	}
+
+	if c.path != nil && !c.path.MatchString(request.URL.Path) {
+		return false
+	}
+
+	return true
+}
+
+func (rule Rule) matches(request *http.Request, httpData *HttpData) bool {
+	c := rule.compiled
+
+	if !rule.matchesRequest(request) {
+		return false
+	}
+
+	if len(c.status) > 0 {
+		found := false
+		for _, s := range c.status {
+			if httpData.StatusCode >= s.from && httpData.StatusCode <= s.to {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if c.route != nil && !c.route.MatchString(httpData.Route) {
+		return false
+	}
+
+	if c.contentType != nil {
+		contentType, _, _ := mime.ParseMediaType(httpData.Response.Header.Get("Content-Type"))
+		if !c.contentType.MatchString(contentType) {
+			return false
+		}
+	}
+
+	if c.event != nil && !c.event.MatchString(httpData.Event) {
+		return false
+	}
+
+	return true
+}
+
+// apply changes httpData according to the decision and returns false when it must not be captured.
+func (d RuleDecision) apply(httpData *HttpData) bool {
+	if d.Skip {
+		return false
+	}
+
+	if d.HeadersOnly {
+		httpData.Request.Body = nil
+		httpData.Request.Multipart = nil
+		httpData.Response.Body = nil
+		httpData.Response.Multipart = nil
+	}
+
+	if d.BodyLimit > 0 {
+		httpData.Request.limit(d.BodyLimit)
+		httpData.Response.limit(d.BodyLimit)
+	}
+
+	if d.Event != "" {
+		httpData.Event = d.Event
+	}
+
+	return true
+}
+
+func (p *Payload) limit(n int) {
+	if len(p.Body) > n {
+		p.Body = p.Body[:n]
+		p.Truncated = true
+	}
+}
+
+// applyRules evaluates rules and returns the context for the processors, ok is false when the exchange is skipped.
+func applyRules(ctx context.Context, rules *Rules, request *http.Request, httpData *HttpData) (context.Context, bool) {
+	decision, matched := rules.Decide(request, httpData)
+	if !matched {
+		return ctx, true
+	}
+
+	if !decision.apply(httpData) {
+		return ctx, false
+	}
+
+	return context.WithValue(ctx, KeyRuleDecisionCtx, decision), true
+}
+
+// headDecision is made from the request alone before the capture, so the skipped exchanges are never read and the
+// limited ones are only read up to their limit.
+type headDecision struct {
+	skip bool
+
+	// final is true when the rules were decided from the request, decision and matched are then final.
+	final    bool
+	matched  bool
+	decision RuleDecision
+
+	// tail is the part of the sampler which needs the complete exchange.
+	tail Sampler
+}
+
+// decideHead runs the rules and the head samplers on the request, eventKnown is false when the event of httpData
+// can still change during the exchange.
+func decideHead(ctx context.Context, rules *Rules, sampler Sampler, request *http.Request, httpData *HttpData, eventKnown bool) headDecision {
+	h := headDecision{}
+	h.decision, h.matched, h.final = rules.decideRequest(request, httpData.Event, eventKnown)
+
+	if h.matched {
+		if h.decision.Skip {
+			h.skip = true
+			return h
+		}
+
+		ctx = context.WithValue(ctx, KeyRuleDecisionCtx, h.decision)
+	}
+
+	head, tail := splitSampler(sampler)
+	h.tail = tail
+	if head != nil && !head.Sample(ctx, httpData) {
+		h.skip = true
+	}
+
+	return h
+}
+
+// bodyLimit is how much of each body is captured, -1 for all of it.
+func (h headDecision) bodyLimit() int {
+	if !h.matched {
+		return -1
+	}
+
+	if h.decision.HeadersOnly {
+		return 0
+	}
+
+	if h.decision.BodyLimit > 0 {
+		return h.decision.BodyLimit
+	}
+
+	return -1
+}
+
+// decideTail finishes the decision once the exchange is complete and returns the context for the processors,
+// ok is false when the exchange is dropped.
+func (h headDecision) decideTail(ctx context.Context, rules *Rules, request *http.Request, httpData *HttpData) (context.Context, bool) {
+	if !h.final {
+		var ok bool
+		if ctx, ok = applyRules(ctx, rules, request, httpData); !ok {
+			return ctx, false
+		}
+	} else if h.matched {
+		// the bodies were only read up to the limit, the decoded ones may still be longer
+		h.decision.apply(httpData)
+		ctx = context.WithValue(ctx, KeyRuleDecisionCtx, h.decision)
+	}
+
+	if h.tail != nil && !h.tail.Sample(ctx, httpData) {
+		return ctx, false
+	}
+
+	return ctx, true
+}
@@ -0,0 +1,278 @@
+package hachibi_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mtfiqh/hachibi"
+)
+
+const rulesYAML = `
+rules:
+  - name: health
+    match:
+      path: /health*
+    action:
+      skip: true
+  - name: static
+    match:
+      method: GET
+      path: /static/**
+    action:
+      skip: true
+  - name: internal
+    match:
+      route: /internal/**
+    action:
+      skip: true
+  - name: upload
+    match:
+      method: [POST, PUT]
+      pathRegex: ^/files/[0-9]+$
+    action:
+      headersOnly: true
+      event: upload
+  - name: server error
+    match:
+      status: 5xx
+      contentType: application/*
+    action:
+      bodyLimit: 4
+      sinks: audit
+`
+
+type captureProcessor struct {
+	records []hachibi.HttpData
+	sinks   []string
+}
+
+func (c *captureProcessor) Process(ctx context.Context, httpData *hachibi.HttpData) error {
+	c.records = append(c.records, *httpData)
+	if d, ok := hachibi.RuleDecisionFromCtx(ctx); ok {
+		c.sinks = d.Sinks
+	}
+
+	return nil
+}
+
+func TestRules(t *testing.T) {
+	rules, err := hachibi.LoadRules(strings.NewReader(rulesYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "application/json")
+		if request.URL.Path == "/fail" {
+			writer.WriteHeader(http.StatusBadGateway)
+		} else {
+			writer.WriteHeader(http.StatusOK)
+		}
+		writer.Write([]byte(`{"message":"hello"}`))
+	}
+
+	t.Run("middleware", func(t *testing.T) {
+		p := &captureProcessor{}
+		m := hachibi.NewMiddleware(hachibi.MiddlewareWithProcessor(p), hachibi.MiddlewareWithRules(rules))
+		h := m.Middleware(handler)
+
+		for _, target := range []string{"/healthz", "/static/css/app.css"} {
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+		}
+
+		routed := m.SetRoute("/internal/{name}")(handler)
+		m.Middleware(routed).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/debug", nil))
+
+		if len(p.records) != 0 {
+			t.Fatalf("expected skipped records, got %d", len(p.records))
+		}
+
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/files/12", strings.NewReader("file content")))
+		if len(p.records) != 1 {
+			t.Fatal("expected upload to be captured")
+		}
+
+		upload := p.records[0]
+		if upload.Event != "upload" || upload.Request.Body != nil || upload.Response.Body != nil || upload.Request.Header == nil {
+			t.Fatalf("unexpected upload record %+v", upload)
+		}
+
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fail", nil))
+		failed := p.records[1]
+		if string(failed.Response.Body) != `{"me` || !failed.Response.Truncated {
+			t.Fatalf("expected truncated body, got %q", failed.Response.Body)
+		}
+
+		if len(p.sinks) != 1 || p.sinks[0] != "audit" {
+			t.Fatalf("unexpected sinks %v", p.sinks)
+		}
+	})
+
+	t.Run("transport", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(handler))
+		defer server.Close()
+
+		p := &captureProcessor{}
+		client := http.Client{Transport: hachibi.NewTransport(hachibi.TransportWithProcessor(p), hachibi.TransportWithRules(rules))}
+
+		for _, target := range []string{"/health", "/users", "/fail"} {
+			res, err := client.Get(server.URL + target)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			b, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+
+			if string(b) != `{"message":"hello"}` {
+				t.Fatalf("caller must receive the whole body, got %q", b)
+			}
+		}
+
+		if len(p.records) != 2 {
+			t.Fatalf("expected 2 records, got %d", len(p.records))
+		}
+
+		if len(p.records[0].Error) != 0 || string(p.records[0].Response.Body) != `{"message":"hello"}` {
+			t.Fatalf("unexpected record %+v", p.records[0])
+		}
+
+		if string(p.records[1].Response.Body) != `{"me` {
+			t.Fatalf("unexpected body %q", p.records[1].Response.Body)
+		}
+	})
+
+	t.Run("decided before the capture", func(t *testing.T) {
+		rules, err := hachibi.NewRules(
+			hachibi.Rule{Name: "health", Match: hachibi.RuleMatch{Path: "/health"}, Action: hachibi.RuleAction{Skip: true}},
+			hachibi.Rule{Name: "import", Match: hachibi.RuleMatch{Method: []string{http.MethodPost}, Path: "/import"}, Action: hachibi.RuleAction{BodyLimit: 4}},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		big := strings.Repeat("x", 1<<20)
+		body := &countingReader{Reader: strings.NewReader(big)}
+		consumed, sent := -1, 0
+		p := &captureProcessor{}
+		transport := hachibi.NewTransport(hachibi.TransportWithProcessor(p), hachibi.TransportWithRules(rules), hachibi.TransportWithRoundTripper(
+			roundTripperFunc(func(request *http.Request) (*http.Response, error) {
+				consumed = body.n
+				b, _ := io.ReadAll(request.Body)
+				sent = len(b)
+
+				return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(big)), Request: request}, nil
+			}),
+		))
+
+		request, _ := http.NewRequest(http.MethodPost, "http://api.example.com/health", body)
+		response, err := transport.RoundTrip(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, response.Body)
+		response.Body.Close()
+
+		if consumed != 0 || len(p.records) != 0 {
+			t.Fatalf("a skipped exchange must not be read, %d bytes were read before the round trip", consumed)
+		}
+
+		body = &countingReader{Reader: strings.NewReader(big)}
+		request, _ = http.NewRequest(http.MethodPost, "http://api.example.com/import", body)
+		response, err = transport.RoundTrip(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		received, _ := io.ReadAll(response.Body)
+		response.Body.Close()
+
+		if consumed > 5 || sent != len(big) || len(received) != len(big) {
+			t.Fatalf("the body must only be read up to the limit, %d bytes were read before the round trip, %d sent, %d received", consumed, sent, len(received))
+		}
+
+		if len(p.records) != 1 || string(p.records[0].Request.Body) != "xxxx" || !p.records[0].Request.Truncated || string(p.records[0].Response.Body) != "xxxx" || !p.records[0].Response.Truncated {
+			t.Fatalf("unexpected records %+v", p.records)
+		}
+
+		p.records = nil
+		m := hachibi.NewMiddleware(hachibi.MiddlewareWithProcessor(p), hachibi.MiddlewareWithRules(rules))
+		recorder := httptest.NewRecorder()
+		m.Middleware(func(writer http.ResponseWriter, request *http.Request) {
+			b, _ := io.ReadAll(request.Body)
+			writer.Write(b[:10])
+			writer.Write(b[10:20])
+		}).ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/import", strings.NewReader(big)))
+
+		if recorder.Body.Len() != 20 || len(p.records) != 1 || string(p.records[0].Request.Body) != "xxxx" || string(p.records[0].Response.Body) != "xxxx" || !p.records[0].Response.Truncated {
+			t.Fatalf("unexpected middleware record %+v", p.records)
+		}
+	})
+
+	t.Run("invalid rule", func(t *testing.T) {
+		_, err := hachibi.LoadRules(strings.NewReader("rules:\n  - match: {status: abc}\n"))
+		if err == nil {
+			t.Fatal("expected error")
+		}
+	})
+	t.Run("json", func(t *testing.T) {
+		var parsed hachibi.Rules
+		err := json.Unmarshal([]byte(`{"rules":[{"match":{"method":"POST","status":[404,"5xx"]},"action":{"sinks":"audit"}}]}`), &parsed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rule := parsed.Rules[0]
+		if !reflect.DeepEqual([]string(rule.Match.Method), []string{"POST"}) || !reflect.DeepEqual([]string(rule.Match.Status), []string{"404", "5xx"}) || !reflect.DeepEqual([]string(rule.Action.Sinks), []string{"audit"}) {
+			t.Fatalf("unexpected rule %+v", rule)
+		}
+
+		if _, err := hachibi.NewRules(parsed.Rules...); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := json.Unmarshal([]byte(`{"rules":[{"match":{"method":{"a":1}}}]}`), &parsed); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	t.Run("status range", func(t *testing.T) {
+		for s, want := range map[string][2]int{"404": {404, 404}, "5xx": {500, 599}, "400-499": {400, 499}} {
+			from, to, err := hachibi.ParseStatusRange(s)
+			if err != nil || from != want[0] || to != want[1] {
+				t.Fatalf("%s: unexpected range %d-%d %v", s, from, to, err)
+			}
+		}
+
+		if _, _, err := hachibi.ParseStatusRange("500-400"); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+type roundTripperFunc func(request *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
+
+// countingReader counts the bytes read from it.
+type countingReader struct {
+	io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.n += n
+	return n, err
+}
+
+func (c *countingReader) Close() error {
+	return nil
+}
@@ -0,0 +1,293 @@
+package hachibi
+
+import (
+	"context"
+	"math/rand"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sampler decides whether a record goes through the processors.
+//
+// The samplers deciding from the request alone (NewRateSampler, TokenBucketSampler and a RouteSampler made of them)
+// run before the capture so the exchanges they drop are never read, the others (e.g. TailSampler or a SamplerFunc)
+// run once the response is complete.
+type Sampler interface {
+	Sample(ctx context.Context, httpData *HttpData) bool
+}
+
+// headSampler is a Sampler which only reads the method, the URL and the event of httpData when head is true.
+type headSampler interface {
+	Sampler
+	head() bool
+}
+
+type SamplerFunc func(ctx context.Context, httpData *HttpData) bool
+
+func (f SamplerFunc) Sample(ctx context.Context, httpData *HttpData) bool {
+	return f(ctx, httpData)
+}
+
+type rateSampler float64
+
+// NewRateSampler keeps the given fraction (0..1) of the records.
+func NewRateSampler(rate float64) Sampler {
+	return rateSampler(rate)
+}
+
+func (r rateSampler) Sample(ctx context.Context, httpData *HttpData) bool {
+	if r >= 1 {
+		return true
+	}
+
+	if r <= 0 {
+		return false
+	}
+
+	return rand.Float64() < float64(r)
+}
+
+func (r rateSampler) head() bool {
+	return true
+}
+
+type allSampler []Sampler
+
+// SampleAll keeps a record only when every sampler keeps it, samplers are asked in order
+// so a token bucket should be placed last to only spend tokens on kept records.
+// The leading samplers deciding from the request run before the capture, the rest once the response is complete.
+func SampleAll(samplers ...Sampler) Sampler {
+	return allSampler(samplers)
+}
+
+func (a allSampler) Sample(ctx context.Context, httpData *HttpData) bool {
+	for _, s := range a {
+		if !s.Sample(ctx, httpData) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isHeadSampler(s Sampler) bool {
+	h, ok := s.(headSampler)
+	return ok && h.head()
+}
+
+// splitSampler returns the part of s which can run before the capture and the part which needs the response,
+// either can be nil.
+func splitSampler(s Sampler) (head Sampler, tail Sampler) {
+	if s == nil {
+		return nil, nil
+	}
+
+	if all, ok := s.(allSampler); ok {
+		i := 0
+		for i < len(all) && isHeadSampler(all[i]) {
+			i++
+		}
+
+		if i > 0 {
+			head = all[:i]
+		}
+
+		if i < len(all) {
+			tail = all[i:]
+		}
+
+		return head, tail
+	}
+
+	if isHeadSampler(s) {
+		return s, nil
+	}
+
+	return nil, s
+}
+
+type TokenBucketSampler struct {
+	mu       sync.Mutex
+	rate     float64
+	burst    float64
+	tokens   float64
+	lastFill time.Time
+}
+
+// NewTokenBucketSampler keeps at most perSecond records per second, allowing bursts up to burst records.
+func NewTokenBucketSampler(perSecond float64, burst int) *TokenBucketSampler {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &TokenBucketSampler{
+		rate:     perSecond,
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		lastFill: time.Now(),
+	}
+}
+
+func (s *TokenBucketSampler) Sample(ctx context.Context, httpData *HttpData) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.tokens += now.Sub(s.lastFill).Seconds() * s.rate
+	if s.tokens > s.burst {
+		s.tokens = s.burst
+	}
+	s.lastFill = now
+
+	if s.tokens < 1 {
+		return false
+	}
+
+	s.tokens--
+	return true
+}
+
+func (s *TokenBucketSampler) head() bool {
+	return true
+}
+
+type routeSampler struct {
+	method  string
+	path    *regexp.Regexp
+	sampler Sampler
+}
+
+type RouteSampler struct {
+	routes   []routeSampler
+	fallback Sampler
+}
+
+type RouteSamplerOpt func(*RouteSampler)
+
+// RouteSamplerWithRoute uses sampler for the records matching method (empty for any) and the path glob,
+// the first matching route wins.
+func RouteSamplerWithRoute(method, path string, sampler Sampler) RouteSamplerOpt {
+	return func(r *RouteSampler) {
+		re, err := compileGlob(path)
+		if err != nil {
+			re = regexp.MustCompile("^" + regexp.QuoteMeta(path) + "$")
+		}
+
+		r.routes = append(r.routes, routeSampler{method: method, path: re, sampler: sampler})
+	}
+}
+
+// NewRouteSampler samples per route, records not matching any route go to fallback (nil keeps them).
+func NewRouteSampler(fallback Sampler, opts ...RouteSamplerOpt) *RouteSampler {
+	r := &RouteSampler{fallback: fallback}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+func (r *RouteSampler) Sample(ctx context.Context, httpData *HttpData) bool {
+	path := httpData.URL
+	if u, err := url.Parse(httpData.URL); err == nil {
+		path = u.Path
+	}
+
+	for _, route := range r.routes {
+		if route.method != "" && !strings.EqualFold(route.method, httpData.Method) {
+			continue
+		}
+
+		if route.path != nil && !route.path.MatchString(path) {
+			continue
+		}
+
+		return route.sampler.Sample(ctx, httpData)
+	}
+
+	if r.fallback == nil {
+		return true
+	}
+
+	return r.fallback.Sample(ctx, httpData)
+}
+
+// head is true when every sampler of the routes decides from the request.
+func (r *RouteSampler) head() bool {
+	for _, route := range r.routes {
+		if !isHeadSampler(route.sampler) {
+			return false
+		}
+	}
+
+	return r.fallback == nil || isHeadSampler(r.fallback)
+}
+
+// TailSampler always keeps failed or slow exchanges and leaves the rest to the base sampler.
+type TailSampler struct {
+	base       Sampler
+	minStatus  int
+	keepErrors bool
+	slowerThan time.Duration
+}
+
+type TailSamplerOpt func(*TailSampler)
+
+// TailSamplerWithStatus keeps every record with a status code >= status, 0 disables it.
+func TailSamplerWithStatus(status int) TailSamplerOpt {
+	return func(s *TailSampler) {
+		s.minStatus = status
+	}
+}
+
+// TailSamplerWithErrors keeps every record having an error, e.g. a failed round trip.
+func TailSamplerWithErrors(keep bool) TailSamplerOpt {
+	return func(s *TailSampler) {
+		s.keepErrors = keep
+	}
+}
+
+// TailSamplerWithSlowerThan keeps every record that took longer than d, 0 disables it.
+func TailSamplerWithSlowerThan(d time.Duration) TailSamplerOpt {
+	return func(s *TailSampler) {
+		s.slowerThan = d
+	}
+}
+
+// NewTailSampler keeps 5xx responses and errors by default.
+func NewTailSampler(base Sampler, opts ...TailSamplerOpt) *TailSampler {
+	s := &TailSampler{
+		base:       base,
+		minStatus:  500,
+		keepErrors: true,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+func (s *TailSampler) Sample(ctx context.Context, httpData *HttpData) bool {
+	if s.minStatus > 0 && httpData.StatusCode >= s.minStatus {
+		return true
+	}
+
+	if s.keepErrors && len(httpData.Error) > 0 {
+		return true
+	}
+
+	if s.slowerThan > 0 && time.Duration(httpData.Duration)*time.Millisecond > s.slowerThan {
+		return true
+	}
+
+	if s.base == nil {
+		return true
+	}
+
+	return s.base.Sample(ctx, httpData)
+}
@@ -0,0 +1,146 @@
+package hachibi_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mtfiqh/hachibi"
+)
+
+func TestSampler(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("rate", func(t *testing.T) {
+		if hachibi.NewRateSampler(0).Sample(ctx, &hachibi.HttpData{}) {
+			t.Fatal("rate 0 must drop")
+		}
+
+		if !hachibi.NewRateSampler(1).Sample(ctx, &hachibi.HttpData{}) {
+			t.Fatal("rate 1 must keep")
+		}
+	})
+
+	t.Run("token bucket", func(t *testing.T) {
+		s := hachibi.NewTokenBucketSampler(1, 2)
+		kept := 0
+		for i := 0; i < 5; i++ {
+			if s.Sample(ctx, &hachibi.HttpData{}) {
+				kept++
+			}
+		}
+
+		if kept != 2 {
+			t.Fatalf("expected burst of 2, got %d", kept)
+		}
+	})
+
+	t.Run("route", func(t *testing.T) {
+		s := hachibi.NewRouteSampler(hachibi.NewRateSampler(1),
+			hachibi.RouteSamplerWithRoute(http.MethodGet, "/products/**", hachibi.NewRateSampler(0)),
+		)
+
+		if s.Sample(ctx, &hachibi.HttpData{Method: http.MethodGet, URL: "http://shop/products/1/price?currency=idr"}) {
+			t.Fatal("hot route must be dropped")
+		}
+
+		if !s.Sample(ctx, &hachibi.HttpData{Method: http.MethodPost, URL: "/products/1"}) {
+			t.Fatal("other method must use fallback")
+		}
+	})
+
+	t.Run("tail", func(t *testing.T) {
+		s := hachibi.NewTailSampler(hachibi.NewRateSampler(0), hachibi.TailSamplerWithSlowerThan(time.Second))
+
+		cases := []struct {
+			name     string
+			httpData hachibi.HttpData
+			keep     bool
+		}{
+			{name: "success", httpData: hachibi.HttpData{StatusCode: 200, Duration: 20}, keep: false},
+			{name: "client error", httpData: hachibi.HttpData{StatusCode: 404}, keep: false},
+			{name: "server error", httpData: hachibi.HttpData{StatusCode: 503}, keep: true},
+			{name: "transport error", httpData: hachibi.HttpData{Error: hachibi.Error{errors.New("connection refused")}}, keep: true},
+			{name: "slow", httpData: hachibi.HttpData{StatusCode: 200, Duration: 1500}, keep: true},
+		}
+
+		for _, c := range cases {
+			if got := s.Sample(ctx, &c.httpData); got != c.keep {
+				t.Errorf("%s: expected %v, got %v", c.name, c.keep, got)
+			}
+		}
+	})
+
+	t.Run("head", func(t *testing.T) {
+		body := &countingReader{Reader: strings.NewReader("payload")}
+		consumed := -1
+		var tail []int
+		p := &captureProcessor{}
+		transport := hachibi.NewTransport(
+			hachibi.TransportWithProcessor(p),
+			hachibi.TransportWithRoundTripper(roundTripperFunc(func(request *http.Request) (*http.Response, error) {
+				consumed = body.n
+				return &http.Response{StatusCode: http.StatusCreated, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("created")), Request: request}, nil
+			})),
+			hachibi.TransportWithSampler(hachibi.SampleAll(
+				hachibi.NewRouteSampler(nil, hachibi.RouteSamplerWithRoute("", "/drop", hachibi.NewRateSampler(0))),
+				hachibi.SamplerFunc(func(ctx context.Context, httpData *hachibi.HttpData) bool {
+					tail = append(tail, httpData.StatusCode)
+					return true
+				}),
+			)),
+		)
+
+		for _, target := range []string{"/drop", "/keep"} {
+			request, _ := http.NewRequest(http.MethodPost, "http://api.example.com"+target, body)
+			response, err := transport.RoundTrip(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.Copy(io.Discard, response.Body)
+			response.Body.Close()
+
+			if target == "/drop" && consumed != 0 {
+				t.Fatalf("a dropped exchange must not be read, %d bytes were read before the round trip", consumed)
+			}
+		}
+
+		if len(p.records) != 1 || string(p.records[0].Request.Body) != "payload" || string(p.records[0].Response.Body) != "created" {
+			t.Fatalf("unexpected records %+v", p.records)
+		}
+
+		if len(tail) != 1 || tail[0] != http.StatusCreated {
+			t.Fatalf("the tail sampler must see the complete exchanges which were kept, got %v", tail)
+		}
+	})
+
+	t.Run("middleware", func(t *testing.T) {
+		p := &captureProcessor{}
+		m := hachibi.NewMiddleware(
+			hachibi.MiddlewareWithProcessor(p),
+			hachibi.MiddlewareWithSampler(hachibi.NewTailSampler(hachibi.NewRateSampler(0))),
+		)
+
+		h := m.Middleware(func(writer http.ResponseWriter, request *http.Request) {
+			if request.URL.Path == "/fail" {
+				writer.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			writer.WriteHeader(http.StatusOK)
+		})
+
+		for _, target := range []string{"/ok", "/fail", "/ok"} {
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+		}
+
+		if len(p.records) != 1 || p.records[0].StatusCode != http.StatusInternalServerError {
+			t.Fatalf("expected only the failed request, got %d records", len(p.records))
+		}
+	})
+}
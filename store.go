@@ -0,0 +1,365 @@
+package hachibi
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Error stages are the prefixes added to the errors of a capture depending on where they happened.
+const (
+	ErrorStageRequest     = "request"
+	ErrorStageResponse    = "response"
+	ErrorStagePreProcess  = "pre process error"
+	ErrorStageProcess     = "process error"
+	ErrorStagePostProcess = "post process error"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Store reads back the captures written by a sink.
+type Store interface {
+	Query(ctx context.Context, filter Filter) (Iterator[HttpData], error)
+}
+
+// Iterator walks the result of a query:
+//
+//	for it.Next() {
+//		httpData := it.Value()
+//	}
+//	if err := it.Err(); err != nil {...}
+type Iterator[T any] interface {
+	Next() bool
+	Value() T
+	Err() error
+	// Cursor is set into Filter.Cursor to get the next page, it's empty when there is none.
+	// It's only known once Next returned false.
+	Cursor() string
+	Close() error
+}
+
+// Collect reads all the values of it and closes it.
+func Collect[T any](it Iterator[T]) ([]T, error) {
+	defer it.Close()
+
+	values := make([]T, 0)
+	for it.Next() {
+		values = append(values, it.Value())
+	}
+
+	return values, it.Err()
+}
+
+type Sort int
+
+const (
+	SortNewest = Sort(iota)
+	SortOldest
+	SortSlowest
+	SortFastest
+)
+
+// ParseSort reads newest, oldest, slowest or fastest.
+func ParseSort(s string) (Sort, error) {
+	switch strings.ToLower(s) {
+	case "", "newest":
+		return SortNewest, nil
+	case "oldest":
+		return SortOldest, nil
+	case "slowest":
+		return SortSlowest, nil
+	case "fastest":
+		return SortFastest, nil
+	}
+
+	return SortNewest, errors.Errorf("invalid sort %q", s)
+}
+
+func (s Sort) String() string {
+	switch s {
+	case SortOldest:
+		return "oldest"
+	case SortSlowest:
+		return "slowest"
+	case SortFastest:
+		return "fastest"
+	default:
+		return "newest"
+	}
+}
+
+func (s Sort) byDuration() bool {
+	return s == SortSlowest || s == SortFastest
+}
+
+func (s Sort) descending() bool {
+	return s == SortNewest || s == SortSlowest
+}
+
+// key is the value the captures are sorted by, ties are sorted by ID.
+func (s Sort) key(httpData *HttpData) int64 {
+	if s.byDuration() {
+		return httpData.Duration
+	}
+
+	return httpData.StartedAt.UnixNano()
+}
+
+// Filter selects captures, a zero field matches everything.
+type Filter struct {
+	ID     string
+	Method string
+	// StatusFrom and StatusTo are inclusive.
+	StatusFrom int
+	StatusTo   int
+	Event      string
+	Route      string
+	// DurationFrom and DurationTo are inclusive, in milliseconds like HttpData.Duration.
+	DurationFrom int64
+	DurationTo   int64
+	// URL matches the captures whose URL contains it, URLPrefix the ones starting with it.
+	URL       string
+	URLPrefix string
+	// From is inclusive, To is exclusive.
+	From time.Time
+	To   time.Time
+	// Header matches the captures whose request or response has the header with a value containing the given one.
+	Header map[string]string
+	// Text matches the captures whose request or response body contains it, ignoring case.
+	Text string
+	// HasError keeps only the captures with (true) or without (false) errors.
+	HasError *bool
+	// ErrorStage keeps the captures with an error of the stage, e.g. ErrorStageProcess.
+	ErrorStage string
+
+	Sort   Sort
+	Cursor string
+	// Limit is the size of a page, 0 returns everything.
+	Limit int
+}
+
+// Match reports whether httpData is selected by f, Sort, Cursor and Limit are ignored.
+func (f Filter) Match(httpData *HttpData) bool {
+	if f.ID != "" && httpData.ID != f.ID {
+		return false
+	}
+
+	if f.Method != "" && !strings.EqualFold(f.Method, httpData.Method) {
+		return false
+	}
+
+	if f.StatusFrom > 0 && httpData.StatusCode < f.StatusFrom {
+		return false
+	}
+
+	if f.StatusTo > 0 && httpData.StatusCode > f.StatusTo {
+		return false
+	}
+
+	if f.Event != "" && httpData.Event != f.Event {
+		return false
+	}
+
+	if f.Route != "" && httpData.Route != f.Route {
+		return false
+	}
+
+	if f.DurationFrom > 0 && httpData.Duration < f.DurationFrom {
+		return false
+	}
+
+	if f.DurationTo > 0 && httpData.Duration > f.DurationTo {
+		return false
+	}
+
+	if f.URL != "" && !strings.Contains(httpData.URL, f.URL) {
+		return false
+	}
+
+	if f.URLPrefix != "" && !strings.HasPrefix(httpData.URL, f.URLPrefix) {
+		return false
+	}
+
+	if !f.From.IsZero() && httpData.StartedAt.Before(f.From) {
+		return false
+	}
+
+	if !f.To.IsZero() && !httpData.StartedAt.Before(f.To) {
+		return false
+	}
+
+	for key, value := range f.Header {
+		if !headerContains(httpData.Request.Header, key, value) && !headerContains(httpData.Response.Header, key, value) {
+			return false
+		}
+	}
+
+	if f.Text != "" && !bodyContains(httpData.Request.Body, f.Text) && !bodyContains(httpData.Response.Body, f.Text) {
+		return false
+	}
+
+	if f.HasError != nil && *f.HasError != (len(httpData.Error) > 0) {
+		return false
+	}
+
+	if f.ErrorStage != "" {
+		found := false
+		for _, e := range httpData.Error {
+			if errorOfStage(e.Error(), f.ErrorStage) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func headerContains(header http.Header, key string, value string) bool {
+	for _, v := range header.Values(key) {
+		if strings.Contains(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func bodyContains(body []byte, text string) bool {
+	return strings.Contains(strings.ToLower(string(body)), strings.ToLower(text))
+}
+
+func errorOfStage(message string, stage string) bool {
+	return message == stage || strings.HasPrefix(message, stage+": ")
+}
+
+// cursor is the position of the last capture of a page.
+type cursor struct {
+	key int64
+	id  string
+}
+
+func (c cursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(c.key, 10) + "|" + c.id))
+}
+
+func parseCursor(s string) (*cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	key, id, ok := strings.Cut(string(b), "|")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+
+	k, err := strconv.ParseInt(key, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &cursor{key: k, id: id}, nil
+}
+
+// after reports whether httpData comes after c in the order of s.
+func (c cursor) after(s Sort, httpData *HttpData) bool {
+	key := s.key(httpData)
+	if s.descending() {
+		return key < c.key || (key == c.key && httpData.ID < c.id)
+	}
+
+	return key > c.key || (key == c.key && httpData.ID > c.id)
+}
+
+// sliceIterator pages the captures kept in memory.
+type sliceIterator struct {
+	records []HttpData
+	index   int
+	next    string
+}
+
+// newSliceIterator sorts the matching records and cuts the page selected by filter.
+func newSliceIterator(records []HttpData, filter Filter) (*sliceIterator, error) {
+	c, err := parseCursor(filter.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	s := filter.Sort
+	sort.SliceStable(records, func(i, j int) bool {
+		ki, kj := s.key(&records[i]), s.key(&records[j])
+		if ki == kj {
+			if s.descending() {
+				return records[i].ID > records[j].ID
+			}
+			return records[i].ID < records[j].ID
+		}
+
+		if s.descending() {
+			return ki > kj
+		}
+		return ki < kj
+	})
+
+	page := make([]HttpData, 0)
+	for i := range records {
+		if c != nil && !c.after(s, &records[i]) {
+			continue
+		}
+
+		page = append(page, records[i])
+	}
+
+	it := &sliceIterator{records: page, index: -1}
+	if filter.Limit > 0 && len(page) > filter.Limit {
+		it.records = page[:filter.Limit]
+		last := &it.records[filter.Limit-1]
+		it.next = cursor{key: s.key(last), id: last.ID}.String()
+	}
+
+	return it, nil
+}
+
+func (it *sliceIterator) Next() bool {
+	if it.index+1 >= len(it.records) {
+		return false
+	}
+
+	it.index++
+	return true
+}
+
+func (it *sliceIterator) Value() HttpData {
+	return it.records[it.index]
+}
+
+func (it *sliceIterator) Err() error {
+	return nil
+}
+
+func (it *sliceIterator) Cursor() string {
+	if it.index+1 < len(it.records) {
+		return ""
+	}
+
+	return it.next
+}
+
+func (it *sliceIterator) Close() error {
+	return nil
+}
@@ -0,0 +1,116 @@
+package hachibi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// JSONLinesStore is a Processor appending the captures to a file, one JSON document per line, and a Store reading them.
+type JSONLinesStore struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewJSONLinesStore(path string) *JSONLinesStore {
+	return &JSONLinesStore{path: path}
+}
+
+func (s *JSONLinesStore) Process(ctx context.Context, httpData *HttpData) error {
+	b, err := json.Marshal(httpData)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal capture")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return errors.Wrap(err, "failed to open captures file")
+		}
+
+		s.file = f
+	}
+
+	// a single write keeps the line whole when other processes append to the same file
+	if _, err := s.file.Write(append(b, '\n')); err != nil {
+		return errors.Wrap(err, "failed to write capture")
+	}
+
+	return nil
+}
+
+// Close closes the file written by Process.
+func (s *JSONLinesStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// Query reads the whole file, a last line being written is ignored.
+func (s *JSONLinesStore) Query(ctx context.Context, filter Filter) (Iterator[HttpData], error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open captures file")
+	}
+	defer f.Close()
+
+	records := make([]HttpData, 0)
+	err = ReadJSONLines(f, func(httpData HttpData) error {
+		if filter.Match(&httpData) {
+			records = append(records, httpData)
+		}
+
+		return ctx.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return newSliceIterator(records, filter)
+}
+
+// ReadJSONLines calls fn with every complete line of r, it stops at the first error.
+func ReadJSONLines(r io.Reader, fn func(HttpData) error) error {
+	reader := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		b, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read captures")
+		}
+
+		b = bytes.TrimSpace(b)
+		if len(b) == 0 {
+			continue
+		}
+
+		var httpData HttpData
+		if err := json.Unmarshal(b, &httpData); err != nil {
+			return errors.Wrapf(err, "invalid capture at line %d", line)
+		}
+
+		if err := fn(httpData); err != nil {
+			return err
+		}
+	}
+}
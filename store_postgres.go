@@ -0,0 +1,492 @@
+package hachibi
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+const DefaultPostgresTable = "logs"
+
+// PostgresStore is a Processor inserting the captures in a table, logs by default, and a Store querying them.
+// The bodies are kept byte for byte in bytea columns, the JSON and text bodies are also copied in the payload
+// columns so they can be queried.
+type PostgresStore struct {
+	db    *sqlx.DB
+	table string
+}
+
+type PostgresOpt func(*PostgresStore)
+
+func PostgresWithTable(table string) PostgresOpt {
+	return func(s *PostgresStore) {
+		s.table = table
+	}
+}
+
+func NewPostgresStore(db *sqlx.DB, opts ...PostgresOpt) *PostgresStore {
+	s := &PostgresStore{db: db, table: DefaultPostgresTable}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Migrate creates the table, the columns missing from a table created by an older version are added.
+func (s *PostgresStore) Migrate(ctx context.Context) error {
+	table := pq.QuoteIdentifier(s.table)
+	statements := []string{
+		`create table if not exists ` + table + ` (
+			id text primary key,
+			request jsonb,
+			response jsonb,
+			method text not null default '',
+			url text not null default '',
+			duration bigint not null default 0,
+			status_code integer not null default 0,
+			created_at timestamptz not null default now()
+		)`,
+		`alter table ` + table + ` add column if not exists event text`,
+		`alter table ` + table + ` add column if not exists error jsonb`,
+		`alter table ` + table + ` add column if not exists upstream jsonb`,
+		`alter table ` + table + ` add column if not exists route text`,
+		`alter table ` + table + ` add column if not exists peer text`,
+		`alter table ` + table + ` add column if not exists violations jsonb`,
+		`alter table ` + table + ` add column if not exists pii jsonb`,
+		`alter table ` + table + ` add column if not exists images jsonb`,
+		`alter table ` + table + ` add column if not exists request_body bytea`,
+		`alter table ` + table + ` add column if not exists response_body bytea`,
+		`alter table ` + table + ` add column if not exists origin text`,
+		`create index if not exists ` + pq.QuoteIdentifier(s.table+"_created_at_idx") + ` on ` + table + ` (created_at, id)`,
+	}
+
+	for _, statement := range statements {
+		if _, err := s.db.ExecContext(ctx, statement); err != nil {
+			return errors.Wrap(err, "failed to migrate captures table")
+		}
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) Process(ctx context.Context, httpData *HttpData) error {
+	request, err := json.Marshal(newStoredPayload(httpData.Request.Payload))
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal request")
+	}
+
+	response, err := json.Marshal(newStoredPayload(httpData.Response.Payload))
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal response")
+	}
+
+	var errs any
+	if len(httpData.Error) > 0 {
+		errs, _ = json.Marshal(httpData.Error)
+	}
+
+	var violations any
+	if len(httpData.Violations) > 0 {
+		if violations, err = json.Marshal(httpData.Violations); err != nil {
+			return errors.Wrap(err, "failed to marshal violations")
+		}
+	}
+
+	var pii any
+	if len(httpData.PII) > 0 {
+		if pii, err = json.Marshal(httpData.PII); err != nil {
+			return errors.Wrap(err, "failed to marshal pii")
+		}
+	}
+
+	var images any
+	if len(httpData.Images) > 0 {
+		if images, err = json.Marshal(httpData.Images); err != nil {
+			return errors.Wrap(err, "failed to marshal images")
+		}
+	}
+
+	var upstream any
+	if httpData.Upstream != nil {
+		if upstream, err = json.Marshal(httpData.Upstream); err != nil {
+			return errors.Wrap(err, "failed to marshal upstream")
+		}
+	}
+
+	startedAt := httpData.StartedAt
+	if startedAt.IsZero() {
+		startedAt = time.Now()
+	}
+
+	query := `insert into ` + pq.QuoteIdentifier(s.table) + ` (id, request, response, method, url, duration, status_code, created_at, event, error, upstream, route, peer, violations, pii, images, request_body, response_body, origin)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)`
+	_, err = s.db.ExecContext(ctx, query,
+		httpData.ID, request, response, httpData.Method, httpData.URL, httpData.Duration, httpData.StatusCode, startedAt,
+		sql.NullString{String: httpData.Event, Valid: httpData.Event != ""}, errs, upstream,
+		sql.NullString{String: httpData.Route, Valid: httpData.Route != ""},
+		sql.NullString{String: httpData.Peer, Valid: httpData.Peer != ""}, violations, pii, images,
+		rawBody(httpData.Request.Body), rawBody(httpData.Response.Body),
+		sql.NullString{String: httpData.Origin, Valid: httpData.Origin != ""},
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert capture")
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) Query(ctx context.Context, filter Filter) (Iterator[HttpData], error) {
+	query, args, err := s.buildQuery(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query captures")
+	}
+
+	return &postgresIterator{rows: rows, sort: filter.Sort, limit: filter.Limit}, nil
+}
+
+func (s *PostgresStore) buildQuery(filter Filter) (string, []any, error) {
+	where := make([]string, 0)
+	args := make([]any, 0)
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.ID != "" {
+		where = append(where, "id = "+arg(filter.ID))
+	}
+
+	if filter.Method != "" {
+		where = append(where, "upper(method) = "+arg(strings.ToUpper(filter.Method)))
+	}
+
+	if filter.StatusFrom > 0 {
+		where = append(where, "status_code >= "+arg(filter.StatusFrom))
+	}
+
+	if filter.StatusTo > 0 {
+		where = append(where, "status_code <= "+arg(filter.StatusTo))
+	}
+
+	if filter.Event != "" {
+		where = append(where, "event = "+arg(filter.Event))
+	}
+
+	if filter.Route != "" {
+		where = append(where, "route = "+arg(filter.Route))
+	}
+
+	if filter.DurationFrom > 0 {
+		where = append(where, "duration >= "+arg(filter.DurationFrom))
+	}
+
+	if filter.DurationTo > 0 {
+		where = append(where, "duration <= "+arg(filter.DurationTo))
+	}
+
+	if filter.URL != "" {
+		where = append(where, "url like "+arg("%"+escapeLike(filter.URL)+"%"))
+	}
+
+	if filter.URLPrefix != "" {
+		where = append(where, "url like "+arg(escapeLike(filter.URLPrefix)+"%"))
+	}
+
+	if !filter.From.IsZero() {
+		where = append(where, "created_at >= "+arg(filter.From))
+	}
+
+	if !filter.To.IsZero() {
+		where = append(where, "created_at < "+arg(filter.To))
+	}
+
+	for _, key := range sortedKeys(filter.Header) {
+		k := arg(http.CanonicalHeaderKey(key))
+		v := arg("%" + escapeLike(filter.Header[key]) + "%")
+		where = append(where, fmt.Sprintf("(request->'header'->>%s like %s or response->'header'->>%s like %s)", k, v, k, v))
+	}
+
+	if filter.Text != "" {
+		v := arg("%" + escapeLike(filter.Text) + "%")
+		where = append(where, fmt.Sprintf("(request->>'body' ilike %s or response->>'body' ilike %s)", v, v))
+	}
+
+	if filter.HasError != nil {
+		op := "="
+		if *filter.HasError {
+			op = ">"
+		}
+		where = append(where, "jsonb_array_length(coalesce(error, '[]'::jsonb)) "+op+" 0")
+	}
+
+	if filter.ErrorStage != "" {
+		where = append(where, fmt.Sprintf("exists (select 1 from jsonb_array_elements_text(coalesce(error, '[]'::jsonb)) e where e = %s or e like %s)",
+			arg(filter.ErrorStage), arg(escapeLike(filter.ErrorStage)+": %")))
+	}
+
+	column, direction, op := "created_at", "desc", "<"
+	if filter.Sort.byDuration() {
+		column = "duration"
+	}
+	if !filter.Sort.descending() {
+		direction, op = "asc", ">"
+	}
+
+	c, err := parseCursor(filter.Cursor)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if c != nil {
+		var key any = c.key
+		if !filter.Sort.byDuration() {
+			key = time.Unix(0, c.key)
+		}
+		where = append(where, fmt.Sprintf("(%s, id) %s (%s, %s)", column, op, arg(key), arg(c.id)))
+	}
+
+	query := `select id, request, response, method, url, duration, status_code, created_at, event, error, upstream, route, peer, violations, pii, images, request_body, response_body, origin from ` + pq.QuoteIdentifier(s.table)
+	if len(where) > 0 {
+		query += " where " + strings.Join(where, " and ")
+	}
+	query += fmt.Sprintf(" order by %s %s, id %s", column, direction, direction)
+
+	if filter.Limit > 0 {
+		// one more row tells whether there is a next page
+		query += " limit " + arg(filter.Limit+1)
+	}
+
+	return query, args, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+type postgresRow struct {
+	ID         string         `db:"id"`
+	Request    []byte         `db:"request"`
+	Response   []byte         `db:"response"`
+	Method     string         `db:"method"`
+	URL        string         `db:"url"`
+	Duration   int64          `db:"duration"`
+	StatusCode int            `db:"status_code"`
+	CreatedAt  time.Time      `db:"created_at"`
+	Event      sql.NullString `db:"event"`
+	Error      []byte         `db:"error"`
+	Upstream   []byte         `db:"upstream"`
+	Route      sql.NullString `db:"route"`
+	Peer       sql.NullString `db:"peer"`
+	Violations []byte         `db:"violations"`
+	PII        []byte         `db:"pii"`
+	Images     []byte         `db:"images"`
+
+	// RequestBody and ResponseBody are null in the rows inserted by older versions.
+	RequestBody  []byte `db:"request_body"`
+	ResponseBody []byte `db:"response_body"`
+
+	Origin sql.NullString `db:"origin"`
+}
+
+func (r postgresRow) httpData() (HttpData, error) {
+	httpData := HttpData{
+		ID:         r.ID,
+		StartedAt:  r.CreatedAt,
+		Method:     r.Method,
+		URL:        r.URL,
+		Duration:   r.Duration,
+		StatusCode: r.StatusCode,
+		Event:      r.Event.String,
+		Route:      r.Route.String,
+		Peer:       r.Peer.String,
+		Origin:     r.Origin.String,
+	}
+
+	for _, p := range []struct {
+		column []byte
+		body   []byte
+		to     *Payload
+	}{{r.Request, r.RequestBody, &httpData.Request.Payload}, {r.Response, r.ResponseBody, &httpData.Response.Payload}} {
+		if len(p.column) == 0 {
+			continue
+		}
+
+		var stored storedPayload
+		if err := json.Unmarshal(p.column, &stored); err != nil {
+			return httpData, errors.Wrapf(err, "invalid payload of capture %s", r.ID)
+		}
+
+		*p.to = stored.payload()
+		if p.body != nil {
+			p.to.Body = append([]byte(nil), p.body...)
+		}
+	}
+
+	if len(r.Error) > 0 {
+		if err := json.Unmarshal(r.Error, &httpData.Error); err != nil {
+			return httpData, errors.Wrapf(err, "invalid error of capture %s", r.ID)
+		}
+	}
+
+	if len(r.Upstream) > 0 {
+		if err := json.Unmarshal(r.Upstream, &httpData.Upstream); err != nil {
+			return httpData, errors.Wrapf(err, "invalid upstream of capture %s", r.ID)
+		}
+	}
+
+	if len(r.Violations) > 0 {
+		if err := json.Unmarshal(r.Violations, &httpData.Violations); err != nil {
+			return httpData, errors.Wrapf(err, "invalid violations of capture %s", r.ID)
+		}
+	}
+
+	if len(r.PII) > 0 {
+		if err := json.Unmarshal(r.PII, &httpData.PII); err != nil {
+			return httpData, errors.Wrapf(err, "invalid pii of capture %s", r.ID)
+		}
+	}
+
+	if len(r.Images) > 0 {
+		if err := json.Unmarshal(r.Images, &httpData.Images); err != nil {
+			return httpData, errors.Wrapf(err, "invalid images of capture %s", r.ID)
+		}
+	}
+
+	return httpData, nil
+}
+
+type postgresIterator struct {
+	rows  *sqlx.Rows
+	sort  Sort
+	limit int
+
+	count   int
+	current HttpData
+	next    string
+	err     error
+}
+
+func (it *postgresIterator) Next() bool {
+	if it.err != nil || !it.rows.Next() {
+		return false
+	}
+
+	if it.limit > 0 && it.count == it.limit {
+		it.next = cursor{key: it.sort.key(&it.current), id: it.current.ID}.String()
+		return false
+	}
+
+	var row postgresRow
+	if err := it.rows.StructScan(&row); err != nil {
+		it.err = errors.Wrap(err, "failed to scan capture")
+		return false
+	}
+
+	it.current, it.err = row.httpData()
+	if it.err != nil {
+		return false
+	}
+
+	it.count++
+	return true
+}
+
+func (it *postgresIterator) Value() HttpData {
+	return it.current
+}
+
+func (it *postgresIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+
+	return it.rows.Err()
+}
+
+func (it *postgresIterator) Cursor() string {
+	return it.next
+}
+
+func (it *postgresIterator) Close() error {
+	return it.rows.Close()
+}
+
+// storedPayload is how a Payload is kept in a jsonb column, the body is JSON when it's valid JSON and a string
+// when it's text so it can be queried, jsonb doesn't keep the formatting nor the order of the keys and
+// a text body can't be told from a JSON string, the exact body is in the bytea column.
+// BodyBase64 is the binary body of the rows inserted by older versions.
+type storedPayload struct {
+	Header      http.Header     `json:"header"`
+	Body        json.RawMessage `json:"body,omitempty"`
+	BodyBase64  []byte          `json:"bodyBase64,omitempty"`
+	Truncated   bool            `json:"truncated,omitempty"`
+	Encoding    string          `json:"encoding,omitempty"`
+	EncodedSize int             `json:"encodedSize,omitempty"`
+	Multipart   *MultipartData  `json:"multipart,omitempty"`
+}
+
+func newStoredPayload(p Payload) storedPayload {
+	s := storedPayload{
+		Header:      p.Header,
+		Truncated:   p.Truncated,
+		Encoding:    p.Encoding,
+		EncodedSize: p.EncodedSize,
+		Multipart:   p.Multipart,
+	}
+
+	switch {
+	case len(p.Body) == 0:
+	case json.Valid(p.Body):
+		s.Body = p.Body
+	case utf8.Valid(p.Body):
+		s.Body, _ = json.Marshal(string(p.Body))
+	}
+
+	return s
+}
+
+// rawBody is the value of a body column, null when there is no body.
+func rawBody(body []byte) any {
+	if body == nil {
+		return nil
+	}
+
+	return body
+}
+
+func (s storedPayload) payload() Payload {
+	p := Payload{
+		Header:      s.Header,
+		Truncated:   s.Truncated,
+		Encoding:    s.Encoding,
+		EncodedSize: s.EncodedSize,
+		Multipart:   s.Multipart,
+	}
+
+	var text string
+	switch {
+	case s.BodyBase64 != nil:
+		p.Body = s.BodyBase64
+	case json.Unmarshal(s.Body, &text) == nil:
+		p.Body = []byte(text)
+	case len(s.Body) > 0:
+		p.Body = s.Body
+	}
+
+	return p
+}
@@ -0,0 +1,166 @@
+package hachibi
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math/bits"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"golang.org/x/image/draw"
+)
+
+const (
+	DefaultThumbnailWidth   = 128
+	DefaultThumbnailHeight  = 128
+	DefaultThumbnailQuality = 70
+)
+
+// ImageHash is a 64 bits perceptual hash, similar images have hashes with a small HammingDistance.
+// It's marshaled as 16 hex digits as JSON numbers can't hold 64 bits.
+type ImageHash uint64
+
+func (h ImageHash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+func (h ImageHash) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+func (h *ImageHash) UnmarshalText(text []byte) error {
+	v, err := strconv.ParseUint(string(text), 16, 64)
+	if err != nil {
+		return errors.Wrap(err, "invalid image hash")
+	}
+
+	*h = ImageHash(v)
+	return nil
+}
+
+// HammingDistance is the number of bits differing between a and b, from 0 (same picture) to 64.
+// Up to 10 usually means the same picture resized or re-encoded.
+func HammingDistance(a, b ImageHash) int {
+	return bits.OnesCount64(uint64(a ^ b))
+}
+
+// AverageHash (aHash) sets a bit for every pixel of the 8x8 grayscale image brighter than the mean.
+func AverageHash(img image.Image) ImageHash {
+	gray := grayscale(img, 8, 8)
+
+	sum := 0
+	for _, v := range gray.Pix {
+		sum += int(v)
+	}
+	mean := sum / len(gray.Pix)
+
+	var h ImageHash
+	for i, v := range gray.Pix {
+		if int(v) > mean {
+			h |= 1 << (63 - i)
+		}
+	}
+
+	return h
+}
+
+// DifferenceHash (dHash) sets a bit for every pixel of the 9x8 grayscale image brighter than its right neighbour.
+func DifferenceHash(img image.Image) ImageHash {
+	gray := grayscale(img, 9, 8)
+
+	var h ImageHash
+	i := 0
+	for y := 0; y < 8; y++ {
+		row := gray.Pix[y*gray.Stride:]
+		for x := 0; x < 8; x++ {
+			if row[x] > row[x+1] {
+				h |= 1 << (63 - i)
+			}
+			i++
+		}
+	}
+
+	return h
+}
+
+// grayscale averages the luminance of the pixels covered by each of the w x h cells,
+// interpolating scalers skip too many pixels when the image is that much reduced.
+func grayscale(img image.Image, w, h int) *image.Gray {
+	src := flatten(img)
+	bounds := src.Bounds()
+	sums := make([]uint64, w*h)
+	counts := make([]uint64, w*h)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		cy := (y - bounds.Min.Y) * h / bounds.Dy()
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			cx := (x - bounds.Min.X) * w / bounds.Dx()
+			sums[cy*w+cx] += uint64(color.GrayModel.Convert(src.At(x, y)).(color.Gray).Y)
+			counts[cy*w+cx]++
+		}
+	}
+
+	dst := image.NewGray(image.Rect(0, 0, w, h))
+	for i := range sums {
+		if counts[i] > 0 {
+			dst.Pix[i] = uint8(sums[i] / counts[i])
+		}
+	}
+
+	return dst
+}
+
+// fit scales img down to fit in the width x height box keeping its aspect ratio, it's never enlarged.
+func fit(img image.Image, width int, height int, interpolation Interpolation) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= width && h <= height {
+		return img
+	}
+
+	if w*height > h*width {
+		h = max(h*width/w, 1)
+		w = width
+	} else {
+		w = max(w*height/h, 1)
+		h = height
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	interpolation.scaler().Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+
+	return dst
+}
+
+// Thumbnail decodes the image in r and returns it as a JPEG fitting in the width x height box,
+// oriented and without metadata.
+func Thumbnail(r io.Reader, width int, height int) (*CompressResult, error) {
+	return Compress(r,
+		WithBoundingBox(width, height),
+		WithCompressQuality(WithQualityValue(DefaultThumbnailQuality)),
+	)
+}
+
+// WithBoundingBox scales the image down, after WithResizeImage, until it fits in the width x height box.
+func WithBoundingBox(width int, height int) CompressOption {
+	return func(options *CompressOptions) {
+		options.boxWidth = width
+		options.boxHeight = height
+	}
+}
+
+// decodeOriented decodes the image in b the way it's meant to be displayed, see decodeImage for maxPixels.
+func decodeOriented(b []byte, maxPixels int) (image.Image, error) {
+	img, _, err := decodeImage(b, maxPixels)
+	if err != nil {
+		return nil, err
+	}
+
+	if metadata, err := decodeImageMetadata(b); err == nil {
+		img = applyOrientation(img, metadata.Orientation)
+	}
+
+	return img, nil
+}
@@ -0,0 +1,162 @@
+package hachibi_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/color"
+	"os"
+	"testing"
+
+	"github.com/mtfiqh/hachibi"
+)
+
+func TestThumbnail(t *testing.T) {
+	original, err := os.ReadFile("test.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("fits the bounding box", func(t *testing.T) {
+		result, err := hachibi.Thumbnail(bytes.NewReader(original), 128, 128)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result.Format != hachibi.FormatJPEG || result.Width != 128 || result.Height != 51 || result.Quality != hachibi.DefaultThumbnailQuality {
+			t.Fatalf("unexpected result %+v", result)
+		}
+	})
+
+	t.Run("orientation", func(t *testing.T) {
+		result, err := hachibi.Thumbnail(bytes.NewReader(exifJPEG(t, 6)), 10, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result.Width != 5 || result.Height != 10 {
+			t.Fatalf("unexpected result %+v", result)
+		}
+	})
+
+	t.Run("never enlarged", func(t *testing.T) {
+		result, err := hachibi.Thumbnail(bytes.NewReader(exifJPEG(t, 1)), 128, 128)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result.Width != 40 || result.Height != 20 {
+			t.Fatalf("unexpected result %+v", result)
+		}
+	})
+}
+
+func TestImageHash(t *testing.T) {
+	original, err := os.ReadFile("test.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(original))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compressed, err := hachibi.Compress(bytes.NewReader(original), hachibi.WithCompressQuality(hachibi.WithQualityValue(20)), hachibi.WithResizeImage(hachibi.WithResizeValue(1, 3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	similar, _, _ := image.Decode(bytes.NewReader(compressed.Bytes))
+
+	bounds := src.Bounds()
+	inverted := image.NewRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := src.At(x, y).RGBA()
+			inverted.Set(x, y, color.RGBA{R: 255 - uint8(r>>8), G: 255 - uint8(g>>8), B: 255 - uint8(b>>8), A: 255})
+		}
+	}
+
+	for name, hash := range map[string]func(image.Image) hachibi.ImageHash{"aHash": hachibi.AverageHash, "dHash": hachibi.DifferenceHash} {
+		t.Run(name, func(t *testing.T) {
+			h := hash(src)
+			if h != hash(src) {
+				t.Fatal("hash must be deterministic")
+			}
+
+			if d := hachibi.HammingDistance(h, hash(similar)); d > 10 {
+				t.Fatalf("resized and re-encoded image is at distance %d", d)
+			}
+
+			if d := hachibi.HammingDistance(h, hash(inverted)); d < 32 {
+				t.Fatalf("inverted image is at distance %d", d)
+			}
+		})
+	}
+
+	t.Run("hamming distance", func(t *testing.T) {
+		if hachibi.HammingDistance(0, ^hachibi.ImageHash(0)) != 64 || hachibi.HammingDistance(0b1011, 0b0010) != 2 {
+			t.Fatal("unexpected distance")
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		b, err := json.Marshal(map[string]hachibi.ImageHash{"h": 0xff})
+		if err != nil || string(b) != `{"h":"00000000000000ff"}` {
+			t.Fatalf("unexpected json %s %v", b, err)
+		}
+
+		var got map[string]hachibi.ImageHash
+		if err := json.Unmarshal(b, &got); err != nil || got["h"] != 0xff {
+			t.Fatalf("unexpected hash %v %v", got, err)
+		}
+	})
+
+	t.Run("pre processor", func(t *testing.T) {
+		encoded := base64.StdEncoding.EncodeToString(original)
+		httpData := &hachibi.HttpData{}
+		httpData.Request.Body = []byte(`{"a":"` + encoded + `","b":"` + encoded + `"}`)
+		body := append([]byte(nil), httpData.Request.Body...)
+
+		p := hachibi.NewImagePreProcessor(hachibi.ImageWithThumbnail(64, 64), hachibi.ImageWithHashes())
+		if err := p.PreProcess(context.Background(), httpData); err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(httpData.Request.Body, body) || len(httpData.Images) != 2 {
+			t.Fatalf("unexpected images %+v", httpData.Images)
+		}
+
+		a, b := httpData.Images[0], httpData.Images[1]
+		if a.Action != hachibi.ImageActionKept || a.Width != 1232 || a.Height != 494 || a.AHash != b.AHash || a.DHash != b.DHash || a.AHash != hachibi.AverageHash(src) {
+			t.Fatalf("unexpected image %+v", a)
+		}
+
+		config, _, err := image.DecodeConfig(bytes.NewReader(a.Thumbnail))
+		if err != nil || config.Width != 64 || config.Height != 25 {
+			t.Fatalf("unexpected thumbnail %+v %v", config, err)
+		}
+	})
+
+	t.Run("pre processor over the pixel limit", func(t *testing.T) {
+		httpData := &hachibi.HttpData{}
+		httpData.Request.Body = []byte(`{"a":"` + base64.StdEncoding.EncodeToString(original) + `"}`)
+
+		p := hachibi.NewImagePreProcessor(hachibi.ImageWithThumbnail(64, 64), hachibi.ImageWithHashes(),
+			hachibi.ImageWithCompression(hachibi.WithMaxPixels(1000)))
+		if err := p.PreProcess(context.Background(), httpData); err == nil {
+			t.Fatal("the image over the limit must not be compressed")
+		}
+
+		if len(httpData.Images) != 1 {
+			t.Fatalf("unexpected images %+v", httpData.Images)
+		}
+
+		if img := httpData.Images[0]; img.Thumbnail != nil || img.AHash != 0 || img.Width != 0 {
+			t.Fatalf("the image over the limit must not be decoded %+v", img)
+		}
+	})
+}
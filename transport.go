@@ -5,12 +5,25 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 )
 
 type Payload struct {
 	Header http.Header `json:"header"`
 	Body   []byte      `json:"body"`
+
+	Truncated bool `json:"truncated,omitempty"`
+
+	// Encoding is the Content-Encoding Body was decoded from, EncodedSize is the size before decoding.
+	Encoding    string `json:"encoding,omitempty"`
+	EncodedSize int    `json:"encodedSize,omitempty"`
+
+	// Multipart replaces Body when the payload is multipart.
+	Multipart *MultipartData `json:"multipart,omitempty"`
+
+	// Decoded is filled by Decode, or during capture when decoding is enabled.
+	Decoded *Decoded `json:"decoded,omitempty"`
 }
 
 type Request struct {
@@ -21,11 +34,22 @@ type Response struct {
 	Payload
 }
 
+// Transport captures the exchanges of an http.Client, a response is captured while the client reads its body and
+// its record is processed once the body is read to the end or closed, see Response.Truncated.
 type Transport struct {
 	originalRoundTripper http.RoundTripper
 
+	// HttpData holds the defaults (e.g. Event) copied into the record of every round trip.
 	HttpData
 
+	rules      *Rules
+	sampler    Sampler
+	decompress decompressConfig
+	decode     bool
+	blobStore  BlobStore
+
+	routeNormalizer *RouteNormalizer
+
 	preProcessor  PreProcessor
 	processor     Processor
 	postProcessor PostProcessor
@@ -40,6 +64,30 @@ type PreProcessor interface {
 	PreProcess(ctx context.Context, httpData *HttpData) error
 }
 
+type preProcessorChain []PreProcessor
+
+// ChainPreProcessors runs preProcessors in order as one PreProcessor, e.g. an ImagePreProcessor,
+// an openapi.Validator and a DriftDetector before a PIIDetector masking what they look at.
+// Every preProcessor runs, their errors are returned as an Error.
+func ChainPreProcessors(preProcessors ...PreProcessor) PreProcessor {
+	return preProcessorChain(preProcessors)
+}
+
+func (c preProcessorChain) PreProcess(ctx context.Context, httpData *HttpData) error {
+	errs := make(Error, 0)
+	for _, preProcessor := range c {
+		if err := preProcessor.PreProcess(ctx, httpData); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
 type PostProcessor interface {
 	PostProcessor(ctx context.Context, httpData *HttpData) error
 }
@@ -52,6 +100,7 @@ func NewTransport(opts ...TransportOpt) *Transport {
 	t := &Transport{
 		originalRoundTripper: http.DefaultTransport,
 		HttpData:             HttpData{Error: nil},
+		decompress:           defaultDecompressConfig(),
 	}
 
 	for _, opt := range opts {
@@ -65,54 +114,96 @@ func (t *Transport) RoundTrip(request *http.Request) (*http.Response, error) {
 	tNow := time.Now().Local()
 	ctx := request.Context()
 
-	var response *http.Response
+	httpData := HttpData{ID: uuid.New().String(), StartedAt: tNow, Event: t.Event, Method: request.Method, URL: request.URL.String(), Error: nil}
 
-	if err := t.extractRequest(request); err != nil {
-		t.HttpData.AppendError(err)
+	head := decideHead(ctx, t.rules, t.sampler, request, &httpData, true)
+	if head.skip {
+		return t.originalRoundTripper.RoundTrip(request)
 	}
 
-	defer func() {
-
-		if response != nil {
-			if err := t.extractResponse(response); err != nil {
-				t.HttpData.AppendError(err)
-			}
-		}
+	if err := httpData.extractRequest(request, t.blobStore, head.bodyLimit()); err != nil {
+		httpData.AppendError(err)
+	}
 
-		currentTime := time.Now().Local()
-		t.Duration = currentTime.Sub(tNow).Milliseconds()
+	if err := httpData.Request.decompress(t.decompress); err != nil {
+		httpData.AppendError(errors.Wrap(err, "request"))
+	}
 
-		if t.preProcessor != nil {
-			if err := t.preProcessor.PreProcess(ctx, &t.HttpData); err != nil {
-				err = errors.Wrap(err, "pre process error")
-				t.HttpData.AppendError(err)
-			}
+	// the record is processed once the response is read by the caller, it may be streamed
+	finish := func(response *http.Response) {
+		if err := httpData.finishRequestMultipart(); err != nil {
+			httpData.AppendError(errors.Wrap(err, "request multipart"))
 		}
 
-		if t.processor != nil {
-			if err := t.processor.Process(ctx, &t.HttpData); err != nil {
-				err = errors.Wrap(err, "process error")
-				t.HttpData.AppendError(err)
+		if response != nil {
+			if err := httpData.Response.decompress(t.decompress); err != nil {
+				httpData.AppendError(errors.Wrap(err, "response"))
 			}
-		}
 
-		if t.postProcessor != nil {
-			if err := t.postProcessor.PostProcessor(ctx, &t.HttpData); err != nil {
-				err = errors.Wrap(err, "post process error")
-				t.HttpData.AppendError(err)
+			if err := httpData.Response.parseMultipart(ctx, t.blobStore); err != nil {
+				httpData.AppendError(errors.Wrap(err, "response multipart"))
 			}
 		}
 
-		if t.Error != nil && t.errorHandler != nil {
-			t.errorHandler.ErrorHandle(ctx, t.Error)
-		}
-	}()
+		currentTime := time.Now().Local()
+		httpData.Duration = currentTime.Sub(tNow).Milliseconds()
+
+		t.process(ctx, head, request, &httpData)
+	}
 
 	response, errRoundTrip := t.originalRoundTripper.RoundTrip(request)
 	if errRoundTrip != nil {
-		t.HttpData.AppendError(errRoundTrip)
+		httpData.AppendError(errRoundTrip)
+		finish(nil)
 		return nil, errRoundTrip
 	}
 
+	httpData.extractResponse(response, head.bodyLimit(), func() {
+		finish(response)
+	})
+
 	return response, nil
 }
+
+// process finishes the decision of the rules and the sampler made before the capture, then runs the processors
+// on the record of a finished exchange.
+func (t *Transport) process(ctx context.Context, head headDecision, request *http.Request, httpData *HttpData) {
+	if t.routeNormalizer != nil {
+		t.routeNormalizer.PreProcess(ctx, httpData)
+	}
+
+	ctx, ok := head.decideTail(ctx, t.rules, request, httpData)
+	if !ok {
+		return
+	}
+
+	if t.decode {
+		httpData.Request.Decode()
+		httpData.Response.Decode()
+	}
+
+	if t.preProcessor != nil {
+		if err := t.preProcessor.PreProcess(ctx, httpData); err != nil {
+			err = errors.Wrap(err, "pre process error")
+			httpData.AppendError(err)
+		}
+	}
+
+	if t.processor != nil {
+		if err := t.processor.Process(ctx, httpData); err != nil {
+			err = errors.Wrap(err, "process error")
+			httpData.AppendError(err)
+		}
+	}
+
+	if t.postProcessor != nil {
+		if err := t.postProcessor.PostProcessor(ctx, httpData); err != nil {
+			err = errors.Wrap(err, "post process error")
+			httpData.AppendError(err)
+		}
+	}
+
+	if httpData.Error != nil && t.errorHandler != nil {
+		t.errorHandler.ErrorHandle(ctx, httpData.Error)
+	}
+}
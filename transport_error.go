@@ -1,12 +1,53 @@
 package hachibi
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 )
 
 type Error []error
 
+// MarshalJSON writes the messages of the errors, most errors have no exported field.
+func (e Error) MarshalJSON() ([]byte, error) {
+	if e == nil {
+		return []byte("null"), nil
+	}
+
+	messages := make([]string, 0, len(e))
+	for _, ee := range e {
+		messages = append(messages, ee.Error())
+	}
+
+	return json.Marshal(messages)
+}
+
+// UnmarshalJSON reads the messages written by MarshalJSON, the original error types are lost.
+func (e *Error) UnmarshalJSON(b []byte) error {
+	var messages []string
+	if err := json.Unmarshal(b, &messages); err != nil {
+		return err
+	}
+
+	if messages == nil {
+		*e = nil
+		return nil
+	}
+
+	*e = make(Error, 0, len(messages))
+	for _, m := range messages {
+		*e = append(*e, stringError(m))
+	}
+
+	return nil
+}
+
+type stringError string
+
+func (s stringError) Error() string {
+	return string(s)
+}
+
 func (e Error) Error() string {
 	errors := make([]string, 0)
 
@@ -1,5 +1,7 @@
 package hachibi
 
+import "net/http"
+
 type TransportOpt func(*Transport)
 
 func TransportWithProcessor(processor Processor) TransportOpt {
@@ -8,6 +10,13 @@ func TransportWithProcessor(processor Processor) TransportOpt {
 	}
 }
 
+// TransportWithRoundTripper sends the requests with rt instead of http.DefaultTransport.
+func TransportWithRoundTripper(rt http.RoundTripper) TransportOpt {
+	return func(transport *Transport) {
+		transport.originalRoundTripper = rt
+	}
+}
+
 func TransportWithEventName(name string) TransportOpt {
 	return func(transport *Transport) {
 		transport.Event = name
@@ -20,6 +29,7 @@ func TransportWithErrorHandler(handler ErrorHandler) TransportOpt {
 	}
 }
 
+// TransportWithPreProcessor runs p before the processor, ChainPreProcessors combines several of them.
 func TransportWithPreProcessor(p PreProcessor) TransportOpt {
 	return func(transport *Transport) {
 		transport.preProcessor = p
@@ -31,3 +41,51 @@ func TransportWithPostProcessor(p PostProcessor) TransportOpt {
 		transport.postProcessor = p
 	}
 }
+
+func TransportWithRules(rules *Rules) TransportOpt {
+	return func(transport *Transport) {
+		transport.rules = rules
+	}
+}
+
+func TransportWithSampler(sampler Sampler) TransportOpt {
+	return func(transport *Transport) {
+		transport.sampler = sampler
+	}
+}
+
+// TransportWithoutDecompression keeps compressed bodies as they are.
+func TransportWithoutDecompression() TransportOpt {
+	return func(transport *Transport) {
+		transport.decompress.disabled = true
+	}
+}
+
+// TransportWithMaxDecompressRatio limits decoded size / encoded size, 0 removes the limit.
+func TransportWithMaxDecompressRatio(ratio int) TransportOpt {
+	return func(transport *Transport) {
+		transport.decompress.maxRatio = ratio
+	}
+}
+
+// TransportWithDecoding fills Payload.Decoded of the request and response before the processors run.
+// It adds to the capture and never removes the bodies, see Payload.Decode.
+func TransportWithDecoding() TransportOpt {
+	return func(transport *Transport) {
+		transport.decode = true
+	}
+}
+
+// TransportWithBlobStore offloads the files of multipart requests and responses to store, only their reference is captured.
+func TransportWithBlobStore(store BlobStore) TransportOpt {
+	return func(transport *Transport) {
+		transport.blobStore = store
+	}
+}
+
+// TransportWithRouteNormalizer sets the route of the records from their URL, before the rules and the processors run.
+func TransportWithRouteNormalizer(normalizer *RouteNormalizer) TransportOpt {
+	return func(transport *Transport) {
+		transport.routeNormalizer = normalizer
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"log"
 	"mime/multipart"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"path/filepath"
@@ -264,3 +265,139 @@ func TestTransport_RoundTrip(t *testing.T) {
 
 	})
 }
+
+// eventStream sends a first event, then a second one once next is closed, the client must get the first
+// while the response is still open.
+func eventStream(next <-chan struct{}) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(writer, "data: 1\n\n")
+		http.NewResponseController(writer).Flush()
+
+		select {
+		case <-next:
+		case <-request.Context().Done():
+			return
+		}
+
+		io.WriteString(writer, "data: 2\n\n")
+	}
+}
+
+// readEvent reads the first event of an event stream, failing after a second.
+func readEvent(t *testing.T, body io.Reader) string {
+	t.Helper()
+
+	event := make(chan string, 1)
+	go func() {
+		b := make([]byte, len("data: 1\n\n"))
+		io.ReadFull(body, b)
+		event <- string(b)
+	}()
+
+	select {
+	case e := <-event:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("the first event must be read before the end of the response")
+		return ""
+	}
+}
+
+// echoUpgrade switches to a protocol echoing every byte, like a WebSocket handshake.
+func echoUpgrade(writer http.ResponseWriter, request *http.Request) {
+	conn, rw, err := http.NewResponseController(writer).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	rw.Flush()
+	io.Copy(conn, rw)
+}
+
+func TestTransport_Streaming(t *testing.T) {
+	t.Run("event stream", func(t *testing.T) {
+		next := make(chan struct{})
+		server := httptest.NewServer(eventStream(next))
+		defer server.Close()
+
+		p := &captureProcessor{}
+		client := http.Client{Transport: hachibi.NewTransport(hachibi.TransportWithProcessor(p))}
+
+		res, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		if event := readEvent(t, res.Body); event != "data: 1\n\n" || len(p.records) != 0 {
+			t.Fatalf("unexpected event %q", event)
+		}
+
+		close(next)
+		rest, _ := io.ReadAll(res.Body)
+		if string(rest) != "data: 2\n\n" {
+			t.Fatalf("unexpected rest %q", rest)
+		}
+
+		if len(p.records) != 1 || string(p.records[0].Response.Body) != "data: 1\n\ndata: 2\n\n" || p.records[0].Response.Truncated {
+			t.Fatalf("unexpected records %+v", p.records)
+		}
+	})
+
+	t.Run("closed stream", func(t *testing.T) {
+		next := make(chan struct{})
+		defer close(next)
+		server := httptest.NewServer(eventStream(next))
+		defer server.Close()
+
+		p := &captureProcessor{}
+		client := http.Client{Transport: hachibi.NewTransport(hachibi.TransportWithProcessor(p))}
+
+		res, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		readEvent(t, res.Body)
+		res.Body.Close()
+
+		if len(p.records) != 1 || string(p.records[0].Response.Body) != "data: 1\n\n" || !p.records[0].Response.Truncated {
+			t.Fatalf("unexpected records %+v", p.records)
+		}
+	})
+
+	t.Run("upgrade", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(echoUpgrade))
+		defer server.Close()
+
+		p := &captureProcessor{}
+		client := http.Client{Transport: hachibi.NewTransport(hachibi.TransportWithProcessor(p))}
+
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "echo")
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		conn, ok := res.Body.(io.ReadWriteCloser)
+		if !ok || res.StatusCode != http.StatusSwitchingProtocols {
+			t.Fatalf("the upgraded connection must be left to the caller %d %T", res.StatusCode, res.Body)
+		}
+
+		io.WriteString(conn, "ping")
+		b := make([]byte, 4)
+		if _, err := io.ReadFull(conn, b); err != nil || string(b) != "ping" {
+			t.Fatalf("unexpected echo %q %v", b, err)
+		}
+
+		if len(p.records) != 1 || p.records[0].StatusCode != http.StatusSwitchingProtocols || p.records[0].Response.Body != nil {
+			t.Fatalf("unexpected records %+v", p.records)
+		}
+	})
+}
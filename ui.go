@@ -0,0 +1,340 @@
+package hachibi
+
+import (
+	"embed"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const DefaultUILimit = 100
+
+//go:embed ui
+var uiFiles embed.FS
+
+// UI is a web interface browsing the captures of a Store, it has no external dependency.
+// It only uses relative URLs, so it can be mounted under any path of an admin server:
+//
+//	mux.Handle("/debug/hachibi/", http.StripPrefix("/debug/hachibi", hachibi.NewUI(recorder)))
+type UI struct {
+	store Store
+	blobs BlobStore
+	limit int
+
+	mux *http.ServeMux
+}
+
+type UIOpt func(*UI)
+
+// UIWithBlobStore shows the multipart files offloaded to store.
+func UIWithBlobStore(store BlobStore) UIOpt {
+	return func(ui *UI) {
+		ui.blobs = store
+	}
+}
+
+// UIWithLimit is the maximum number of captures listed per page, DefaultUILimit by default.
+func UIWithLimit(n int) UIOpt {
+	return func(ui *UI) {
+		ui.limit = n
+	}
+}
+
+func NewUI(store Store, opts ...UIOpt) *UI {
+	ui := &UI{store: store, limit: DefaultUILimit, mux: http.NewServeMux()}
+	for _, opt := range opts {
+		opt(ui)
+	}
+
+	static, _ := fs.Sub(uiFiles, "ui")
+	ui.mux.Handle("GET /", http.FileServerFS(static))
+	ui.mux.HandleFunc("GET /api/captures", ui.list)
+	ui.mux.HandleFunc("GET /api/captures/{id}", ui.show)
+	ui.mux.HandleFunc("GET /api/captures/{id}/har", ui.har)
+	ui.mux.HandleFunc("GET /api/captures/{id}/curl", ui.curl)
+	ui.mux.HandleFunc("GET /api/captures/{id}/{payload}/body", ui.body)
+	ui.mux.HandleFunc("GET /api/captures/{id}/{payload}/files/{key}/{index}", ui.file)
+
+	return ui
+}
+
+func (ui *UI) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	ui.mux.ServeHTTP(writer, request)
+}
+
+// captureSummary is a line of the captures list.
+type captureSummary struct {
+	ID         string    `json:"id"`
+	StartedAt  time.Time `json:"startedAt"`
+	Method     string    `json:"method"`
+	URL        string    `json:"url"`
+	StatusCode int       `json:"statusCode"`
+	Duration   int64     `json:"duration"`
+	Event      string    `json:"event"`
+	Errors     int       `json:"errors"`
+	Images     int       `json:"images"`
+}
+
+// captureList is a page of captures, Cursor gets the next one.
+type captureList struct {
+	Captures []captureSummary `json:"captures"`
+	Cursor   string           `json:"cursor,omitempty"`
+}
+
+func (ui *UI) list(writer http.ResponseWriter, request *http.Request) {
+	filter, err := parseUIFilter(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if filter.Limit <= 0 || filter.Limit > ui.limit {
+		filter.Limit = ui.limit
+	}
+
+	it, err := ui.store.Query(request.Context(), filter)
+	if errors.Is(err, ErrInvalidCursor) {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	records, err := Collect(it)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	summaries := make([]captureSummary, 0, len(records))
+	for _, r := range records {
+		summaries = append(summaries, captureSummary{
+			ID:         r.ID,
+			StartedAt:  r.StartedAt,
+			Method:     r.Method,
+			URL:        r.URL,
+			StatusCode: r.StatusCode,
+			Duration:   r.Duration,
+			Event:      r.Event,
+			Errors:     len(r.Error),
+			Images:     len(r.Images),
+		})
+	}
+
+	writeJSON(writer, captureList{Captures: summaries, Cursor: it.Cursor()})
+}
+
+func parseUIFilter(request *http.Request) (Filter, error) {
+	query := request.URL.Query()
+	filter := Filter{
+		Method:     query.Get("method"),
+		Event:      query.Get("event"),
+		URL:        query.Get("url"),
+		URLPrefix:  query.Get("urlPrefix"),
+		Text:       query.Get("text"),
+		ErrorStage: query.Get("errorStage"),
+		Cursor:     query.Get("cursor"),
+	}
+
+	sort, err := ParseSort(query.Get("sort"))
+	if err != nil {
+		return filter, err
+	}
+	filter.Sort = sort
+
+	if s := query.Get("status"); s != "" {
+		sr, err := parseStatusRange(s)
+		if err != nil {
+			return filter, err
+		}
+
+		filter.StatusFrom, filter.StatusTo = sr.from, sr.to
+	}
+
+	for name, t := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if s := query.Get(name); s != "" {
+			v, err := parseUITime(s)
+			if err != nil {
+				return filter, err
+			}
+
+			*t = v
+		}
+	}
+
+	if s := query.Get("error"); s != "" {
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return filter, err
+		}
+
+		filter.HasError = &v
+	}
+
+	if s := query.Get("limit"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return filter, err
+		}
+
+		filter.Limit = v
+	}
+
+	return filter, nil
+}
+
+// parseUITime accepts RFC 3339 and the local time of the datetime-local inputs.
+func parseUITime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	return time.ParseInLocation("2006-01-02T15:04", s, time.Local)
+}
+
+func (ui *UI) find(writer http.ResponseWriter, request *http.Request) (*HttpData, bool) {
+	it, err := ui.store.Query(request.Context(), Filter{ID: request.PathValue("id"), Limit: 1})
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	records, err := Collect(it)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if len(records) == 0 {
+		http.NotFound(writer, request)
+		return nil, false
+	}
+
+	return &records[0], true
+}
+
+func (ui *UI) show(writer http.ResponseWriter, request *http.Request) {
+	if record, ok := ui.find(writer, request); ok {
+		writeJSON(writer, record)
+	}
+}
+
+func (ui *UI) har(writer http.ResponseWriter, request *http.Request) {
+	record, ok := ui.find(writer, request)
+	if !ok {
+		return
+	}
+
+	writer.Header().Set("Content-Disposition", `attachment; filename="`+record.ID+`.har"`)
+	writeJSON(writer, NewHAR(*record))
+}
+
+func (ui *UI) curl(writer http.ResponseWriter, request *http.Request) {
+	if record, ok := ui.find(writer, request); ok {
+		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(writer, record.Curl()+"\n")
+	}
+}
+
+func (ui *UI) payload(writer http.ResponseWriter, request *http.Request) (*Payload, bool) {
+	record, ok := ui.find(writer, request)
+	if !ok {
+		return nil, false
+	}
+
+	switch request.PathValue("payload") {
+	case "request":
+		return &record.Request.Payload, true
+	case "response":
+		return &record.Response.Payload, true
+	}
+
+	http.NotFound(writer, request)
+	return nil, false
+}
+
+// body serves a captured body to be shown inline, only images are served as captured data may be any HTML.
+func (ui *UI) body(writer http.ResponseWriter, request *http.Request) {
+	payload, ok := ui.payload(writer, request)
+	if !ok {
+		return
+	}
+
+	contentType := http.DetectContentType(payload.Body)
+	if !strings.HasPrefix(contentType, "image/") {
+		http.Error(writer, "not an image", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	serveCaptured(writer, contentType, "", payload.Body)
+}
+
+func (ui *UI) file(writer http.ResponseWriter, request *http.Request) {
+	payload, ok := ui.payload(writer, request)
+	if !ok {
+		return
+	}
+
+	index, err := strconv.Atoi(request.PathValue("index"))
+	files := payload.Multipart.FilesOf(request.PathValue("key"))
+	if err != nil || index < 0 || index >= len(files) {
+		http.NotFound(writer, request)
+		return
+	}
+
+	file := files[index]
+	content := file.File
+	if content == nil && file.Ref != "" && ui.blobs != nil {
+		rc, err := ui.blobs.Open(request.Context(), file.Ref)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer rc.Close()
+
+		if content, err = io.ReadAll(rc); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadGateway)
+			return
+		}
+	}
+
+	if content == nil {
+		http.NotFound(writer, request)
+		return
+	}
+
+	serveCaptured(writer, http.DetectContentType(content), file.FileName, content)
+}
+
+// serveCaptured only lets the browser show images, anything else is downloaded.
+func serveCaptured(writer http.ResponseWriter, contentType string, name string, content []byte) {
+	header := writer.Header()
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Content-Security-Policy", "sandbox")
+
+	if strings.HasPrefix(contentType, "image/") && contentType != "image/svg+xml" {
+		header.Set("Content-Type", contentType)
+	} else {
+		header.Set("Content-Type", "application/octet-stream")
+		header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	}
+
+	header.Set("Content-Length", strconv.Itoa(len(content)))
+	writer.Write(content)
+}
+
+func writeJSON(writer http.ResponseWriter, v any) {
+	writer.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
@@ -0,0 +1,197 @@
+(function () {
+  "use strict";
+
+  const filters = document.getElementById("filters");
+  const captures = document.getElementById("captures");
+  const detail = document.getElementById("detail");
+  const more = document.getElementById("more");
+  let selected = null;
+  let cursor = "";
+
+  function el(tag, attrs, ...children) {
+    const node = document.createElement(tag);
+    for (const [key, value] of Object.entries(attrs || {})) {
+      if (value !== undefined && value !== null) {
+        node.setAttribute(key, value);
+      }
+    }
+    for (const child of children) {
+      node.append(child instanceof Node ? child : String(child));
+    }
+    return node;
+  }
+
+  function query() {
+    const params = new URLSearchParams();
+    for (const [key, value] of new FormData(filters)) {
+      if (!value) {
+        continue;
+      }
+      // datetime-local inputs are in the browser time zone
+      params.set(key, key === "from" || key === "to" ? new Date(value).toISOString() : value);
+    }
+    return params;
+  }
+
+  // load shows the first page, or appends the next one
+  async function load(next) {
+    const params = query();
+    if (next) {
+      params.set("cursor", cursor);
+    }
+
+    const response = await fetch("api/captures?" + params);
+    if (!response.ok) {
+      alert(await response.text());
+      return;
+    }
+
+    const page = await response.json();
+    const rows = page.captures.map(row);
+    if (next) {
+      captures.append(...rows);
+    } else {
+      captures.replaceChildren(...rows);
+    }
+    cursor = page.cursor || "";
+    more.hidden = !cursor;
+    document.getElementById("empty").hidden = captures.children.length > 0;
+  }
+
+  function row(capture) {
+    const tr = el("tr", {"data-id": capture.id},
+      el("td", {}, new Date(capture.startedAt).toLocaleString()),
+      el("td", {}, capture.method),
+      el("td", {class: "status-" + String(capture.statusCode)[0]}, capture.statusCode || "-"),
+      el("td", {class: "url", title: capture.url}, capture.url),
+      el("td", {}, capture.duration + " ms"),
+      el("td", {}, capture.event || ""),
+      el("td", {class: "error"}, capture.errors ? capture.errors + " error(s)" : ""));
+    if (capture.id === selected) {
+      tr.classList.add("selected");
+    }
+    tr.addEventListener("click", () => show(capture.id));
+    return tr;
+  }
+
+  function decodeBody(body) {
+    if (!body) {
+      return {size: 0};
+    }
+
+    const bytes = Uint8Array.from(atob(body), (c) => c.charCodeAt(0));
+    try {
+      return {size: bytes.length, text: new TextDecoder("utf-8", {fatal: true}).decode(bytes)};
+    } catch (e) {
+      return {size: bytes.length};
+    }
+  }
+
+  function pretty(text) {
+    try {
+      return JSON.stringify(JSON.parse(text), null, 2);
+    } catch (e) {
+      return text;
+    }
+  }
+
+  function headers(header) {
+    const dl = el("dl");
+    for (const key of Object.keys(header || {}).sort()) {
+      for (const value of header[key]) {
+        dl.append(el("dt", {}, key), el("dd", {}, value));
+      }
+    }
+    return dl;
+  }
+
+  function isImage(payload) {
+    const contentType = ((payload.header || {})["Content-Type"] || [""])[0];
+    return contentType.startsWith("image/");
+  }
+
+  function payload(id, name, data) {
+    const nodes = [headers(data.header)];
+    const base = "api/captures/" + encodeURIComponent(id) + "/" + name;
+
+    if (data.multipart) {
+      nodes.push(headers(data.multipart.fields));
+
+      for (const [key, files] of Object.entries(data.multipart.files || {})) {
+        files.forEach((file, index) => {
+          const href = base + "/files/" + encodeURIComponent(key) + "/" + index;
+          const caption = el("figcaption", {}, key + ": ", el("a", {href: href}, file.file_name), " (" + file.size + " bytes)");
+          const figure = el("figure", {}, caption);
+          if ((file.content_type || "").startsWith("image/")) {
+            figure.prepend(el("img", {src: href, alt: file.file_name, loading: "lazy"}));
+          }
+          nodes.push(figure);
+        });
+      }
+    }
+
+    const body = decodeBody(data.body);
+    if (isImage(data) && body.size > 0) {
+      nodes.push(el("figure", {}, el("img", {src: base + "/body", alt: name + " body"})));
+    } else if (body.text !== undefined) {
+      nodes.push(el("pre", {}, pretty(body.text)));
+    } else if (body.size > 0) {
+      nodes.push(el("p", {}, "binary body, " + body.size + " bytes"));
+    }
+
+    if (data.truncated) {
+      nodes.push(el("p", {class: "error"}, "body truncated"));
+    }
+
+    return nodes;
+  }
+
+  async function show(id) {
+    const base = "api/captures/" + encodeURIComponent(id);
+    const [record, curl] = await Promise.all([
+      fetch(base).then((r) => r.json()),
+      fetch(base + "/curl").then((r) => r.text()),
+    ]);
+
+    selected = id;
+    for (const tr of captures.children) {
+      tr.classList.toggle("selected", tr.dataset.id === id);
+    }
+
+    document.getElementById("title").textContent = record.method + " " + record.url + " → " + (record.statusCode || "-");
+    document.getElementById("errors").replaceChildren(...(record.error || []).map((e) => el("li", {class: "error"}, e)));
+    document.getElementById("images").replaceChildren(...(record.images || []).map((image) => {
+      const caption = el("figcaption", {}, image.location + ": " + image.action + " " + (image.width || "?") + "x" + (image.height || "?"));
+      const figure = el("figure", {}, caption);
+      if (image.thumbnail) {
+        figure.prepend(el("img", {src: "data:image/jpeg;base64," + image.thumbnail, alt: image.location}));
+      }
+      return figure;
+    }));
+    document.getElementById("request").replaceChildren(...payload(id, "request", record.request));
+    document.getElementById("response").replaceChildren(...payload(id, "response", record.response));
+    document.getElementById("curl").textContent = curl;
+    document.getElementById("har").href = base + "/har";
+    detail.hidden = false;
+  }
+
+  filters.addEventListener("submit", (event) => {
+    event.preventDefault();
+    load();
+  });
+  filters.addEventListener("reset", () => setTimeout(load));
+
+  more.addEventListener("click", () => load(true));
+
+  document.getElementById("close").addEventListener("click", () => {
+    detail.hidden = true;
+    selected = null;
+    load();
+  });
+
+  document.getElementById("copy-curl").addEventListener("click", () => {
+    navigator.clipboard.writeText(document.getElementById("curl").textContent);
+  });
+
+  load();
+})();
@@ -0,0 +1,262 @@
+package hachibi_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mtfiqh/hachibi"
+)
+
+func TestMemoryRecorder(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("keeps the newest", func(t *testing.T) {
+		r := hachibi.NewMemoryRecorder(2)
+		for _, id := range []string{"a", "b", "c"} {
+			r.Process(ctx, &hachibi.HttpData{ID: id})
+		}
+
+		records, _ := query(t, r, hachibi.Filter{})
+		if len(records) != 2 || records[0].ID != "c" || records[1].ID != "b" {
+			t.Fatalf("unexpected records %+v", records)
+		}
+	})
+
+	t.Run("records a copy", func(t *testing.T) {
+		r := hachibi.NewMemoryRecorder(0)
+		httpData := &hachibi.HttpData{ID: "a", Request: hachibi.Request{Payload: hachibi.Payload{Body: []byte("before")}}}
+		r.Process(ctx, httpData)
+		httpData.Request.Body[0] = 'B'
+
+		records, _ := query(t, r, hachibi.Filter{ID: "a"})
+		if string(records[0].Request.Body) != "before" {
+			t.Fatal("record must not share the body")
+		}
+	})
+
+	t.Run("middleware identifies the exchange", func(t *testing.T) {
+		r := hachibi.NewMemoryRecorder(0)
+		m := hachibi.NewMiddleware(hachibi.MiddlewareWithProcessor(r))
+		h := m.Middleware(func(writer http.ResponseWriter, request *http.Request) {
+			writer.WriteHeader(http.StatusNoContent)
+		})
+
+		before := time.Now()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil))
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil))
+
+		records, _ := query(t, r, hachibi.Filter{})
+		if len(records) != 2 || records[0].ID == "" || records[0].ID == records[1].ID || records[0].StartedAt.Before(before) {
+			t.Fatalf("unexpected records %+v", records)
+		}
+	})
+}
+
+func TestUI(t *testing.T) {
+	ctx := context.Background()
+	png, err := os.ReadFile("test.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	recorder := hachibi.NewMemoryRecorder(0)
+	records := []hachibi.HttpData{
+		{
+			ID: "list", StartedAt: now.Add(-time.Hour), Method: http.MethodGet, URL: "http://api/users?page=2", StatusCode: 200, Duration: 12,
+			Response: hachibi.Response{Payload: hachibi.Payload{Header: http.Header{"Content-Type": {"application/json"}}, Body: []byte(`[{"id":1}]`)}},
+		},
+		{
+			ID: "create", StartedAt: now.Add(-time.Minute), Method: http.MethodPost, URL: "http://api/users", StatusCode: 500, Event: "register",
+			Request: hachibi.Request{Payload: hachibi.Payload{Header: http.Header{"Content-Type": {"application/json"}}, Body: []byte(`{"name":"taufiq"}`)}},
+			Error:   hachibi.Error{errors.New("database is down")},
+		},
+		{
+			ID: "avatar", StartedAt: now, Method: http.MethodPut, URL: "http://api/users/1/avatar", StatusCode: 200,
+			Request: hachibi.Request{Payload: hachibi.Payload{Multipart: &hachibi.MultipartData{
+				Fields: map[string][]string{"name": {"me"}},
+				Files:  map[string][]hachibi.MultipartFileData{"file": {{FileName: "test.png", ContentType: "image/png", File: png, Size: int64(len(png))}, {FileName: "page.html", File: []byte("<script>alert(1)</script>")}}},
+			}}},
+			Response: hachibi.Response{Payload: hachibi.Payload{Header: http.Header{"Content-Type": {"image/png"}}, Body: png}},
+		},
+	}
+	for i := range records {
+		recorder.Process(ctx, &records[i])
+	}
+
+	server := httptest.NewServer(http.StripPrefix("/debug/hachibi", hachibi.NewUI(recorder)))
+	defer server.Close()
+
+	get := func(t *testing.T, path string) (*http.Response, []byte) {
+		t.Helper()
+
+		res, err := http.Get(server.URL + "/debug/hachibi" + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		b, _ := io.ReadAll(res.Body)
+		return res, b
+	}
+
+	t.Run("static files", func(t *testing.T) {
+		for path, want := range map[string]string{"/": `src="app.js"`, "/app.js": "api/captures", "/app.css": "body"} {
+			res, body := get(t, path)
+			if res.StatusCode != http.StatusOK || !strings.Contains(string(body), want) {
+				t.Fatalf("unexpected %s: %d", path, res.StatusCode)
+			}
+
+			if strings.Contains(string(body), "https://") {
+				t.Fatalf("%s must not load external resources", path)
+			}
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		cases := []struct {
+			query string
+			ids   string
+		}{
+			{query: "", ids: "avatar,create,list"},
+			{query: "method=post", ids: "create"},
+			{query: "status=2xx", ids: "avatar,list"},
+			{query: "status=500", ids: "create"},
+			{query: "event=register", ids: "create"},
+			{query: "url=" + url.QueryEscape("/users/1"), ids: "avatar"},
+			{query: "error=true", ids: "create"},
+			{query: "error=false", ids: "avatar,list"},
+			{query: "from=" + url.QueryEscape(now.Add(-2*time.Minute).Format(time.RFC3339)), ids: "avatar,create"},
+			{query: "to=" + url.QueryEscape(now.Add(-2*time.Minute).Format(time.RFC3339)), ids: "list"},
+			{query: "limit=1", ids: "avatar"},
+			{query: "urlPrefix=" + url.QueryEscape("http://api/users?"), ids: "list"},
+			{query: "text=TAUFIQ", ids: "create"},
+			{query: "sort=oldest", ids: "list,create,avatar"},
+			{query: "sort=slowest", ids: "list,create,avatar"},
+		}
+
+		for _, c := range cases {
+			res, body := get(t, "/api/captures?"+c.query)
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("%s: unexpected status %d %s", c.query, res.StatusCode, body)
+			}
+
+			var page struct {
+				Captures []struct {
+					ID     string `json:"id"`
+					Errors int    `json:"errors"`
+				} `json:"captures"`
+			}
+			json.Unmarshal(body, &page)
+
+			ids := make([]string, 0)
+			for _, s := range page.Captures {
+				ids = append(ids, s.ID)
+			}
+
+			if strings.Join(ids, ",") != c.ids {
+				t.Fatalf("%s: got %v, want %s", c.query, ids, c.ids)
+			}
+		}
+
+		for _, q := range []string{"status=abc", "sort=random", "cursor=abc"} {
+			if res, _ := get(t, "/api/captures?"+q); res.StatusCode != http.StatusBadRequest {
+				t.Fatalf("%s: unexpected status %d", q, res.StatusCode)
+			}
+		}
+	})
+
+	t.Run("pages", func(t *testing.T) {
+		ids := make([]string, 0)
+		cursor := ""
+		for i := 0; i < 3; i++ {
+			_, body := get(t, "/api/captures?limit=2&cursor="+cursor)
+
+			var page struct {
+				Captures []struct {
+					ID string `json:"id"`
+				} `json:"captures"`
+				Cursor string `json:"cursor"`
+			}
+			json.Unmarshal(body, &page)
+
+			for _, c := range page.Captures {
+				ids = append(ids, c.ID)
+			}
+
+			if cursor = page.Cursor; cursor == "" {
+				break
+			}
+		}
+
+		if strings.Join(ids, ",") != "avatar,create,list" || cursor != "" {
+			t.Fatalf("unexpected pages %v %q", ids, cursor)
+		}
+	})
+
+	t.Run("show", func(t *testing.T) {
+		res, body := get(t, "/api/captures/create")
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status %d", res.StatusCode)
+		}
+
+		var record struct {
+			ID    string   `json:"id"`
+			Error []string `json:"error"`
+		}
+		if err := json.Unmarshal(body, &record); err != nil || record.ID != "create" || record.Error[0] != "database is down" {
+			t.Fatalf("unexpected record %s", body)
+		}
+
+		if res, _ := get(t, "/api/captures/unknown"); res.StatusCode != http.StatusNotFound {
+			t.Fatalf("unexpected status %d", res.StatusCode)
+		}
+	})
+
+	t.Run("exports", func(t *testing.T) {
+		res, body := get(t, "/api/captures/create/har")
+		var har hachibi.HAR
+		if err := json.Unmarshal(body, &har); err != nil || len(har.Log.Entries) != 1 || !strings.HasPrefix(res.Header.Get("Content-Disposition"), "attachment") {
+			t.Fatalf("unexpected har %s", body)
+		}
+
+		_, body = get(t, "/api/captures/create/curl")
+		if !strings.HasPrefix(string(body), "curl -X 'POST' 'http://api/users'") {
+			t.Fatalf("unexpected curl %s", body)
+		}
+	})
+
+	t.Run("images and files", func(t *testing.T) {
+		res, body := get(t, "/api/captures/avatar/response/body")
+		if res.Header.Get("Content-Type") != "image/png" || len(body) != len(png) {
+			t.Fatalf("unexpected body %d %s", res.StatusCode, res.Header.Get("Content-Type"))
+		}
+
+		res, body = get(t, "/api/captures/avatar/request/files/file/0")
+		if res.Header.Get("Content-Type") != "image/png" || len(body) != len(png) {
+			t.Fatalf("unexpected file %d %s", res.StatusCode, res.Header.Get("Content-Type"))
+		}
+
+		res, _ = get(t, "/api/captures/avatar/request/files/file/1")
+		if res.Header.Get("Content-Type") != "application/octet-stream" || !strings.HasPrefix(res.Header.Get("Content-Disposition"), "attachment") {
+			t.Fatal("non image files must be downloaded")
+		}
+
+		if res, _ := get(t, "/api/captures/list/response/body"); res.StatusCode != http.StatusUnsupportedMediaType {
+			t.Fatalf("unexpected status %d", res.StatusCode)
+		}
+
+		if res, _ := get(t, "/api/captures/avatar/request/files/file/2"); res.StatusCode != http.StatusNotFound {
+			t.Fatalf("unexpected status %d", res.StatusCode)
+		}
+	})
+}